@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"prometheus-awair-exporter/internal/config"
+	"prometheus-awair-exporter/internal/exporter"
+
+	"github.com/rs/zerolog/log"
+)
+
+// auditRow is the audit result of a single device.
+type auditRow struct {
+	Name            string   `json:"name"`
+	Hostname        string   `json:"hostname"`
+	Reachable       bool     `json:"reachable"`
+	Error           string   `json:"error,omitempty"`
+	DeviceUUID      string   `json:"device_uuid,omitempty"`
+	FirmwareVersion string   `json:"firmware_version,omitempty"`
+	Timezone        string   `json:"timezone,omitempty"`
+	Display         string   `json:"display,omitempty"`
+	LEDMode         string   `json:"led_mode,omitempty"`
+	LEDBrightness   int      `json:"led_brightness,omitempty"`
+	Deviations      []string `json:"deviations,omitempty"`
+}
+
+// deviations lists the settings of c which differ from the baseline.
+func deviations(b config.Baseline, c *exporter.ConfigResponse) []string {
+	deviations := []string{}
+	for _, check := range []struct{ setting, want, got string }{
+		{"firmware_version", b.FirmwareVersion, c.FirmwareVersion},
+		{"timezone", b.Timezone, c.Timezone},
+		{"display", b.Display, c.Display},
+		{"led_mode", b.LEDMode, c.LED.Mode},
+	} {
+		if check.want != "" && !strings.EqualFold(check.want, check.got) {
+			deviations = append(deviations, check.setting)
+		}
+	}
+	if b.LEDBrightness != nil && *b.LEDBrightness != c.LED.Brightness {
+		deviations = append(deviations, "led_brightness")
+	}
+	return deviations
+}
+
+// auditCommand queries every configured device and reports its settings,
+// flagging unreachable devices and deviations from the declared baseline.
+func auditCommand() *command {
+	c := newCommand("audit", "Reports the settings of configured devices against the baseline.", "[flags]")
+	configFile := c.flags.String("config.file", "awair.yaml", "configuration file listing the devices")
+	output := outputFlag(c.flags)
+	c.run = func(args []string) {
+		c.flags.Parse(args)
+		runAudit(*configFile, *output)
+	}
+	return c
+}
+
+func runAudit(configFile, output string) {
+	cfg, err := config.Load(configFile)
+	if err != nil {
+		log.Fatal().Err(err).Str("file", configFile).Msg("Failed to load configuration file.")
+	}
+
+	rows := make([]auditRow, len(cfg.Devices))
+	wg := sync.WaitGroup{}
+	for i, d := range cfg.Devices {
+		wg.Add(1)
+		go func(i int, d config.Device) {
+			defer wg.Done()
+			row := auditRow{Name: d.Name, Hostname: d.Hostname}
+			c, err := exporter.GetDeviceConfig(d.Hostname)
+			if err != nil {
+				row.Error = err.Error()
+				rows[i] = row
+				return
+			}
+			row.Reachable = true
+			row.DeviceUUID = c.DeviceUUID
+			row.FirmwareVersion = c.FirmwareVersion
+			row.Timezone = c.Timezone
+			row.Display = c.Display
+			row.LEDMode = c.LED.Mode
+			row.LEDBrightness = c.LED.Brightness
+			row.Deviations = deviations(cfg.Baseline, c)
+			rows[i] = row
+		}(i, d)
+	}
+	wg.Wait()
+
+	err = writeOutput(os.Stdout, output, rows, func(out io.Writer) {
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tHOSTNAME\tREACHABLE\tDEVICE UUID\tFIRMWARE\tTIMEZONE\tDISPLAY\tLED\tDEVIATIONS")
+		for _, r := range rows {
+			led := ""
+			if r.Reachable {
+				led = r.LEDMode + "/" + strconv.Itoa(r.LEDBrightness)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%t\t%s\t%s\t%s\t%s\t%s\t%s\n",
+				r.Name, r.Hostname, r.Reachable, r.DeviceUUID, r.FirmwareVersion,
+				r.Timezone, r.Display, led, strings.Join(r.Deviations, ","))
+		}
+		w.Flush()
+	})
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to write output.")
+	}
+
+	for _, r := range rows {
+		if !r.Reachable || len(r.Deviations) > 0 {
+			os.Exit(1)
+		}
+	}
+}
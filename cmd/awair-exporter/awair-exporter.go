@@ -2,21 +2,53 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
+	"prometheus-awair-exporter/internal/access"
+	"prometheus-awair-exporter/internal/api"
 	"prometheus-awair-exporter/internal/app_info"
+	"prometheus-awair-exporter/internal/aqi"
+	"prometheus-awair-exporter/internal/cloud"
+	"prometheus-awair-exporter/internal/compliance"
+	"prometheus-awair-exporter/internal/config"
+	"prometheus-awair-exporter/internal/control"
+	"prometheus-awair-exporter/internal/digest"
+	"prometheus-awair-exporter/internal/discovery"
+	"prometheus-awair-exporter/internal/drift"
+	"prometheus-awair-exporter/internal/esphome"
 	"prometheus-awair-exporter/internal/exporter"
+	"prometheus-awair-exporter/internal/exposition"
+	"prometheus-awair-exporter/internal/federation"
+	"prometheus-awair-exporter/internal/history"
+	"prometheus-awair-exporter/internal/leader"
+	"prometheus-awair-exporter/internal/promquery"
+	"prometheus-awair-exporter/internal/public"
+	"prometheus-awair-exporter/internal/reachability"
+	"prometheus-awair-exporter/internal/recovery"
+	"prometheus-awair-exporter/internal/redis"
+	"prometheus-awair-exporter/internal/reference"
+	"prometheus-awair-exporter/internal/schedule"
+	"prometheus-awair-exporter/internal/shard"
+	"prometheus-awair-exporter/internal/sink"
+	"prometheus-awair-exporter/internal/smoke"
+	"prometheus-awair-exporter/internal/state"
+	"prometheus-awair-exporter/internal/trigger"
+	"prometheus-awair-exporter/internal/units"
+	"prometheus-awair-exporter/internal/ventilation"
 
 	"github.com/joho/godotenv"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/collectors"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
@@ -37,13 +69,551 @@ func newHealthCheckHandler() http.Handler {
 	})
 }
 
+// stringList is a flag which may be passed multiple times.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, " ")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// splitList splits comma separated values, dropping empty and repeated
+// entries.
+func splitList(values ...string) []string {
+	list := []string{}
+	seen := map[string]bool{}
+	for _, v := range values {
+		for _, item := range strings.Split(v, ",") {
+			item = strings.TrimSpace(item)
+			if item != "" && !seen[item] {
+				seen[item] = true
+				list = append(list, item)
+			}
+		}
+	}
+	return list
+}
+
+// deviceTarget is a device to scrape, with its options from the
+// configuration file.
+type deviceTarget struct {
+	name     string
+	hostname string
+	opts     []exporter.Option
+	// source is the discovery source the device was found by, e.g. scan.
+	source string
+}
+
+// from returns t found by source.
+func (t deviceTarget) from(source string) deviceTarget {
+	t.source = source
+	return t
+}
+
+// inventory holds the devices of the configuration file.
+type inventory struct {
+	devices    map[string]config.Device
+	labelNames map[string]bool
+	aliases    map[string]string
+	// cloud are the devices read from the Awair Cloud, by device UUID.
+	cloud []string
+	// fallbacks read devices from the Awair Cloud when their Local API
+	// fails, by hostname.
+	fallbacks map[string]exporter.Source
+	// deviceLabels label all devices, if set.
+	deviceLabels exporter.DeviceLabels
+}
+
+func newInventory(cfg *config.Config) (*inventory, error) {
+	inv := &inventory{devices: map[string]config.Device{}, labelNames: map[string]bool{}, aliases: cfg.DeviceAliases(), fallbacks: map[string]exporter.Source{}}
+	fallback := false
+	for _, d := range cfg.Devices {
+		if len(d.Endpoints) > 0 {
+			if err := exporter.CheckEndpoints(d.Endpoints); err != nil {
+				return nil, fmt.Errorf("device %s: %w", d.Hostname, err)
+			}
+		}
+		if err := exporter.CheckWake(d.Wake); err != nil {
+			return nil, fmt.Errorf("device %s: %w", d.Name, err)
+		}
+		if d.CloudData != "" {
+			if err := cloud.CheckData(d.CloudData); err != nil {
+				return nil, fmt.Errorf("device %s: %w", d.Name, err)
+			}
+		}
+		if d.CloudFallback {
+			if d.Hostname == "" || d.DeviceUUID == "" {
+				return nil, fmt.Errorf("device %s: cloud_fallback requires a hostname and a device_uuid", d.Name)
+			}
+			fallback = true
+		}
+		if d.Hostname == "" {
+			if d.DeviceUUID == "" {
+				return nil, fmt.Errorf("device %s: a hostname or, to read it from the Awair Cloud, a device_uuid is required", d.Name)
+			}
+			inv.devices[d.DeviceUUID] = d
+			inv.cloud = append(inv.cloud, d.DeviceUUID)
+		} else {
+			inv.devices[d.Hostname] = d
+		}
+		for name := range d.Labels {
+			if name == "source" {
+				return nil, fmt.Errorf("device %s: the source label is set by the exporter", d.Name)
+			}
+			inv.labelNames[name] = true
+		}
+	}
+	if len(inv.cloud) > 0 || fallback {
+		// The series of devices read from the cloud are told apart by
+		// their source label.
+		inv.labelNames["source"] = true
+	}
+	return inv, nil
+}
+
+// target returns the device at hostname with the options of the configured
+// device of the same hostname, if any. name defaults to the configured
+// name, then to the hostname.
+func (inv *inventory) target(name, hostname string) deviceTarget {
+	return inv.labelledTarget(name, hostname, nil)
+}
+
+// labelledTarget is target with labels, e.g. from a service catalog, taking
+// precedence over the configured ones. Their names must be known to the
+// inventory.
+func (inv *inventory) labelledTarget(name, hostname string, extra map[string]string) deviceTarget {
+	d := inv.devices[hostname]
+	t := deviceTarget{name: name, hostname: hostname}
+	if t.name == "" {
+		t.name = d.Name
+	}
+	if t.name == "" {
+		t.name = hostname
+	}
+	if len(inv.labelNames) > 0 {
+		// Every device carries every label, so series of the same name
+		// don't differ in their label names.
+		labels := map[string]string{}
+		for name := range inv.labelNames {
+			labels[name] = d.Labels[name]
+			if value, ok := extra[name]; ok {
+				labels[name] = value
+			}
+		}
+		if inv.labelNames["source"] && labels["source"] == "" {
+			labels["source"] = "local"
+		}
+		t.opts = append(t.opts, exporter.WithLabels(labels))
+	}
+	if len(inv.aliases) > 0 {
+		t.opts = append(t.opts, exporter.WithAliases(inv.aliases))
+	}
+	if inv.deviceLabels != nil {
+		t.opts = append(t.opts, exporter.WithDeviceLabels(inv.deviceLabels))
+	}
+	if d.Timeout > 0 {
+		t.opts = append(t.opts, exporter.WithTimeout(d.Timeout))
+	}
+	if d.PollInterval > 0 {
+		t.opts = append(t.opts, exporter.WithPollInterval(d.PollInterval))
+	}
+	if len(d.Endpoints) > 0 {
+		t.opts = append(t.opts, exporter.WithEndpoints(d.Endpoints))
+	}
+	if len(d.Addresses) > 0 {
+		t.opts = append(t.opts, exporter.WithAddresses(d.Addresses))
+	}
+	if d.Wake != "" {
+		t.opts = append(t.opts, exporter.WithWake(d.Wake))
+	}
+	if src, ok := inv.fallbacks[hostname]; ok {
+		t.opts = append(t.opts, exporter.WithFallback(src, "source", "cloud"))
+	}
+	return t
+}
+
+// catalogTarget is labelledTarget with the options set by a service
+// catalog, taking precedence over the configured ones.
+func (inv *inventory) catalogTarget(t discovery.Target) (deviceTarget, error) {
+	target := inv.labelledTarget(t.Name, t.Hostname, t.Labels)
+	if t.Timeout > 0 {
+		target.opts = append(target.opts, exporter.WithTimeout(t.Timeout))
+	}
+	if t.PollInterval > 0 {
+		target.opts = append(target.opts, exporter.WithPollInterval(t.PollInterval))
+	}
+	if len(t.Endpoints) > 0 {
+		if err := exporter.CheckEndpoints(t.Endpoints); err != nil {
+			return target, err
+		}
+		target.opts = append(target.opts, exporter.WithEndpoints(t.Endpoints))
+	}
+	return target, nil
+}
+
+// targets returns the devices to scrape: the given hostnames, or the
+// configured devices without any.
+func (inv *inventory) targets(hostnames []string, cfg *config.Config) []deviceTarget {
+	if len(hostnames) == 0 {
+		for _, d := range cfg.Devices {
+			if d.Hostname != "" {
+				hostnames = append(hostnames, d.Hostname)
+			}
+		}
+	}
+	targets := []deviceTarget{}
+	for _, hostname := range hostnames {
+		targets = append(targets, inv.target("", hostname).from("static"))
+	}
+	return targets
+}
+
+// cloudTargets returns the devices read from the Awair Cloud, with their
+// device UUID as hostname.
+func (inv *inventory) cloudTargets() []deviceTarget {
+	targets := []deviceTarget{}
+	for _, uuid := range inv.cloud {
+		targets = append(targets, inv.labelledTarget("", uuid, map[string]string{"source": "cloud"}).from("cloud"))
+	}
+	return targets
+}
+
+func deviceHostnames(targets []deviceTarget) []string {
+	hostnames := make([]string, len(targets))
+	for i, t := range targets {
+		hostnames[i] = t.hostname
+	}
+	return hostnames
+}
+
+// fleetDevices lets the admin API add and remove the fleet's devices,
+// recording them in the registry.
+type fleetDevices struct {
+	*exporter.Fleet
+	inv      *inventory
+	add      func(deviceTarget) error
+	registry *state.Registry
+}
+
+func (d fleetDevices) AddDevice(_ context.Context, name, hostname string) (exporter.DeviceInfo, error) {
+	for _, existing := range d.Devices() {
+		if existing.Hostname == hostname && existing.Name != name {
+			return exporter.DeviceInfo{}, fmt.Errorf("%w as %s", api.ErrExists, existing.Name)
+		}
+	}
+	if err := d.add(d.inv.target(name, hostname).from("api")); err != nil {
+		return exporter.DeviceInfo{}, err
+	}
+	if err := d.registry.Add(name, hostname); err != nil {
+		log.Error().Err(err).Str("name", name).Msg("Failed to record device in state file.")
+	}
+	for _, added := range d.Devices() {
+		if added.Name == name {
+			return added, nil
+		}
+	}
+	return exporter.DeviceInfo{}, fmt.Errorf("device %s was removed while being added", name)
+}
+
+func (d fleetDevices) RemoveDevice(uuid string) bool {
+	for _, existing := range d.Devices() {
+		if existing.DeviceUUID != uuid {
+			continue
+		}
+		if err := d.registry.Remove(existing.Name); err != nil {
+			log.Error().Err(err).Str("name", existing.Name).Msg("Failed to remove device from state file.")
+		}
+		return d.Remove(existing.Name)
+	}
+	return false
+}
+
+// addCloudAccount returns an update adding the devices of the Awair Cloud
+// account which aren't in the fleet under their device UUID.
+func addCloudAccount(fleet *exporter.Fleet, inv *inventory, add func(deviceTarget) error) cloud.UpdateFunc {
+	return func(devices []cloud.Device) {
+		known := map[string]bool{}
+		for _, d := range fleet.Devices() {
+			known[d.DeviceUUID] = true
+		}
+		for _, d := range devices {
+			if known[d.DeviceUUID] {
+				continue
+			}
+			t := inv.labelledTarget(d.DeviceUUID, d.DeviceUUID, map[string]string{"source": "cloud"}).from("cloud")
+			if err := add(t); err != nil && !errors.Is(err, api.ErrNotOwned) {
+				log.Error().Err(err).Str("device_uuid", d.DeviceUUID).Msg("Failed to add device of the Awair Cloud account.")
+			}
+		}
+	}
+}
+
+// restoreDevices adds the devices of the registry but those already in the
+// fleet, retrying those which can't be reached every minute until they are
+// added, replaced or removed.
+func restoreDevices(ctx context.Context, registry *state.Registry, fleet *exporter.Fleet, inv *inventory, add func(deviceTarget) error) {
+	present := func(name string) bool {
+		for _, n := range fleet.Names() {
+			if n == name {
+				return true
+			}
+		}
+		return false
+	}
+	recorded := func(d state.RegisteredDevice) bool {
+		for _, r := range registry.Devices() {
+			if r == d {
+				return true
+			}
+		}
+		return false
+	}
+	for _, d := range registry.Devices() {
+		if present(d.Name) {
+			continue
+		}
+		go func(d state.RegisteredDevice) {
+			for {
+				err := add(inv.target(d.Name, d.Hostname).from("state"))
+				if err == nil {
+					log.Info().Str("name", d.Name).Str("hostname", d.Hostname).Msg("Restored device from state file.")
+					return
+				}
+				if errors.Is(err, api.ErrNotOwned) {
+					return
+				}
+				log.Warn().Err(err).
+					Str("name", d.Name).
+					Str("hostname", d.Hostname).
+					Msg("Failed to restore device from state file, retrying in a minute.")
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(time.Minute):
+				}
+				if present(d.Name) || !recorded(d) {
+					return
+				}
+			}
+		}(d)
+	}
+}
+
+// catalogSync adds and removes the devices supplied by a service catalog.
+type catalogSync struct {
+	source string
+	inv    *inventory
+	fleet  *exporter.Fleet
+	add    func(deviceTarget) error
+	// managed holds the targets added from the catalog by name, to apply
+	// changes.
+	managed map[string]string
+}
+
+func newCatalogSync(source string, inv *inventory, fleet *exporter.Fleet, add func(deviceTarget) error) *catalogSync {
+	return &catalogSync{source: source, inv: inv, fleet: fleet, add: add, managed: map[string]string{}}
+}
+
+// reconcile adds the found targets which are new or changed, and removes
+// those which are gone, returning the outcome of adding each target this
+// shard owns.
+func (c *catalogSync) reconcile(found []discovery.Target) map[string]error {
+	results := map[string]error{}
+	seen := map[string]bool{}
+	for _, t := range found {
+		seen[t.Name] = true
+		id := fmt.Sprint(t.Hostname, t.Labels, t.Timeout, t.PollInterval, t.Endpoints)
+		if c.managed[t.Name] == id {
+			results[t.Name] = nil
+			continue
+		}
+		target, err := c.inv.catalogTarget(t)
+		target.source = strings.ToLower(c.source)
+		if err == nil {
+			err = c.add(target)
+		}
+		if errors.Is(err, api.ErrNotOwned) {
+			c.managed[t.Name] = id
+			continue
+		}
+		results[t.Name] = err
+		if err != nil {
+			log.Error().Err(err).
+				Str("name", t.Name).
+				Str("hostname", t.Hostname).
+				Msgf("Failed to add device from %s.", c.source)
+			continue
+		}
+		c.managed[t.Name] = id
+	}
+	for name := range c.managed {
+		if !seen[name] {
+			c.fleet.Remove(name)
+			delete(c.managed, name)
+			log.Info().Str("name", name).Msgf("Removed device gone from %s.", c.source)
+		}
+	}
+	return results
+}
+
+// expireScanned removes the devices in the ranges scanner scans which
+// haven't answered for expire, checking every interval until ctx is done,
+// so a device gone from the network doesn't stay down on dashboards.
+// Devices at the hostnames of static are kept.
+func expireScanned(ctx context.Context, fleet *exporter.Fleet, scanner *discovery.Scanner, registry *state.Registry, static map[string]bool, expire, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, d := range fleet.Devices() {
+			if static[d.Hostname] || !scanner.Covers(d.Hostname) {
+				continue
+			}
+			unseen, ok := fleet.Unseen(d.Name)
+			if !ok || unseen < expire {
+				continue
+			}
+			fleet.Remove(d.Name)
+			if err := registry.Remove(d.Name); err != nil {
+				log.Error().Err(err).Str("name", d.Name).Msg("Failed to remove device from state file.")
+			}
+			scanner.Forget(d.Hostname)
+			log.Info().Str("name", d.Name).Str("hostname", d.Hostname).Dur("unseen", unseen).Msg("Removed device no longer answering in the scanned ranges.")
+		}
+	}
+}
+
 func main() {
+	collectConcurrency := flag.Int("collect.concurrency", 0, "maximum number of devices queried at once on scrape (0 queries all at once)")
+	collectDeadline := flag.Duration("collect.deadline", 0, "time after which a device queried on scrape is given up on and exposed as down, to stay within the scrape timeout (0 disables)")
+	configFile := flag.String("config.file", "", "YAML file configuring devices, their names, labels and timeouts, listen addresses and log level, overridden by flags")
+	var devices stringList
+	flag.Var(&devices, "device", "hostname of an awair device to scrape (repeatable or comma separated, default AWAIR_HOSTNAME)")
+	var sinks stringList
+	flag.Var(&sinks, "sink", "pushes polled readings to an output, kind[:key=value,...] (repeatable, requires -pollinterval)")
 	debug := flag.Bool("debug", false, "sets log level to debug")
+	summaryInterval := flag.Duration("log.summary-interval", 0, "logs a line per device with the success rate, average latency and errors of its readings at this interval, e.g. 1h")
+	logLevel := flag.String("log.level", "", "sets log level to one of trace, debug, info, warn or error, overriding log_level of -config.file (default info)")
 	goCollector := flag.Bool("gocollector", false, "enables go stats exporter")
 	processCollector := flag.Bool("processcollector", false, "enables process stats exporter")
+	freshness := flag.Duration("freshness", 0, "serves a reading queried on scrape from cache for this long, e.g. 10s, as the device only refreshes every ~10s")
+	pollInterval := flag.Duration("pollinterval", 0, "polls the device in the background at this interval (e.g. 10s) instead of on every scrape")
+	watchdogIntervals := flag.Int("watchdog.intervals", exporter.DefaultWatchdogIntervals, "restarts the background poller after this many poll intervals without a completed poll (0 disables)")
+	reachabilityInterval := flag.Duration("reachability.interval", 0, "probes whether devices answer on the network at this interval, faster than polling them, exposing awair_device_reachable (0 disables)")
+	reachabilityMethod := flag.String("reachability.method", reachability.MethodTCP, "reachability probe: tcp, connecting to the port of the Local API, or icmp, an echo request requiring CAP_NET_RAW")
+	reachabilityTimeout := flag.Duration("reachability.timeout", 2*time.Second, "time after which a device not answering a reachability probe is unreachable")
+	resolveInterval := flag.Duration("resolve.interval", exporter.DefaultResolveInterval, "resolves the hostnames of devices again after this long, or after a failed request, to follow address changes (0 resolves before every request)")
+	wake := flag.String("wake", "", "wakes up devices on Wi-Fi power saving which miss the first request after sleeping: retry, retrying a request without answer once right away, or prewarm, connecting to the device before every reading")
+	strict := flag.Bool("strict", false, "logs and counts device response fields not mapped by the exporter")
+	leaderLock := flag.String("leader.lockfile", "", "only publishes to sinks while holding an exclusive lock on this file, for active/passive pairs sharing a volume")
+	redisURL := flag.String("redis.url", "", "shares readings with other replicas through Redis so only one queries each device, redis[s]://[:password@]host[:port][/db]")
+	stateFile := flag.String("state.file", "", "file persisting exporter state, such as the restart count and devices added at runtime, across restarts")
+	shardFlag := flag.String("shard", "", "handles only the devices hashed to shard n of m replicas, given as n/m")
+	ingest := flag.Bool("ingest", false, "accepts readings POSTed to /api/v1/ingest/<name> by relays, exposed like polled devices")
+	ingestToken := flag.String("ingest.token", "", "bearer token required to push readings, required with -ingest")
+	ingestMaxDevices := flag.Int("ingest.max-devices", exporter.DefaultIngestLimit, "maximum number of devices added by pushed readings")
+	ingestTransform := flag.String("ingest.transform", "", "YAML file mapping reading fields to JSON paths, to accept pushed payloads of other formats")
+	publicPage := flag.Bool("public", false, "serves a read-only status page of selected metrics per room, without device identifiers, on /public")
+	publicMetrics := flag.String("public.metrics", strings.Join(public.DefaultMetrics, ","), "comma separated list of metrics shown on /public and /kiosk")
+	publicPrometheus := flag.String("public.prometheus.url", "", "Prometheus server queried for the trends shown on /public, with credentials from -config.file")
+	publicTrendWindow := flag.Duration("public.trend-window", 24*time.Hour, "period of the trends shown on /public")
+	aqiFlag := flag.String("aqi", "", "comma separated list of air quality index standards exposed as awair_aqi from the PM readings, overriding aqi of -config.file: "+strings.Join(aqi.Names(), ", "))
+	unitsFlag := flag.String("units", "", "units readings are shown in on /public, /kiosk and /api/v1/readings, metric or imperial, overriding units of -config.file (default metric); metrics stay in metric units")
+	kiosk := flag.Bool("kiosk", false, "serves current values with trend arrows as plain text or compact JSON for e-ink displays on /kiosk")
+	kioskWindow := flag.Duration("kiosk.trend-window", 15*time.Minute, "period over which /kiosk trends are computed")
+	discoveryCIDR := flag.String("discovery.cidr", "", "comma separated list of IPv4 ranges scanned for devices, for networks without mDNS")
+	discoveryInterval := flag.Duration("discovery.interval", 10*time.Minute, "interval between scans of -discovery.cidr")
+	discoveryExpire := flag.Duration("discovery.expire", 0, "removes devices in -discovery.cidr which haven't answered for this long, so they are found again once back (0 keeps them)")
+	tombstoneRetention := flag.Duration("tombstone.retention", exporter.DefaultTombstoneRetention, "period for which removed devices are exposed as awair_device_removed (0 disables)")
+	consulURL := flag.String("consul.url", "", "Consul agent whose catalog supplies the devices as instances of -consul.service, authenticated by CONSUL_HTTP_TOKEN")
+	consulService := flag.String("consul.service", "awair", "Consul service whose healthy instances are devices")
+	consulLabels := flag.String("consul.tag-labels", "", "comma separated list of labels set by Consul service tags of the form label=value")
+	kubernetes := flag.Bool("kubernetes", false, "adds the devices of AwairDevice resources, through the API server and service account of the pod")
+	kubernetesNamespace := flag.String("kubernetes.namespace", "", "namespace whose AwairDevice resources are devices (default the namespace of the pod)")
+	kubernetesLabels := flag.String("kubernetes.labels", "", "comma separated list of labels set by the spec.labels of AwairDevice resources")
+	discoveryPriority := flag.String("discovery.priority", strings.Join(exporter.DefaultSourcePriority, ","), "comma separated order in which devices found by several sources with the same device UUID are collected, while healthy: static, kubernetes, consul, api, state, scan or cloud")
+	discoveryRate := flag.Float64("discovery.rate", 10, "maximum number of addresses probed per second while scanning")
+	adminToken := flag.String("admin.token", "", "enables the admin API adding and removing devices at runtime on /api/v1/devices, authenticated by this bearer token")
+	smokePollInterval := flag.Duration("smoke.pollinterval", 10*time.Second, "poll interval of devices polled in the background while the smoke mode is active")
+	smokeDuration := flag.Duration("smoke.duration", 12*time.Hour, "duration of the smoke mode unless its activation sets one")
+	smokePM25 := flag.Float64("smoke.pm25-threshold", 12, "PM2.5 alert threshold exposed while the smoke mode is active (µg/m³)")
+	driftDays := flag.Int("drift.days", 0, "days after which CO2 readings never returning near outdoor levels raise a drift alert, e.g. 7 (0 disables)")
+	driftTolerance := flag.Float64("drift.tolerance", 100, "ppm above the outdoor CO2 level of 420ppm the daily minimum may stay without raising a drift alert")
+	var listen stringList
+	flag.Var(&listen, "web.listen", "address to serve on, addr[=feature,...] with features metrics, api, ingest, admin and public (repeatable, default :8080 with all features)")
+	digestWebhook := flag.String("digest.webhook", "", "URL to POST the daily aggregates of every device to as JSON")
+	digestSheet := flag.String("digest.sheet", "", "ID of a Google Sheet to append the daily aggregates of every device to, authenticated by the service account key file in GOOGLE_APPLICATION_CREDENTIALS")
+	digestSchedule := flag.String("digest.schedule", "@daily", "cron expression in the exporter's time zone at which the aggregates since the previous digest are sent, e.g. @weekly or 0 7 * * 1")
+	digestSheetRange := flag.String("digest.sheet-range", "Sheet1", "sheet or range of -digest.sheet the daily aggregates are appended to")
+	cloudURL := flag.String("cloud.url", cloud.DefaultURL, "base URL of the Awair developer API devices without a hostname in -config.file are read from, authenticated by the access token in AWAIR_CLOUD_TOKEN")
+	cloudTokenURL := flag.String("cloud.token-url", "", "OAuth2 token endpoint issuing the access tokens of the Awair developer API instead of AWAIR_CLOUD_TOKEN, to the client in AWAIR_CLOUD_CLIENT_ID and AWAIR_CLOUD_CLIENT_SECRET, by the refresh token in AWAIR_CLOUD_REFRESH_TOKEN if set")
+	cloudSyncInterval := flag.Duration("cloud.sync-interval", time.Hour, "interval at which the devices of the Awair Cloud account are listed to label all devices with their name, room type, space type and location (0 disables)")
+	cloudCoordinates := flag.Bool("cloud.coordinates", false, "adds the latitude and longitude set in the Awair app to awair_cloud_device_info")
+	cloudAddDevices := flag.Bool("cloud.add-devices", false, "reads the devices of the Awair Cloud account which aren't local devices from the cloud")
+	cloudPollInterval := flag.Duration("cloud.pollinterval", 5*time.Minute, "poll interval of devices read from the Awair Cloud, whose API limits the requests per day")
+	cloudData := flag.String("cloud.data", cloud.DataLatest, "readings of devices read from the Awair Cloud unless their cloud_data in -config.file sets them: latest, or the averages 5-min-avg or 15-min-avg, polled no more often than they change")
+	esphomeListen := flag.String("esphome.listen", "", "address to serve the ESPHome native API on for Home Assistant to adopt the devices' sensors, e.g. :6053 (unencrypted)")
+	esphomeName := flag.String("esphome.name", "awair-exporter", "node name of the exporter on the ESPHome native API")
+	baselineNight := flag.String("baseline.night", "", "local hours start-end over which the overnight CO2 baseline is taken, e.g. 1-6 (empty disables)")
+	seriesLimit := flag.Int("series.limit", 0, "maximum number of series served on /metrics, leaving out whole metric families beyond it (0 disables)")
+	labelBudget := flag.Int("series.label-budget", 0, "number of distinct values of a label across the series served on /metrics above which a warning is logged, e.g. 1000")
+	allow := flag.String("web.allow", "", "comma separated list of CIDRs allowed to access the exporter's endpoints, /healthz excepted (default everyone)")
+	trustedProxiesFlag := flag.String("web.trusted-proxies", "", "comma separated list of reverse proxy CIDRs whose X-Forwarded-For header identifies the client")
+	probe := flag.Bool("probe", false, "serves the metrics of any device named by the target parameter on /probe?target=<hostname>, for multi-target scrape configs")
+	deviceMetrics := flag.Bool("devicemetrics", false, "serves the metrics of each device on /metrics/device/<name>")
+	federate := flag.String("federate", "", "comma separated list of site=url awair-exporter instances to federate instead of a local device")
+
+	commands := subcommands()
+	if len(os.Args) > 1 {
+		if c, ok := commands[os.Args[1]]; ok {
+			c.run(os.Args[2:])
+			return
+		}
+	}
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags]\n       %s <command> [flags]\n\nCommands:\n", app_name, app_name)
+		for _, c := range sortedCommands(commands) {
+			fmt.Fprintf(flag.CommandLine.Output(), "  %-12s%s\n", c.name(), c.description)
+		}
+		fmt.Fprintln(flag.CommandLine.Output(), "\nFlags:")
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
+	cfg := &config.Config{}
+	if *configFile != "" {
+		if _, err := os.Stat(*configFile); err != nil {
+			log.Fatal().Err(err).Msg("Failed to load -config.file.")
+		}
+		var err error
+		if cfg, err = config.Load(*configFile); err != nil {
+			log.Fatal().Err(err).Str("file", *configFile).Msg("Failed to load -config.file.")
+		}
+	}
+
 	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	if cfg.LogLevel != "" {
+		level, err := zerolog.ParseLevel(cfg.LogLevel)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Invalid log_level in -config.file.")
+		}
+		zerolog.SetGlobalLevel(level)
+	}
+	if *logLevel != "" {
+		level, err := zerolog.ParseLevel(*logLevel)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Invalid -log.level.")
+		}
+		zerolog.SetGlobalLevel(level)
+	}
 	if *debug {
 		zerolog.SetGlobalLevel(zerolog.DebugLevel)
 	}
@@ -55,13 +625,118 @@ func main() {
 		log.Warn().Err(err).Msg("No .env file loaded")
 	}
 
-	hostname := os.Getenv("AWAIR_HOSTNAME")
-	if hostname == "" {
+	allowlist, err := access.ParseAllowlist(*allow)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to parse -web.allow.")
+	}
+	trustedProxies, err := access.ParseTrustedProxies(*trustedProxiesFlag)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to parse -web.trusted-proxies.")
+	}
+	ownShard, err := shard.Parse(*shardFlag)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to parse -shard.")
+	}
+	if *unitsFlag == "" {
+		*unitsFlag = cfg.Units
+	}
+	displayUnits, err := units.Parse(*unitsFlag)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid -units.")
+	}
+	aqiNames := cfg.AQI
+	if *aqiFlag != "" {
+		aqiNames = splitList(*aqiFlag)
+	}
+	aqiStandards, err := aqi.Lookup(aqiNames)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid -aqi.")
+	}
+
+	hostnames := splitList(devices...)
+	if len(hostnames) == 0 {
+		hostnames = splitList(os.Getenv("AWAIR_HOSTNAME"))
+	}
+	inv, err := newInventory(cfg)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid devices in -config.file.")
+	}
+	for _, name := range splitList(*consulLabels, *kubernetesLabels) {
+		inv.labelNames[name] = true
+	}
+	if err := cloud.CheckData(*cloudData); err != nil {
+		log.Fatal().Err(err).Msg("Invalid -cloud.data.")
+	}
+	if err := exporter.CheckWake(*wake); err != nil {
+		log.Fatal().Err(err).Msg("Invalid -wake.")
+	}
+	var cloudClient *cloud.Client
+	var cloudOAuth2 *cloud.OAuth2
+	if *cloudTokenURL != "" {
+		cloudOAuth2 = cloud.NewOAuth2(*cloudTokenURL, os.Getenv("AWAIR_CLOUD_CLIENT_ID"), os.Getenv("AWAIR_CLOUD_CLIENT_SECRET"), os.Getenv("AWAIR_CLOUD_REFRESH_TOKEN"))
+		cloudClient = cloud.New(*cloudURL, cloudOAuth2)
+	} else if token := os.Getenv("AWAIR_CLOUD_TOKEN"); token != "" {
+		cloudClient = cloud.New(*cloudURL, cloud.StaticToken(token))
+	}
+	for _, d := range cfg.Devices {
+		if !d.CloudFallback {
+			continue
+		}
+		if cloudClient == nil {
+			log.Fatal().Msg("AWAIR_CLOUD_TOKEN or -cloud.token-url must be set for devices falling back to the Awair Cloud.")
+		}
+		data := d.CloudData
+		if data == "" {
+			data = *cloudData
+		}
+		src, err := cloud.NewSource(cloudClient, d.DeviceUUID, data)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Invalid devices in -config.file.")
+		}
+		inv.fallbacks[d.Hostname] = src
+	}
+	var cloudInventory *cloud.Inventory
+	if cloudClient != nil && *cloudSyncInterval > 0 {
+		cloudInventory = cloud.NewInventory(cloudClient, *cloudCoordinates)
+		inv.deviceLabels = cloudInventory
+	}
+	if *cloudAddDevices {
+		if cloudInventory == nil {
+			log.Fatal().Msg("-cloud.add-devices requires AWAIR_CLOUD_TOKEN or -cloud.token-url, and -cloud.sync-interval.")
+		}
+		inv.labelNames["source"] = true
+	}
+	targets := inv.targets(hostnames, cfg)
+	cloudTargets := inv.cloudTargets()
+	if len(targets) == 0 && len(cloudTargets) == 0 && !*cloudAddDevices && *federate == "" && !*ingest && *discoveryCIDR == "" && *consulURL == "" && !*kubernetes && !*probe {
 		log.Fatal().
-			Msg("AWAIR_HOSTNAME must be set to the hostname of the awair device")
+			Msg("AWAIR_HOSTNAME, -device or -config.file must set the hostname of the awair device")
 	}
 
-	var srv http.Server
+	if len(listen) == 0 {
+		listen = cfg.Listen
+	}
+	if len(listen) == 0 {
+		listen = stringList{":8080"}
+	}
+	listeners := []listener{}
+	for _, def := range listen {
+		l, err := parseListener(def)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to parse -web.listen.")
+		}
+		listeners = append(listeners, l)
+	}
+	servers := make([]*http.Server, len(listeners))
+	for i, l := range listeners {
+		servers[i] = &http.Server{Addr: l.addr}
+	}
+
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	zones := schedule.NewZones()
+	scheduler := schedule.New()
+	go scheduler.Run(ctx)
 
 	idleConnsClosed := make(chan struct{})
 	go func() {
@@ -73,10 +748,13 @@ func main() {
 		log.Info().
 			Str("signal", sig.String()).
 			Msg("Stopping in response to signal")
+		stop()
 		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 		defer cancel()
-		if err := srv.Shutdown(ctx); err != nil {
-			log.Fatal().Err(err).Msg("Failed to gracefully close http server")
+		for _, srv := range servers {
+			if err := srv.Shutdown(ctx); err != nil {
+				log.Fatal().Err(err).Msg("Failed to gracefully close http server")
+			}
 		}
 		close(idleConnsClosed)
 	}()
@@ -86,36 +764,463 @@ func main() {
 		Str("version", version).
 		Msg("Exporter Started.")
 
-	ex, err := exporter.NewAwairExporter(hostname)
-	if err != nil {
-		log.Fatal().
-			Err(err).
-			Msg("Failed to connect to Awair device.")
-	}
-
 	appFunc := app_info.AppInfoGaugeFunc(
 		app_name,
 		version,
-		hostname,
+		strings.Join(deviceHostnames(targets), ","),
 	)
 	reg := prometheus.NewPedanticRegistry()
-	reg.MustRegister(
-		appFunc,
-		ex,
-	)
+	reg.MustRegister(appFunc)
+	if cloudClient != nil {
+		reg.MustRegister(cloudClient)
+	}
+	if cloudOAuth2 != nil {
+		reg.MustRegister(cloudOAuth2)
+	}
+
+	store, err := state.Open(*stateFile)
+	if err != nil {
+		log.Fatal().Err(err).Str("file", *stateFile).Msg("Failed to load state file.")
+	}
+	lifecycle, err := state.RecordStart(store, time.Now())
+	if err != nil {
+		log.Fatal().Err(err).Str("file", *stateFile).Msg("Failed to record start in state file.")
+	}
+	log.Info().Int("restarts", lifecycle.Restarts()).Msg("Recorded exporter start.")
+	registry, err := state.OpenRegistry(store)
+	if err != nil {
+		log.Fatal().Err(err).Str("file", *stateFile).Msg("Failed to load device registry from state file.")
+	}
+	reg.MustRegister(lifecycle)
+	recoverer := recovery.New()
+	reg.MustRegister(recoverer)
+	routes := routes{}
+	if *adminToken != "" {
+		routes.handle("admin", "/-/loglevel", api.NewLogLevelHandler(*adminToken))
+	}
+
+	sinksDone := make(chan struct{})
+	budget := exposition.NewBudget(reg, *seriesLimit, *labelBudget)
+	reg.MustRegister(budget)
+	profileHandler, err := exposition.NewProfileHandler(budget, cfg.Profiles)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid profiles in -config.file.")
+	}
+	var metricsHandler http.Handler
+	if *federate != "" {
+		upstreams, err := federation.ParseUpstreams(*federate)
+		if err != nil {
+			log.Fatal().
+				Err(err).
+				Msg("Failed to parse federation upstreams.")
+		}
+		reg.MustRegister(federation.NewFederator(upstreams))
+		close(sinksDone)
+	} else {
+		sinkManager := sink.NewManager()
+		for _, def := range sinks {
+			cfg, err := sink.ParseConfig(def)
+			if err != nil {
+				log.Fatal().Err(err).Msg("Failed to parse sink.")
+			}
+			s, err := sink.New(cfg)
+			if err != nil {
+				log.Fatal().Err(err).Str("sink", cfg.Name).Msg("Failed to configure sink.")
+			}
+			sinkManager.Add(cfg, s)
+		}
+		if sinkManager.Len() > 0 && *pollInterval <= 0 {
+			log.Fatal().Msg("-pollinterval must be set when sinks are configured")
+		}
+		if *leaderLock != "" {
+			elector := leader.NewFileLock(*leaderLock, 5*time.Second)
+			sinkManager.SetElector(elector)
+			reg.MustRegister(elector)
+			go elector.Run(ctx)
+		}
+		go func() {
+			sinkManager.Run(ctx)
+			close(sinksDone)
+		}()
+
+		opts := []exporter.Option{
+			exporter.WithStrictMode(*strict),
+			exporter.WithPollInterval(*pollInterval),
+			exporter.WithFreshness(*freshness),
+			exporter.WithPublisher(sinkManager),
+			exporter.WithRecoverer(recoverer),
+			exporter.WithWatchdog(*watchdogIntervals),
+			exporter.WithResolveInterval(*resolveInterval),
+			exporter.WithWake(*wake),
+		}
+		if len(aqiStandards) > 0 {
+			opts = append(opts, exporter.WithDerivedMetrics(aqi.New(aqiStandards...)))
+		}
+		if *redisURL != "" {
+			client, err := redis.New(*redisURL, "awair-exporter:")
+			if err != nil {
+				log.Fatal().Err(err).Msg("Failed to parse -redis.url.")
+			}
+			opts = append(opts, exporter.WithSharedCache(client))
+		}
+		if *adminToken != "" {
+			mode := smoke.New(*smokePollInterval, history.DefaultThresholds.PM25, *smokePM25)
+			opts = append(opts, exporter.WithIntervalOverride(mode))
+			reg.MustRegister(mode)
+			routes.handle("admin", "/api/v1/smoke", api.NewSmokeHandler(*adminToken, mode, *smokeDuration))
+		}
+		fleet := exporter.NewFleet(
+			exporter.WithCollectConcurrency(*collectConcurrency),
+			exporter.WithCollectDeadline(*collectDeadline),
+			exporter.WithTombstoneRetention(*tombstoneRetention),
+			exporter.WithSourcePriority(splitList(*discoveryPriority)),
+			exporter.WithIngestLimit(*ingestMaxDevices),
+		)
+		if *summaryInterval > 0 {
+			go fleet.LogSummaries(ctx, *summaryInterval)
+		}
+		addDevice := func(t deviceTarget) error {
+			deviceOpts := append(append([]exporter.Option{}, opts...), t.opts...)
+			deviceOpts = append(deviceOpts, exporter.WithDiscoverySource(t.source))
+			ex, err := exporter.NewAwairExporter(t.hostname, deviceOpts...)
+			if err != nil {
+				return err
+			}
+			if !ownShard.Owns(ex.DeviceUUID()) {
+				log.Info().
+					Str("device_uuid", ex.DeviceUUID()).
+					Stringer("shard", ownShard).
+					Msg("Device belongs to another shard, skipping.")
+				return api.ErrNotOwned
+			}
+			fleet.AddPolled(ctx, t.name, ex)
+			return nil
+		}
+		connected := sync.WaitGroup{}
+		for _, t := range targets {
+			connected.Add(1)
+			go func(t deviceTarget) {
+				defer connected.Done()
+				if err := addDevice(t); err != nil && !errors.Is(err, api.ErrNotOwned) {
+					log.Fatal().
+						Err(err).
+						Str("hostname", t.hostname).
+						Msg("Failed to connect to Awair device.")
+				}
+			}(t)
+		}
+		connected.Wait()
+		addCloudDevice := func(t deviceTarget) error {
+			data := inv.devices[t.hostname].CloudData
+			if data == "" {
+				data = *cloudData
+			}
+			src, err := cloud.NewSource(cloudClient, t.hostname, data)
+			if err != nil {
+				return err
+			}
+			if !ownShard.Owns(t.hostname) {
+				return api.ErrNotOwned
+			}
+			// Averages aren't polled more often than they change, unless
+			// the device sets its poll interval.
+			interval := *cloudPollInterval
+			if cloud.DataInterval(data) > interval {
+				interval = cloud.DataInterval(data)
+			}
+			// The smoke mode doesn't poll the cloud more often, which
+			// would exhaust the quota of the API.
+			deviceOpts := append(append([]exporter.Option{}, opts...),
+				exporter.WithPollInterval(interval),
+				exporter.WithIntervalOverride(nil),
+				exporter.WithDiscoverySource(t.source))
+			fleet.AddPolled(ctx, t.name, exporter.NewSourcedExporter(t.hostname, src, append(deviceOpts, t.opts...)...))
+			return nil
+		}
+		if len(cloudTargets) > 0 && cloudClient == nil {
+			log.Fatal().Msg("AWAIR_CLOUD_TOKEN or -cloud.token-url must be set to read devices from the Awair Cloud.")
+		}
+		for _, t := range cloudTargets {
+			if err := addCloudDevice(t); err != nil && !errors.Is(err, api.ErrNotOwned) {
+				log.Fatal().Err(err).Msg("Invalid devices in -config.file.")
+			}
+		}
+		if cloudInventory != nil {
+			var update cloud.UpdateFunc
+			if *cloudAddDevices {
+				// Devices of the account not among the fleet's are
+				// added under their device UUID.
+				update = addCloudAccount(fleet, inv, addCloudDevice)
+			}
+			reg.MustRegister(cloudInventory)
+			devices, err := cloudInventory.Sync(ctx)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to sync devices of the Awair Cloud account.")
+			} else if update != nil {
+				update(devices)
+			}
+			go cloudInventory.Run(ctx, *cloudSyncInterval, update)
+		}
+		restoreDevices(ctx, registry, fleet, inv, addDevice)
+		if *discoveryCIDR != "" {
+			prefixes, err := discovery.ParsePrefixes(*discoveryCIDR)
+			if err != nil {
+				log.Fatal().Err(err).Msg("Failed to parse -discovery.cidr.")
+			}
+			scanner := discovery.NewScanner(prefixes, *discoveryRate, 2*time.Second,
+				func(hostname string, _ *exporter.ConfigResponse) {
+					err := addDevice(inv.target(hostname, hostname).from("scan"))
+					if err != nil && !errors.Is(err, api.ErrNotOwned) {
+						log.Error().Err(err).
+							Str("hostname", hostname).
+							Msg("Failed to add discovered device.")
+						return
+					}
+					if err == nil {
+						if err := registry.Add(hostname, hostname); err != nil {
+							log.Error().Err(err).Str("hostname", hostname).Msg("Failed to record device in state file.")
+						}
+					}
+				})
+			filter, err := discovery.NewFilter(cfg.Discovery)
+			if err != nil {
+				log.Fatal().Err(err).Msg("Invalid discovery filter in -config.file.")
+			}
+			scanner.SetFilter(filter)
+			static := map[string]bool{}
+			for _, t := range targets {
+				scanner.Known(t.hostname)
+				static[t.hostname] = true
+			}
+			for _, d := range registry.Devices() {
+				scanner.Known(d.Hostname)
+			}
+			reg.MustRegister(scanner)
+			go scanner.Run(ctx, *discoveryInterval)
+			if *discoveryExpire > 0 {
+				go expireScanned(ctx, fleet, scanner, registry, static, *discoveryExpire, *discoveryInterval)
+			}
+		}
+		if *consulURL != "" {
+			consul := newCatalogSync("Consul", inv, fleet, addDevice)
+			watch, err := discovery.NewConsul(*consulURL, *consulService, os.Getenv("CONSUL_HTTP_TOKEN"), splitList(*consulLabels),
+				func(found []discovery.Target) { consul.reconcile(found) })
+			if err != nil {
+				log.Fatal().Err(err).Msg("Failed to configure -consul.url.")
+			}
+			reg.MustRegister(watch)
+			go watch.Run(ctx)
+		}
+		if *kubernetes {
+			crd := newCatalogSync("Kubernetes", inv, fleet, addDevice)
+			watch, err := discovery.NewKubernetesInCluster(*kubernetesNamespace, splitList(*kubernetesLabels), crd.reconcile)
+			if err != nil {
+				log.Fatal().Err(err).Msg("Failed to configure -kubernetes.")
+			}
+			reg.MustRegister(watch)
+			go watch.Run(ctx)
+		}
+		if *ingest {
+			if *ingestToken == "" {
+				log.Fatal().Msg("-ingest requires -ingest.token.")
+			}
+			var transform api.Transform
+			if *ingestTransform != "" {
+				if transform, err = api.LoadTransform(*ingestTransform); err != nil {
+					log.Fatal().Err(err).Msg("Failed to load -ingest.transform.")
+				}
+			}
+			routes.handle("ingest", "/api/v1/ingest/", api.NewIngestHandler("/api/v1/ingest/", *ingestToken, transform,
+				func(name string, values *exporter.AwairValues, config *exporter.ConfigResponse) error {
+					ingestOpts := append(inv.target(name, name).opts,
+						exporter.WithPublisher(sinkManager),
+						exporter.WithRecoverer(recoverer),
+					)
+					return fleet.Ingest(name, values, config, ingestOpts...)
+				}))
+		}
+		if *adminToken != "" {
+			routes.handle("admin", "/api/v1/devices", api.NewDevicesHandler("/api/v1/devices", *adminToken,
+				fleetDevices{Fleet: fleet, inv: inv, add: addDevice, registry: registry}))
+		}
+		if *publicPage || *kiosk {
+			fields, err := public.ParseMetrics(*publicMetrics)
+			if err != nil {
+				log.Fatal().Err(err).Msg("Failed to parse -public.metrics.")
+			}
+			if *publicPage {
+				var hist public.HistorySource
+				if *publicPrometheus != "" {
+					cfg.Prometheus.URL = *publicPrometheus
+				}
+				if cfg.Prometheus.URL != "" {
+					client, err := promquery.New(cfg.Prometheus)
+					if err != nil {
+						log.Fatal().Err(err).Msg("Failed to configure the Prometheus server for /public.")
+					}
+					hist = public.NewPrometheusHistory(client, *publicTrendWindow, *publicTrendWindow/96)
+				}
+				routes.handle("public", "/public", public.NewHandler(fleet, fields, hist, displayUnits))
+			}
+			if *kiosk {
+				trends := public.NewTrends(fleet, *kioskWindow)
+				go trends.Run(ctx, time.Minute)
+				routes.handle("public", "/kiosk", public.NewKioskHandler(fleet, trends, fields, displayUnits))
+			}
+		}
+		if *reachabilityInterval > 0 {
+			prober, err := reachability.New(fleet, *reachabilityMethod, *reachabilityTimeout)
+			if err != nil {
+				log.Fatal().Err(err).Msg("Invalid -reachability.method.")
+			}
+			reg.MustRegister(prober)
+			go prober.Run(ctx, *reachabilityInterval)
+		}
+		if *driftDays > 0 {
+			detector, err := drift.New(fleet, store, zones, *driftDays, *driftTolerance)
+			if err != nil {
+				log.Fatal().Err(err).Str("file", *stateFile).Msg("Failed to load drift detection history from state file.")
+			}
+			reg.MustRegister(detector)
+			go detector.Run(ctx, time.Minute)
+		}
+		if *baselineNight != "" {
+			night, err := schedule.ParseWindow(*baselineNight)
+			if err != nil {
+				log.Fatal().Err(err).Msg("Failed to parse -baseline.night.")
+			}
+			baseline, err := drift.NewBaseline(fleet, store, night, zones)
+			if err != nil {
+				log.Fatal().Err(err).Str("file", *stateFile).Msg("Failed to load overnight CO2 baselines from state file.")
+			}
+			reg.MustRegister(baseline)
+			go baseline.Run(ctx, time.Minute)
+		}
+		if *digestWebhook != "" || *digestSheet != "" {
+			targets := []digest.Target{}
+			if *digestWebhook != "" {
+				targets = append(targets, digest.NewWebhook(*digestWebhook))
+			}
+			if *digestSheet != "" {
+				sheet, err := digest.NewSheet(*digestSheet, *digestSheetRange, os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"))
+				if err != nil {
+					log.Fatal().Err(err).Msg("Failed to load the service account key for -digest.sheet.")
+				}
+				targets = append(targets, sheet)
+			}
+			cron, err := schedule.ParseCron(*digestSchedule)
+			if err != nil {
+				log.Fatal().Err(err).Msg("Failed to parse -digest.schedule.")
+			}
+			d := digest.New(fleet, history.DefaultThresholds, targets...)
+			reg.MustRegister(d)
+			scheduler.Add("digest", cron, time.Local, d.Cut)
+			go d.Run(ctx, time.Minute)
+		}
+		if len(cfg.References) > 0 {
+			comparator, err := reference.New(fleet, cfg.References)
+			if err != nil {
+				log.Fatal().Err(err).Msg("Invalid references in -config.file.")
+			}
+			reg.MustRegister(comparator)
+			go comparator.Run(ctx, 30*time.Second)
+		}
+		if len(cfg.Compliance.Standards) > 0 {
+			tracker, err := compliance.New(fleet, cfg.Compliance)
+			if err != nil {
+				log.Fatal().Err(err).Msg("Invalid compliance in -config.file.")
+			}
+			reg.MustRegister(tracker)
+			go tracker.Run(ctx, time.Minute)
+		}
+		if len(cfg.Zones) > 0 {
+			ranker, err := ventilation.New(fleet, cfg.Zones)
+			if err != nil {
+				log.Fatal().Err(err).Msg("Invalid zones in -config.file.")
+			}
+			reg.MustRegister(ranker)
+			go ranker.Run(ctx, 5*time.Minute)
+		}
+		if len(cfg.Controllers) > 0 {
+			controller, err := control.New(fleet, cfg.Controllers, cfg.Zones)
+			if err != nil {
+				log.Fatal().Err(err).Msg("Invalid controllers in -config.file.")
+			}
+			reg.MustRegister(controller)
+			go controller.Run(ctx, 30*time.Second)
+		}
+		if len(cfg.Triggers) > 0 {
+			triggers, err := trigger.New(fleet, cfg.Triggers)
+			if err != nil {
+				log.Fatal().Err(err).Msg("Invalid triggers in -config.file.")
+			}
+			reg.MustRegister(triggers)
+			go triggers.Run(ctx, 30*time.Second)
+		}
+		if *esphomeListen != "" {
+			node, err := esphome.New(*esphomeName, "Awair Exporter", version, fleet)
+			if err != nil {
+				log.Fatal().Err(err).Msg("Invalid -esphome.name.")
+			}
+			ln, err := net.Listen("tcp", *esphomeListen)
+			if err != nil {
+				log.Fatal().Err(err).Str("address", *esphomeListen).Msg("Failed to listen for the ESPHome native API.")
+			}
+			reg.MustRegister(node)
+			go node.Run(ctx, 30*time.Second)
+			go func() {
+				if err := node.Serve(ctx, ln); err != nil {
+					log.Error().Err(err).Msg("ESPHome native API server stopped.")
+				}
+			}()
+			go func() {
+				if err := node.Advertise(ctx, ln.Addr().(*net.TCPAddr).Port); err != nil {
+					log.Error().Err(err).Msg("Failed to advertise the ESPHome native API by mDNS.")
+				}
+			}()
+		}
+		reg.MustRegister(fleet, sinkManager)
+		routes.handle("api", "/api/v1/sd", api.NewServiceDiscoveryHandler(fleet))
+		routes.handle("api", "/api/v1/metadata", api.NewMetadataHandler(reg))
+		routes.handle("api", "/api/v1/readings", exposition.NewConditionalHandler(fleet, api.NewReadingsHandler(fleet, displayUnits)))
+		metricsHandler = exposition.NewConditionalHandler(fleet, profileHandler)
+		if *probe {
+			routes.handle("metrics", "/probe", exposition.NewProbeHandler(
+				func(ctx context.Context, target string) (prometheus.Collector, error) {
+					probeOpts := append([]exporter.Option{
+						exporter.WithStrictMode(*strict),
+						exporter.WithRecoverer(recoverer),
+					}, inv.target(target, target).opts...)
+					return exporter.Probe(ctx, target, probeOpts...)
+				}))
+		}
+		if *deviceMetrics {
+			routes.handle("metrics", "/metrics/device/", exposition.NewDeviceHandler("/metrics/device/", fleet))
+		}
+	}
 	if *goCollector {
 		reg.MustRegister(collectors.NewGoCollector())
 	}
 	if *processCollector {
 		reg.MustRegister(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
 	}
-	router := http.NewServeMux()
-	router.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
-	router.Handle("/healthz", newHealthCheckHandler())
-	srv.Addr = ":8080"
-	srv.Handler = router
-	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
-		log.Fatal().Err(err).Msg("Failed to start HTTP Server")
+	if metricsHandler == nil {
+		metricsHandler = profileHandler
+	}
+	routes.handle("metrics", "/metrics", metricsHandler)
+	for i, l := range listeners {
+		srv := servers[i]
+		srv.Handler = trustedProxies.Handler(recoverer.Handler("http", allowlist.Handler(routes.mux(l.features), "/healthz")))
+		go func(l listener) {
+			log.Info().
+				Str("addr", l.addr).
+				Strs("features", featureList(l.features)).
+				Msg("Listening.")
+			if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+				log.Fatal().Err(err).Str("addr", l.addr).Msg("Failed to start HTTP Server")
+			}
+		}(l)
 	}
 	<-idleConnsClosed
+	<-sinksDone
 }
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"prometheus-awair-exporter/internal/cloud"
+	"prometheus-awair-exporter/internal/exporter"
+	"prometheus-awair-exporter/internal/history"
+	"prometheus-awair-exporter/internal/remotewrite"
+
+	"github.com/rs/zerolog/log"
+)
+
+// backfillCommand fills Prometheus' data with the history of a device, from
+// CSV exports or the Awair Cloud, e.g. after an outage of the exporter or
+// for the months before it was deployed. The series are pushed to a remote
+// write endpoint, or converted into the OpenMetrics format promtool builds
+// TSDB blocks from.
+func backfillCommand() *command {
+	c := newCommand("backfill", "Backfills the history of a device from CSV exports or the Awair Cloud, into a remote write endpoint or OpenMetrics for promtool tsdb create-blocks-from openmetrics.", "-device <uuid> [-out file | -remote-write url] (-from time [-to time] | <csv-file>...)")
+	device := c.flags.String("device", "", "device UUID the history belongs to, e.g. awair-element_1234")
+	out := c.flags.String("out", "", "file to write OpenMetrics to (default stdout)")
+	remoteWrite := c.flags.String("remote-write", "", "URL of a Prometheus remote write endpoint to push the history to instead, e.g. http://prometheus:9090/api/v1/write")
+	from := c.flags.String("from", "", "start of the history to take from the Awair Cloud instead of CSV files, as RFC 3339 or a date, e.g. 2024-01-01")
+	to := c.flags.String("to", "", "end of the history taken from the Awair Cloud (default now)")
+	data := c.flags.String("data", cloud.Data5MinAvg, "readings taken from the Awair Cloud: raw, 5-min-avg or 15-min-avg")
+	cloudURL := c.flags.String("cloud.url", cloud.DefaultURL, "base URL of the Awair developer API, authenticated by the access token in AWAIR_CLOUD_TOKEN")
+	cloudTokenURL := c.flags.String("cloud.token-url", "", "OAuth2 token endpoint issuing the access tokens of the Awair developer API instead of AWAIR_CLOUD_TOKEN, to the client in AWAIR_CLOUD_CLIENT_ID and AWAIR_CLOUD_CLIENT_SECRET, by the refresh token in AWAIR_CLOUD_REFRESH_TOKEN if set")
+	c.run = func(args []string) {
+		c.flags.Parse(args)
+		if *device == "" || (*from == "") == (c.flags.NArg() == 0) {
+			c.flags.Usage()
+			os.Exit(2)
+		}
+		var w backfillWriter
+		if *remoteWrite != "" {
+			w = &remoteWriter{client: remotewrite.New(*remoteWrite), device: *device}
+		} else {
+			w = &openMetricsWriter{out: *out}
+		}
+		if *from == "" {
+			readings, fields := readHistoryFiles(c.flags.Args())
+			if err := w.write(context.Background(), readings, fields); err != nil {
+				log.Fatal().Err(err).Msg("Failed to backfill.")
+			}
+			if err := w.close(*device); err != nil {
+				log.Fatal().Err(err).Msg("Failed to backfill.")
+			}
+			return
+		}
+
+		start, err := parseBackfillTime(*from)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Invalid -from.")
+		}
+		end := time.Now()
+		if *to != "" {
+			if end, err = parseBackfillTime(*to); err != nil {
+				log.Fatal().Err(err).Msg("Invalid -to.")
+			}
+		}
+		if !end.After(start) {
+			log.Fatal().Msg("-to must be after -from.")
+		}
+		if _, err := cloud.HistorySpan(*data); err != nil {
+			log.Fatal().Err(err).Msg("Invalid -data.")
+		}
+		var tokens cloud.TokenSource
+		if *cloudTokenURL != "" {
+			tokens = cloud.NewOAuth2(*cloudTokenURL, os.Getenv("AWAIR_CLOUD_CLIENT_ID"), os.Getenv("AWAIR_CLOUD_CLIENT_SECRET"), os.Getenv("AWAIR_CLOUD_REFRESH_TOKEN"))
+		} else if token := os.Getenv("AWAIR_CLOUD_TOKEN"); token != "" {
+			tokens = cloud.StaticToken(token)
+		} else {
+			log.Fatal().Msg("AWAIR_CLOUD_TOKEN or -cloud.token-url must be set to backfill from the Awair Cloud.")
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		fetchErr := backfillCloud(ctx, cloud.New(*cloudURL, tokens), w, *device, *data, start, end)
+		if err := w.close(*device); err != nil {
+			log.Fatal().Err(err).Msg("Failed to backfill.")
+		}
+		if fetchErr != nil {
+			os.Exit(1)
+		}
+	}
+	return c
+}
+
+// parseBackfillTime parses a time given as RFC 3339 or a date in UTC.
+func parseBackfillTime(s string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// backfillCloud takes the history of data of the device from start until
+// end from the cloud, as many requests as the API needs, handing each
+// response to w. A failure, e.g. once the daily quota of the API is used
+// up, is logged with the time to resume from.
+func backfillCloud(ctx context.Context, client *cloud.Client, w backfillWriter, device, data string, start, end time.Time) error {
+	span, err := cloud.HistorySpan(data)
+	if err != nil {
+		return err
+	}
+	total := 0
+	for from := start; from.Before(end); from = from.Add(span) {
+		to := from.Add(span)
+		if to.After(end) {
+			to = end
+		}
+		readings, fields, err := client.History(ctx, device, data, from, to)
+		if err == nil {
+			err = w.write(ctx, readings, fields)
+		}
+		if err != nil {
+			log.Error().Err(err).Str("from", from.Format(time.RFC3339)).Msg("Failed to backfill, resume with this -from.")
+			return err
+		}
+		total += len(readings)
+		log.Info().Str("until", to.Format(time.RFC3339)).Int("readings", total).Msg("Backfilled.")
+	}
+	return nil
+}
+
+// backfillWriter is the output of the backfill command.
+type backfillWriter interface {
+	// write adds readings with the given fields present.
+	write(ctx context.Context, readings []exporter.AwairValues, fields []string) error
+	// close finishes the output of the device's history.
+	close(device string) error
+}
+
+// remoteWriter pushes each batch of readings to a remote write endpoint
+// right away, so a backfill failing part way keeps the history before.
+type remoteWriter struct {
+	client *remotewrite.Client
+	device string
+}
+
+func (r *remoteWriter) write(ctx context.Context, readings []exporter.AwairValues, fields []string) error {
+	if len(readings) == 0 {
+		return nil
+	}
+	series, err := history.RemoteWriteSeries(r.device, readings, fields)
+	if err != nil {
+		return err
+	}
+	return r.client.Write(ctx, series)
+}
+
+func (r *remoteWriter) close(string) error {
+	return nil
+}
+
+// openMetricsWriter collects the readings, as OpenMetrics lists the samples
+// of each series together, and writes them to out, or stdout, on close.
+type openMetricsWriter struct {
+	out      string
+	readings []exporter.AwairValues
+	fields   []string
+	seen     map[string]bool
+}
+
+func (o *openMetricsWriter) write(_ context.Context, readings []exporter.AwairValues, fields []string) error {
+	if o.seen == nil {
+		o.seen = map[string]bool{}
+	}
+	for _, field := range fields {
+		if !o.seen[field] {
+			o.seen[field] = true
+			o.fields = append(o.fields, field)
+		}
+	}
+	o.readings = append(o.readings, readings...)
+	return nil
+}
+
+func (o *openMetricsWriter) close(device string) error {
+	var w io.WriteCloser = os.Stdout
+	if o.out != "" {
+		f, err := os.Create(o.out)
+		if err != nil {
+			return err
+		}
+		w = f
+	}
+	if err := history.WriteOpenMetrics(w, device, o.readings, o.fields); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// readHistoryFiles reads the readings of CSV exports and the names of the
+// fields present in any of them.
+func readHistoryFiles(files []string) ([]exporter.AwairValues, []string) {
+	readings := []exporter.AwairValues{}
+	fields := []string{}
+	seen := map[string]bool{}
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			log.Fatal().Err(err).Str("file", file).Msg("Failed to open history file.")
+		}
+		values, present, err := history.ReadCSV(f)
+		f.Close()
+		if err != nil {
+			log.Fatal().Err(err).Str("file", file).Msg("Failed to read history file.")
+		}
+		for _, field := range present {
+			if !seen[field] {
+				seen[field] = true
+				fields = append(fields, field)
+			}
+		}
+		readings = append(readings, values...)
+	}
+	return readings, fields
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// command is a subcommand of the exporter binary.
+type command struct {
+	flags       *flag.FlagSet
+	description string
+	usage       string
+	// arguments are offered as completions besides the flags.
+	arguments []string
+	run       func(args []string)
+}
+
+func (c *command) name() string {
+	return c.flags.Name()
+}
+
+// newCommand creates a subcommand whose flags are defined on the returned
+// command's FlagSet before run is set.
+func newCommand(name, description, usage string) *command {
+	c := &command{
+		flags:       flag.NewFlagSet(name, flag.ExitOnError),
+		description: description,
+		usage:       usage,
+	}
+	c.flags.Usage = func() {
+		fmt.Fprintf(c.flags.Output(), "%s\n\nUsage: %s %s %s\n", description, app_name, name, usage)
+		c.flags.PrintDefaults()
+	}
+	return c
+}
+
+// subcommands returns every subcommand, keyed by name.
+func subcommands() map[string]*command {
+	commands := map[string]*command{}
+	for _, c := range []*command{
+		replayCommand(),
+		importCommand(),
+		backfillCommand(),
+		reportCommand(),
+		provisionCommand(),
+		auditCommand(),
+		topCommand(),
+	} {
+		commands[c.name()] = c
+	}
+	completion := completionCommand(commands)
+	commands[completion.name()] = completion
+	return commands
+}
+
+// outputFlag adds the -output flag shared by subcommands producing reports.
+func outputFlag(fs *flag.FlagSet) *string {
+	return fs.String("output", "table", "output format, table or json")
+}
+
+// writeOutput writes v as indented JSON, or calls table for the human
+// readable format.
+func writeOutput(w io.Writer, format string, v interface{}, table func(w io.Writer)) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	case "table":
+		table(w)
+		return nil
+	default:
+		return fmt.Errorf("unknown output format %q, expected table or json", format)
+	}
+}
+
+func completionCommand(commands map[string]*command) *command {
+	c := newCommand("completion", "Generates a shell completion script.", "bash|zsh|fish")
+	c.arguments = []string{"bash", "zsh", "fish"}
+	c.run = func(args []string) {
+		c.flags.Parse(args)
+		if c.flags.NArg() != 1 {
+			c.flags.Usage()
+			os.Exit(2)
+		}
+		switch c.flags.Arg(0) {
+		case "bash":
+			fmt.Print(bashCompletion(commands))
+		case "zsh":
+			fmt.Print("autoload -U +X bashcompinit && bashcompinit\n" + bashCompletion(commands))
+		case "fish":
+			fmt.Print(fishCompletion(commands))
+		default:
+			c.flags.Usage()
+			os.Exit(2)
+		}
+	}
+	return c
+}
+
+func flagNames(fs *flag.FlagSet) []string {
+	names := []string{}
+	fs.VisitAll(func(f *flag.Flag) {
+		names = append(names, "-"+f.Name)
+	})
+	return names
+}
+
+func sortedCommands(commands map[string]*command) []*command {
+	sorted := make([]*command, 0, len(commands))
+	for _, c := range commands {
+		sorted = append(sorted, c)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].name() < sorted[j].name()
+	})
+	return sorted
+}
+
+func bashCompletion(commands map[string]*command) string {
+	b := &strings.Builder{}
+	names := []string{}
+	for _, c := range sortedCommands(commands) {
+		names = append(names, c.name())
+	}
+	fmt.Fprintf(b, "_%s() {\n", strings.ReplaceAll(app_name, "-", "_"))
+	fmt.Fprintf(b, "    local cur=\"${COMP_WORDS[COMP_CWORD]}\"\n")
+	fmt.Fprintf(b, "    case \"${COMP_WORDS[1]}\" in\n")
+	for _, c := range sortedCommands(commands) {
+		fmt.Fprintf(b, "    %s)\n        COMPREPLY=($(compgen -W %q -- \"$cur\")) ;;\n", c.name(), strings.Join(append(c.arguments, flagNames(c.flags)...), " "))
+	}
+	fmt.Fprintf(b, "    *)\n        COMPREPLY=($(compgen -W %q -- \"$cur\")) ;;\n", strings.Join(append(names, flagNames(flag.CommandLine)...), " "))
+	fmt.Fprintf(b, "    esac\n}\n")
+	fmt.Fprintf(b, "complete -F _%s %s\n", strings.ReplaceAll(app_name, "-", "_"), app_name)
+	return b.String()
+}
+
+func fishCompletion(commands map[string]*command) string {
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "complete -c %s -f\n", app_name)
+	flag.CommandLine.VisitAll(func(f *flag.Flag) {
+		fmt.Fprintf(b, "complete -c %s -n '__fish_use_subcommand' -o %s -d %q\n", app_name, f.Name, f.Usage)
+	})
+	for _, c := range sortedCommands(commands) {
+		fmt.Fprintf(b, "complete -c %s -n '__fish_use_subcommand' -a %s -d %q\n", app_name, c.name(), c.description)
+		for _, arg := range c.arguments {
+			fmt.Fprintf(b, "complete -c %s -n '__fish_seen_subcommand_from %s' -a %s\n", app_name, c.name(), arg)
+		}
+		c.flags.VisitAll(func(f *flag.Flag) {
+			fmt.Fprintf(b, "complete -c %s -n '__fish_seen_subcommand_from %s' -o %s -d %q\n", app_name, c.name(), f.Name, f.Usage)
+		})
+	}
+	return b.String()
+}
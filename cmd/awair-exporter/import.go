@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"time"
+
+	"prometheus-awair-exporter/internal/exporter"
+	"prometheus-awair-exporter/internal/history"
+	"prometheus-awair-exporter/internal/sink"
+
+	"github.com/rs/zerolog/log"
+)
+
+// importBatchSize is the number of records delivered to the sink at once.
+const importBatchSize = 1000
+
+// importSummary is the result of an import.
+type importSummary struct {
+	Files    int `json:"files"`
+	Imported int `json:"imported"`
+}
+
+// importCommand loads the history exported from the Awair cloud dashboard
+// into a sink, so it can be analysed alongside the readings the exporter
+// publishes.
+func importCommand() *command {
+	c := newCommand("import", "Loads Awair cloud history CSV exports into a sink.", "-sink kind[:key=value,...] -device <uuid> <csv-file>...")
+	sinkDef := c.flags.String("sink", "", "sink to load the history into, kind[:key=value,...]")
+	device := c.flags.String("device", "", "device UUID the history belongs to, e.g. awair-element_1234")
+	output := outputFlag(c.flags)
+	c.run = func(args []string) {
+		c.flags.Parse(args)
+		if *sinkDef == "" || *device == "" || c.flags.NArg() == 0 {
+			c.flags.Usage()
+			os.Exit(2)
+		}
+		runImport(*sinkDef, *device, c.flags.Args(), *output)
+	}
+	return c
+}
+
+func runImport(sinkDef, device string, files []string, output string) {
+	cfg, err := sink.ParseConfig(sinkDef)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to parse sink.")
+	}
+	s, err := sink.New(cfg)
+	if err != nil {
+		log.Fatal().Err(err).Str("sink", cfg.Name).Msg("Failed to configure sink.")
+	}
+
+	summary := importSummary{}
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			log.Fatal().Err(err).Str("file", file).Msg("Failed to open history file.")
+		}
+		values, fields, err := history.ReadCSV(f)
+		f.Close()
+		if err != nil {
+			log.Fatal().Err(err).Str("file", file).Msg("Failed to read history file.")
+		}
+
+		// Only deliver the columns present in the export, rather than
+		// zeroes for the sensors it lacks.
+		filter := cfg.Filter
+		if filter.Metrics == nil {
+			filter.Metrics = map[string]bool{}
+			for _, field := range fields {
+				filter.Metrics[field] = true
+			}
+		}
+		config := &exporter.ConfigResponse{DeviceUUID: device}
+		readings := make([]exporter.Reading, len(values))
+		for i := range values {
+			readings[i] = exporter.Reading{Config: config, Values: &values[i]}
+		}
+		records := filter.Apply(readings)
+		for start := 0; start < len(records); start += importBatchSize {
+			end := start + importBatchSize
+			if end > len(records) {
+				end = len(records)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			err := s.Write(ctx, records[start:end])
+			cancel()
+			if err != nil {
+				log.Fatal().Err(err).
+					Str("file", file).
+					Int("imported", summary.Imported).
+					Msg("Failed to deliver history to sink.")
+			}
+			summary.Imported += end - start
+		}
+		summary.Files++
+	}
+
+	err = writeOutput(os.Stdout, output, summary, func(io.Writer) {
+		log.Info().
+			Int("files", summary.Files).
+			Int("imported", summary.Imported).
+			Msg("Import finished.")
+	})
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to write output.")
+	}
+}
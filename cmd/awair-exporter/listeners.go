@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// webFeatures are the groups of endpoints a listener can serve: metrics
+// serves /metrics and /metrics/device/<name>, api the JSON API, ingest the
+// push ingestion endpoint, admin the admin API and public the status page
+// and kiosk endpoint. /healthz is served by every listener.
+var webFeatures = []string{"admin", "api", "ingest", "metrics", "public"}
+
+func allFeatures() map[string]bool {
+	features := map[string]bool{}
+	for _, feature := range webFeatures {
+		features[feature] = true
+	}
+	return features
+}
+
+// listener is an address the exporter serves a subset of its features on.
+type listener struct {
+	addr     string
+	features map[string]bool
+}
+
+// parseListener parses addr[=feature,...]. Without features, all are
+// served.
+func parseListener(s string) (listener, error) {
+	addr, list, ok := strings.Cut(s, "=")
+	l := listener{addr: addr, features: map[string]bool{}}
+	if !ok {
+		l.features = allFeatures()
+		return l, nil
+	}
+	for _, feature := range strings.Split(list, ",") {
+		feature = strings.TrimSpace(feature)
+		if !allFeatures()[feature] {
+			return listener{}, fmt.Errorf("unknown feature %q for listener %s (available: %s)", feature, addr, strings.Join(webFeatures, ", "))
+		}
+		l.features[feature] = true
+	}
+	return l, nil
+}
+
+func featureList(features map[string]bool) []string {
+	list := []string{}
+	for feature := range features {
+		list = append(list, feature)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// routes holds the handlers of each feature by path.
+type routes map[string]map[string]http.Handler
+
+func (r routes) handle(feature, path string, h http.Handler) {
+	if r[feature] == nil {
+		r[feature] = map[string]http.Handler{}
+	}
+	r[feature][path] = h
+}
+
+// mux serves the handlers of the given features and /healthz.
+func (r routes) mux(features map[string]bool) *http.ServeMux {
+	mux := http.NewServeMux()
+	for feature := range features {
+		for path, h := range r[feature] {
+			mux.Handle(path, h)
+		}
+	}
+	mux.Handle("/healthz", newHealthCheckHandler())
+	return mux
+}
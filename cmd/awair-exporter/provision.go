@@ -0,0 +1,108 @@
+package main
+
+import (
+	"io"
+	"os"
+	"strings"
+
+	"prometheus-awair-exporter/internal/config"
+	"prometheus-awair-exporter/internal/exporter"
+
+	"github.com/rs/zerolog/log"
+)
+
+// provisionedDevice is the result of provisioning a single device.
+type provisionedDevice struct {
+	Name       string   `json:"name"`
+	Hostname   string   `json:"hostname"`
+	DeviceUUID string   `json:"device_uuid,omitempty"`
+	Error      string   `json:"error,omitempty"`
+	Replaced   bool     `json:"replaced"`
+	Deviations []string `json:"deviations,omitempty"`
+}
+
+// provisionCommand verifies Local API access to new devices and records
+// them in the configuration file. Devices are given as name=hostname, or
+// just a hostname in which case the device UUID is used as name.
+func provisionCommand() *command {
+	c := newCommand("provision", "Verifies Local API access to devices and records them in the configuration file.", "[flags] [name=]hostname...")
+	configFile := c.flags.String("config.file", "awair.yaml", "configuration file the devices are written to")
+	display := c.flags.String("profile.display", "", "expected display mode, devices deviating are reported")
+	ledMode := c.flags.String("profile.led.mode", "", "expected LED mode, devices deviating are reported")
+	timezone := c.flags.String("profile.timezone", "", "expected timezone, devices deviating are reported")
+	output := outputFlag(c.flags)
+	c.run = func(args []string) {
+		c.flags.Parse(args)
+		if c.flags.NArg() == 0 {
+			c.flags.Usage()
+			os.Exit(2)
+		}
+		profile := config.Baseline{
+			Display:  *display,
+			LEDMode:  *ledMode,
+			Timezone: *timezone,
+		}
+		runProvision(*configFile, profile, c.flags.Args(), *output)
+	}
+	return c
+}
+
+func runProvision(configFile string, profile config.Baseline, hosts []string, output string) {
+	cfg, err := config.Load(configFile)
+	if err != nil {
+		log.Fatal().Err(err).Str("file", configFile).Msg("Failed to load configuration file.")
+	}
+
+	failed := 0
+	results := []provisionedDevice{}
+	for _, arg := range hosts {
+		name, hostname, ok := strings.Cut(arg, "=")
+		if !ok {
+			name, hostname = "", arg
+		}
+		logger := log.With().Str("hostname", hostname).Logger()
+
+		device, err := exporter.GetDeviceConfig(hostname)
+		if err != nil {
+			logger.Error().Err(err).Msg("Local API is not reachable, is it enabled in the Awair app?")
+			results = append(results, provisionedDevice{Name: name, Hostname: hostname, Error: err.Error()})
+			failed++
+			continue
+		}
+		if name == "" {
+			name = device.DeviceUUID
+		}
+		logger = logger.With().Str("name", name).Str("device_uuid", device.DeviceUUID).Logger()
+
+		deviating := deviations(profile, device)
+		for _, setting := range deviating {
+			logger.Warn().
+				Str("setting", setting).
+				Msg("Device deviates from profile, update it in the Awair app.")
+		}
+
+		replaced := cfg.AddDevice(config.Device{
+			Name:       name,
+			Hostname:   hostname,
+			DeviceUUID: device.DeviceUUID,
+		})
+		logger.Info().Bool("replaced", replaced).Msg("Provisioned device.")
+		results = append(results, provisionedDevice{
+			Name:       name,
+			Hostname:   hostname,
+			DeviceUUID: device.DeviceUUID,
+			Replaced:   replaced,
+			Deviations: deviating,
+		})
+	}
+
+	if err := cfg.Save(configFile); err != nil {
+		log.Fatal().Err(err).Str("file", configFile).Msg("Failed to write configuration file.")
+	}
+	if err := writeOutput(os.Stdout, output, results, func(io.Writer) {}); err != nil {
+		log.Fatal().Err(err).Msg("Failed to write output.")
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"time"
+
+	"prometheus-awair-exporter/internal/sink"
+
+	"github.com/rs/zerolog/log"
+)
+
+// replaySummary is the result of a replay.
+type replaySummary struct {
+	Replayed  int `json:"replayed"`
+	Remaining int `json:"remaining"`
+}
+
+// replayCommand re-delivers the batches recorded in a dead-letter file.
+// Batches which fail again are kept in the file for a later attempt.
+func replayCommand() *command {
+	c := newCommand("replay", "Re-delivers the batches recorded in a sink dead-letter file.", "-sink kind[:key=value,...] <dead-letter-file>")
+	sinkDef := c.flags.String("sink", "", "sink to deliver the batches to, kind[:key=value,...]")
+	output := outputFlag(c.flags)
+	c.run = func(args []string) {
+		c.flags.Parse(args)
+		if *sinkDef == "" || c.flags.NArg() != 1 {
+			c.flags.Usage()
+			os.Exit(2)
+		}
+		runReplay(*sinkDef, c.flags.Arg(0), *output)
+	}
+	return c
+}
+
+func runReplay(sinkDef, file, output string) {
+
+	cfg, err := sink.ParseConfig(sinkDef)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to parse sink.")
+	}
+	s, err := sink.New(cfg)
+	if err != nil {
+		log.Fatal().Err(err).Str("sink", cfg.Name).Msg("Failed to configure sink.")
+	}
+	letters, err := sink.ReadDeadLetters(file)
+	if err != nil {
+		log.Fatal().Err(err).Str("file", file).Msg("Failed to read dead-letter file.")
+	}
+
+	remaining := []sink.DeadLetter{}
+	for _, d := range letters {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err := s.Write(ctx, d.Records)
+		cancel()
+		if err != nil {
+			log.Error().Err(err).
+				Time("failed_at", d.FailedAt).
+				Msg("Failed to replay batch")
+			d.Error = err.Error()
+			remaining = append(remaining, d)
+		}
+	}
+	if err := sink.WriteDeadLetters(file, remaining); err != nil {
+		log.Fatal().Err(err).Str("file", file).Msg("Failed to update dead-letter file.")
+	}
+	summary := replaySummary{
+		Replayed:  len(letters) - len(remaining),
+		Remaining: len(remaining),
+	}
+	err = writeOutput(os.Stdout, output, summary, func(io.Writer) {
+		log.Info().
+			Int("replayed", summary.Replayed).
+			Int("remaining", summary.Remaining).
+			Msg("Replay finished.")
+	})
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to write output.")
+	}
+	if len(remaining) > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"prometheus-awair-exporter/internal/exporter"
+	"prometheus-awair-exporter/internal/history"
+	"prometheus-awair-exporter/internal/units"
+
+	"github.com/rs/zerolog/log"
+)
+
+// reportCommand summarizes history CSV exports per device or zone, for
+// weekly digests run from cron rather than dashboards.
+func reportCommand() *command {
+	c := newCommand("report", "Summarizes history CSV exports per device or zone as an HTML report.", "[flags] [name=]<csv-file>...")
+	title := c.flags.String("title", "Air quality report", "title of the report")
+	format := c.flags.String("format", "html", "report format, html or json")
+	out := c.flags.String("out", "", "file to write the report to (default stdout unless sent)")
+	co2 := c.flags.Float64("co2.threshold", history.DefaultThresholds.CO2, "CO2 level (ppm) above which hours are counted")
+	pm25 := c.flags.Float64("pm25.threshold", history.DefaultThresholds.PM25, "PM2.5 level (µg/m³) above which hours are counted")
+	unitsFlag := c.flags.String("units", string(units.Metric), "units of the report, metric or imperial")
+	postURL := c.flags.String("post", "", "URL to POST the report to")
+	smtpAddr := c.flags.String("mail.smtp", "", "SMTP server host:port to email the report through")
+	mailFrom := c.flags.String("mail.from", "", "sender of the report email")
+	mailTo := c.flags.String("mail.to", "", "comma separated list of report email recipients")
+	mailUser := c.flags.String("mail.user", "", "SMTP user, authenticating with the password in SMTP_PASSWORD")
+	c.run = func(args []string) {
+		c.flags.Parse(args)
+		if c.flags.NArg() == 0 || (*smtpAddr != "") != (*mailTo != "") {
+			c.flags.Usage()
+			os.Exit(2)
+		}
+		u, err := units.Parse(*unitsFlag)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Invalid -units.")
+		}
+		thresholds := history.Thresholds{CO2: *co2, PM25: *pm25}
+		summaries := summarizeFiles(c.flags.Args(), thresholds)
+		report := &bytes.Buffer{}
+		switch *format {
+		case "html":
+			err = history.WriteHTML(report, *title, thresholds, u, summaries)
+		case "json":
+			for i := range summaries {
+				summaries[i] = summaries[i].Convert(u)
+			}
+			enc := json.NewEncoder(report)
+			enc.SetIndent("", "  ")
+			err = enc.Encode(summaries)
+		default:
+			err = fmt.Errorf("unknown report format %q, expected html or json", *format)
+		}
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to render report.")
+		}
+		contentType := "text/html; charset=utf-8"
+		if *format == "json" {
+			contentType = "application/json"
+		}
+
+		if *out != "" {
+			if err := os.WriteFile(*out, report.Bytes(), 0o644); err != nil {
+				log.Fatal().Err(err).Str("file", *out).Msg("Failed to write report.")
+			}
+		} else if *postURL == "" && *smtpAddr == "" {
+			os.Stdout.Write(report.Bytes())
+		}
+		if *postURL != "" {
+			if err := postReport(*postURL, contentType, report.Bytes()); err != nil {
+				log.Fatal().Err(err).Str("url", *postURL).Msg("Failed to post report.")
+			}
+		}
+		if *smtpAddr != "" {
+			var auth smtp.Auth
+			if *mailUser != "" {
+				host, _, _ := strings.Cut(*smtpAddr, ":")
+				auth = smtp.PlainAuth("", *mailUser, os.Getenv("SMTP_PASSWORD"), host)
+			}
+			recipients := splitList(*mailTo)
+			msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nMIME-Version: 1.0\r\nContent-Type: %s\r\n\r\n%s",
+				*mailFrom, strings.Join(recipients, ", "), *title, contentType, report)
+			if err := smtp.SendMail(*smtpAddr, auth, *mailFrom, recipients, []byte(msg)); err != nil {
+				log.Fatal().Err(err).Str("smtp", *smtpAddr).Msg("Failed to email report.")
+			}
+		}
+	}
+	return c
+}
+
+// summarizeFiles summarizes the history files given as [name=]file, merging
+// the files of the same name. Without a name, the file name is used.
+func summarizeFiles(args []string, t history.Thresholds) []history.Summary {
+	names := []string{}
+	readings := map[string][]exporter.AwairValues{}
+	for _, arg := range args {
+		name, file, ok := strings.Cut(arg, "=")
+		if !ok {
+			file = arg
+			name = strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			log.Fatal().Err(err).Str("file", file).Msg("Failed to open history file.")
+		}
+		values, _, err := history.ReadCSV(f)
+		f.Close()
+		if err != nil {
+			log.Fatal().Err(err).Str("file", file).Msg("Failed to read history file.")
+		}
+		if _, ok := readings[name]; !ok {
+			names = append(names, name)
+		}
+		readings[name] = append(readings[name], values...)
+	}
+	summaries := make([]history.Summary, len(names))
+	for i, name := range names {
+		summaries[i] = history.Summarize(name, readings[name], t)
+	}
+	return summaries
+}
+
+func postReport(url, contentType string, report []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(report))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"prometheus-awair-exporter/internal/exporter"
+)
+
+const (
+	ansiReset  = "\033[0m"
+	ansiGreen  = "\033[32m"
+	ansiYellow = "\033[33m"
+	ansiRed    = "\033[31m"
+	ansiClear  = "\033[H\033[2J"
+)
+
+// threshold colours a reading, higher values being worse unless inverted.
+type threshold struct {
+	good, fair float64
+	inverted   bool
+}
+
+// topThresholds follow the Awair app's colour bands.
+var topThresholds = map[string]threshold{
+	"score": {good: 80, fair: 60, inverted: true},
+	"co2":   {good: 600, fair: 1000},
+	"voc":   {good: 333, fair: 1000},
+	"pm25":  {good: 15, fair: 35},
+}
+
+func colorize(field string, value float64) string {
+	text := fmt.Sprintf("%.1f", value)
+	t, ok := topThresholds[field]
+	if !ok {
+		return text
+	}
+	color := ansiRed
+	switch {
+	case !t.inverted && value <= t.good, t.inverted && value >= t.good:
+		color = ansiGreen
+	case !t.inverted && value <= t.fair, t.inverted && value >= t.fair:
+		color = ansiYellow
+	}
+	return color + text + ansiReset
+}
+
+// topCommand renders a live view of the readings served by a running
+// exporter's JSON API, for use over SSH when Grafana is unreachable. With
+// -output json a single snapshot of the readings is printed instead.
+func topCommand() *command {
+	c := newCommand("top", "Shows a live view of the readings of a running exporter.", "[flags]")
+	url := c.flags.String("url", "http://localhost:8080", "base URL of the awair-exporter to watch")
+	interval := c.flags.Duration("interval", 5*time.Second, "refresh interval")
+	output := outputFlag(c.flags)
+	c.run = func(args []string) {
+		c.flags.Parse(args)
+		url := strings.TrimSuffix(*url, "/")
+		switch *output {
+		case "table":
+			runTop(url, *interval)
+		case "json":
+			readings, err := fetchReadings(&http.Client{Timeout: *interval}, url)
+			if err == nil {
+				err = writeOutput(os.Stdout, *output, readings, nil)
+			}
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		default:
+			fmt.Fprintf(os.Stderr, "unknown output format %q, expected table or json\n", *output)
+			os.Exit(2)
+		}
+	}
+	return c
+}
+
+func runTop(url string, interval time.Duration) {
+	sigchan := make(chan os.Signal, 1)
+	signal.Notify(sigchan, os.Interrupt, syscall.SIGTERM)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	client := &http.Client{Timeout: interval}
+	for {
+		renderTop(os.Stdout, client, url)
+		select {
+		case <-sigchan:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func renderTop(out io.Writer, client *http.Client, url string) {
+	fmt.Fprint(out, ansiClear)
+	fmt.Fprintf(out, "awair-exporter top - %s - %s\n\n", url, time.Now().Format(time.TimeOnly))
+
+	readings, err := fetchReadings(client, url)
+	if err != nil {
+		fmt.Fprintf(out, "%sError: %s%s\n", ansiRed, err, ansiReset)
+		return
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "DEVICE\tSCORE\tTEMP\tHUMID\tCO2\tVOC\tPM2.5")
+	for _, r := range readings {
+		if r.Values == nil || r.Config == nil {
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			r.Config.DeviceUUID,
+			colorize("score", r.Values.Score),
+			colorize("temp", r.Values.Temp),
+			colorize("humid", r.Values.Humidity),
+			colorize("co2", r.Values.CO2),
+			colorize("voc", r.Values.Voc),
+			colorize("pm25", r.Values.PM25),
+		)
+	}
+	w.Flush()
+}
+
+func fetchReadings(client *http.Client, url string) ([]exporter.Reading, error) {
+	resp, err := client.Get(url + "/api/v1/readings")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	readings := []exporter.Reading{}
+	if err := json.NewDecoder(resp.Body).Decode(&readings); err != nil {
+		return nil, err
+	}
+	return readings, nil
+}
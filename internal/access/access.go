@@ -0,0 +1,98 @@
+// Package access restricts which clients may use the exporter's endpoints.
+package access
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Allowlist is a set of networks allowed to access the exporter. An empty
+// Allowlist allows everyone.
+type Allowlist []*net.IPNet
+
+// ParseAllowlist parses a comma separated list of CIDRs. Plain addresses
+// allow that single host.
+func ParseAllowlist(s string) (Allowlist, error) {
+	return parseNetworks(s)
+}
+
+// parseNetworks parses a comma separated list of CIDRs or addresses.
+func parseNetworks(s string) ([]*net.IPNet, error) {
+	list := []*net.IPNet{}
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", entry)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				bits = 32
+			}
+			entry = fmt.Sprintf("%s/%d", entry, bits)
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, network)
+	}
+	return list, nil
+}
+
+// Allows reports whether ip belongs to one of the networks.
+func (l Allowlist) Allows(ip net.IP) bool {
+	if len(l) == 0 {
+		return true
+	}
+	for _, network := range l {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Handler rejects requests to h from clients outside the allowlist with
+// 403 Forbidden. Paths in exempt, such as a health check, are served to
+// everyone.
+func (l Allowlist) Handler(h http.Handler, exempt ...string) http.Handler {
+	if len(l) == 0 {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, path := range exempt {
+			if r.URL.Path == path {
+				h.ServeHTTP(w, r)
+				return
+			}
+		}
+		ip := remoteIP(r)
+		if ip == nil || !l.Allows(ip) {
+			log.Debug().
+				Str("remote_addr", r.RemoteAddr).
+				Str("path", r.URL.Path).
+				Msg("Rejected request from client outside allowlist.")
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// remoteIP returns the address of the peer connected to the exporter.
+func remoteIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
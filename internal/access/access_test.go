@@ -0,0 +1,54 @@
+package access
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/tj/assert"
+)
+
+func TestParseAllowlist(t *testing.T) {
+	assert := assert.New(t)
+	l, err := ParseAllowlist("192.168.1.0/24, 10.0.0.5,fd00::/8")
+	assert.Nil(err)
+	assert.Len(l, 3)
+	assert.True(l.Allows(net.ParseIP("192.168.1.20")))
+	assert.True(l.Allows(net.ParseIP("10.0.0.5")))
+	assert.False(l.Allows(net.ParseIP("10.0.0.6")))
+	assert.True(l.Allows(net.ParseIP("fd12::1")))
+	assert.False(l.Allows(net.ParseIP("2001:db8::1")))
+
+	empty, err := ParseAllowlist("")
+	assert.Nil(err)
+	assert.True(empty.Allows(net.ParseIP("203.0.113.1")))
+
+	_, err = ParseAllowlist("not-an-ip")
+	assert.NotNil(err)
+	_, err = ParseAllowlist("10.0.0.0/33")
+	assert.NotNil(err)
+}
+
+func TestAllowlistHandler(t *testing.T) {
+	assert := assert.New(t)
+	l, err := ParseAllowlist("192.168.1.0/24")
+	assert.Nil(err)
+	h := l.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), "/healthz")
+
+	for _, c := range []struct {
+		remoteAddr, path string
+		code             int
+	}{
+		{"192.168.1.2:51234", "/metrics", http.StatusOK},
+		{"203.0.113.1:51234", "/metrics", http.StatusForbidden},
+		{"203.0.113.1:51234", "/api/v1/readings", http.StatusForbidden},
+		{"203.0.113.1:51234", "/healthz", http.StatusOK},
+	} {
+		r := httptest.NewRequest(http.MethodGet, c.path, nil)
+		r.RemoteAddr = c.remoteAddr
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		assert.Equal(c.code, w.Code, c.remoteAddr+c.path)
+	}
+}
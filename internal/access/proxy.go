@@ -0,0 +1,67 @@
+package access
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// TrustedProxies are the reverse proxies whose X-Forwarded-For header is
+// honored to find the real client. None are trusted by default.
+type TrustedProxies []*net.IPNet
+
+// ParseTrustedProxies parses a comma separated list of CIDRs or addresses.
+func ParseTrustedProxies(s string) (TrustedProxies, error) {
+	return parseNetworks(s)
+}
+
+func (t TrustedProxies) trusts(ip net.IP) bool {
+	for _, network := range t {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the address of the client which sent r. When the peer
+// is a trusted proxy, X-Forwarded-For is walked from the nearest hop back,
+// skipping trusted proxies, so a client can't spoof its address by sending
+// the header itself.
+func (t TrustedProxies) ClientIP(r *http.Request) net.IP {
+	ip := remoteIP(r)
+	if ip == nil || !t.trusts(ip) {
+		return ip
+	}
+	hops := []string{}
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop
+		if !t.trusts(hop) {
+			break
+		}
+	}
+	return ip
+}
+
+// Handler sets the RemoteAddr of requests to h to the real client behind
+// trusted proxies, so allowlists and logs see the client rather than the
+// proxy.
+func (t TrustedProxies) Handler(h http.Handler) http.Handler {
+	if len(t) == 0 {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ip := t.ClientIP(r); ip != nil {
+			r = r.Clone(r.Context())
+			r.RemoteAddr = net.JoinHostPort(ip.String(), "0")
+		}
+		h.ServeHTTP(w, r)
+	})
+}
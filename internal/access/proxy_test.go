@@ -0,0 +1,58 @@
+package access
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/tj/assert"
+)
+
+func TestClientIP(t *testing.T) {
+	assert := assert.New(t)
+	trusted, err := ParseTrustedProxies("10.0.0.0/8")
+	assert.Nil(err)
+
+	for _, c := range []struct {
+		remoteAddr string
+		forwarded  []string
+		client     string
+	}{
+		{"192.168.1.2:5000", nil, "192.168.1.2"},
+		{"192.168.1.2:5000", []string{"203.0.113.1"}, "192.168.1.2"},
+		{"10.0.0.1:5000", nil, "10.0.0.1"},
+		{"10.0.0.1:5000", []string{"203.0.113.1"}, "203.0.113.1"},
+		{"10.0.0.1:5000", []string{"198.51.100.7, 203.0.113.1, 10.0.0.2"}, "203.0.113.1"},
+		{"10.0.0.1:5000", []string{"198.51.100.7", "203.0.113.1"}, "203.0.113.1"},
+		{"10.0.0.1:5000", []string{"10.0.0.3, 10.0.0.2"}, "10.0.0.3"},
+		{"10.0.0.1:5000", []string{"garbage"}, "10.0.0.1"},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		r.RemoteAddr = c.remoteAddr
+		for _, f := range c.forwarded {
+			r.Header.Add("X-Forwarded-For", f)
+		}
+		assert.Equal(c.client, trusted.ClientIP(r).String(), c.remoteAddr, c.forwarded)
+	}
+}
+
+func TestTrustedProxiesWithAllowlist(t *testing.T) {
+	assert := assert.New(t)
+	trusted, err := ParseTrustedProxies("10.0.0.1")
+	assert.Nil(err)
+	allowlist, err := ParseAllowlist("192.168.1.0/24")
+	assert.Nil(err)
+	h := trusted.Handler(allowlist.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+	for forwarded, code := range map[string]int{
+		"192.168.1.2": http.StatusOK,
+		"203.0.113.1": http.StatusForbidden,
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		r.RemoteAddr = "10.0.0.1:5000"
+		r.Header.Set("X-Forwarded-For", forwarded)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		assert.Equal(code, w.Code, forwarded)
+	}
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"prometheus-awair-exporter/internal/exporter"
+	"prometheus-awair-exporter/internal/units"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ReadingSource provides the latest device readings served by the API.
+type ReadingSource interface {
+	Readings() []exporter.Reading
+}
+
+// Reading is a device reading as served by the readings API. Unlike
+// exporter.Reading it includes what the exporter detected about the
+// device's payload.
+type Reading struct {
+	Config *exporter.ConfigResponse `json:"config"`
+	Values *Values                  `json:"values"`
+}
+
+// Values are the air-data values of a Reading.
+type Values struct {
+	*exporter.AwairValues
+	Absent []string `json:"absent,omitempty"`
+	Schema string   `json:"schema,omitempty"`
+}
+
+// NewReading returns the API form of r.
+func NewReading(r exporter.Reading) Reading {
+	reading := Reading{Config: r.Config}
+	if r.Values != nil {
+		reading.Values = &Values{AwairValues: r.Values, Absent: r.Values.Absent, Schema: r.Values.Schema}
+	}
+	return reading
+}
+
+// Reading returns r as an exporter.Reading.
+func (r Reading) Reading() exporter.Reading {
+	reading := exporter.Reading{Config: r.Config}
+	if r.Values != nil && r.Values.AwairValues != nil {
+		values := *r.Values.AwairValues
+		values.Absent, values.Schema = r.Values.Absent, r.Values.Schema
+		reading.Values = &values
+	}
+	return reading
+}
+
+// NewReadingsHandler serves the latest readings of every device as JSON,
+// ordered by device UUID, in the units of u unless the units parameter
+// asks for others. Clients reading the values as the devices report them,
+// such as federating exporters, ask for metric units.
+func NewReadingsHandler(src ReadingSource, u units.System) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		system := u
+		if name := r.URL.Query().Get("units"); name != "" {
+			var err error
+			if system, err = units.Parse(name); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		readings := append([]exporter.Reading(nil), src.Readings()...)
+		exporter.SortReadings(readings)
+		served := make([]Reading, len(readings))
+		for i, r := range readings {
+			r.Values = system.Values(r.Values)
+			served[i] = NewReading(r)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(served); err != nil {
+			log.Error().Err(err).Msg("Failed to encode readings")
+		}
+	})
+}
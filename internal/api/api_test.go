@@ -0,0 +1,88 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"prometheus-awair-exporter/internal/exporter"
+	"prometheus-awair-exporter/internal/units"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tj/assert"
+)
+
+type staticSource []exporter.Reading
+
+func (s staticSource) Readings() []exporter.Reading {
+	return s
+}
+
+func TestReadingsHandler(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	src := staticSource{
+		{
+			Config: &exporter.ConfigResponse{DeviceUUID: "awair-element_1"},
+			Values: &exporter.AwairValues{Score: 89, Absent: []string{"co2"}, Schema: "current"},
+		},
+	}
+	srv := httptest.NewServer(NewReadingsHandler(src, units.Metric))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	require.Nil(err)
+	defer resp.Body.Close()
+	assert.Equal("application/json", resp.Header.Get("Content-Type"))
+
+	readings := []Reading{}
+	require.Nil(json.NewDecoder(resp.Body).Decode(&readings))
+	require.Len(readings, 1)
+	assert.Equal(src[0], readings[0].Reading())
+}
+
+func TestReadingsHandlerOrder(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	srv := httptest.NewServer(NewReadingsHandler(staticSource{
+		{Values: &exporter.AwairValues{Score: 1}},
+		{Config: &exporter.ConfigResponse{DeviceUUID: "awair-element_2"}},
+		{Config: &exporter.ConfigResponse{DeviceUUID: "awair-element_1"}},
+	}, units.Metric))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	require.Nil(err)
+	defer resp.Body.Close()
+	readings := []exporter.Reading{}
+	require.Nil(json.NewDecoder(resp.Body).Decode(&readings))
+	require.Len(readings, 3)
+	assert.Equal("awair-element_1", readings[0].Config.DeviceUUID)
+	assert.Equal("awair-element_2", readings[1].Config.DeviceUUID)
+	assert.Nil(readings[2].Config)
+}
+
+func TestReadingsHandlerUnits(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	src := staticSource{{Values: &exporter.AwairValues{Temp: 20, CO2: 600}}}
+	srv := httptest.NewServer(NewReadingsHandler(src, units.Imperial))
+	defer srv.Close()
+
+	for query, temp := range map[string]float64{"": 68, "?units=metric": 20} {
+		resp, err := http.Get(srv.URL + query)
+		require.Nil(err)
+		readings := []exporter.Reading{}
+		require.Nil(json.NewDecoder(resp.Body).Decode(&readings))
+		resp.Body.Close()
+		assert.Equal(temp, readings[0].Values.Temp, query)
+		assert.Equal(600.0, readings[0].Values.CO2, query)
+	}
+	assert.Equal(20.0, src[0].Values.Temp, "the reading is left as is")
+
+	resp, err := http.Get(srv.URL + "?units=kelvin")
+	require.Nil(err)
+	resp.Body.Close()
+	assert.Equal(http.StatusBadRequest, resp.StatusCode)
+}
@@ -0,0 +1,31 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/tj/assert"
+)
+
+func TestAuthorized(t *testing.T) {
+	assert := assert.New(t)
+	for auth, want := range map[string]bool{
+		"Bearer secret":  true,
+		"Bearer secret2": false,
+		"Bearer secre":   false,
+		"bearer secret":  false,
+		"secret":         false,
+		"":               false,
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if auth != "" {
+			r.Header.Set("Authorization", auth)
+		}
+		assert.Equal(want, authorized(r, "secret"), auth)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Authorization", "Bearer ")
+	assert.False(authorized(r, ""), "an empty token authorizes nothing")
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"prometheus-awair-exporter/internal/exporter"
+
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	// ErrNotOwned is returned by a DeviceManager for devices handled by
+	// another shard.
+	ErrNotOwned = errors.New("device belongs to another shard")
+	// ErrExists is returned by a DeviceManager for devices it already has.
+	ErrExists = errors.New("device already exists")
+)
+
+// DeviceManager adds and removes devices at runtime.
+type DeviceManager interface {
+	// AddDevice connects to the device at hostname and adds it under name.
+	AddDevice(ctx context.Context, name, hostname string) (exporter.DeviceInfo, error)
+	// RemoveDevice removes the device with the given UUID, reporting
+	// whether it was present.
+	RemoveDevice(uuid string) bool
+	// Devices lists the current devices.
+	Devices() []exporter.DeviceInfo
+}
+
+// NewDevicesHandler serves the admin API on prefix: GET lists the devices,
+// POST with a JSON body of name and hostname adds one and DELETE
+// prefix/<uuid> removes one. Requests must carry token as a bearer token.
+func NewDevicesHandler(prefix, token string, devices DeviceManager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(r, token) {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		uuid := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, prefix), "/")
+		switch {
+		case r.Method == http.MethodGet && uuid == "":
+			writeJSON(w, http.StatusOK, devices.Devices())
+		case r.Method == http.MethodPost && uuid == "":
+			req := exporter.DeviceInfo{}
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxIngestSize)).Decode(&req); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if req.Hostname == "" {
+				http.Error(w, "hostname is required", http.StatusBadRequest)
+				return
+			}
+			if req.Name == "" {
+				req.Name = req.Hostname
+			}
+			added, err := devices.AddDevice(r.Context(), req.Name, req.Hostname)
+			switch {
+			case errors.Is(err, ErrNotOwned), errors.Is(err, ErrExists):
+				http.Error(w, err.Error(), http.StatusConflict)
+			case err != nil:
+				http.Error(w, err.Error(), http.StatusBadGateway)
+			default:
+				log.Info().
+					Str("name", added.Name).
+					Str("hostname", added.Hostname).
+					Str("device_uuid", added.DeviceUUID).
+					Msg("Added device through the admin API.")
+				writeJSON(w, http.StatusCreated, added)
+			}
+		case r.Method == http.MethodDelete && uuid != "":
+			if !devices.RemoveDevice(uuid) {
+				http.NotFound(w, r)
+				return
+			}
+			log.Info().Str("device_uuid", uuid).Msg("Removed device through the admin API.")
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Error().Err(err).Msg("Failed to encode response")
+	}
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"prometheus-awair-exporter/internal/exporter"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tj/assert"
+)
+
+type fakeDevices struct {
+	devices []exporter.DeviceInfo
+}
+
+func (f *fakeDevices) AddDevice(_ context.Context, name, hostname string) (exporter.DeviceInfo, error) {
+	switch hostname {
+	case "unreachable":
+		return exporter.DeviceInfo{}, errors.New("connection refused")
+	case "other-shard":
+		return exporter.DeviceInfo{}, ErrNotOwned
+	}
+	d := exporter.DeviceInfo{Name: name, Hostname: hostname, DeviceUUID: "awair-element_" + hostname}
+	f.devices = append(f.devices, d)
+	return d, nil
+}
+
+func (f *fakeDevices) RemoveDevice(uuid string) bool {
+	for i, d := range f.devices {
+		if d.DeviceUUID == uuid {
+			f.devices = append(f.devices[:i], f.devices[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (f *fakeDevices) Devices() []exporter.DeviceInfo {
+	return f.devices
+}
+
+func TestDevicesHandler(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	devices := &fakeDevices{}
+	h := NewDevicesHandler("/api/v1/devices", "secret", devices)
+
+	do := func(method, path, token, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	assert.Equal(http.StatusUnauthorized, do(http.MethodGet, "/api/v1/devices", "", "").Code)
+	assert.Equal(http.StatusUnauthorized, do(http.MethodGet, "/api/v1/devices", "wrong", "").Code)
+
+	w := do(http.MethodPost, "/api/v1/devices", "secret", `{"name": "bedroom", "hostname": "1"}`)
+	assert.Equal(http.StatusCreated, w.Code)
+	added := exporter.DeviceInfo{}
+	require.Nil(json.Unmarshal(w.Body.Bytes(), &added))
+	assert.Equal(exporter.DeviceInfo{Name: "bedroom", Hostname: "1", DeviceUUID: "awair-element_1"}, added)
+
+	assert.Equal(http.StatusCreated, do(http.MethodPost, "/api/v1/devices", "secret", `{"hostname": "2"}`).Code)
+	assert.Equal("2", devices.devices[1].Name, "the name defaults to the hostname")
+	assert.Equal(http.StatusBadRequest, do(http.MethodPost, "/api/v1/devices", "secret", `{"name": "office"}`).Code)
+	assert.Equal(http.StatusBadGateway, do(http.MethodPost, "/api/v1/devices", "secret", `{"hostname": "unreachable"}`).Code)
+	assert.Equal(http.StatusConflict, do(http.MethodPost, "/api/v1/devices", "secret", `{"hostname": "other-shard"}`).Code)
+
+	w = do(http.MethodGet, "/api/v1/devices", "secret", "")
+	assert.Equal(http.StatusOK, w.Code)
+	listed := []exporter.DeviceInfo{}
+	require.Nil(json.Unmarshal(w.Body.Bytes(), &listed))
+	assert.Len(listed, 2)
+
+	assert.Equal(http.StatusNoContent, do(http.MethodDelete, "/api/v1/devices/awair-element_1", "secret", "").Code)
+	assert.Equal(http.StatusNotFound, do(http.MethodDelete, "/api/v1/devices/awair-element_1", "secret", "").Code)
+	assert.Equal(http.StatusMethodNotAllowed, do(http.MethodDelete, "/api/v1/devices", "secret", "").Code)
+	assert.Len(devices.devices, 1)
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"prometheus-awair-exporter/internal/exporter"
+
+	"github.com/rs/zerolog/log"
+)
+
+// maxIngestSize bounds the size of a pushed reading.
+const maxIngestSize = 64 << 10
+
+// IngestFunc stores a reading pushed for the device called name.
+type IngestFunc func(name string, values *exporter.AwairValues, config *exporter.ConfigResponse) error
+
+// NewIngestHandler accepts readings POSTed to prefix/<name>, either as the
+// device's air-data JSON or as a reading with values and config as served
+// by the readings API, or, with a transform, as any JSON payload the
+// transform extracts the values from. Requests must carry token as a
+// bearer token; without one, every request is refused.
+func NewIngestHandler(prefix, token string, transform Transform, ingest IngestFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		if !authorized(r, token) {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		name := strings.TrimPrefix(r.URL.Path, prefix)
+		if name == "" || strings.Contains(name, "/") {
+			http.NotFound(w, r)
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxIngestSize))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		var values *exporter.AwairValues
+		var config *exporter.ConfigResponse
+		if transform != nil {
+			values, err = transform.Apply(body)
+		} else {
+			values, config, err = decodeReading(body)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := ingest(name, values, config); err != nil {
+			log.Warn().Err(err).Str("device", name).Msg("Rejected pushed reading")
+			status := http.StatusConflict
+			if errors.Is(err, exporter.ErrIngestLimit) {
+				status = http.StatusForbidden
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// decodeReading decodes a reading, or plain air-data values.
+func decodeReading(body []byte) (*exporter.AwairValues, *exporter.ConfigResponse, error) {
+	raw := map[string]json.RawMessage{}
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return nil, nil, err
+	}
+	if rawValues, ok := raw["values"]; ok {
+		var config *exporter.ConfigResponse
+		if rawConfig, ok := raw["config"]; ok {
+			if err := json.Unmarshal(rawConfig, &config); err != nil {
+				return nil, nil, err
+			}
+		}
+		values, err := exporter.DecodeValues(rawValues)
+		if err != nil {
+			return nil, nil, err
+		}
+		return values, config, nil
+	}
+	values, err := exporter.DecodeValues(body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return values, nil, nil
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"prometheus-awair-exporter/internal/exporter"
+
+	"github.com/tj/assert"
+)
+
+type ingested struct {
+	name   string
+	values *exporter.AwairValues
+	config *exporter.ConfigResponse
+}
+
+func TestIngestHandler(t *testing.T) {
+	assert := assert.New(t)
+	got := []ingested{}
+	h := NewIngestHandler("/api/v1/ingest/", "secret", nil, func(name string, values *exporter.AwairValues, config *exporter.ConfigResponse) error {
+		if name == "polled" {
+			return errors.New("device polled is polled, not ingested")
+		}
+		if name == "extra" {
+			return exporter.ErrIngestLimit
+		}
+		got = append(got, ingested{name, values, config})
+		return nil
+	})
+
+	post := func(path, token, body string) int {
+		r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	assert.Equal(http.StatusNoContent, post("/api/v1/ingest/bedroom", "secret", `{"score": 89, "co2": 625}`))
+	assert.Equal(http.StatusNoContent, post("/api/v1/ingest/office", "secret",
+		`{"config": {"device_uuid": "awair-element_2"}, "values": {"score": 70, "co2": 600, "absent": ["co2"]}}`))
+	assert.Equal(http.StatusUnauthorized, post("/api/v1/ingest/bedroom", "", `{"score": 89}`))
+	assert.Equal(http.StatusBadRequest, post("/api/v1/ingest/bedroom", "secret", `<html>`))
+	assert.Equal(http.StatusNotFound, post("/api/v1/ingest/", "secret", `{}`))
+	assert.Equal(http.StatusConflict, post("/api/v1/ingest/polled", "secret", `{"score": 89}`))
+	assert.Equal(http.StatusForbidden, post("/api/v1/ingest/extra", "secret", `{"score": 89}`))
+	assert.Equal(http.StatusRequestEntityTooLarge, post("/api/v1/ingest/bedroom", "secret", strings.Repeat(" ", maxIngestSize+1)))
+
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/ingest/bedroom", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(http.StatusMethodNotAllowed, w.Code)
+
+	assert.Len(got, 2)
+	assert.Equal("bedroom", got[0].name)
+	assert.Equal(float64(625), got[0].values.CO2)
+	assert.Nil(got[0].config)
+	assert.Equal("awair-element_2", got[1].config.DeviceUUID)
+	assert.Equal(float64(70), got[1].values.Score)
+	assert.True(got[1].values.Has("co2"), "pushed readings can't mark fields absent")
+	assert.False(got[1].values.Has("voc"))
+}
+
+func TestIngestHandlerWithoutToken(t *testing.T) {
+	h := NewIngestHandler("/api/v1/ingest/", "", nil, func(string, *exporter.AwairValues, *exporter.ConfigResponse) error {
+		t.Fatal("reading ingested without a token")
+		return nil
+	})
+	for _, auth := range []string{"", "Bearer "} {
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/ingest/garage", strings.NewReader(`{"score": 89}`))
+		if auth != "" {
+			r.Header.Set("Authorization", auth)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	}
+}
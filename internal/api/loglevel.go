@@ -0,0 +1,119 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// logLevelRequest sets the log level, for duration unless empty.
+type logLevelRequest struct {
+	Level    string `json:"level"`
+	Duration string `json:"duration"`
+}
+
+// logLevelStatus is the log level, and when it reverts to the previous one
+// if set temporarily.
+type logLevelStatus struct {
+	Level string     `json:"level"`
+	Until *time.Time `json:"until,omitempty"`
+}
+
+// logLevel changes the global log level, reverting it after a while if
+// asked to.
+type logLevel struct {
+	mu       sync.Mutex
+	until    *time.Time
+	previous zerolog.Level
+	revert   *time.Timer
+}
+
+func (l *logLevel) status() logLevelStatus {
+	return logLevelStatus{Level: zerolog.GlobalLevel().String(), Until: l.until}
+}
+
+func (l *logLevel) set(level zerolog.Level, d time.Duration) logLevelStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	previous := zerolog.GlobalLevel()
+	if l.revert != nil {
+		// A temporary change extended reverts to the level it replaced.
+		l.revert.Stop()
+		l.revert = nil
+		previous = l.previous
+	}
+	l.until = nil
+	log.Info().Str("level", level.String()).Dur("duration", d).Msg("Changing log level.")
+	zerolog.SetGlobalLevel(level)
+	if d > 0 {
+		until := time.Now().Add(d)
+		l.previous = previous
+		l.until = &until
+		var revert *time.Timer
+		revert = time.AfterFunc(d, func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			if l.revert != revert {
+				// Changed again while firing.
+				return
+			}
+			zerolog.SetGlobalLevel(previous)
+			l.until = nil
+			l.revert = nil
+			log.Info().Str("level", previous.String()).Msg("Reverted log level.")
+		})
+		l.revert = revert
+	}
+	return l.status()
+}
+
+// NewLogLevelHandler serves the global log level: GET returns it and PUT
+// changes it to the level of a JSON body, or of the level query parameter,
+// e.g. to debug logging while chasing an intermittent device failure. With
+// a duration, the previous level is restored after it. Requests must carry
+// token as a bearer token.
+func NewLogLevelHandler(token string) http.Handler {
+	l := &logLevel{}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(r, token) {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			writeJSON(w, http.StatusOK, l.status())
+		case http.MethodPut:
+			req := logLevelRequest{
+				Level:    r.URL.Query().Get("level"),
+				Duration: r.URL.Query().Get("duration"),
+			}
+			if r.ContentLength != 0 {
+				if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxIngestSize)).Decode(&req); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+			level, err := zerolog.ParseLevel(req.Level)
+			if err != nil || req.Level == "" {
+				http.Error(w, "invalid level", http.StatusBadRequest)
+				return
+			}
+			var d time.Duration
+			if req.Duration != "" {
+				if d, err = time.ParseDuration(req.Duration); err != nil || d <= 0 {
+					http.Error(w, "invalid duration", http.StatusBadRequest)
+					return
+				}
+			}
+			writeJSON(w, http.StatusOK, l.set(level, d))
+		default:
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		}
+	})
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+	"github.com/tj/assert"
+)
+
+func TestLogLevelHandler(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	defer zerolog.SetGlobalLevel(zerolog.GlobalLevel())
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	h := NewLogLevelHandler("secret")
+
+	do := func(method, token, target, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	status := func(w *httptest.ResponseRecorder) logLevelStatus {
+		s := logLevelStatus{}
+		require.Equal(http.StatusOK, w.Code, w.Body.String())
+		require.Nil(json.Unmarshal(w.Body.Bytes(), &s))
+		return s
+	}
+
+	assert.Equal(http.StatusUnauthorized, do(http.MethodPut, "", "/-/loglevel?level=debug", "").Code)
+	assert.Equal(logLevelStatus{Level: "info"}, status(do(http.MethodGet, "secret", "/-/loglevel", "")))
+
+	assert.Equal(logLevelStatus{Level: "debug"}, status(do(http.MethodPut, "secret", "/-/loglevel?level=debug", "")))
+	assert.Equal(zerolog.DebugLevel, zerolog.GlobalLevel())
+	assert.Equal(http.StatusBadRequest, do(http.MethodPut, "secret", "/-/loglevel?level=loud", "").Code)
+	assert.Equal(http.StatusBadRequest, do(http.MethodPut, "secret", "/-/loglevel", "").Code)
+	assert.Equal(zerolog.DebugLevel, zerolog.GlobalLevel())
+
+	s := status(do(http.MethodPut, "secret", "/-/loglevel", `{"level": "trace", "duration": "50ms"}`))
+	assert.Equal("trace", s.Level)
+	require.NotNil(s.Until)
+	// Extending a temporary change still reverts to the level it replaced.
+	status(do(http.MethodPut, "secret", "/-/loglevel", `{"level": "trace", "duration": "100ms"}`))
+	assert.Eventually(func() bool {
+		return zerolog.GlobalLevel() == zerolog.DebugLevel
+	}, time.Second, 10*time.Millisecond)
+	assert.Nil(status(do(http.MethodGet, "secret", "/-/loglevel", "")).Until)
+
+	assert.Equal(http.StatusBadRequest, do(http.MethodPut, "secret", "/-/loglevel", `{"level": "warn", "duration": "soon"}`).Code)
+	assert.Equal(http.StatusMethodNotAllowed, do(http.MethodPost, "secret", "/-/loglevel", "").Code)
+}
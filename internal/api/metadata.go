@@ -0,0 +1,96 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/rs/zerolog/log"
+)
+
+// metadata describes a metric as Prometheus' /api/v1/metadata does.
+type metadata struct {
+	Type string `json:"type"`
+	Help string `json:"help"`
+	Unit string `json:"unit"`
+}
+
+// metadataResponse is the response format of Prometheus' /api/v1/metadata.
+type metadataResponse struct {
+	Status string                `json:"status"`
+	Data   map[string][]metadata `json:"data"`
+}
+
+// unitSuffixes are the metric name suffixes taken as the unit of a metric,
+// as OpenMetrics names metrics with a unit.
+var unitSuffixes = []string{"seconds", "bytes", "ratio", "percent", "celsius", "volts", "lux", "db"}
+
+// NewMetadataHandler serves the type, help and unit of the metrics gathered
+// from g in the format of Prometheus' /api/v1/metadata, for dashboards and
+// pipelines to describe the metrics without hard-coding them. The metric
+// parameter limits the response to one metric.
+func NewMetadataHandler(g prometheus.Gatherer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mfs, err := g.Gather()
+		if err != nil {
+			// Gathering fails as a whole on inconsistent series, the
+			// metadata of the others is still served.
+			log.Warn().Err(err).Msg("Failed to gather some metrics for their metadata")
+		}
+		only := r.URL.Query().Get("metric")
+		resp := metadataResponse{Status: "success", Data: map[string][]metadata{}}
+		for _, mf := range mfs {
+			if only != "" && mf.GetName() != only {
+				continue
+			}
+			resp.Data[mf.GetName()] = []metadata{{
+				Type: metricType(mf.GetType()),
+				Help: mf.GetHelp(),
+				Unit: unit(mf.GetName(), mf.GetHelp()),
+			}}
+		}
+		writeJSON(w, http.StatusOK, resp)
+	})
+}
+
+// metricType returns the name Prometheus gives the type t.
+func metricType(t dto.MetricType) string {
+	switch t {
+	case dto.MetricType_COUNTER:
+		return "counter"
+	case dto.MetricType_GAUGE:
+		return "gauge"
+	case dto.MetricType_HISTOGRAM:
+		return "histogram"
+	case dto.MetricType_GAUGE_HISTOGRAM:
+		return "gaugehistogram"
+	case dto.MetricType_SUMMARY:
+		return "summary"
+	}
+	return "unknown"
+}
+
+// unit returns the unit of a metric from the suffix of its name, or else
+// the unit its help ends with in parentheses, e.g. ppm for "Carbon Dioxide
+// (ppm)". Parentheses starting with a digit, such as ranges, aren't units.
+func unit(name, help string) string {
+	name = strings.TrimSuffix(name, "_total")
+	for _, suffix := range unitSuffixes {
+		if strings.HasSuffix(name, "_"+suffix) {
+			return suffix
+		}
+	}
+	if !strings.HasSuffix(help, ")") {
+		return ""
+	}
+	i := strings.LastIndex(help, "(")
+	if i < 0 {
+		return ""
+	}
+	u, _, _ := strings.Cut(help[i+1:len(help)-1], " - ")
+	if u == "" || (u[0] >= '0' && u[0] <= '9') {
+		return ""
+	}
+	return u
+}
@@ -0,0 +1,39 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tj/assert"
+)
+
+func TestMetadataHandler(t *testing.T) {
+	assert := assert.New(t)
+	reg := prometheus.NewRegistry()
+	co2 := prometheus.NewGauge(prometheus.GaugeOpts{Name: "awair_co2", Help: "Carbon Dioxide (ppm)"})
+	co2.Set(600)
+	score := prometheus.NewGauge(prometheus.GaugeOpts{Name: "awair_score", Help: "Awair Score (0-100)"})
+	pm10 := prometheus.NewGauge(prometheus.GaugeOpts{Name: "awair_pm10", Help: "Estimated particulate matter (µg/m³ - calculated by the PM2.5 sensor)"})
+	restarts := prometheus.NewCounter(prometheus.CounterOpts{Name: "awair_poller_restarts_total", Help: "Number of restarts"})
+	duration := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "awair_request_duration_seconds", Help: "Duration of requests"})
+	reg.MustRegister(co2, score, pm10, restarts, duration)
+
+	w := httptest.NewRecorder()
+	NewMetadataHandler(reg).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/metadata", nil))
+	assert.Equal("application/json", w.Header().Get("Content-Type"))
+	assert.JSONEq(`{"status": "success", "data": {
+		"awair_co2": [{"type": "gauge", "help": "Carbon Dioxide (ppm)", "unit": "ppm"}],
+		"awair_score": [{"type": "gauge", "help": "Awair Score (0-100)", "unit": ""}],
+		"awair_pm10": [{"type": "gauge", "help": "Estimated particulate matter (µg/m³ - calculated by the PM2.5 sensor)", "unit": "µg/m³"}],
+		"awair_poller_restarts_total": [{"type": "counter", "help": "Number of restarts", "unit": ""}],
+		"awair_request_duration_seconds": [{"type": "histogram", "help": "Duration of requests", "unit": "seconds"}]
+	}}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	NewMetadataHandler(reg).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/metadata?metric=awair_co2", nil))
+	assert.JSONEq(`{"status": "success", "data": {
+		"awair_co2": [{"type": "gauge", "help": "Carbon Dioxide (ppm)", "unit": "ppm"}]
+	}}`, w.Body.String())
+}
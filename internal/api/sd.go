@@ -0,0 +1,39 @@
+package api
+
+import (
+	"net/http"
+
+	"prometheus-awair-exporter/internal/exporter"
+)
+
+// DeviceLister lists the exporter's devices.
+type DeviceLister interface {
+	Devices() []exporter.DeviceInfo
+}
+
+// targetGroup is a target group of the Prometheus HTTP service discovery
+// format.
+type targetGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels"`
+}
+
+// NewServiceDiscoveryHandler serves the devices as targets for Prometheus'
+// http_sd_configs, one group per device with its hostname as the target and
+// its UUID, model and name as labels.
+func NewServiceDiscoveryHandler(devices DeviceLister) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		groups := []targetGroup{}
+		for _, d := range devices.Devices() {
+			groups = append(groups, targetGroup{
+				Targets: []string{d.Hostname},
+				Labels: map[string]string{
+					"device_uuid": d.DeviceUUID,
+					"model":       exporter.ModelName(d.DeviceUUID),
+					"name":        d.Name,
+				},
+			})
+		}
+		writeJSON(w, http.StatusOK, groups)
+	})
+}
@@ -0,0 +1,30 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"prometheus-awair-exporter/internal/exporter"
+
+	"github.com/tj/assert"
+)
+
+func TestServiceDiscoveryHandler(t *testing.T) {
+	assert := assert.New(t)
+	devices := &fakeDevices{devices: []exporter.DeviceInfo{
+		{Name: "bedroom", Hostname: "192.168.1.2", DeviceUUID: "awair-element_1"},
+		{Name: "office", Hostname: "192.168.1.3", DeviceUUID: "awair-omni_2"},
+	}}
+	w := httptest.NewRecorder()
+	NewServiceDiscoveryHandler(devices).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/sd", nil))
+	assert.Equal("application/json", w.Header().Get("Content-Type"))
+	assert.JSONEq(`[
+		{"targets": ["192.168.1.2"], "labels": {"device_uuid": "awair-element_1", "model": "awair-element", "name": "bedroom"}},
+		{"targets": ["192.168.1.3"], "labels": {"device_uuid": "awair-omni_2", "model": "awair-omni", "name": "office"}}
+	]`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	NewServiceDiscoveryHandler(&fakeDevices{}).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/sd", nil))
+	assert.JSONEq(`[]`, w.Body.String())
+}
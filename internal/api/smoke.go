@@ -0,0 +1,73 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"prometheus-awair-exporter/internal/smoke"
+)
+
+// SmokeMode is toggled by the smoke mode endpoint.
+type SmokeMode interface {
+	Activate(d time.Duration, reason string) smoke.Status
+	Deactivate() smoke.Status
+	Status() smoke.Status
+}
+
+// smokeRequest activates the smoke mode. It also accepts the webhook
+// payload of Alertmanager, so an alert on outdoor air quality can trigger
+// the smoke mode, and end it once resolved.
+type smokeRequest struct {
+	Duration string `json:"duration"`
+	Reason   string `json:"reason"`
+	// Status is set by Alertmanager, firing or resolved.
+	Status      string            `json:"status"`
+	GroupLabels map[string]string `json:"groupLabels"`
+}
+
+// NewSmokeHandler serves the smoke mode: GET returns its status, POST with
+// an optional JSON body of duration and reason activates it, for
+// defaultDuration unless given, and DELETE ends it. Requests must carry
+// token as a bearer token.
+func NewSmokeHandler(token string, mode SmokeMode, defaultDuration time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(r, token) {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, mode.Status())
+		case http.MethodPost:
+			req := smokeRequest{}
+			if r.ContentLength != 0 {
+				if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxIngestSize)).Decode(&req); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+			if req.Status == "resolved" {
+				writeJSON(w, http.StatusOK, mode.Deactivate())
+				return
+			}
+			d := defaultDuration
+			if req.Duration != "" {
+				var err error
+				if d, err = time.ParseDuration(req.Duration); err != nil || d <= 0 {
+					http.Error(w, "invalid duration", http.StatusBadRequest)
+					return
+				}
+			}
+			reason := req.Reason
+			if reason == "" && req.GroupLabels["alertname"] != "" {
+				reason = "alert " + req.GroupLabels["alertname"]
+			}
+			writeJSON(w, http.StatusOK, mode.Activate(d, reason))
+		case http.MethodDelete:
+			writeJSON(w, http.StatusOK, mode.Deactivate())
+		default:
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		}
+	})
+}
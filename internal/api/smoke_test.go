@@ -0,0 +1,59 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"prometheus-awair-exporter/internal/smoke"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tj/assert"
+)
+
+func TestSmokeHandler(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	mode := smoke.New(10*time.Second, 35, 12)
+	h := NewSmokeHandler("secret", mode, 12*time.Hour)
+
+	do := func(method, token, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/api/v1/smoke", strings.NewReader(body))
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	status := func(w *httptest.ResponseRecorder) smoke.Status {
+		s := smoke.Status{}
+		require.Nil(json.Unmarshal(w.Body.Bytes(), &s))
+		return s
+	}
+
+	assert.Equal(http.StatusUnauthorized, do(http.MethodPost, "", "").Code)
+	assert.False(status(do(http.MethodGet, "secret", "")).Active)
+
+	start := time.Now()
+	s := status(do(http.MethodPost, "secret", ""))
+	assert.True(s.Active)
+	assert.WithinDuration(start.Add(12*time.Hour), s.Until, time.Minute, "active for the default duration")
+
+	s = status(do(http.MethodPost, "secret", `{"duration": "2h", "reason": "wildfire"}`))
+	assert.WithinDuration(start.Add(2*time.Hour), s.Until, time.Minute)
+	assert.Equal("wildfire", s.Reason)
+	assert.Equal(http.StatusBadRequest, do(http.MethodPost, "secret", `{"duration": "soon"}`).Code)
+
+	assert.False(status(do(http.MethodDelete, "secret", "")).Active)
+
+	// Alertmanager webhooks
+	s = status(do(http.MethodPost, "secret", `{"status": "firing", "groupLabels": {"alertname": "OutdoorAQIHigh"}}`))
+	assert.True(s.Active)
+	assert.Equal("alert OutdoorAQIHigh", s.Reason)
+	assert.False(status(do(http.MethodPost, "secret", `{"status": "resolved"}`)).Active)
+	assert.Equal(http.StatusMethodNotAllowed, do(http.MethodPut, "secret", "").Code)
+}
@@ -0,0 +1,177 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"prometheus-awair-exporter/internal/exporter"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Transform maps the fields of a reading, by their Local API name, to paths
+// into the JSON payload pushed by an intermediary. A path is a dot separated
+// list of object keys, each optionally followed by [n] to pick the n-th
+// array element or by [key=value] to pick the array element whose key has
+// that value, e.g. data.sensors[comp=temp].value.
+type Transform map[string]string
+
+// LoadTransform reads a transform from the YAML file at path.
+func LoadTransform(path string) (Transform, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	t := Transform{}
+	if err := yaml.Unmarshal(data, &t); err != nil {
+		return nil, err
+	}
+	return t, t.validate()
+}
+
+func (t Transform) validate() error {
+	if len(t) == 0 {
+		return errors.New("transform maps no fields")
+	}
+	known := map[string]bool{"timestamp": true}
+	for _, f := range (&exporter.AwairValues{}).Fields() {
+		known[f.Name] = true
+	}
+	for field, path := range t {
+		if !known[field] {
+			return fmt.Errorf("unknown field %q", field)
+		}
+		if _, err := parsePath(path); err != nil {
+			return fmt.Errorf("field %s: %w", field, err)
+		}
+	}
+	return nil
+}
+
+// Apply extracts the values of a reading from body. Fields whose path is
+// missing from body are left unset, but at least one must be found.
+func (t Transform) Apply(body []byte) (*exporter.AwairValues, error) {
+	var doc interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, err
+	}
+	fields := map[string]interface{}{}
+	for field, path := range t {
+		steps, err := parsePath(path)
+		if err != nil {
+			return nil, err
+		}
+		v, ok := lookup(doc, steps)
+		if !ok {
+			continue
+		}
+		if field == "timestamp" {
+			fields[field] = fmt.Sprint(v)
+			continue
+		}
+		n, err := toFloat(v)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", field, err)
+		}
+		fields[field] = n
+	}
+	if len(fields) == 0 {
+		return nil, errors.New("payload contains none of the mapped fields")
+	}
+	encoded, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	values := &exporter.AwairValues{}
+	return values, json.Unmarshal(encoded, values)
+}
+
+// step selects a child of a JSON value: an object key, an array index or,
+// with match set, the array element whose key has the value match.
+type step struct {
+	key   string
+	index int
+	match *string
+}
+
+func parsePath(path string) ([]step, error) {
+	steps := []step{}
+	for _, segment := range strings.Split(path, ".") {
+		key, rest, _ := strings.Cut(segment, "[")
+		if key != "" {
+			steps = append(steps, step{key: key, index: -1})
+		}
+		for rest != "" {
+			selector, after, ok := strings.Cut(rest, "]")
+			if !ok {
+				return nil, fmt.Errorf("unterminated [ in path %q", path)
+			}
+			if k, v, ok := strings.Cut(selector, "="); ok {
+				steps = append(steps, step{key: k, index: -1, match: &v})
+			} else if n, err := strconv.Atoi(selector); err == nil && n >= 0 {
+				steps = append(steps, step{index: n})
+			} else {
+				return nil, fmt.Errorf("invalid selector [%s] in path %q", selector, path)
+			}
+			if after != "" && !strings.HasPrefix(after, "[") {
+				return nil, fmt.Errorf("invalid path %q", path)
+			}
+			rest = strings.TrimPrefix(after, "[")
+		}
+		if key == "" && !strings.HasPrefix(segment, "[") {
+			return nil, fmt.Errorf("empty key in path %q", path)
+		}
+	}
+	return steps, nil
+}
+
+func lookup(v interface{}, steps []step) (interface{}, bool) {
+	for _, s := range steps {
+		switch {
+		case s.match != nil:
+			elems, ok := v.([]interface{})
+			if !ok {
+				return nil, false
+			}
+			found := false
+			for _, elem := range elems {
+				if obj, ok := elem.(map[string]interface{}); ok && fmt.Sprint(obj[s.key]) == *s.match {
+					v, found = elem, true
+					break
+				}
+			}
+			if !found {
+				return nil, false
+			}
+		case s.index >= 0:
+			elems, ok := v.([]interface{})
+			if !ok || s.index >= len(elems) {
+				return nil, false
+			}
+			v = elems[s.index]
+		default:
+			obj, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			if v, ok = obj[s.key]; !ok {
+				return nil, false
+			}
+		}
+	}
+	return v, v != nil
+}
+
+func toFloat(v interface{}) (float64, error) {
+	switch n := v.(type) {
+	case float64:
+		return n, nil
+	case string:
+		return strconv.ParseFloat(n, 64)
+	}
+	return 0, fmt.Errorf("%v is not a number", v)
+}
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tj/assert"
+)
+
+func TestTransform(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	path := filepath.Join(t.TempDir(), "transform.yaml")
+	require.Nil(os.WriteFile(path, []byte(`
+timestamp: data[0].timestamp
+score: data[0].score
+temp: data[0].sensors[comp=temp].value
+co2: data[0].sensors[comp=co2].value
+pm25: data[0].sensors[comp=pm25].value
+`), 0o644))
+	transform, err := LoadTransform(path)
+	require.Nil(err)
+
+	values, err := transform.Apply([]byte(`{"data": [{
+		"timestamp": "2024-01-01T00:00:00.000Z",
+		"score": 91,
+		"sensors": [{"comp": "temp", "value": 21.5}, {"comp": "co2", "value": "612"}]
+	}]}`))
+	require.Nil(err)
+	assert.Equal("2024-01-01T00:00:00.000Z", values.Timestamp)
+	assert.Equal(float64(91), values.Score)
+	assert.Equal(21.5, values.Temp)
+	assert.Equal(float64(612), values.CO2)
+	assert.Equal(float64(0), values.PM25)
+
+	_, err = transform.Apply([]byte(`{"other": 1}`))
+	assert.NotNil(err)
+	_, err = transform.Apply([]byte(`{"data": [{"score": true}]}`))
+	assert.NotNil(err)
+
+	assert.NotNil(Transform{"radon": "value"}.validate())
+	assert.NotNil(Transform{"temp": "sensors[comp=temp"}.validate())
+	assert.NotNil(Transform{"temp": "data..temp"}.validate())
+	assert.NotNil(Transform{}.validate())
+}
@@ -0,0 +1,115 @@
+// Package aqi computes air quality indexes of national standards from the
+// particulate matter readings of devices, as derived metrics.
+package aqi
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"prometheus-awair-exporter/internal/exporter"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Standard is an air quality index standard.
+type Standard interface {
+	// Name names the standard in the standard label, e.g. us-epa.
+	Name() string
+	// Index returns the index of the PM2.5 and PM10 concentrations in
+	// µg/m³ and the name of its category, e.g. moderate.
+	Index(pm25, pm10 float64) (float64, string)
+}
+
+var standards = map[string]Standard{}
+
+// Register makes s selectable by its name. It is intended to be called from
+// an init function, e.g. of a fork adding a standard.
+func Register(s Standard) {
+	standards[s.Name()] = s
+}
+
+// Names returns the names of the registered standards, sorted.
+func Names() []string {
+	names := make([]string, 0, len(standards))
+	for name := range standards {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Lookup returns the registered standards with the given names.
+func Lookup(names []string) ([]Standard, error) {
+	selected := make([]Standard, 0, len(names))
+	for _, name := range names {
+		s, ok := standards[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown AQI standard %q, known are %s", name, strings.Join(Names(), ", "))
+		}
+		selected = append(selected, s)
+	}
+	return selected, nil
+}
+
+// band maps the concentrations from cLo to cHi onto the indexes from iLo to
+// iHi.
+type band struct {
+	cLo, cHi, iLo, iHi float64
+	category           string
+}
+
+// interpolate returns the index of the concentration c by linear
+// interpolation within its band, and the category of the band. The index
+// of concentrations above the last band is its highest.
+func interpolate(bands []band, c float64) (float64, string) {
+	for _, b := range bands {
+		if c <= b.cHi {
+			return b.iLo + (b.iHi-b.iLo)/(b.cHi-b.cLo)*(c-b.cLo), b.category
+		}
+	}
+	last := bands[len(bands)-1]
+	return last.iHi, last.category
+}
+
+// Derived emits the indexes of the selected standards for every reading of
+// a device with a PM2.5 sensor.
+type Derived struct {
+	standards []Standard
+	index     *prometheus.Desc
+	category  *prometheus.Desc
+}
+
+// New returns the derived metrics of standards, for
+// exporter.WithDerivedMetrics.
+func New(standards ...Standard) *Derived {
+	return &Derived{
+		standards: standards,
+		index: prometheus.NewDesc(
+			prometheus.BuildFQName("awair", "", "aqi"),
+			"Air quality index of the PM2.5 and PM10 readings by the standard",
+			[]string{"device_uuid", "standard"}, nil,
+		),
+		category: prometheus.NewDesc(
+			prometheus.BuildFQName("awair", "aqi", "category"),
+			"Category of the air quality index by the standard",
+			[]string{"device_uuid", "standard", "category"}, nil,
+		),
+	}
+}
+
+func (d *Derived) Describe(ch chan<- *prometheus.Desc) {
+	ch <- d.index
+	ch <- d.category
+}
+
+func (d *Derived) Collect(ch chan<- prometheus.Metric, values *exporter.AwairValues, config *exporter.ConfigResponse) {
+	if !exporter.ModelOf(config.DeviceUUID).Has("pm25") {
+		return
+	}
+	for _, s := range d.standards {
+		index, category := s.Index(values.PM25, values.PM10Est)
+		ch <- prometheus.MustNewConstMetric(d.index, prometheus.GaugeValue, index, config.DeviceUUID, s.Name())
+		ch <- prometheus.MustNewConstMetric(d.category, prometheus.GaugeValue, 1, config.DeviceUUID, s.Name(), category)
+	}
+}
@@ -0,0 +1,82 @@
+package aqi
+
+import (
+	"strings"
+	"testing"
+
+	"prometheus-awair-exporter/internal/exporter"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"github.com/tj/assert"
+)
+
+func TestStandards(t *testing.T) {
+	tests := []struct {
+		standard   Standard
+		pm25, pm10 float64
+		index      float64
+		category   string
+	}{
+		{usEPA, 0, 0, 0, "good"},
+		{usEPA, 9.05, 10, 50, "good"},
+		{usEPA, 35.4, 40, 100, "moderate"},
+		{usEPA, 12, 200, 123, "unhealthy_for_sensitive_groups"},
+		{usEPA, 1000, 0, 500, "hazardous"},
+		{euCAQI, 20, 10, 33, "low"},
+		{euCAQI, 10, 100, 78, "high"},
+		{euCAQI, 500, 0, 125, "very_high"},
+		{ukDAQI, 11.4, 0, 1, "low"},
+		{ukDAQI, 40, 20, 4, "moderate"},
+		{ukDAQI, 5, 95, 9, "high"},
+		{ukDAQI, 80, 0, 10, "very_high"},
+		{china, 35, 50, 50, "excellent"},
+		{china, 50, 20, 69, "good"},
+		{china, 600, 0, 500, "severely_polluted"},
+	}
+	for _, tt := range tests {
+		index, category := tt.standard.Index(tt.pm25, tt.pm10)
+		assert.Equal(t, tt.index, index, "%s of %v, %v", tt.standard.Name(), tt.pm25, tt.pm10)
+		assert.Equal(t, tt.category, category, "%s of %v, %v", tt.standard.Name(), tt.pm25, tt.pm10)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	assert.Equal(t, []string{"china", "eu-caqi", "uk-daqi", "us-epa"}, Names())
+	selected, err := Lookup([]string{"us-epa", "uk-daqi"})
+	require.Nil(t, err)
+	assert.Equal(t, []Standard{usEPA, ukDAQI}, selected)
+	_, err = Lookup([]string{"who"})
+	assert.EqualError(t, err, `unknown AQI standard "who", known are china, eu-caqi, uk-daqi, us-epa`)
+}
+
+// collector collects the derived metrics of a reading.
+type collector struct {
+	d      *Derived
+	values *exporter.AwairValues
+	config *exporter.ConfigResponse
+}
+
+func (c collector) Describe(ch chan<- *prometheus.Desc) { c.d.Describe(ch) }
+
+func (c collector) Collect(ch chan<- prometheus.Metric) { c.d.Collect(ch, c.values, c.config) }
+
+func TestDerived(t *testing.T) {
+	d := New(usEPA, ukDAQI)
+	c := collector{d, &exporter.AwairValues{PM25: 40, PM10Est: 42}, &exporter.ConfigResponse{DeviceUUID: "awair-element_1"}}
+	expected := `
+# HELP awair_aqi Air quality index of the PM2.5 and PM10 readings by the standard
+# TYPE awair_aqi gauge
+awair_aqi{device_uuid="awair-element_1",standard="uk-daqi"} 4
+awair_aqi{device_uuid="awair-element_1",standard="us-epa"} 112
+# HELP awair_aqi_category Category of the air quality index by the standard
+# TYPE awair_aqi_category gauge
+awair_aqi_category{category="moderate",device_uuid="awair-element_1",standard="uk-daqi"} 1
+awair_aqi_category{category="unhealthy_for_sensitive_groups",device_uuid="awair-element_1",standard="us-epa"} 1
+`
+	assert.Nil(t, testutil.CollectAndCompare(c, strings.NewReader(expected)))
+
+	c.config = &exporter.ConfigResponse{DeviceUUID: "awair-glow-c_2"}
+	assert.Equal(t, 0, testutil.CollectAndCount(c), "left out without a PM2.5 sensor")
+}
@@ -0,0 +1,156 @@
+package aqi
+
+import (
+	"math"
+)
+
+// The devices report current concentrations, so the indexes are of these
+// rather than of the averages, e.g. over 24 hours, the standards prescribe.
+func init() {
+	Register(usEPA)
+	Register(euCAQI)
+	Register(ukDAQI)
+	Register(china)
+}
+
+// bandStandard is a standard taking the higher of the indexes of PM2.5 and
+// PM10 interpolated within their bands.
+type bandStandard struct {
+	name       string
+	pm25, pm10 []band
+	// round rounds the concentrations as the standard prescribes.
+	round func(pm25, pm10 float64) (float64, float64)
+	// index rounds and bounds the interpolated index.
+	index func(float64) float64
+}
+
+func (s *bandStandard) Name() string {
+	return s.name
+}
+
+func (s *bandStandard) Index(pm25, pm10 float64) (float64, string) {
+	pm25, pm10 = s.round(pm25, pm10)
+	index, category := interpolate(s.pm25, pm25)
+	if i, c := interpolate(s.pm10, pm10); i > index {
+		index, category = i, c
+	}
+	return s.index(index), category
+}
+
+// usEPA is the AQI of the US Environmental Protection Agency, with the
+// PM2.5 breakpoints of 2024, from 0 to 500.
+var usEPA = &bandStandard{
+	name: "us-epa",
+	pm25: []band{
+		{0, 9, 0, 50, "good"},
+		{9.1, 35.4, 51, 100, "moderate"},
+		{35.5, 55.4, 101, 150, "unhealthy_for_sensitive_groups"},
+		{55.5, 125.4, 151, 200, "unhealthy"},
+		{125.5, 225.4, 201, 300, "very_unhealthy"},
+		{225.5, 325.4, 301, 500, "hazardous"},
+	},
+	pm10: []band{
+		{0, 54, 0, 50, "good"},
+		{55, 154, 51, 100, "moderate"},
+		{155, 254, 101, 150, "unhealthy_for_sensitive_groups"},
+		{255, 354, 151, 200, "unhealthy"},
+		{355, 424, 201, 300, "very_unhealthy"},
+		{425, 604, 301, 500, "hazardous"},
+	},
+	round: func(pm25, pm10 float64) (float64, float64) {
+		return math.Floor(pm25*10) / 10, math.Floor(pm10)
+	},
+	index: func(i float64) float64 {
+		return math.Min(math.Round(i), 500)
+	},
+}
+
+// euCAQI is the hourly Common Air Quality Index of the EU, 100 and above
+// being very high.
+var euCAQI = &bandStandard{
+	name: "eu-caqi",
+	pm25: []band{
+		{0, 15, 0, 25, "very_low"},
+		{15, 30, 25, 50, "low"},
+		{30, 55, 50, 75, "medium"},
+		{55, 110, 75, 100, "high"},
+		{110, 165, 100, 125, "very_high"},
+	},
+	pm10: []band{
+		{0, 25, 0, 25, "very_low"},
+		{25, 50, 25, 50, "low"},
+		{50, 90, 50, 75, "medium"},
+		{90, 180, 75, 100, "high"},
+		{180, 270, 100, 125, "very_high"},
+	},
+	round: func(pm25, pm10 float64) (float64, float64) {
+		return pm25, pm10
+	},
+	index: func(i float64) float64 {
+		return math.Round(i)
+	},
+}
+
+// ukDAQI is the Daily Air Quality Index of the UK, from 1 to 10.
+var ukDAQI = &bandStandard{
+	name: "uk-daqi",
+	pm25: []band{
+		{0, 11, 1, 1, "low"},
+		{12, 23, 2, 2, "low"},
+		{24, 35, 3, 3, "low"},
+		{36, 41, 4, 4, "moderate"},
+		{42, 47, 5, 5, "moderate"},
+		{48, 53, 6, 6, "moderate"},
+		{54, 58, 7, 7, "high"},
+		{59, 64, 8, 8, "high"},
+		{65, 70, 9, 9, "high"},
+		{71, math.MaxFloat64, 10, 10, "very_high"},
+	},
+	pm10: []band{
+		{0, 16, 1, 1, "low"},
+		{17, 33, 2, 2, "low"},
+		{34, 50, 3, 3, "low"},
+		{51, 58, 4, 4, "moderate"},
+		{59, 66, 5, 5, "moderate"},
+		{67, 75, 6, 6, "moderate"},
+		{76, 83, 7, 7, "high"},
+		{84, 91, 8, 8, "high"},
+		{92, 100, 9, 9, "high"},
+		{101, math.MaxFloat64, 10, 10, "very_high"},
+	},
+	round: func(pm25, pm10 float64) (float64, float64) {
+		return math.Round(pm25), math.Round(pm10)
+	},
+	index: func(i float64) float64 {
+		return i
+	},
+}
+
+// china is the AQI of China's HJ 633-2012, from 0 to 500.
+var china = &bandStandard{
+	name: "china",
+	pm25: []band{
+		{0, 35, 0, 50, "excellent"},
+		{35, 75, 50, 100, "good"},
+		{75, 115, 100, 150, "lightly_polluted"},
+		{115, 150, 150, 200, "moderately_polluted"},
+		{150, 250, 200, 300, "heavily_polluted"},
+		{250, 350, 300, 400, "severely_polluted"},
+		{350, 500, 400, 500, "severely_polluted"},
+	},
+	pm10: []band{
+		{0, 50, 0, 50, "excellent"},
+		{50, 150, 50, 100, "good"},
+		{150, 250, 100, 150, "lightly_polluted"},
+		{250, 350, 150, 200, "moderately_polluted"},
+		{350, 420, 200, 300, "heavily_polluted"},
+		{420, 500, 300, 400, "severely_polluted"},
+		{500, 600, 400, 500, "severely_polluted"},
+	},
+	round: func(pm25, pm10 float64) (float64, float64) {
+		return pm25, pm10
+	},
+	index: func(i float64) float64 {
+		return math.Min(math.Ceil(i), 500)
+	},
+}
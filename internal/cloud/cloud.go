@@ -0,0 +1,325 @@
+// Package cloud takes readings from the Awair Cloud through the developer
+// API, for devices the exporter can't reach on the local network.
+package cloud
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"prometheus-awair-exporter/internal/exporter"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultURL is the base URL of the developer API.
+const DefaultURL = "https://developer-apis.awair.is"
+
+// maxResponseSize bounds the size of an API response.
+const maxResponseSize = 1 << 20
+
+// Client queries the developer API with bearer access tokens. It tracks
+// the daily quotas of the API from the rate limit headers of its responses,
+// skipping requests which would exhaust them, and is a collector of their
+// state.
+type Client struct {
+	url    string
+	tokens TokenSource
+	client *http.Client
+
+	mu     sync.Mutex
+	quotas map[quotaKey]*quota
+	// warned are the quotas whose throttling was logged.
+	warned map[quotaKey]bool
+
+	quotaLimit     *prometheus.Desc
+	quotaRemaining *prometheus.Desc
+	quotaReset     *prometheus.Desc
+	throttled      *prometheus.CounterVec
+}
+
+// New returns a Client for the API at url, e.g. DefaultURL, authenticated
+// by the tokens of tokens, e.g. a StaticToken.
+func New(url string, tokens TokenSource) *Client {
+	labels := []string{"endpoint", "device_uuid"}
+	return &Client{
+		url:    strings.TrimSuffix(url, "/"),
+		tokens: tokens,
+		client: &http.Client{Timeout: 30 * time.Second},
+		quotas: map[quotaKey]*quota{},
+		warned: map[quotaKey]bool{},
+		quotaLimit: prometheus.NewDesc(
+			prometheus.BuildFQName("awair", "cloud", "quota_limit"),
+			"Number of requests the Awair Cloud API quota allows per period",
+			labels, nil,
+		),
+		quotaRemaining: prometheus.NewDesc(
+			prometheus.BuildFQName("awair", "cloud", "quota_remaining"),
+			"Number of requests left of the Awair Cloud API quota",
+			labels, nil,
+		),
+		quotaReset: prometheus.NewDesc(
+			prometheus.BuildFQName("awair", "cloud", "quota_reset_timestamp_seconds"),
+			"Unix time at which the Awair Cloud API quota resets",
+			labels, nil,
+		),
+		throttled: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "awair",
+			Subsystem: "cloud",
+			Name:      "throttled_requests_total",
+			Help:      "Number of requests to the Awair Cloud API skipped to keep its quota from exhaustion",
+		}, labels),
+	}
+}
+
+// Device is a device of the account.
+type Device struct {
+	Name         string  `json:"name"`
+	DeviceUUID   string  `json:"deviceUUID"`
+	DeviceType   string  `json:"deviceType"`
+	DeviceID     int     `json:"deviceId"`
+	MACAddress   string  `json:"macAddress"`
+	RoomType     string  `json:"roomType"`
+	SpaceType    string  `json:"spaceType"`
+	LocationName string  `json:"locationName"`
+	Timezone     string  `json:"timezone"`
+	Preference   string  `json:"preference"`
+	Latitude     float64 `json:"latitude"`
+	Longitude    float64 `json:"longitude"`
+}
+
+// DisplaySettings are the display preferences of a device.
+type DisplaySettings struct {
+	Mode      string `json:"mode"`
+	ClockMode string `json:"clock_mode"`
+	// TempUnit is c or f.
+	TempUnit string `json:"temp_unit"`
+}
+
+// get retrieves path, about the device with uuid unless empty, and decodes
+// the JSON response into v. Error responses are returned as an
+// *exporter.DeviceError, responses which can't be decoded as an
+// *exporter.DecodeError. Requests the quota doesn't allow are skipped with
+// a *throttledError.
+func (c *Client) get(ctx context.Context, endpoint, uuid, path string, v interface{}) error {
+	if err := c.throttle(endpoint, uuid); err != nil {
+		return err
+	}
+	token, err := c.tokens.Token(ctx)
+	if err != nil {
+		return fmt.Errorf("access token: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	c.updateQuota(endpoint, uuid, resp)
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		class := exporter.ErrorClassUnexpected
+		switch {
+		case resp.StatusCode == http.StatusTooManyRequests:
+			class = exporter.ErrorClassRateLimited
+		case resp.StatusCode == http.StatusUnauthorized, resp.StatusCode == http.StatusForbidden:
+			class = exporter.ErrorClassAPIDisabled
+		case resp.StatusCode >= 500:
+			class = exporter.ErrorClassInternal
+		}
+		if len(body) > 256 {
+			body = append(body[:256], "..."...)
+		}
+		return &exporter.DeviceError{Endpoint: endpoint, StatusCode: resp.StatusCode, Class: class, Body: string(body)}
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return &exporter.DecodeError{Endpoint: endpoint, Err: err}
+	}
+	return nil
+}
+
+// Devices returns the devices of the account.
+func (c *Client) Devices(ctx context.Context) ([]Device, error) {
+	resp := struct {
+		Devices []Device `json:"devices"`
+	}{}
+	if err := c.get(ctx, "cloud-devices", "", "/v1/users/self/devices", &resp); err != nil {
+		return nil, err
+	}
+	return resp.Devices, nil
+}
+
+// Display returns the display preferences of the device with uuid.
+func (c *Client) Display(ctx context.Context, uuid string) (*DisplaySettings, error) {
+	deviceType, id, err := splitUUID(uuid)
+	if err != nil {
+		return nil, err
+	}
+	settings := &DisplaySettings{}
+	if err := c.get(ctx, "cloud-display", uuid, fmt.Sprintf("/v1/devices/%s/%s/display", deviceType, id), settings); err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// splitUUID returns the device type and ID of a device UUID such as
+// awair-element_1234.
+func splitUUID(uuid string) (string, string, error) {
+	i := strings.LastIndex(uuid, "_")
+	if i <= 0 || i == len(uuid)-1 {
+		return "", "", fmt.Errorf("invalid device UUID %q, expected e.g. awair-element_1234", uuid)
+	}
+	return uuid[:i], uuid[i+1:], nil
+}
+
+// airData is a reading as returned by the air-data endpoints.
+type airData struct {
+	Timestamp string  `json:"timestamp"`
+	Score     float64 `json:"score"`
+	Sensors   []struct {
+		Comp  string  `json:"comp"`
+		Value float64 `json:"value"`
+	} `json:"sensors"`
+}
+
+// values maps the reading onto the fields of the Local API. Sensors only
+// the Local API reports, such as the VOC baseline, are left zero.
+func (d airData) values() *exporter.AwairValues {
+	v := &exporter.AwairValues{Timestamp: d.Timestamp, Score: d.Score}
+	for _, s := range d.Sensors {
+		switch s.Comp {
+		case "temp":
+			v.Temp = s.Value
+		case "humid":
+			v.Humidity = s.Value
+		case "co2":
+			v.CO2 = s.Value
+		case "voc":
+			v.Voc = s.Value
+		case "pm25":
+			v.PM25 = s.Value
+		case "pm10":
+			v.PM10Est = s.Value
+		}
+	}
+	return v
+}
+
+// Readings of the air-data endpoints: the latest one, or averages over 5
+// or 15 minutes, which are smoother and change less often.
+const (
+	DataLatest   = "latest"
+	Data5MinAvg  = "5-min-avg"
+	Data15MinAvg = "15-min-avg"
+)
+
+// CheckData returns an error unless data names readings of the air-data
+// endpoints.
+func CheckData(data string) error {
+	switch data {
+	case DataLatest, Data5MinAvg, Data15MinAvg:
+		return nil
+	}
+	return fmt.Errorf("unknown air data %q, expected %s, %s or %s", data, DataLatest, Data5MinAvg, Data15MinAvg)
+}
+
+// DataInterval returns the interval at which readings of data change, 0
+// for the latest ones.
+func DataInterval(data string) time.Duration {
+	switch data {
+	case Data5MinAvg:
+		return 5 * time.Minute
+	case Data15MinAvg:
+		return 15 * time.Minute
+	}
+	return 0
+}
+
+// Latest returns the latest reading of the device with uuid.
+func (c *Client) Latest(ctx context.Context, uuid string) (*exporter.AwairValues, error) {
+	return c.AirData(ctx, uuid, DataLatest)
+}
+
+// AirData returns the most recent reading of data, e.g. Data5MinAvg, of the
+// device with uuid.
+func (c *Client) AirData(ctx context.Context, uuid, data string) (*exporter.AwairValues, error) {
+	if err := CheckData(data); err != nil {
+		return nil, err
+	}
+	deviceType, id, err := splitUUID(uuid)
+	if err != nil {
+		return nil, err
+	}
+	resp := struct {
+		Data []airData `json:"data"`
+	}{}
+	path := fmt.Sprintf("/v1/users/self/devices/%s/%s/air-data/%s?fahrenheit=false", deviceType, id, data)
+	if data != DataLatest {
+		// Only the most recent average, which comes first.
+		path += "&limit=1&desc=true"
+	}
+	if err := c.get(ctx, "cloud-air-data", uuid, path, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Data) == 0 {
+		// The cloud keeps no reading of a device offline for a while.
+		return nil, &exporter.DeviceError{
+			Endpoint:   "cloud-air-data",
+			StatusCode: http.StatusOK,
+			Class:      exporter.ErrorClassUnexpected,
+			Body:       "no recent reading",
+		}
+	}
+	return resp.Data[0].values(), nil
+}
+
+// Source takes the readings of a device from the cloud.
+type Source struct {
+	client *Client
+	uuid   string
+	data   string
+
+	// last is the latest reading, served again while the quota is
+	// throttled.
+	last *exporter.AwairValues
+}
+
+// NewSource returns the source of data, e.g. DataLatest, of the device with
+// uuid, e.g. awair-element_1234.
+func NewSource(client *Client, uuid, data string) (*Source, error) {
+	if _, _, err := splitUUID(uuid); err != nil {
+		return nil, err
+	}
+	if err := CheckData(data); err != nil {
+		return nil, err
+	}
+	return &Source{client: client, uuid: uuid, data: data}, nil
+}
+
+// Fetch returns the most recent reading of the device. While the quota is
+// throttled, the latest reading is returned again, if any.
+func (s *Source) Fetch(ctx context.Context) (*exporter.AwairValues, *exporter.ConfigResponse, error) {
+	values, err := s.client.AirData(ctx, s.uuid, s.data)
+	var throttled *throttledError
+	if errors.As(err, &throttled) && s.last != nil {
+		values, err = s.last, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	s.last = values
+	return values, &exporter.ConfigResponse{DeviceUUID: s.uuid}, nil
+}
@@ -0,0 +1,319 @@
+package cloud
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"prometheus-awair-exporter/internal/exporter"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"github.com/tj/assert"
+)
+
+const latest = `{"data": [{
+	"timestamp": "2024-03-01T12:00:00.000Z",
+	"score": 87,
+	"sensors": [
+		{"comp": "temp", "value": 21.4},
+		{"comp": "humid", "value": 44.2},
+		{"comp": "co2", "value": 612},
+		{"comp": "voc", "value": 180},
+		{"comp": "pm25", "value": 4}
+	],
+	"indices": [{"comp": "temp", "value": 0}]
+}]}`
+
+func newTestServer(t *testing.T) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"message": "Unauthorized"}`)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/users/self/devices":
+			fmt.Fprint(w, `{"devices": [{"name": "Cabin", "deviceUUID": "awair-element_1234", "deviceType": "awair-element", "deviceId": 1234, "roomType": "LIVING_ROOM", "preference": "GENERAL", "latitude": 47.61, "longitude": -122.33}]}`)
+		case "/v1/devices/awair-element/1234/display":
+			fmt.Fprint(w, `{"mode": "score", "clock_mode": "24hr", "temp_unit": "f"}`)
+		case "/v1/users/self/devices/awair-element/1234/air-data/latest":
+			assert.Equal(t, "false", r.URL.Query().Get("fahrenheit"))
+			fmt.Fprint(w, latest)
+		case "/v1/users/self/devices/awair-element/1234/air-data/15-min-avg":
+			assert.Equal(t, "1", r.URL.Query().Get("limit"))
+			assert.Equal(t, "true", r.URL.Query().Get("desc"))
+			fmt.Fprint(w, `{"data": [
+				{"timestamp": "2024-03-01T12:00:00.000Z", "score": 85.5, "sensors": [{"comp": "co2", "value": 640.25}]},
+				{"timestamp": "2024-03-01T11:45:00.000Z", "score": 90, "sensors": [{"comp": "co2", "value": 580}]}
+			]}`)
+		case "/v1/users/self/devices/awair-element/1234/air-data/raw":
+			assert.Equal(t, "2024-03-01T11:00:00Z", r.URL.Query().Get("from"))
+			assert.Equal(t, "2024-03-01T12:00:00Z", r.URL.Query().Get("to"))
+			assert.Equal(t, "360", r.URL.Query().Get("limit"))
+			assert.Equal(t, "false", r.URL.Query().Get("desc"))
+			fmt.Fprint(w, `{"data": [
+				{"timestamp": "2024-03-01T11:00:00.000Z", "score": 90, "sensors": [{"comp": "co2", "value": 580}]},
+				{"timestamp": "2024-03-01T11:00:10.000Z", "score": 89, "sensors": [{"comp": "co2", "value": 590}, {"comp": "pm10", "value": 7}]}
+			]}`)
+		case "/v1/users/self/devices/awair-element/5678/air-data/latest":
+			fmt.Fprint(w, `{"data": []}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestClient(t *testing.T) {
+	srv := newTestServer(t)
+	c := New(srv.URL+"/", StaticToken("secret"))
+
+	devices, err := c.Devices(context.Background())
+	require.Nil(t, err)
+	assert.Equal(t, []Device{{
+		Name:       "Cabin",
+		DeviceUUID: "awair-element_1234",
+		DeviceType: "awair-element",
+		DeviceID:   1234,
+		RoomType:   "LIVING_ROOM",
+		Preference: "GENERAL",
+		Latitude:   47.61,
+		Longitude:  -122.33,
+	}}, devices)
+
+	display, err := c.Display(context.Background(), "awair-element_1234")
+	require.Nil(t, err)
+	assert.Equal(t, &DisplaySettings{Mode: "score", ClockMode: "24hr", TempUnit: "f"}, display)
+
+	values, err := c.Latest(context.Background(), "awair-element_1234")
+	require.Nil(t, err)
+	assert.Equal(t, &exporter.AwairValues{
+		Timestamp: "2024-03-01T12:00:00.000Z",
+		Score:     87,
+		Temp:      21.4,
+		Humidity:  44.2,
+		CO2:       612,
+		Voc:       180,
+		PM25:      4,
+	}, values)
+
+	values, err = c.AirData(context.Background(), "awair-element_1234", Data15MinAvg)
+	require.Nil(t, err)
+	assert.Equal(t, &exporter.AwairValues{
+		Timestamp: "2024-03-01T12:00:00.000Z",
+		Score:     85.5,
+		CO2:       640.25,
+	}, values)
+	_, err = c.AirData(context.Background(), "awair-element_1234", "hourly")
+	assert.NotNil(t, err)
+
+	_, err = c.Latest(context.Background(), "awair-element_5678")
+	assert.Equal(t, exporter.ErrorClassUnexpected, exporter.ErrorClass(err))
+
+	_, err = New(srv.URL, StaticToken("wrong")).Devices(context.Background())
+	assert.Equal(t, exporter.ErrorClassAPIDisabled, exporter.ErrorClass(err))
+
+	_, err = NewSource(c, "1234", DataLatest)
+	assert.NotNil(t, err)
+	_, err = NewSource(c, "awair-element_1234", "hourly")
+	assert.NotNil(t, err)
+}
+
+func TestHistory(t *testing.T) {
+	srv := newTestServer(t)
+	c := New(srv.URL, StaticToken("secret"))
+	from := time.Date(2024, 3, 1, 11, 0, 0, 0, time.UTC)
+
+	readings, fields, err := c.History(context.Background(), "awair-element_1234", DataRaw, from, from.Add(time.Hour))
+	require.Nil(t, err)
+	assert.Equal(t, []exporter.AwairValues{
+		{Timestamp: "2024-03-01T11:00:00.000Z", Score: 90, CO2: 580},
+		{Timestamp: "2024-03-01T11:00:10.000Z", Score: 89, CO2: 590, PM10Est: 7},
+	}, readings)
+	assert.Equal(t, []string{"score", "co2", "pm10_est"}, fields)
+
+	_, _, err = c.History(context.Background(), "awair-element_1234", DataRaw, from, from.Add(2*time.Hour))
+	assert.NotNil(t, err, "longer than an hour of raw readings")
+	_, _, err = c.History(context.Background(), "awair-element_1234", DataLatest, from, from.Add(time.Hour))
+	assert.NotNil(t, err)
+}
+
+func TestSource(t *testing.T) {
+	srv := newTestServer(t)
+	src, err := NewSource(New(srv.URL, StaticToken("secret")), "awair-element_1234", DataLatest)
+	require.Nil(t, err)
+	e := exporter.NewSourcedExporter("awair-element_1234", src,
+		exporter.WithLabels(map[string]string{"source": "cloud"}))
+
+	assert.Nil(t, testutil.CollectAndCompare(e, strings.NewReader(`
+# HELP awair_co2 Carbon Dioxide (ppm)
+# TYPE awair_co2 gauge
+awair_co2{device_uuid="awair-element_1234",source="cloud"} 612
+# HELP awair_up Whether the latest query of the device succeeded (1) or failed (0)
+# TYPE awair_up gauge
+awair_up{device_uuid="awair-element_1234",source="cloud"} 1
+`), "awair_co2", "awair_up"))
+
+	src, err = NewSource(New(srv.URL, StaticToken("secret")), "awair-element_5678", DataLatest)
+	require.Nil(t, err)
+	e = exporter.NewSourcedExporter("awair-element_5678", src)
+	assert.Nil(t, testutil.CollectAndCompare(e, strings.NewReader(`
+# HELP awair_up Whether the latest query of the device succeeded (1) or failed (0)
+# TYPE awair_up gauge
+awair_up{device_uuid="awair-element_5678"} 0
+`), "awair_up"))
+}
+
+func TestInventory(t *testing.T) {
+	srv := newTestServer(t)
+	i := NewInventory(New(srv.URL, StaticToken("secret")), false)
+	assert.Nil(t, i.DeviceLabels("awair-element_1234"))
+
+	devices, err := i.Sync(context.Background())
+	require.Nil(t, err)
+	assert.Len(t, devices, 1)
+	assert.Equal(t, map[string]string{
+		"location":   "",
+		"name":       "Cabin",
+		"room_type":  "living_room",
+		"space_type": "",
+	}, i.DeviceLabels("awair-element_1234"))
+
+	_, err = NewInventory(New(srv.URL, StaticToken("wrong")), false).Sync(context.Background())
+	assert.NotNil(t, err)
+
+	assert.Nil(t, testutil.CollectAndCompare(i, strings.NewReader(`
+# HELP awair_cloud_device_info Metadata of the device as set in the Awair app, always 1
+# TYPE awair_cloud_device_info gauge
+awair_cloud_device_info{device_uuid="awair-element_1234",location="",name="Cabin",preference="general",room_type="living_room",space_type="",temp_unit="f"} 1
+# HELP awair_cloud_devices Number of devices of the Awair Cloud account
+# TYPE awair_cloud_devices gauge
+awair_cloud_devices 1
+# HELP awair_cloud_sync_failures_total Number of failed syncs of the devices of the Awair Cloud account
+# TYPE awair_cloud_sync_failures_total counter
+awair_cloud_sync_failures_total 0
+`)))
+
+	i = NewInventory(New(srv.URL, StaticToken("secret")), true)
+	_, err = i.Sync(context.Background())
+	require.Nil(t, err)
+	assert.Nil(t, testutil.CollectAndCompare(i, strings.NewReader(`
+# HELP awair_cloud_device_info Metadata of the device as set in the Awair app, always 1
+# TYPE awair_cloud_device_info gauge
+awair_cloud_device_info{device_uuid="awair-element_1234",latitude="47.61",location="",longitude="-122.33",name="Cabin",preference="general",room_type="living_room",space_type="",temp_unit="f"} 1
+`), "awair_cloud_device_info"))
+}
+
+func TestQuota(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch r.URL.Path {
+		case "/v1/users/self/devices/awair-element/1234/air-data/latest":
+			w.Header().Set("X-RateLimit-Limit", "100")
+			w.Header().Set("X-RateLimit-Remaining", "10")
+			w.Header().Set("X-RateLimit-Reset", "3600")
+			fmt.Fprint(w, latest)
+		default:
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer srv.Close()
+	c := New(srv.URL, StaticToken("secret"))
+
+	src, err := NewSource(c, "awair-element_1234", DataLatest)
+	require.Nil(t, err)
+	values, _, err := src.Fetch(context.Background())
+	require.Nil(t, err)
+	// 10 requests left for the next hour are spread over it, the latest
+	// reading is served in the meantime.
+	cached, _, err := src.Fetch(context.Background())
+	require.Nil(t, err)
+	assert.Equal(t, values, cached)
+	assert.Equal(t, 1, requests)
+	_, err = c.Latest(context.Background(), "awair-element_1234")
+	assert.Equal(t, exporter.ErrorClassRateLimited, exporter.ErrorClass(err))
+
+	// Without headers, a 429 exhausts the quota.
+	_, err = c.Latest(context.Background(), "awair-element_5678")
+	assert.Equal(t, exporter.ErrorClassRateLimited, exporter.ErrorClass(err))
+	_, err = c.Latest(context.Background(), "awair-element_5678")
+	assert.Equal(t, exporter.ErrorClassRateLimited, exporter.ErrorClass(err))
+	assert.Equal(t, 2, requests)
+
+	assert.Nil(t, testutil.CollectAndCompare(c, strings.NewReader(`
+# HELP awair_cloud_quota_limit Number of requests the Awair Cloud API quota allows per period
+# TYPE awair_cloud_quota_limit gauge
+awair_cloud_quota_limit{device_uuid="awair-element_1234",endpoint="cloud-air-data"} 100
+# HELP awair_cloud_quota_remaining Number of requests left of the Awair Cloud API quota
+# TYPE awair_cloud_quota_remaining gauge
+awair_cloud_quota_remaining{device_uuid="awair-element_1234",endpoint="cloud-air-data"} 10
+awair_cloud_quota_remaining{device_uuid="awair-element_5678",endpoint="cloud-air-data"} 0
+# HELP awair_cloud_throttled_requests_total Number of requests to the Awair Cloud API skipped to keep its quota from exhaustion
+# TYPE awair_cloud_throttled_requests_total counter
+awair_cloud_throttled_requests_total{device_uuid="awair-element_1234",endpoint="cloud-air-data"} 2
+awair_cloud_throttled_requests_total{device_uuid="awair-element_5678",endpoint="cloud-air-data"} 1
+`), "awair_cloud_quota_limit", "awair_cloud_quota_remaining", "awair_cloud_throttled_requests_total"))
+}
+
+func TestOAuth2(t *testing.T) {
+	grants := []string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Nil(t, r.ParseForm())
+		assert.Equal(t, "exporter", r.PostForm.Get("client_id"))
+		assert.Equal(t, "hunter2", r.PostForm.Get("client_secret"))
+		grants = append(grants, r.PostForm.Get("grant_type")+" "+r.PostForm.Get("refresh_token"))
+		if len(grants) == 3 {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error": "invalid_grant"}`)
+			return
+		}
+		fmt.Fprintf(w, `{"access_token": "token-%d", "expires_in": 600, "refresh_token": "refresh-%d"}`, len(grants), len(grants))
+	}))
+	defer srv.Close()
+	now := time.Unix(1700000000, 0)
+	o := NewOAuth2(srv.URL, "exporter", "hunter2", "refresh-0")
+	o.now = func() time.Time { return now }
+
+	token, err := o.Token(context.Background())
+	require.Nil(t, err)
+	assert.Equal(t, "token-1", token)
+	now = now.Add(time.Minute)
+	token, _ = o.Token(context.Background())
+	assert.Equal(t, "token-1", token, "cached until shortly before expiry")
+	// Refreshed a tenth of its lifetime before expiry, with the rotated
+	// refresh token.
+	now = now.Add(8 * time.Minute)
+	token, _ = o.Token(context.Background())
+	assert.Equal(t, "token-2", token)
+	assert.Equal(t, []string{"refresh_token refresh-0", "refresh_token refresh-1"}, grants)
+
+	// A failed refresh keeps the token until it expires.
+	now = now.Add(9 * time.Minute)
+	token, err = o.Token(context.Background())
+	require.Nil(t, err)
+	assert.Equal(t, "token-2", token)
+	now = now.Add(time.Minute)
+	token, _ = o.Token(context.Background())
+	assert.Equal(t, "token-4", token)
+
+	assert.Nil(t, testutil.CollectAndCompare(o, strings.NewReader(`
+# HELP awair_cloud_token_expiry_timestamp_seconds Unix time at which the access token of the Awair Cloud API expires
+# TYPE awair_cloud_token_expiry_timestamp_seconds gauge
+awair_cloud_token_expiry_timestamp_seconds 1.70000174e+09
+# HELP awair_cloud_token_refresh_failures_total Number of failed requests for an access token of the Awair Cloud API
+# TYPE awair_cloud_token_refresh_failures_total counter
+awair_cloud_token_refresh_failures_total 1
+`)))
+
+	c := NewOAuth2(srv.URL, "exporter", "hunter2", "")
+	_, err = c.Token(context.Background())
+	require.Nil(t, err)
+	assert.Equal(t, "client_credentials ", grants[len(grants)-1])
+}
@@ -0,0 +1,94 @@
+package cloud
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	"prometheus-awair-exporter/internal/exporter"
+)
+
+// DataRaw are the readings as taken, every 10 seconds, which the air-data
+// endpoints only return for a time range.
+const DataRaw = "raw"
+
+// historyLimits are the longest time range the air-data endpoints return
+// readings of data for per request, and how many readings that is.
+var historyLimits = map[string]struct {
+	span  time.Duration
+	limit int
+}{
+	DataRaw:      {time.Hour, 360},
+	Data5MinAvg:  {24 * time.Hour, 288},
+	Data15MinAvg: {7 * 24 * time.Hour, 672},
+}
+
+// compFields maps the sensors of the air-data endpoints to the fields of
+// the Local API.
+var compFields = map[string]string{
+	"temp":  "temp",
+	"humid": "humid",
+	"co2":   "co2",
+	"voc":   "voc",
+	"pm25":  "pm25",
+	"pm10":  "pm10_est",
+}
+
+// HistorySpan returns the longest time range History returns readings of
+// data for, or an error for data without history, such as DataLatest.
+func HistorySpan(data string) (time.Duration, error) {
+	l, ok := historyLimits[data]
+	if !ok {
+		return 0, fmt.Errorf("unknown history data %q, expected %s, %s or %s", data, DataRaw, Data5MinAvg, Data15MinAvg)
+	}
+	return l.span, nil
+}
+
+// History returns the readings of data, e.g. Data5MinAvg, of the device with
+// uuid from from until to, in time order. The range must not be longer than
+// HistorySpan(data). It returns the readings and the names of the fields
+// present in them, as history.ReadCSV does.
+func (c *Client) History(ctx context.Context, uuid, data string, from, to time.Time) ([]exporter.AwairValues, []string, error) {
+	span, err := HistorySpan(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !to.After(from) || to.Sub(from) > span {
+		return nil, nil, fmt.Errorf("history range %s to %s isn't within %s", from.Format(time.RFC3339), to.Format(time.RFC3339), span)
+	}
+	deviceType, id, err := splitUUID(uuid)
+	if err != nil {
+		return nil, nil, err
+	}
+	query := url.Values{
+		"from":       {from.UTC().Format(time.RFC3339)},
+		"to":         {to.UTC().Format(time.RFC3339)},
+		"limit":      {fmt.Sprint(historyLimits[data].limit)},
+		"desc":       {"false"},
+		"fahrenheit": {"false"},
+	}
+	resp := struct {
+		Data []airData `json:"data"`
+	}{}
+	path := fmt.Sprintf("/v1/users/self/devices/%s/%s/air-data/%s?%s", deviceType, id, data, query.Encode())
+	if err := c.get(ctx, "cloud-air-data", uuid, path, &resp); err != nil {
+		return nil, nil, err
+	}
+	readings := make([]exporter.AwairValues, 0, len(resp.Data))
+	fields := []string{"score"}
+	seen := map[string]bool{}
+	for _, d := range resp.Data {
+		readings = append(readings, *d.values())
+		for _, s := range d.Sensors {
+			if f, ok := compFields[s.Comp]; ok && !seen[f] {
+				seen[f] = true
+				fields = append(fields, f)
+			}
+		}
+	}
+	if len(readings) == 0 {
+		fields = nil
+	}
+	return readings, fields, nil
+}
@@ -0,0 +1,170 @@
+package cloud
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+// UpdateFunc receives the devices of the account after every sync.
+type UpdateFunc func(devices []Device)
+
+// Inventory keeps the devices of the account, labelling the series of
+// devices, local ones included, with their name, room, space and location
+// as set in the Awair app. It exposes their metadata as an info metric.
+type Inventory struct {
+	client *Client
+	// coordinates adds the latitude and longitude of devices to the info
+	// metric.
+	coordinates bool
+
+	mu      sync.Mutex
+	devices map[string]Device
+	// tempUnits are the temperature units the devices display, by device
+	// UUID.
+	tempUnits map[string]string
+
+	size     *prometheus.Desc
+	info     *prometheus.Desc
+	failures prometheus.Counter
+}
+
+// NewInventory returns an Inventory of the account of client, which is
+// empty until synced. With coordinates, the info metric of devices carries
+// their latitude and longitude, which tell where their owners live.
+func NewInventory(client *Client, coordinates bool) *Inventory {
+	labels := []string{"device_uuid", "name", "location", "room_type", "space_type", "preference", "temp_unit"}
+	if coordinates {
+		labels = append(labels, "latitude", "longitude")
+	}
+	return &Inventory{
+		client:      client,
+		coordinates: coordinates,
+		devices:     map[string]Device{},
+		tempUnits:   map[string]string{},
+		info: prometheus.NewDesc(
+			prometheus.BuildFQName("awair", "cloud", "device_info"),
+			"Metadata of the device as set in the Awair app, always 1",
+			labels, nil,
+		),
+		size: prometheus.NewDesc(
+			prometheus.BuildFQName("awair", "cloud", "devices"),
+			"Number of devices of the Awair Cloud account",
+			nil, nil,
+		),
+		failures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "awair",
+			Subsystem: "cloud",
+			Name:      "sync_failures_total",
+			Help:      "Number of failed syncs of the devices of the Awair Cloud account",
+		}),
+	}
+}
+
+// Sync lists the devices of the account, returning them, and the units
+// they display temperatures in. A device whose display preferences can't
+// be read keeps the unit read last.
+func (i *Inventory) Sync(ctx context.Context) ([]Device, error) {
+	devices, err := i.client.Devices(ctx)
+	if err != nil {
+		i.failures.Inc()
+		return nil, err
+	}
+	byUUID := map[string]Device{}
+	for _, d := range devices {
+		byUUID[d.DeviceUUID] = d
+	}
+	i.mu.Lock()
+	previous := i.tempUnits
+	i.mu.Unlock()
+	tempUnits := map[string]string{}
+	for uuid := range byUUID {
+		display, err := i.client.Display(ctx, uuid)
+		if err != nil {
+			log.Debug().Err(err).Str("device_uuid", uuid).Msg("Failed to read display preferences from the Awair Cloud.")
+			tempUnits[uuid] = previous[uuid]
+			continue
+		}
+		tempUnits[uuid] = display.TempUnit
+	}
+	i.mu.Lock()
+	i.devices = byUUID
+	i.tempUnits = tempUnits
+	i.mu.Unlock()
+	return devices, nil
+}
+
+// Run syncs every interval until ctx is done, handing the devices to
+// update after every successful sync, unless nil.
+func (i *Inventory) Run(ctx context.Context, interval time.Duration, update UpdateFunc) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			devices, err := i.Sync(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Error().Err(err).Msg("Failed to sync devices of the Awair Cloud account.")
+				}
+				continue
+			}
+			if update != nil {
+				update(devices)
+			}
+		}
+	}
+}
+
+// LabelNames returns the names of the labels of DeviceLabels.
+func (i *Inventory) LabelNames() []string {
+	return []string{"location", "name", "room_type", "space_type"}
+}
+
+// DeviceLabels returns the labels of the device with uuid, empty if it
+// isn't in the account. Room and space types are lower-cased, e.g.
+// living_room.
+func (i *Inventory) DeviceLabels(uuid string) map[string]string {
+	i.mu.Lock()
+	d, ok := i.devices[uuid]
+	i.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	return map[string]string{
+		"location":   d.LocationName,
+		"name":       d.Name,
+		"room_type":  strings.ToLower(d.RoomType),
+		"space_type": strings.ToLower(d.SpaceType),
+	}
+}
+
+func (i *Inventory) Describe(ch chan<- *prometheus.Desc) {
+	ch <- i.size
+	ch <- i.info
+	i.failures.Describe(ch)
+}
+
+func (i *Inventory) Collect(ch chan<- prometheus.Metric) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	ch <- prometheus.MustNewConstMetric(i.size, prometheus.GaugeValue, float64(len(i.devices)))
+	for uuid, d := range i.devices {
+		values := []string{
+			uuid, d.Name, d.LocationName, strings.ToLower(d.RoomType), strings.ToLower(d.SpaceType),
+			strings.ToLower(d.Preference), i.tempUnits[uuid],
+		}
+		if i.coordinates {
+			values = append(values, strconv.FormatFloat(d.Latitude, 'f', -1, 64), strconv.FormatFloat(d.Longitude, 'f', -1, 64))
+		}
+		ch <- prometheus.MustNewConstMetric(i.info, prometheus.GaugeValue, 1, values...)
+	}
+	i.failures.Collect(ch)
+}
@@ -0,0 +1,180 @@
+package cloud
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"prometheus-awair-exporter/internal/exporter"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+// quotaReserve is the share of a quota left unused, so the account's other
+// clients, such as the Awair app, aren't starved.
+const quotaReserve = 0.05
+
+// quotaPaced is the share of a quota below which requests are spread over
+// the time left until the quota resets.
+const quotaPaced = 0.25
+
+// defaultQuotaReset is how long a quota is assumed exhausted after a 429
+// response which doesn't tell when it resets.
+const defaultQuotaReset = time.Hour
+
+// quotaKey identifies a quota, which the API keeps per endpoint and device.
+type quotaKey struct {
+	endpoint string
+	uuid     string
+}
+
+// quota is the state of a quota as of the latest response.
+type quota struct {
+	limit     int
+	remaining int
+	reset     time.Time
+	// last is when the latest request was made.
+	last time.Time
+}
+
+// allow reports whether a request may be made at now, or when it may be
+// made otherwise.
+func (q *quota) allow(now time.Time) (bool, time.Time) {
+	if q.remaining < 0 || !now.Before(q.reset) {
+		// Unknown, or reset since.
+		return true, time.Time{}
+	}
+	reserve := 1
+	if r := int(float64(q.limit) * quotaReserve); r > reserve {
+		reserve = r
+	}
+	if q.remaining <= reserve {
+		return false, q.reset
+	}
+	if float64(q.remaining) < float64(q.limit)*quotaPaced {
+		spacing := q.reset.Sub(q.last) / time.Duration(q.remaining-reserve)
+		if next := q.last.Add(spacing); now.Before(next) {
+			return false, next
+		}
+	}
+	return true, time.Time{}
+}
+
+// update records the quota headers of resp, if any. A 429 response without
+// them exhausts the quota until Retry-After, or for defaultQuotaReset.
+func (q *quota) update(resp *http.Response, now time.Time) {
+	h := resp.Header
+	if limit, err := strconv.Atoi(h.Get("X-RateLimit-Limit")); err == nil {
+		q.limit = limit
+	}
+	if remaining, err := strconv.Atoi(h.Get("X-RateLimit-Remaining")); err == nil {
+		q.remaining = remaining
+	}
+	if reset, err := strconv.ParseInt(h.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+		// Either a Unix time or the seconds left.
+		if reset > 1e9 {
+			q.reset = time.Unix(reset, 0)
+		} else {
+			q.reset = now.Add(time.Duration(reset) * time.Second)
+		}
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		q.remaining = 0
+		if !q.reset.After(now) {
+			q.reset = now.Add(defaultQuotaReset)
+			if s, err := strconv.Atoi(h.Get("Retry-After")); err == nil {
+				q.reset = now.Add(time.Duration(s) * time.Second)
+			}
+		}
+	}
+}
+
+// throttledError is returned in place of a request which would take a
+// quota too close to exhaustion. It is classified as rate limited.
+type throttledError struct {
+	*exporter.DeviceError
+}
+
+func newThrottledError(endpoint string, until time.Time) *throttledError {
+	return &throttledError{
+		DeviceError: &exporter.DeviceError{
+			Endpoint: endpoint,
+			Class:    exporter.ErrorClassRateLimited,
+			Body:     fmt.Sprintf("quota nearly exhausted, skipping requests until %s", until.Format(time.RFC3339)),
+		},
+	}
+}
+
+func (e *throttledError) Unwrap() error {
+	return e.DeviceError
+}
+
+// throttle returns a *throttledError if the quota of endpoint for the
+// device with uuid doesn't allow a request now, and records the request
+// otherwise.
+func (c *Client) throttle(endpoint, uuid string) error {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	q, ok := c.quotas[quotaKey{endpoint, uuid}]
+	if !ok {
+		return nil
+	}
+	if allowed, until := q.allow(now); !allowed {
+		c.throttled.WithLabelValues(endpoint, uuid).Inc()
+		if !c.warned[quotaKey{endpoint, uuid}] {
+			c.warned[quotaKey{endpoint, uuid}] = true
+			log.Warn().
+				Str("endpoint", endpoint).
+				Str("device_uuid", uuid).
+				Int("remaining", q.remaining).
+				Time("until", until).
+				Msg("Awair Cloud API quota nearly exhausted, skipping requests.")
+		}
+		return newThrottledError(endpoint, until)
+	}
+	delete(c.warned, quotaKey{endpoint, uuid})
+	q.last = now
+	return nil
+}
+
+// updateQuota records the quota of endpoint for the device with uuid as of
+// resp.
+func (c *Client) updateQuota(endpoint, uuid string, resp *http.Response) {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := quotaKey{endpoint, uuid}
+	q, ok := c.quotas[key]
+	if !ok {
+		q = &quota{remaining: -1, last: now}
+	}
+	q.update(resp, now)
+	if q.remaining >= 0 {
+		c.quotas[key] = q
+	}
+}
+
+func (c *Client) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.quotaLimit
+	ch <- c.quotaRemaining
+	ch <- c.quotaReset
+	c.throttled.Describe(ch)
+}
+
+func (c *Client) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, q := range c.quotas {
+		if q.limit > 0 {
+			ch <- prometheus.MustNewConstMetric(c.quotaLimit, prometheus.GaugeValue, float64(q.limit), key.endpoint, key.uuid)
+		}
+		ch <- prometheus.MustNewConstMetric(c.quotaRemaining, prometheus.GaugeValue, float64(q.remaining), key.endpoint, key.uuid)
+		if !q.reset.IsZero() {
+			ch <- prometheus.MustNewConstMetric(c.quotaReset, prometheus.GaugeValue, float64(q.reset.Unix()), key.endpoint, key.uuid)
+		}
+	}
+	c.throttled.Collect(ch)
+}
@@ -0,0 +1,177 @@
+package cloud
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+// maxRefreshMargin bounds how long before its expiry an access token is
+// refreshed.
+const maxRefreshMargin = 5 * time.Minute
+
+// TokenSource provides the access token requests to the API are
+// authenticated by.
+type TokenSource interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// StaticToken is an access token which never changes, such as the one
+// shown in the developer console.
+type StaticToken string
+
+func (t StaticToken) Token(context.Context) (string, error) {
+	return string(t), nil
+}
+
+// OAuth2 obtains access tokens from an OAuth2 token endpoint, with a
+// refresh token if given and the client credentials grant otherwise. A
+// token is refreshed once less than a tenth of its lifetime, at most
+// maxRefreshMargin, is left, so requests never carry an expired one. It is
+// a collector of the expiry of the current token.
+type OAuth2 struct {
+	tokenURL     string
+	clientID     string
+	clientSecret string
+	client       *http.Client
+	now          func() time.Time
+
+	mu           sync.Mutex
+	refreshToken string
+	token        string
+	expiry       time.Time
+	refreshAt    time.Time
+
+	expiryDesc *prometheus.Desc
+	failures   prometheus.Counter
+}
+
+// NewOAuth2 returns an OAuth2 token source for the client with id and
+// secret at the token endpoint tokenURL. With a refreshToken, tokens are
+// obtained by the refresh token grant, following the refresh tokens the
+// endpoint rotates in.
+func NewOAuth2(tokenURL, id, secret, refreshToken string) *OAuth2 {
+	return &OAuth2{
+		tokenURL:     tokenURL,
+		clientID:     id,
+		clientSecret: secret,
+		client:       &http.Client{Timeout: 30 * time.Second},
+		now:          time.Now,
+		refreshToken: refreshToken,
+		expiryDesc: prometheus.NewDesc(
+			prometheus.BuildFQName("awair", "cloud", "token_expiry_timestamp_seconds"),
+			"Unix time at which the access token of the Awair Cloud API expires",
+			nil, nil,
+		),
+		failures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "awair",
+			Subsystem: "cloud",
+			Name:      "token_refresh_failures_total",
+			Help:      "Number of failed requests for an access token of the Awair Cloud API",
+		}),
+	}
+}
+
+// Token returns the current access token, refreshing it first if it is
+// about to expire. While refreshing fails, a token which hasn't expired
+// yet is still returned.
+func (o *OAuth2) Token(ctx context.Context) (string, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	now := o.now()
+	if o.token != "" && now.Before(o.refreshAt) {
+		return o.token, nil
+	}
+	if err := o.refresh(ctx, now); err != nil {
+		o.failures.Inc()
+		if o.token != "" && (o.expiry.IsZero() || now.Before(o.expiry)) {
+			log.Warn().Err(err).Time("expiry", o.expiry).Msg("Failed to refresh Awair Cloud access token, using the current one")
+			return o.token, nil
+		}
+		return "", err
+	}
+	return o.token, nil
+}
+
+// refresh requests a new access token.
+func (o *OAuth2) refresh(ctx context.Context, now time.Time) error {
+	form := url.Values{"client_id": {o.clientID}, "client_secret": {o.clientSecret}}
+	if o.refreshToken != "" {
+		form.Set("grant_type", "refresh_token")
+		form.Set("refresh_token", o.refreshToken)
+	} else {
+		form.Set("grant_type", "client_credentials")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		if len(body) > 256 {
+			body = append(body[:256], "..."...)
+		}
+		return fmt.Errorf("token endpoint answered %s: %s", resp.Status, body)
+	}
+	token := struct {
+		AccessToken  string `json:"access_token"`
+		ExpiresIn    int    `json:"expires_in"`
+		RefreshToken string `json:"refresh_token"`
+	}{}
+	if err := json.Unmarshal(body, &token); err != nil {
+		return fmt.Errorf("token endpoint response: %w", err)
+	}
+	if token.AccessToken == "" {
+		return fmt.Errorf("token endpoint returned no access token")
+	}
+	o.token = token.AccessToken
+	if token.RefreshToken != "" {
+		o.refreshToken = token.RefreshToken
+	}
+	if token.ExpiresIn <= 0 {
+		// Valid until the API rejects it.
+		o.expiry, o.refreshAt = time.Time{}, now.Add(24*time.Hour)
+	} else {
+		lifetime := time.Duration(token.ExpiresIn) * time.Second
+		margin := lifetime / 10
+		if margin > maxRefreshMargin {
+			margin = maxRefreshMargin
+		}
+		o.expiry, o.refreshAt = now.Add(lifetime), now.Add(lifetime-margin)
+	}
+	log.Debug().Time("expiry", o.expiry).Msg("Refreshed Awair Cloud access token")
+	return nil
+}
+
+func (o *OAuth2) Describe(ch chan<- *prometheus.Desc) {
+	ch <- o.expiryDesc
+	o.failures.Describe(ch)
+}
+
+func (o *OAuth2) Collect(ch chan<- prometheus.Metric) {
+	o.mu.Lock()
+	expiry := o.expiry
+	o.mu.Unlock()
+	if !expiry.IsZero() {
+		ch <- prometheus.MustNewConstMetric(o.expiryDesc, prometheus.GaugeValue, float64(expiry.Unix()))
+	}
+	o.failures.Collect(ch)
+}
@@ -0,0 +1,241 @@
+// Package compliance evaluates the readings of devices against the
+// thresholds of the RESET Air and WELL building standards, for tracking
+// green building certifications.
+package compliance
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"prometheus-awair-exporter/internal/config"
+	"prometheus-awair-exporter/internal/exporter"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultWindow is the period compliance percentages cover unless the
+// configuration sets one.
+const DefaultWindow = 24 * time.Hour
+
+// vocFactor converts the TVOC readings of the devices in ppb to the µg/m³
+// the standards give their thresholds in, by the Mølhave mixture.
+const vocFactor = 4.5
+
+// check is a threshold of a parameter, a field of the readings, within a
+// band of a standard. A reading passes if it is within min and max.
+type check struct {
+	standard, band, parameter string
+	min, max                  float64
+}
+
+// standards are the checks of each standard, with acceptable and the
+// stricter high_performance bands.
+var standards = map[string][]check{
+	// RESET Air for commercial interiors.
+	"reset": {
+		{"reset", "acceptable", "pm25", 0, 35},
+		{"reset", "acceptable", "co2", 0, 1000},
+		{"reset", "acceptable", "voc", 0, 500 / vocFactor},
+		{"reset", "high_performance", "pm25", 0, 12},
+		{"reset", "high_performance", "co2", 0, 600},
+		{"reset", "high_performance", "voc", 0, 400 / vocFactor},
+	},
+	// WELL v2, the air quality precondition as acceptable and the enhanced
+	// air quality and ventilation optimizations as high performance.
+	"well": {
+		{"well", "acceptable", "pm25", 0, 15},
+		{"well", "acceptable", "pm10_est", 0, 50},
+		{"well", "acceptable", "co2", 0, 900},
+		{"well", "acceptable", "voc", 0, 500 / vocFactor},
+		{"well", "acceptable", "humid", 30, 60},
+		{"well", "high_performance", "pm25", 0, 12},
+		{"well", "high_performance", "pm10_est", 0, 30},
+		{"well", "high_performance", "co2", 0, 750},
+		{"well", "high_performance", "voc", 0, 500 / vocFactor},
+		{"well", "high_performance", "humid", 40, 60},
+	},
+}
+
+// ReadingSource provides the latest reading of every device by name.
+type ReadingSource interface {
+	NamedReadings() map[string]exporter.Reading
+}
+
+// outcome is the outcome of a check of a reading.
+type outcome int8
+
+const (
+	// unknown is the outcome of checks of a sensor the device lacks.
+	unknown outcome = iota
+	failed
+	passed
+)
+
+// sample is the outcome of every check of a reading at a time.
+type sample struct {
+	at       time.Time
+	outcomes []outcome
+}
+
+// device is the samples of a device over the window.
+type device struct {
+	uuid    string
+	samples []sample
+}
+
+// Tracker evaluates the latest readings of every device against the
+// checks of the selected standards at an interval, exposing whether the
+// latest passes and the share of readings which passed over the window.
+type Tracker struct {
+	src    ReadingSource
+	checks []check
+	window time.Duration
+
+	mu      sync.Mutex
+	devices map[string]*device
+
+	pass  *prometheus.Desc
+	ratio *prometheus.Desc
+}
+
+// New returns a Tracker of the standards of c.
+func New(src ReadingSource, c config.Compliance) (*Tracker, error) {
+	t := &Tracker{src: src, window: c.Window, devices: map[string]*device{}}
+	if t.window <= 0 {
+		t.window = DefaultWindow
+	}
+	for _, name := range c.Standards {
+		checks, ok := standards[name]
+		if !ok {
+			known := []string{}
+			for name := range standards {
+				known = append(known, name)
+			}
+			sort.Strings(known)
+			return nil, fmt.Errorf("unknown compliance standard %q, known are %s", name, strings.Join(known, ", "))
+		}
+		t.checks = append(t.checks, checks...)
+	}
+	labels := []string{"device_uuid", "standard", "band", "parameter"}
+	t.pass = prometheus.NewDesc(
+		prometheus.BuildFQName("awair", "compliance", "pass"),
+		"Whether the latest reading of the parameter is within the band of the standard (1) or not (0)",
+		labels, nil,
+	)
+	t.ratio = prometheus.NewDesc(
+		prometheus.BuildFQName("awair", "compliance", "ratio"),
+		"Share of the readings of the parameter within the band of the standard over the compliance window",
+		labels, nil,
+	)
+	return t, nil
+}
+
+// Run evaluates the readings at interval until ctx is done. Every interval
+// counts as one reading, so the ratios are shares of time.
+func (t *Tracker) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			t.record(now)
+		}
+	}
+}
+
+// record evaluates the current readings, dropping samples older than the
+// window and devices which are gone.
+func (t *Tracker) record(now time.Time) {
+	readings := t.src.NamedReadings()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for name := range t.devices {
+		if _, ok := readings[name]; !ok {
+			delete(t.devices, name)
+		}
+	}
+	for name, r := range readings {
+		if r.Values == nil || r.Config == nil {
+			continue
+		}
+		d, ok := t.devices[name]
+		if !ok {
+			d = &device{}
+			t.devices[name] = d
+		}
+		d.uuid = r.Config.DeviceUUID
+		kept := d.samples[:0]
+		for _, s := range d.samples {
+			if now.Sub(s.at) < t.window {
+				kept = append(kept, s)
+			}
+		}
+		d.samples = append(kept, sample{at: now, outcomes: t.evaluate(r)})
+	}
+}
+
+// evaluate returns the outcome of every check of a reading.
+func (t *Tracker) evaluate(r exporter.Reading) []outcome {
+	model := exporter.ModelOf(r.Config.DeviceUUID)
+	values := map[string]float64{}
+	for _, f := range r.Values.Fields() {
+		values[f.Name] = f.Value
+	}
+	outcomes := make([]outcome, len(t.checks))
+	for i, c := range t.checks {
+		if !model.Has(c.parameter) || !r.Values.Has(c.parameter) {
+			continue
+		}
+		outcomes[i] = failed
+		if v := values[c.parameter]; v >= c.min && v <= c.max {
+			outcomes[i] = passed
+		}
+	}
+	return outcomes
+}
+
+func (t *Tracker) Describe(ch chan<- *prometheus.Desc) {
+	ch <- t.pass
+	ch <- t.ratio
+}
+
+func (t *Tracker) Collect(ch chan<- prometheus.Metric) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, d := range t.devices {
+		if len(d.samples) == 0 {
+			continue
+		}
+		latest := d.samples[len(d.samples)-1]
+		for i, c := range t.checks {
+			labels := []string{d.uuid, c.standard, c.band, c.parameter}
+			passedCount, known := 0, 0
+			for _, s := range d.samples {
+				switch s.outcomes[i] {
+				case passed:
+					passedCount++
+					known++
+				case failed:
+					known++
+				}
+			}
+			if known == 0 {
+				continue
+			}
+			if latest.outcomes[i] != unknown {
+				value := 0.0
+				if latest.outcomes[i] == passed {
+					value = 1
+				}
+				ch <- prometheus.MustNewConstMetric(t.pass, prometheus.GaugeValue, value, labels...)
+			}
+			ch <- prometheus.MustNewConstMetric(t.ratio, prometheus.GaugeValue, float64(passedCount)/float64(known), labels...)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package compliance
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"prometheus-awair-exporter/internal/config"
+	"prometheus-awair-exporter/internal/exporter"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"github.com/tj/assert"
+)
+
+type staticSource map[string]exporter.Reading
+
+func (s staticSource) NamedReadings() map[string]exporter.Reading {
+	return s
+}
+
+func TestTracker(t *testing.T) {
+	src := staticSource{
+		"office": {
+			Config: &exporter.ConfigResponse{DeviceUUID: "awair-element_1"},
+			Values: &exporter.AwairValues{PM25: 10, CO2: 550, Voc: 80},
+		},
+	}
+	tr, err := New(src, config.Compliance{Standards: []string{"reset"}, Window: time.Hour})
+	require.Nil(t, err)
+
+	now := time.Now()
+	tr.record(now.Add(-2 * time.Hour))
+	tr.record(now.Add(-20 * time.Minute))
+	src["office"] = exporter.Reading{
+		Config: &exporter.ConfigResponse{DeviceUUID: "awair-element_1"},
+		Values: &exporter.AwairValues{PM25: 20, CO2: 700, Voc: 80},
+	}
+	tr.record(now)
+
+	expected := `
+# HELP awair_compliance_pass Whether the latest reading of the parameter is within the band of the standard (1) or not (0)
+# TYPE awair_compliance_pass gauge
+awair_compliance_pass{band="acceptable",device_uuid="awair-element_1",parameter="co2",standard="reset"} 1
+awair_compliance_pass{band="acceptable",device_uuid="awair-element_1",parameter="pm25",standard="reset"} 1
+awair_compliance_pass{band="acceptable",device_uuid="awair-element_1",parameter="voc",standard="reset"} 1
+awair_compliance_pass{band="high_performance",device_uuid="awair-element_1",parameter="co2",standard="reset"} 0
+awair_compliance_pass{band="high_performance",device_uuid="awair-element_1",parameter="pm25",standard="reset"} 0
+awair_compliance_pass{band="high_performance",device_uuid="awair-element_1",parameter="voc",standard="reset"} 1
+# HELP awair_compliance_ratio Share of the readings of the parameter within the band of the standard over the compliance window
+# TYPE awair_compliance_ratio gauge
+awair_compliance_ratio{band="acceptable",device_uuid="awair-element_1",parameter="co2",standard="reset"} 1
+awair_compliance_ratio{band="acceptable",device_uuid="awair-element_1",parameter="pm25",standard="reset"} 1
+awair_compliance_ratio{band="acceptable",device_uuid="awair-element_1",parameter="voc",standard="reset"} 1
+awair_compliance_ratio{band="high_performance",device_uuid="awair-element_1",parameter="co2",standard="reset"} 0.5
+awair_compliance_ratio{band="high_performance",device_uuid="awair-element_1",parameter="pm25",standard="reset"} 0.5
+awair_compliance_ratio{band="high_performance",device_uuid="awair-element_1",parameter="voc",standard="reset"} 1
+`
+	assert.Nil(t, testutil.CollectAndCompare(tr, strings.NewReader(expected)), "the sample older than the window is dropped")
+
+	delete(src, "office")
+	tr.record(now.Add(time.Minute))
+	assert.Equal(t, 0, testutil.CollectAndCount(tr), "gone devices are dropped")
+}
+
+func TestTrackerLeavesOutMissingSensors(t *testing.T) {
+	src := staticSource{
+		"hall": {
+			Config: &exporter.ConfigResponse{DeviceUUID: "awair-glow-c_2"},
+			Values: &exporter.AwairValues{Humidity: 50, Voc: 100},
+		},
+	}
+	tr, err := New(src, config.Compliance{Standards: []string{"well"}})
+	require.Nil(t, err)
+	assert.Equal(t, DefaultWindow, tr.window)
+
+	tr.record(time.Now())
+	assert.Equal(t, 4, testutil.CollectAndCount(tr, "awair_compliance_pass"), "only the voc and humid checks of both bands")
+	assert.Equal(t, 4, testutil.CollectAndCount(tr, "awair_compliance_ratio"))
+}
+
+func TestUnknownStandard(t *testing.T) {
+	_, err := New(staticSource{}, config.Compliance{Standards: []string{"leed"}})
+	assert.EqualError(t, err, `unknown compliance standard "leed", known are reset, well`)
+}
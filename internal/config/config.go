@@ -0,0 +1,290 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"prometheus-awair-exporter/internal/promquery"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Device is a single Awair device managed by the exporter.
+type Device struct {
+	Name     string `yaml:"name"`
+	Hostname string `yaml:"hostname"`
+	// Addresses are further addresses of the device, tried in order when
+	// the one which answered last fails.
+	Addresses []string `yaml:"addresses,omitempty"`
+	// DeviceUUID names the device by its UUID, e.g. awair-element_1234.
+	// Without a hostname, the device is read from the Awair Cloud.
+	DeviceUUID string `yaml:"device_uuid,omitempty"`
+	// CloudData are the readings of a device read from the Awair Cloud:
+	// latest, or the 5-min-avg or 15-min-avg averages, -cloud.data if
+	// empty.
+	CloudData string `yaml:"cloud_data,omitempty"`
+	// CloudFallback reads a device with both a hostname and a device UUID
+	// from the Awair Cloud whenever its Local API fails.
+	CloudFallback bool `yaml:"cloud_fallback,omitempty"`
+	// Labels are attached to the device's series.
+	Labels map[string]string `yaml:"labels,omitempty"`
+	// Timeout bounds requests to the device, the exporter's default if zero.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+	// PollInterval overrides -pollinterval for the device.
+	PollInterval time.Duration `yaml:"poll_interval,omitempty"`
+	// Endpoints are the device endpoints queried for every reading, all
+	// of them if empty.
+	Endpoints []string `yaml:"endpoints,omitempty"`
+	// Wake is the strategy waking up a device on Wi-Fi power saving,
+	// retry or prewarm, -wake if empty.
+	Wake string `yaml:"wake,omitempty"`
+}
+
+// Baseline declares the settings every device is expected to have. Empty
+// values are not checked.
+type Baseline struct {
+	FirmwareVersion string `yaml:"firmware_version,omitempty" json:"firmware_version,omitempty"`
+	Timezone        string `yaml:"timezone,omitempty" json:"timezone,omitempty"`
+	Display         string `yaml:"display,omitempty" json:"display,omitempty"`
+	LEDMode         string `yaml:"led_mode,omitempty" json:"led_mode,omitempty"`
+	LEDBrightness   *int   `yaml:"led_brightness,omitempty" json:"led_brightness,omitempty"`
+}
+
+// Config is the exporter's configuration file. Command line flags override
+// its settings.
+type Config struct {
+	Devices  []Device `yaml:"devices"`
+	Baseline Baseline `yaml:"baseline,omitempty"`
+	// Listen are the addresses to serve on, as given to -web.listen.
+	Listen []string `yaml:"listen,omitempty"`
+	// LogLevel is a zerolog level such as debug, info or warn.
+	LogLevel string `yaml:"log_level,omitempty"`
+	// Units are the units the status pages and JSON API show readings in,
+	// metric or imperial.
+	Units string `yaml:"units,omitempty"`
+	// AQI are the air quality index standards computed from the PM2.5 and
+	// PM10 readings, e.g. us-epa.
+	AQI []string `yaml:"aqi,omitempty"`
+	// Prometheus is the server the status page queries for trends.
+	Prometheus promquery.Config `yaml:"prometheus,omitempty"`
+	// References pair devices with reference instruments to compare
+	// them with.
+	References []Reference `yaml:"references,omitempty"`
+	// Zones group devices for the ventilation ranking.
+	Zones []Zone `yaml:"zones,omitempty"`
+	// Controllers switch smart plugs by humidity.
+	Controllers []Controller `yaml:"controllers,omitempty"`
+	// Triggers call webhooks as sensor values cross thresholds.
+	Triggers []Trigger `yaml:"triggers,omitempty"`
+	// Compliance tracks the readings against building standards.
+	Compliance Compliance `yaml:"compliance,omitempty"`
+	// Discovery filters the devices found by scanning.
+	Discovery DiscoveryFilter `yaml:"discovery,omitempty"`
+	// Profiles map the names of scrape profiles, selected by the profile
+	// parameter of /metrics, to the patterns of the metric names they
+	// serve.
+	Profiles map[string][]string `yaml:"profiles,omitempty"`
+	// Aliases map device UUIDs or hostnames to the friendly names
+	// attached to the series as a name label, in addition to the names of
+	// the configured devices.
+	Aliases map[string]string `yaml:"aliases,omitempty"`
+}
+
+// Reference pairs a device with a reference instrument, both given by their
+// device name. The reference may be polled like any device or have its
+// readings pushed to the ingestion endpoint.
+type Reference struct {
+	Device    string `yaml:"device"`
+	Reference string `yaml:"reference"`
+	// Sensors are the Local API field names compared, co2 and pm25 by
+	// default.
+	Sensors []string `yaml:"sensors,omitempty"`
+	// Window is the period the comparison covers, 24h by default.
+	Window time.Duration `yaml:"window,omitempty"`
+}
+
+// Compliance selects the building standards the readings of all devices
+// are evaluated against.
+type Compliance struct {
+	// Standards are reset and well, none if empty.
+	Standards []string `yaml:"standards,omitempty"`
+	// Window is the period compliance percentages cover, 24h by default.
+	Window time.Duration `yaml:"window,omitempty"`
+}
+
+// Zone groups the devices of rooms sharing a ventilation system, e.g. a
+// floor or a wing, given by their device name.
+type Zone struct {
+	Name    string   `yaml:"name"`
+	Devices []string `yaml:"devices"`
+	// AirChanges is the assumed number of air changes per hour, its supply
+	// airflow divided by its volume. Without, the measured air changes
+	// aren't compared against it.
+	AirChanges float64 `yaml:"air_changes,omitempty"`
+	// Window is the period the ranking covers, 7 days by default.
+	Window time.Duration `yaml:"window,omitempty"`
+}
+
+// Controller switches a humidifier or dehumidifier plugged into a smart
+// plug when the humidity of a device, or the mean of a zone, leaves the
+// band between On and Off. In ventilate mode, it boosts an ERV or HRV
+// through a relay by the CO2 of a device, or the highest of a zone.
+type Controller struct {
+	Name string `yaml:"name"`
+	// Device or Zone is the name of the device or zone whose humidity is
+	// controlled.
+	Device string `yaml:"device,omitempty"`
+	Zone   string `yaml:"zone,omitempty"`
+	Plug   Plug   `yaml:"plug"`
+	// Mode is humidify, dehumidify or ventilate.
+	Mode string `yaml:"mode"`
+	// On and Off are the relative humidities in %, or the CO2 levels in
+	// ppm when ventilating, the plug is switched on and off at.
+	On  float64 `yaml:"on"`
+	Off float64 `yaml:"off"`
+	// MinRuntime is how long the plug is kept on at least, Cooldown how
+	// long it is kept off at least before being switched on again.
+	MinRuntime time.Duration `yaml:"min_runtime,omitempty"`
+	Cooldown   time.Duration `yaml:"cooldown,omitempty"`
+}
+
+// Plug is a smart plug or relay switched over its local HTTP API, or
+// through an MQTT command topic.
+type Plug struct {
+	// Kind is tasmota, shelly (Gen1), shelly-rpc (Gen2 and later), http
+	// or mqtt.
+	Kind string `yaml:"kind"`
+	// URL is the base URL of the plug, e.g. http://192.168.1.50, or the
+	// MQTT broker, e.g. tcp://192.168.1.2:1883.
+	URL string `yaml:"url,omitempty"`
+	// OnURL and OffURL are requested to switch an http relay.
+	OnURL  string `yaml:"on_url,omitempty"`
+	OffURL string `yaml:"off_url,omitempty"`
+	// Topic is the MQTT command topic OnPayload and OffPayload, ON and
+	// OFF by default, are published to.
+	Topic      string `yaml:"topic,omitempty"`
+	OnPayload  string `yaml:"on_payload,omitempty"`
+	OffPayload string `yaml:"off_payload,omitempty"`
+	Username   string `yaml:"username,omitempty"`
+	Password   string `yaml:"password,omitempty"`
+}
+
+// Trigger calls a webhook, such as an IFTTT applet's, when a sensor of a
+// device crosses a threshold, and once more when it recovers.
+type Trigger struct {
+	Name string `yaml:"name"`
+	// Device is the name of the device watched, every device if empty.
+	Device string `yaml:"device,omitempty"`
+	// Sensor is the Local API field name of the sensor, e.g. co2.
+	Sensor string `yaml:"sensor"`
+	// Above or Below is the level the trigger fires at. It resolves once
+	// the value is back past Clear, the level itself by default.
+	Above *float64 `yaml:"above,omitempty"`
+	Below *float64 `yaml:"below,omitempty"`
+	Clear *float64 `yaml:"clear,omitempty"`
+	// URL is called when the trigger fires, ResolvedURL, if set, when it
+	// resolves.
+	URL         string `yaml:"url"`
+	ResolvedURL string `yaml:"resolved_url,omitempty"`
+}
+
+// DiscoveryFilter admits the discovered devices matching any Include rule,
+// every device without any, unless they match an Exclude rule.
+type DiscoveryFilter struct {
+	Include []DiscoveryRule `yaml:"include,omitempty"`
+	Exclude []DiscoveryRule `yaml:"exclude,omitempty"`
+}
+
+// DiscoveryRule matches devices by all of its fields which are set.
+type DiscoveryRule struct {
+	// UUID is a shell pattern of the device UUID, e.g. awair-element_*.
+	UUID string `yaml:"uuid,omitempty"`
+	// MACPrefix is the start of the Wi-Fi MAC address, e.g. 70:88:6B.
+	MACPrefix string `yaml:"mac_prefix,omitempty"`
+	// CIDR is the range of the device's IP address.
+	CIDR string `yaml:"cidr,omitempty"`
+}
+
+// Load reads the configuration file at path. A missing file yields an empty
+// configuration, so it can be created by the provision subcommand.
+func Load(path string) (*Config, error) {
+	cfg := &Config{}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Save writes the configuration to path, replacing it atomically.
+func (c *Config) Save(path string) error {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// DeviceAliases returns the friendly name of every device UUID and hostname
+// given one, by Aliases or by a configured device.
+func (c *Config) DeviceAliases() map[string]string {
+	aliases := map[string]string{}
+	for _, d := range c.Devices {
+		if d.Name == "" {
+			continue
+		}
+		if d.Hostname != "" {
+			aliases[d.Hostname] = d.Name
+		}
+		if d.DeviceUUID != "" {
+			aliases[d.DeviceUUID] = d.Name
+		}
+	}
+	for key, name := range c.Aliases {
+		aliases[key] = name
+	}
+	return aliases
+}
+
+// AddDevice adds d, replacing an existing entry with the same device UUID
+// or hostname. Labels, timeout, poll interval, endpoints, cloud data and
+// cloud fallback of a replaced entry are kept unless d sets them. It reports whether an existing entry was replaced.
+func (c *Config) AddDevice(d Device) bool {
+	for i, existing := range c.Devices {
+		if (d.DeviceUUID != "" && existing.DeviceUUID == d.DeviceUUID) || existing.Hostname == d.Hostname {
+			if d.Labels == nil {
+				d.Labels = existing.Labels
+			}
+			if d.Timeout == 0 {
+				d.Timeout = existing.Timeout
+			}
+			if d.PollInterval == 0 {
+				d.PollInterval = existing.PollInterval
+			}
+			if d.Endpoints == nil {
+				d.Endpoints = existing.Endpoints
+			}
+			if d.CloudData == "" {
+				d.CloudData = existing.CloudData
+			}
+			if !d.CloudFallback {
+				d.CloudFallback = existing.CloudFallback
+			}
+			c.Devices[i] = d
+			return true
+		}
+	}
+	c.Devices = append(c.Devices, d)
+	return false
+}
@@ -0,0 +1,87 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tj/assert"
+)
+
+func TestLoadMissing(t *testing.T) {
+	assert := assert.New(t)
+	cfg, err := Load(filepath.Join(t.TempDir(), "awair.yaml"))
+	assert.Nil(err)
+	assert.Equal(&Config{}, cfg)
+}
+
+func TestSaveAndLoad(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	path := filepath.Join(t.TempDir(), "awair.yaml")
+
+	cfg := &Config{}
+	assert.False(cfg.AddDevice(Device{Name: "bedroom", Hostname: "192.168.1.2", DeviceUUID: "awair-element_1"}))
+	assert.False(cfg.AddDevice(Device{Name: "office", Hostname: "192.168.1.3", DeviceUUID: "awair-element_2"}))
+	assert.True(cfg.AddDevice(Device{Name: "nursery", Hostname: "192.168.1.4", DeviceUUID: "awair-element_1"}))
+	require.Nil(cfg.Save(path))
+
+	loaded, err := Load(path)
+	require.Nil(err)
+	assert.Equal([]Device{
+		{Name: "nursery", Hostname: "192.168.1.4", DeviceUUID: "awair-element_1"},
+		{Name: "office", Hostname: "192.168.1.3", DeviceUUID: "awair-element_2"},
+	}, loaded.Devices)
+}
+
+func TestLoadExporterOptions(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	path := filepath.Join(t.TempDir(), "awair.yaml")
+	require.Nil(os.WriteFile(path, []byte(`
+listen: [":9517"]
+log_level: warn
+devices:
+  - name: bedroom
+    hostname: 192.168.1.2
+    timeout: 3s
+    poll_interval: 1m
+    endpoints: [air-data]
+    labels:
+      floor: "1"
+`), 0o644))
+
+	cfg, err := Load(path)
+	require.Nil(err)
+	assert.Equal([]string{":9517"}, cfg.Listen)
+	assert.Equal("warn", cfg.LogLevel)
+	assert.Equal(3*time.Second, cfg.Devices[0].Timeout)
+	assert.Equal(time.Minute, cfg.Devices[0].PollInterval)
+	assert.Equal(map[string]string{"floor": "1"}, cfg.Devices[0].Labels)
+
+	assert.True(cfg.AddDevice(Device{Name: "bedroom", Hostname: "192.168.1.2", DeviceUUID: "awair-element_1"}))
+	assert.Equal(3*time.Second, cfg.Devices[0].Timeout, "provisioning keeps the exporter options of a device")
+	assert.Equal(time.Minute, cfg.Devices[0].PollInterval)
+	assert.Equal([]string{"air-data"}, cfg.Devices[0].Endpoints)
+	assert.Equal(map[string]string{"floor": "1"}, cfg.Devices[0].Labels)
+}
+
+func TestDeviceAliases(t *testing.T) {
+	assert := assert.New(t)
+	cfg := &Config{
+		Devices: []Device{
+			{Name: "bedroom", Hostname: "192.168.1.2", DeviceUUID: "awair-element_1"},
+			{Hostname: "192.168.1.3"},
+			{Name: "hall", Hostname: "192.168.1.4"},
+		},
+		Aliases: map[string]string{"awair-element_1": "master bedroom", "awair-omni_2": "office"},
+	}
+	assert.Equal(map[string]string{
+		"192.168.1.2":     "bedroom",
+		"awair-element_1": "master bedroom",
+		"192.168.1.4":     "hall",
+		"awair-omni_2":    "office",
+	}, cfg.DeviceAliases())
+}
@@ -0,0 +1,246 @@
+// Package control switches humidifiers and dehumidifiers on smart plugs by
+// the humidity measured by devices, and boosts ventilation by their CO2,
+// closing the loop from measurement to action.
+package control
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"prometheus-awair-exporter/internal/config"
+	"prometheus-awair-exporter/internal/exporter"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+// ReadingSource provides the latest reading of every device by name.
+type ReadingSource interface {
+	NamedReadings() map[string]exporter.Reading
+}
+
+type loop struct {
+	config.Controller
+	devices []string
+	plug    Plug
+
+	// on is the state the plug was last switched to, nil until then, at
+	// changed.
+	on       *bool
+	changed  time.Time
+	switches float64
+	failures float64
+}
+
+// measure returns the mean humidity of the devices of the loop, or their
+// highest CO2 when ventilating, so a single stuffy room boosts.
+func (l *loop) measure(readings map[string]exporter.Reading) (float64, bool) {
+	sum, max, n := 0.0, 0.0, 0
+	for _, device := range l.devices {
+		r, ok := readings[device]
+		if !ok || r.Values == nil {
+			continue
+		}
+		sum += r.Values.Humidity
+		if n == 0 || r.Values.CO2 > max {
+			max = r.Values.CO2
+		}
+		n++
+	}
+	if n == 0 {
+		return 0, false
+	}
+	if l.Mode == "ventilate" {
+		return max, true
+	}
+	return sum / float64(n), true
+}
+
+// held reports whether the plug has to stay as it is at now, as it was
+// switched on less than MinRuntime or off less than Cooldown ago.
+func (l *loop) held(now time.Time) bool {
+	if l.on == nil {
+		return false
+	}
+	if *l.on {
+		return now.Sub(l.changed) < l.MinRuntime
+	}
+	return now.Sub(l.changed) < l.Cooldown
+}
+
+// want returns the state the plug should be in at value, reporting false
+// inside the hysteresis band, where it is left as it is.
+func (l *loop) want(value float64) (on bool, ok bool) {
+	if l.Mode == "humidify" {
+		switch {
+		case value <= l.On:
+			return true, true
+		case value >= l.Off:
+			return false, true
+		}
+		return false, false
+	}
+	switch {
+	case value >= l.On:
+		return true, true
+	case value <= l.Off:
+		return false, true
+	}
+	return false, false
+}
+
+// Controller periodically switches the plug of every control loop by the
+// humidity of its device, or the mean humidity of its zone, with a
+// hysteresis band so the plug doesn't flap. Ventilating loops go by CO2
+// instead.
+type Controller struct {
+	src   ReadingSource
+	loops []*loop
+
+	mu sync.Mutex
+
+	state    *prometheus.Desc
+	switches *prometheus.Desc
+	failures *prometheus.Desc
+}
+
+// New returns a Controller for the given control loops, whose zones are
+// looked up in zones.
+func New(src ReadingSource, controllers []config.Controller, zones []config.Zone) (*Controller, error) {
+	zoneDevices := map[string][]string{}
+	for _, z := range zones {
+		zoneDevices[z.Name] = z.Devices
+	}
+	c := &Controller{src: src}
+	names := map[string]bool{}
+	for _, cfg := range controllers {
+		if cfg.Name == "" || names[cfg.Name] {
+			return nil, fmt.Errorf("controller needs a unique name")
+		}
+		names[cfg.Name] = true
+		l := &loop{Controller: cfg}
+		switch {
+		case cfg.Device != "" && cfg.Zone == "":
+			l.devices = []string{cfg.Device}
+		case cfg.Zone != "" && cfg.Device == "":
+			l.devices = zoneDevices[cfg.Zone]
+			if len(l.devices) == 0 {
+				return nil, fmt.Errorf("controller %s: unknown zone %s", cfg.Name, cfg.Zone)
+			}
+		default:
+			return nil, fmt.Errorf("controller %s needs either a device or a zone", cfg.Name)
+		}
+		switch {
+		case cfg.Mode == "humidify" && cfg.On < cfg.Off:
+		case cfg.Mode == "dehumidify" && cfg.On > cfg.Off:
+		case cfg.Mode == "ventilate" && cfg.On > cfg.Off:
+		case cfg.Mode == "humidify", cfg.Mode == "dehumidify", cfg.Mode == "ventilate":
+			return nil, fmt.Errorf("controller %s: the on and off levels don't form a band to %s", cfg.Name, cfg.Mode)
+		default:
+			return nil, fmt.Errorf("controller %s: mode must be humidify, dehumidify or ventilate", cfg.Name)
+		}
+		if cfg.MinRuntime < 0 || cfg.Cooldown < 0 {
+			return nil, fmt.Errorf("controller %s: min_runtime and cooldown can't be negative", cfg.Name)
+		}
+		plug, err := NewPlug(cfg.Plug)
+		if err != nil {
+			return nil, fmt.Errorf("controller %s: %w", cfg.Name, err)
+		}
+		l.plug = plug
+		c.loops = append(c.loops, l)
+	}
+
+	c.state = prometheus.NewDesc(
+		prometheus.BuildFQName("awair", "control", "plug_on"),
+		"Whether the controller last switched its plug on (1) or off (0)",
+		[]string{"controller", "mode"}, nil,
+	)
+	c.switches = prometheus.NewDesc(
+		prometheus.BuildFQName("awair", "control", "switches_total"),
+		"Number of times the controller switched its plug",
+		[]string{"controller"}, nil,
+	)
+	c.failures = prometheus.NewDesc(
+		prometheus.BuildFQName("awair", "control", "failures_total"),
+		"Number of times switching the plug failed",
+		[]string{"controller"}, nil,
+	)
+	return c, nil
+}
+
+// Run evaluates the control loops at interval until ctx is done.
+func (c *Controller) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			c.evaluate(ctx, now)
+		}
+	}
+}
+
+// evaluate switches the plug of every loop whose humidity or CO2 left its
+// band at now, retrying failed switches on the next evaluation.
+func (c *Controller) evaluate(ctx context.Context, now time.Time) {
+	readings := c.src.NamedReadings()
+	for _, l := range c.loops {
+		value, ok := l.measure(readings)
+		if !ok {
+			continue
+		}
+		on, ok := l.want(value)
+		c.mu.Lock()
+		current, held := l.on, l.held(now)
+		c.mu.Unlock()
+		if !ok || (current != nil && *current == on) || held {
+			continue
+		}
+		err := l.plug.Set(ctx, on)
+		c.mu.Lock()
+		if err != nil {
+			l.failures++
+		} else {
+			l.on = &on
+			l.changed = now
+			l.switches++
+		}
+		c.mu.Unlock()
+		if err != nil {
+			log.Error().Err(err).Str("controller", l.Name).Bool("on", on).Msg("Failed to switch plug.")
+			continue
+		}
+		log.Info().
+			Str("controller", l.Name).
+			Bool("on", on).
+			Str("mode", l.Mode).
+			Float64("value", value).
+			Msg("Switched plug.")
+	}
+}
+
+func (c *Controller) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.state
+	ch <- c.switches
+	ch <- c.failures
+}
+
+func (c *Controller) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, l := range c.loops {
+		if l.on != nil {
+			on := 0.0
+			if *l.on {
+				on = 1
+			}
+			ch <- prometheus.MustNewConstMetric(c.state, prometheus.GaugeValue, on, l.Name, l.Mode)
+		}
+		ch <- prometheus.MustNewConstMetric(c.switches, prometheus.CounterValue, l.switches, l.Name)
+		ch <- prometheus.MustNewConstMetric(c.failures, prometheus.CounterValue, l.failures, l.Name)
+	}
+}
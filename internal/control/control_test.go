@@ -0,0 +1,185 @@
+package control
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"prometheus-awair-exporter/internal/config"
+	"prometheus-awair-exporter/internal/exporter"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"github.com/tj/assert"
+)
+
+type staticSource map[string]exporter.Reading
+
+func (s staticSource) NamedReadings() map[string]exporter.Reading {
+	return s
+}
+
+type fakePlug struct {
+	calls []bool
+	fail  bool
+}
+
+func (p *fakePlug) Set(_ context.Context, on bool) error {
+	if p.fail {
+		return errors.New("unreachable")
+	}
+	p.calls = append(p.calls, on)
+	return nil
+}
+
+func TestPlugs(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	requests := []string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.RequestURI())
+	}))
+	defer srv.Close()
+
+	for _, kind := range []string{"tasmota", "shelly", "shelly-rpc"} {
+		p, err := NewPlug(config.Plug{Kind: kind, URL: srv.URL + "/"})
+		require.Nil(err)
+		assert.Nil(p.Set(context.Background(), true))
+		assert.Nil(p.Set(context.Background(), false))
+	}
+	assert.Equal([]string{
+		"/cm?cmnd=Power%20On", "/cm?cmnd=Power%20Off",
+		"/relay/0?turn=on", "/relay/0?turn=off",
+		"/rpc/Switch.Set?id=0&on=true", "/rpc/Switch.Set?id=0&on=false",
+	}, requests)
+
+	p, err := NewPlug(config.Plug{Kind: "http", OnURL: srv.URL + "/erv?boost=1", OffURL: srv.URL + "/erv?boost=0"})
+	require.Nil(err)
+	assert.Nil(p.Set(context.Background(), true))
+	assert.Equal("/erv?boost=1", requests[len(requests)-1])
+
+	for _, cfg := range []config.Plug{
+		{Kind: "kasa", URL: srv.URL},
+		{Kind: "tasmota", URL: "ftp://plug"},
+		{Kind: "http", OnURL: srv.URL},
+		{Kind: "mqtt", URL: "tcp://broker"},
+		{Kind: "mqtt", URL: "http://broker", Topic: "erv/cmnd/POWER"},
+	} {
+		_, err = NewPlug(cfg)
+		assert.NotNil(err, cfg)
+	}
+}
+
+func TestNewValidatesControllers(t *testing.T) {
+	assert := assert.New(t)
+	plug := config.Plug{Kind: "tasmota", URL: "http://plug"}
+	for _, cfg := range []config.Controller{
+		{Device: "bedroom", Mode: "dehumidify", On: 60, Off: 50, Plug: plug},
+		{Name: "a", Mode: "dehumidify", On: 60, Off: 50, Plug: plug},
+		{Name: "a", Zone: "attic", Mode: "dehumidify", On: 60, Off: 50, Plug: plug},
+		{Name: "a", Device: "bedroom", Mode: "dehumidify", On: 50, Off: 60, Plug: plug},
+		{Name: "a", Device: "bedroom", Mode: "humidify", On: 50, Off: 40, Plug: plug},
+		{Name: "a", Device: "bedroom", Mode: "dry", On: 60, Off: 50, Plug: plug},
+		{Name: "a", Device: "bedroom", Mode: "ventilate", On: 800, Off: 1000, Plug: plug},
+		{Name: "a", Device: "bedroom", Mode: "ventilate", On: 1000, Off: 800, Cooldown: -time.Minute, Plug: plug},
+	} {
+		_, err := New(staticSource{}, []config.Controller{cfg}, nil)
+		assert.NotNil(err, cfg)
+	}
+}
+
+func TestController(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	bedroom := &exporter.AwairValues{Humidity: 55}
+	nursery := &exporter.AwairValues{Humidity: 35}
+	src := staticSource{
+		"bedroom": {Values: bedroom},
+		"nursery": {Values: nursery},
+		"hall":    {Values: &exporter.AwairValues{Humidity: 25}},
+	}
+	plug := config.Plug{Kind: "tasmota", URL: "http://plug"}
+	c, err := New(src, []config.Controller{
+		{Name: "dehumidifier", Device: "bedroom", Mode: "dehumidify", On: 60, Off: 50, Plug: plug},
+		{Name: "humidifier", Zone: "upstairs", Mode: "humidify", On: 35, Off: 45, Plug: plug},
+	}, []config.Zone{{Name: "upstairs", Devices: []string{"nursery", "hall"}}})
+	require.Nil(err)
+	dehumidifier, humidifier := &fakePlug{}, &fakePlug{}
+	c.loops[0].plug, c.loops[1].plug = dehumidifier, humidifier
+	now := time.Date(2024, 8, 1, 12, 0, 0, 0, time.UTC)
+
+	c.evaluate(context.Background(), now)
+	assert.Nil(dehumidifier.calls, "left alone inside the band")
+	assert.Equal([]bool{true}, humidifier.calls, "the zone's mean of 30% is too dry")
+
+	bedroom.Humidity = 62
+	c.evaluate(context.Background(), now)
+	bedroom.Humidity = 55
+	c.evaluate(context.Background(), now)
+	assert.Equal([]bool{true}, dehumidifier.calls, "kept on inside the band")
+
+	dehumidifier.fail = true
+	bedroom.Humidity = 48
+	c.evaluate(context.Background(), now)
+	dehumidifier.fail = false
+	c.evaluate(context.Background(), now)
+	assert.Equal([]bool{true, false}, dehumidifier.calls, "retried after failing")
+
+	err = testutil.CollectAndCompare(c, strings.NewReader(`
+# HELP awair_control_failures_total Number of times switching the plug failed
+# TYPE awair_control_failures_total counter
+awair_control_failures_total{controller="dehumidifier"} 1
+awair_control_failures_total{controller="humidifier"} 0
+# HELP awair_control_plug_on Whether the controller last switched its plug on (1) or off (0)
+# TYPE awair_control_plug_on gauge
+awair_control_plug_on{controller="dehumidifier",mode="dehumidify"} 0
+awair_control_plug_on{controller="humidifier",mode="humidify"} 1
+# HELP awair_control_switches_total Number of times the controller switched its plug
+# TYPE awair_control_switches_total counter
+awair_control_switches_total{controller="dehumidifier"} 2
+awair_control_switches_total{controller="humidifier"} 1
+`))
+	assert.Nil(err)
+}
+
+func TestVentilate(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	nursery := &exporter.AwairValues{CO2: 900}
+	src := staticSource{
+		"nursery": {Values: nursery},
+		"hall":    {Values: &exporter.AwairValues{CO2: 600}},
+	}
+	c, err := New(src, []config.Controller{{
+		Name: "erv", Zone: "upstairs", Mode: "ventilate", On: 1000, Off: 800,
+		MinRuntime: 15 * time.Minute, Cooldown: 10 * time.Minute,
+		Plug: config.Plug{Kind: "http", OnURL: "http://relay/on", OffURL: "http://relay/off"},
+	}}, []config.Zone{{Name: "upstairs", Devices: []string{"nursery", "hall"}}})
+	require.Nil(err)
+	erv := &fakePlug{}
+	c.loops[0].plug = erv
+	now := time.Date(2024, 8, 1, 12, 0, 0, 0, time.UTC)
+
+	c.evaluate(context.Background(), now)
+	assert.Nil(erv.calls, "left alone inside the band")
+
+	nursery.CO2 = 1100
+	c.evaluate(context.Background(), now)
+	assert.Equal([]bool{true}, erv.calls, "boosted by the stuffiest room")
+
+	nursery.CO2 = 700
+	c.evaluate(context.Background(), now.Add(10*time.Minute))
+	assert.Equal([]bool{true}, erv.calls, "kept on for the minimum runtime")
+	c.evaluate(context.Background(), now.Add(15*time.Minute))
+	assert.Equal([]bool{true, false}, erv.calls, "released on recovery")
+
+	nursery.CO2 = 1200
+	c.evaluate(context.Background(), now.Add(20*time.Minute))
+	assert.Equal([]bool{true, false}, erv.calls, "kept off for the cooldown")
+	c.evaluate(context.Background(), now.Add(25*time.Minute))
+	assert.Equal([]bool{true, false, true}, erv.calls, "boosted again after the cooldown")
+}
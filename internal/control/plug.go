@@ -0,0 +1,146 @@
+package control
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"prometheus-awair-exporter/internal/config"
+	"prometheus-awair-exporter/internal/sink"
+)
+
+// Plug is a switchable smart plug.
+type Plug interface {
+	Set(ctx context.Context, on bool) error
+}
+
+// httpPlug switches a plug by requesting a URL of its local HTTP API.
+type httpPlug struct {
+	client *http.Client
+	url    func(on bool) string
+}
+
+// mqttPlug switches a relay by publishing to its MQTT command topic.
+type mqttPlug struct {
+	publisher  *sink.MQTTPublisher
+	topic      string
+	onPayload  []byte
+	offPayload []byte
+}
+
+func (p *mqttPlug) Set(ctx context.Context, on bool) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if on {
+		return p.publisher.Publish(ctx, p.topic, p.onPayload)
+	}
+	return p.publisher.Publish(ctx, p.topic, p.offPayload)
+}
+
+// NewPlug returns the plug of cfg.
+func NewPlug(cfg config.Plug) (Plug, error) {
+	switch cfg.Kind {
+	case "mqtt":
+		return newMQTTPlug(cfg)
+	case "http":
+		for _, u := range []string{cfg.OnURL, cfg.OffURL} {
+			if !strings.HasPrefix(u, "http://") && !strings.HasPrefix(u, "https://") {
+				return nil, fmt.Errorf("relay URL %q is not http(s)", u)
+			}
+		}
+		return &httpPlug{
+			client: &http.Client{Timeout: 5 * time.Second},
+			url: func(on bool) string {
+				if on {
+					return cfg.OnURL
+				}
+				return cfg.OffURL
+			},
+		}, nil
+	}
+	base, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+	if base.Scheme != "http" && base.Scheme != "https" {
+		return nil, fmt.Errorf("plug URL %q is not http(s)", cfg.URL)
+	}
+	root := strings.TrimSuffix(cfg.URL, "/")
+	p := &httpPlug{client: &http.Client{Timeout: 5 * time.Second}}
+	switch cfg.Kind {
+	case "tasmota":
+		p.url = func(on bool) string {
+			if on {
+				return root + "/cm?cmnd=Power%20On"
+			}
+			return root + "/cm?cmnd=Power%20Off"
+		}
+	case "shelly":
+		p.url = func(on bool) string {
+			if on {
+				return root + "/relay/0?turn=on"
+			}
+			return root + "/relay/0?turn=off"
+		}
+	case "shelly-rpc":
+		p.url = func(on bool) string {
+			return fmt.Sprintf("%s/rpc/Switch.Set?id=0&on=%t", root, on)
+		}
+	default:
+		return nil, fmt.Errorf("unknown plug kind %q, known are tasmota, shelly, shelly-rpc, http and mqtt", cfg.Kind)
+	}
+	return p, nil
+}
+
+func newMQTTPlug(cfg config.Plug) (Plug, error) {
+	if cfg.Topic == "" {
+		return nil, fmt.Errorf("mqtt plug needs a topic")
+	}
+	// Every plug keeps its own connection, so it needs a client ID of its
+	// own, lest the broker drops the connection of the others.
+	hostname, _ := os.Hostname()
+	publisher, err := sink.NewMQTTPublisher(map[string]string{
+		"url":       cfg.URL,
+		"client_id": "awair-exporter-" + hostname + "-" + cfg.Topic,
+		"username":  cfg.Username,
+		"password":  cfg.Password,
+	})
+	if err != nil {
+		return nil, err
+	}
+	p := &mqttPlug{
+		publisher:  publisher,
+		topic:      cfg.Topic,
+		onPayload:  []byte(cfg.OnPayload),
+		offPayload: []byte(cfg.OffPayload),
+	}
+	if cfg.OnPayload == "" {
+		p.onPayload = []byte("ON")
+	}
+	if cfg.OffPayload == "" {
+		p.offPayload = []byte("OFF")
+	}
+	return p, nil
+}
+
+func (p *httpPlug) Set(ctx context.Context, on bool) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.url(on), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("plug answered %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,236 @@
+// Package digest exports daily, or otherwise scheduled, aggregates of every
+// device to webhooks and spreadsheets, for people who track air quality
+// without dashboards.
+package digest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"prometheus-awair-exporter/internal/exporter"
+	"prometheus-awair-exporter/internal/history"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+// ReadingSource provides the latest reading of every device by name.
+type ReadingSource interface {
+	NamedReadings() map[string]exporter.Reading
+}
+
+// Target receives the summaries of every device over a day.
+type Target interface {
+	Name() string
+	Send(ctx context.Context, day string, summaries []history.Summary) error
+}
+
+// pending is a digest not yet delivered to a target.
+type pending struct {
+	day       string
+	summaries []history.Summary
+}
+
+// Digest collects the readings of every device over a period, e.g. a day,
+// and sends their summaries to its targets once the period is cut. Failed
+// deliveries are retried until the next digest replaces them.
+type Digest struct {
+	src        ReadingSource
+	thresholds history.Thresholds
+	targets    []Target
+
+	mu       sync.Mutex
+	day      string
+	readings map[string][]exporter.AwairValues
+	pending  map[string]pending
+	sent     map[string]float64
+	failures map[string]float64
+
+	sentDesc     *prometheus.Desc
+	failuresDesc *prometheus.Desc
+}
+
+// New returns a Digest sending to targets, counting hours above
+// thresholds.
+func New(src ReadingSource, thresholds history.Thresholds, targets ...Target) *Digest {
+	d := &Digest{
+		src:        src,
+		thresholds: thresholds,
+		targets:    targets,
+		readings:   map[string][]exporter.AwairValues{},
+		pending:    map[string]pending{},
+		sent:       map[string]float64{},
+		failures:   map[string]float64{},
+		sentDesc: prometheus.NewDesc(
+			prometheus.BuildFQName("awair", "digest", "sent_total"),
+			"Number of daily digests delivered to the target",
+			[]string{"target"}, nil,
+		),
+		failuresDesc: prometheus.NewDesc(
+			prometheus.BuildFQName("awair", "digest", "failures_total"),
+			"Number of failed attempts to deliver a daily digest to the target",
+			[]string{"target"}, nil,
+		),
+	}
+	for _, t := range targets {
+		d.sent[t.Name()] = 0
+		d.failures[t.Name()] = 0
+	}
+	return d
+}
+
+// Run records the readings and delivers pending digests at interval until
+// ctx is done.
+func (d *Digest) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			d.record(now)
+			d.deliver(ctx)
+		}
+	}
+}
+
+// record adds the current readings to those of the period.
+func (d *Digest) record(now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.day == "" {
+		d.day = now.Format("2006-01-02")
+	}
+	for name, r := range d.src.NamedReadings() {
+		if r.Values == nil {
+			continue
+		}
+		readings := d.readings[name]
+		if n := len(readings); n > 0 && readings[n-1].Timestamp == r.Values.Timestamp {
+			continue
+		}
+		d.readings[name] = append(readings, *r.Values)
+	}
+}
+
+// Cut ends the period at now, e.g. at midnight, making its summaries
+// pending for every target, named after the day the period started on.
+func (d *Digest) Cut(now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.day != "" && len(d.readings) > 0 {
+		summaries := d.summarize()
+		for _, t := range d.targets {
+			if p, ok := d.pending[t.Name()]; ok {
+				log.Warn().Str("target", t.Name()).Str("day", p.day).Msg("Dropping undelivered digest.")
+			}
+			d.pending[t.Name()] = pending{day: d.day, summaries: summaries}
+		}
+	}
+	d.day = now.Format("2006-01-02")
+	d.readings = map[string][]exporter.AwairValues{}
+}
+
+// summarize summarizes the readings of every device by name.
+func (d *Digest) summarize() []history.Summary {
+	names := make([]string, 0, len(d.readings))
+	for name := range d.readings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	summaries := make([]history.Summary, 0, len(names))
+	for _, name := range names {
+		summaries = append(summaries, history.Summarize(name, d.readings[name], d.thresholds))
+	}
+	return summaries
+}
+
+// deliver sends the pending digests to their targets.
+func (d *Digest) deliver(ctx context.Context) {
+	for _, t := range d.targets {
+		d.mu.Lock()
+		p, ok := d.pending[t.Name()]
+		d.mu.Unlock()
+		if !ok {
+			continue
+		}
+		sendCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		err := t.Send(sendCtx, p.day, p.summaries)
+		cancel()
+		d.mu.Lock()
+		if err != nil {
+			d.failures[t.Name()]++
+		} else {
+			d.sent[t.Name()]++
+			delete(d.pending, t.Name())
+		}
+		d.mu.Unlock()
+		if err != nil {
+			log.Error().Err(err).Str("target", t.Name()).Str("day", p.day).Msg("Failed to deliver digest.")
+			continue
+		}
+		log.Info().Str("target", t.Name()).Str("day", p.day).Msg("Delivered digest.")
+	}
+}
+
+func (d *Digest) Describe(ch chan<- *prometheus.Desc) {
+	ch <- d.sentDesc
+	ch <- d.failuresDesc
+}
+
+func (d *Digest) Collect(ch chan<- prometheus.Metric) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, t := range d.targets {
+		ch <- prometheus.MustNewConstMetric(d.sentDesc, prometheus.CounterValue, d.sent[t.Name()], t.Name())
+		ch <- prometheus.MustNewConstMetric(d.failuresDesc, prometheus.CounterValue, d.failures[t.Name()], t.Name())
+	}
+}
+
+// Webhook POSTs digests as JSON to a URL.
+type Webhook struct {
+	URL    string
+	Client *http.Client
+}
+
+// NewWebhook returns a Webhook posting to url.
+func NewWebhook(url string) *Webhook {
+	return &Webhook{URL: url, Client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+func (w *Webhook) Name() string {
+	return "webhook"
+}
+
+func (w *Webhook) Send(ctx context.Context, day string, summaries []history.Summary) error {
+	body, err := json.Marshal(struct {
+		Day       string            `json:"day"`
+		Summaries []history.Summary `json:"summaries"`
+	}{day, summaries})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
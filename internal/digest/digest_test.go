@@ -0,0 +1,153 @@
+package digest
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"prometheus-awair-exporter/internal/exporter"
+	"prometheus-awair-exporter/internal/history"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"github.com/tj/assert"
+)
+
+type staticSource map[string]exporter.Reading
+
+func (s staticSource) NamedReadings() map[string]exporter.Reading {
+	return s
+}
+
+type fakeTarget struct {
+	days []string
+	sent [][]history.Summary
+	fail bool
+}
+
+func (t *fakeTarget) Name() string {
+	return "fake"
+}
+
+func (t *fakeTarget) Send(_ context.Context, day string, summaries []history.Summary) error {
+	if t.fail {
+		return errors.New("unreachable")
+	}
+	t.days = append(t.days, day)
+	t.sent = append(t.sent, summaries)
+	return nil
+}
+
+func TestDigest(t *testing.T) {
+	assert := assert.New(t)
+	bedroom := &exporter.AwairValues{Timestamp: "2024-08-01T22:00:00Z", CO2: 800, Score: 90}
+	src := staticSource{"bedroom": {Values: bedroom}}
+	target := &fakeTarget{fail: true}
+	d := New(src, history.DefaultThresholds, target)
+
+	now := time.Date(2024, 8, 1, 22, 0, 0, 0, time.UTC)
+	d.record(now)
+	d.record(now.Add(time.Minute))
+	bedroom.Timestamp, bedroom.CO2 = "2024-08-01T22:10:00Z", 1200
+	d.record(now.Add(10 * time.Minute))
+	d.deliver(context.Background())
+	assert.Nil(target.sent, "nothing sent during the day")
+
+	d.Cut(now.Add(2 * time.Hour))
+	d.deliver(context.Background())
+	target.fail = false
+	d.deliver(context.Background())
+	d.deliver(context.Background())
+	assert.Equal([]string{"2024-08-01"}, target.days, "retried once after failing")
+	summary := target.sent[0][0]
+	assert.Equal("bedroom", summary.Name)
+	assert.Equal(2, summary.Samples, "unchanged readings are recorded once")
+	assert.Equal(1200.0, summary.MaxCO2)
+
+	err := testutil.CollectAndCompare(d, strings.NewReader(`
+# HELP awair_digest_failures_total Number of failed attempts to deliver a daily digest to the target
+# TYPE awair_digest_failures_total counter
+awair_digest_failures_total{target="fake"} 1
+# HELP awair_digest_sent_total Number of daily digests delivered to the target
+# TYPE awair_digest_sent_total counter
+awair_digest_sent_total{target="fake"} 1
+`))
+	assert.Nil(err)
+}
+
+func TestWebhook(t *testing.T) {
+	assert := assert.New(t)
+	var body map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal("application/json", r.Header.Get("Content-Type"))
+		json.NewDecoder(r.Body).Decode(&body)
+	}))
+	defer srv.Close()
+
+	err := NewWebhook(srv.URL).Send(context.Background(), "2024-08-01", []history.Summary{{Name: "bedroom", AvgCO2: 700}})
+	assert.Nil(err)
+	assert.Equal("2024-08-01", body["day"])
+	assert.Equal(700.0, body["summaries"].([]interface{})[0].(map[string]interface{})["avg_co2"])
+
+	assert.NotNil(NewWebhook(srv.URL+"/missing\x7f").Send(context.Background(), "2024-08-01", nil))
+}
+
+func TestSheet(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.Nil(err)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.Nil(err)
+
+	tokens := 0
+	var appended map[string][][]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			tokens++
+			assert.Equal("urn:ietf:params:oauth:grant-type:jwt-bearer", r.FormValue("grant_type"))
+			assert.Equal(3, len(strings.Split(r.FormValue("assertion"), ".")))
+			io.WriteString(w, `{"access_token":"secret","expires_in":3600}`)
+		case "/spreadsheets/sheet-id/values/Daily:append":
+			assert.Equal("Bearer secret", r.Header.Get("Authorization"))
+			assert.Equal("USER_ENTERED", r.URL.Query().Get("valueInputOption"))
+			json.NewDecoder(r.Body).Decode(&appended)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	credentials := filepath.Join(t.TempDir(), "key.json")
+	data, _ := json.Marshal(serviceAccount{
+		ClientEmail: "awair@project.iam.gserviceaccount.com",
+		PrivateKey:  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		TokenURI:    srv.URL + "/token",
+	})
+	require.Nil(os.WriteFile(credentials, data, 0o600))
+	s, err := NewSheet("sheet-id", "Daily", credentials)
+	require.Nil(err)
+	s.baseURL = srv.URL + "/spreadsheets"
+
+	summaries := []history.Summary{{Name: "bedroom", Samples: 3, AvgScore: 85.333, AvgCO2: 700, MaxCO2: 900}}
+	assert.Nil(s.Send(context.Background(), "2024-08-01", summaries))
+	assert.Nil(s.Send(context.Background(), "2024-08-02", summaries))
+	assert.Equal(1, tokens, "the access token is cached")
+	assert.Equal([]interface{}{"2024-08-02", "bedroom", 3.0, 85.33, 700.0, 900.0, 0.0, 0.0, 0.0}, appended["values"][0])
+
+	_, err = NewSheet("sheet-id", "Daily", filepath.Join(t.TempDir(), "missing.json"))
+	assert.NotNil(err)
+}
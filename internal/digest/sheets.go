@@ -0,0 +1,197 @@
+package digest
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"prometheus-awair-exporter/internal/history"
+)
+
+const (
+	sheetsURL   = "https://sheets.googleapis.com/v4/spreadsheets"
+	sheetsScope = "https://www.googleapis.com/auth/spreadsheets"
+)
+
+// serviceAccount holds the fields of a Google service account key file
+// needed to obtain access tokens.
+type serviceAccount struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+// Sheet appends digests to a Google Sheet, authenticating as a service
+// account the sheet is shared with.
+type Sheet struct {
+	spreadsheet string
+	sheetRange  string
+	account     serviceAccount
+	key         *rsa.PrivateKey
+	client      *http.Client
+	baseURL     string
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// NewSheet returns a Sheet appending to sheetRange, e.g. Sheet1, of the
+// spreadsheet with the given ID, using the service account key file at
+// credentials.
+func NewSheet(spreadsheet, sheetRange, credentials string) (*Sheet, error) {
+	data, err := os.ReadFile(credentials)
+	if err != nil {
+		return nil, err
+	}
+	s := &Sheet{
+		spreadsheet: spreadsheet,
+		sheetRange:  sheetRange,
+		client:      &http.Client{Timeout: 30 * time.Second},
+		baseURL:     sheetsURL,
+	}
+	if err := json.Unmarshal(data, &s.account); err != nil {
+		return nil, fmt.Errorf("service account key %s: %w", credentials, err)
+	}
+	if s.account.ClientEmail == "" || s.account.TokenURI == "" {
+		return nil, fmt.Errorf("service account key %s lacks client_email or token_uri", credentials)
+	}
+	block, _ := pem.Decode([]byte(s.account.PrivateKey))
+	if block == nil {
+		return nil, fmt.Errorf("service account key %s has no PEM private key", credentials)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("service account key %s: %w", credentials, err)
+	}
+	var ok bool
+	if s.key, ok = key.(*rsa.PrivateKey); !ok {
+		return nil, fmt.Errorf("service account key %s is not an RSA key", credentials)
+	}
+	return s, nil
+}
+
+func (s *Sheet) Name() string {
+	return "sheet"
+}
+
+func (s *Sheet) Send(ctx context.Context, day string, summaries []history.Summary) error {
+	token, err := s.accessToken(ctx)
+	if err != nil {
+		return err
+	}
+	// One row per device: day, name, samples, average score, average and
+	// maximum CO2, average PM2.5 and the hours above both thresholds.
+	rows := make([][]interface{}, 0, len(summaries))
+	for _, sum := range summaries {
+		rows = append(rows, []interface{}{
+			day, sum.Name, sum.Samples, round(sum.AvgScore), round(sum.AvgCO2), sum.MaxCO2,
+			round(sum.AvgPM25), round(sum.HoursAboveCO2), round(sum.HoursAbovePM25),
+		})
+	}
+	body, err := json.Marshal(map[string]interface{}{"values": rows})
+	if err != nil {
+		return err
+	}
+	u := fmt.Sprintf("%s/%s/values/%s:append?valueInputOption=USER_ENTERED&insertDataOption=INSERT_ROWS",
+		s.baseURL, url.PathEscape(s.spreadsheet), url.PathEscape(s.sheetRange))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("sheets API answered %s", resp.Status)
+	}
+	return nil
+}
+
+// accessToken returns a cached access token, exchanging a JWT signed with
+// the service account key for a new one shortly before it expires.
+func (s *Sheet) accessToken(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if s.token != "" && now.Before(s.expires) {
+		return s.token, nil
+	}
+	assertion, err := s.assertion(now)
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.account.TokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token endpoint answered %s", resp.Status)
+	}
+	token := struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	s.token = token.AccessToken
+	s.expires = now.Add(time.Duration(token.ExpiresIn)*time.Second - time.Minute)
+	return s.token, nil
+}
+
+// assertion returns a JWT asserting the identity of the service account,
+// signed with its key.
+func (s *Sheet) assertion(now time.Time) (string, error) {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":   s.account.ClientEmail,
+		"scope": sheetsScope,
+		"aud":   s.account.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// round rounds v to two decimals for the sheet.
+func round(v float64) float64 {
+	return math.Round(v*100) / 100
+}
@@ -0,0 +1,201 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+// consulWait bounds how long a blocking query waits for the catalog to
+// change.
+const consulWait = 5 * time.Minute
+
+// Target is a device supplied by a service catalog, with the options it
+// sets, if any.
+type Target struct {
+	Name         string
+	Hostname     string
+	Labels       map[string]string
+	Timeout      time.Duration
+	PollInterval time.Duration
+	Endpoints    []string
+}
+
+// UpdateFunc is called with all targets of the catalog whenever it was
+// queried.
+type UpdateFunc func(targets []Target)
+
+// consulEntry is an entry of Consul's health endpoint.
+type consulEntry struct {
+	Node struct {
+		Address string
+	}
+	Service struct {
+		ID      string
+		Address string
+		Port    int
+		Tags    []string
+		Meta    map[string]string
+	}
+}
+
+// Consul watches the healthy instances of a service in the Consul catalog,
+// each being a device. Tags of the form name=value set the labels given to
+// NewConsul, the meta key name sets the device name, the service ID being
+// used otherwise.
+type Consul struct {
+	url     *url.URL
+	service string
+	token   string
+	labels  []string
+	update  UpdateFunc
+	client  *http.Client
+	backoff time.Duration
+
+	targets  prometheus.Gauge
+	failures prometheus.Counter
+}
+
+// NewConsul returns a watch of service on the Consul agent at rawURL,
+// authenticated by token if set.
+func NewConsul(rawURL, service, token string, labels []string, update UpdateFunc) (*Consul, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("consul URL %q is not http(s)", rawURL)
+	}
+	return &Consul{
+		url:     u,
+		service: service,
+		token:   token,
+		labels:  labels,
+		update:  update,
+		client:  &http.Client{Timeout: consulWait + 30*time.Second},
+		backoff: 10 * time.Second,
+		targets: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Namespace: "awair",
+				Subsystem: "discovery",
+				Name:      "consul_targets",
+				Help:      "Number of devices supplied by the Consul catalog",
+			},
+		),
+		failures: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: "awair",
+				Subsystem: "discovery",
+				Name:      "consul_failures_total",
+				Help:      "Number of failed queries of the Consul catalog",
+			},
+		),
+	}, nil
+}
+
+// Run watches the catalog with blocking queries until ctx is done.
+func (c *Consul) Run(ctx context.Context) {
+	index := uint64(0)
+	for {
+		targets, next, err := c.fetch(ctx, index)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			c.failures.Inc()
+			log.Error().Err(err).Str("service", c.service).Msg("Failed to query the Consul catalog.")
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(c.backoff):
+			}
+			continue
+		}
+		// An index going backwards means the catalog was reset.
+		if next < index {
+			next = 0
+		}
+		index = next
+		c.targets.Set(float64(len(targets)))
+		c.update(targets)
+	}
+}
+
+// fetch returns the healthy instances of the service once the catalog
+// changed from index, or the wait elapsed, along with the new index.
+func (c *Consul) fetch(ctx context.Context, index uint64) ([]Target, uint64, error) {
+	u := c.url.JoinPath("/v1/health/service", c.service)
+	q := url.Values{"passing": {"1"}}
+	if index > 0 {
+		q.Set("index", strconv.FormatUint(index, 10))
+		q.Set("wait", consulWait.String())
+	}
+	u.RawQuery = q.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, index, err
+	}
+	if c.token != "" {
+		req.Header.Set("X-Consul-Token", c.token)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, index, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, index, fmt.Errorf("consul answered %s", resp.Status)
+	}
+	entries := []consulEntry{}
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, index, err
+	}
+	next, _ := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+
+	targets := make([]Target, 0, len(entries))
+	for _, e := range entries {
+		address := e.Service.Address
+		if address == "" {
+			address = e.Node.Address
+		}
+		if e.Service.Port != 0 && e.Service.Port != 80 {
+			address = net.JoinHostPort(address, strconv.Itoa(e.Service.Port))
+		}
+		t := Target{Name: e.Service.Meta["name"], Hostname: address, Labels: map[string]string{}}
+		if t.Name == "" {
+			t.Name = e.Service.ID
+		}
+		for _, tag := range e.Service.Tags {
+			name, value, ok := strings.Cut(tag, "=")
+			if !ok {
+				continue
+			}
+			for _, l := range c.labels {
+				if l == name {
+					t.Labels[name] = value
+				}
+			}
+		}
+		targets = append(targets, t)
+	}
+	return targets, next, nil
+}
+
+func (c *Consul) Describe(ch chan<- *prometheus.Desc) {
+	c.targets.Describe(ch)
+	c.failures.Describe(ch)
+}
+
+func (c *Consul) Collect(ch chan<- prometheus.Metric) {
+	c.targets.Collect(ch)
+	c.failures.Collect(ch)
+}
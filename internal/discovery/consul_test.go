@@ -0,0 +1,68 @@
+package discovery
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"github.com/tj/assert"
+)
+
+func TestConsul(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	queries := []string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.RequestURI())
+		assert.Equal("secret", r.Header.Get("X-Consul-Token"))
+		w.Header().Set("X-Consul-Index", "42")
+		w.Write([]byte(`[
+			{"Node": {"Address": "10.0.0.1"}, "Service": {"ID": "awair-1", "Address": "192.168.1.2", "Port": 80,
+				"Tags": ["room=bedroom", "floor=1", "ignored=x", "plain"], "Meta": {"name": "bedroom"}}},
+			{"Node": {"Address": "10.0.0.2"}, "Service": {"ID": "awair-2", "Port": 8080, "Tags": []}}
+		]`))
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	updates := [][]Target{}
+	c, err := NewConsul(srv.URL, "awair", "secret", []string{"room", "floor"}, func(targets []Target) {
+		updates = append(updates, targets)
+		if len(updates) == 2 {
+			cancel()
+		}
+	})
+	require.Nil(err)
+	c.Run(ctx)
+
+	require.Len(updates, 2)
+	assert.Equal([]Target{
+		{Name: "bedroom", Hostname: "192.168.1.2", Labels: map[string]string{"room": "bedroom", "floor": "1"}},
+		{Name: "awair-2", Hostname: "10.0.0.2:8080", Labels: map[string]string{}},
+	}, updates[0])
+	assert.Equal([]string{
+		"/v1/health/service/awair?passing=1",
+		"/v1/health/service/awair?index=42&passing=1&wait=5m0s",
+	}, queries)
+	assert.Equal(2.0, testutil.ToFloat64(c.targets))
+
+	_, err = NewConsul("consul:8500", "awair", "", nil, nil)
+	assert.NotNil(err)
+}
+
+func TestConsulFailure(t *testing.T) {
+	assert := assert.New(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no leader", http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	c, err := NewConsul(srv.URL, "awair", "", nil, nil)
+	assert.Nil(err)
+	_, _, err = c.fetch(context.Background(), 0)
+	assert.NotNil(err)
+}
@@ -0,0 +1,114 @@
+package discovery
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"path"
+	"strings"
+
+	"prometheus-awair-exporter/internal/config"
+	"prometheus-awair-exporter/internal/exporter"
+)
+
+// rule is a parsed config.DiscoveryRule.
+type rule struct {
+	uuid      string
+	macPrefix string
+	prefix    netip.Prefix
+}
+
+// normalizeMAC drops the separators of a MAC address or prefix and
+// upper-cases it.
+func normalizeMAC(mac string) string {
+	return strings.ToUpper(strings.NewReplacer(":", "", "-", "", ".", "").Replace(mac))
+}
+
+func parseRules(rules []config.DiscoveryRule) ([]rule, error) {
+	parsed := []rule{}
+	for _, r := range rules {
+		if r.UUID == "" && r.MACPrefix == "" && r.CIDR == "" {
+			return nil, fmt.Errorf("discovery rule needs a uuid, mac_prefix or cidr")
+		}
+		p := rule{uuid: r.UUID, macPrefix: normalizeMAC(r.MACPrefix)}
+		if _, err := path.Match(r.UUID, ""); err != nil {
+			return nil, fmt.Errorf("discovery rule: invalid uuid pattern %q", r.UUID)
+		}
+		if r.CIDR != "" {
+			prefix, err := netip.ParsePrefix(r.CIDR)
+			if err != nil {
+				return nil, fmt.Errorf("discovery rule: %w", err)
+			}
+			p.prefix = prefix.Masked()
+		}
+		parsed = append(parsed, p)
+	}
+	return parsed, nil
+}
+
+// matches reports whether the device at addr with config matches r. A
+// rule on a property the device doesn't report doesn't match.
+func (r rule) matches(addr netip.Addr, config *exporter.ConfigResponse) bool {
+	if r.uuid != "" {
+		if ok, _ := path.Match(r.uuid, config.DeviceUUID); !ok {
+			return false
+		}
+	}
+	if r.macPrefix != "" {
+		mac := normalizeMAC(config.WifiMAC)
+		if mac == "" || !strings.HasPrefix(mac, r.macPrefix) {
+			return false
+		}
+	}
+	if r.prefix.IsValid() && (!addr.IsValid() || !r.prefix.Contains(addr)) {
+		return false
+	}
+	return true
+}
+
+// Filter admits or rejects discovered devices, e.g. to leave out devices
+// of neighbours sharing the network.
+type Filter struct {
+	include []rule
+	exclude []rule
+}
+
+// NewFilter returns the Filter of cfg.
+func NewFilter(cfg config.DiscoveryFilter) (*Filter, error) {
+	include, err := parseRules(cfg.Include)
+	if err != nil {
+		return nil, err
+	}
+	exclude, err := parseRules(cfg.Exclude)
+	if err != nil {
+		return nil, err
+	}
+	return &Filter{include: include, exclude: exclude}, nil
+}
+
+// hostAddr returns the IP address of hostname, with or without a port,
+// falling back to the address the device reports.
+func hostAddr(hostname string, config *exporter.ConfigResponse) netip.Addr {
+	if host, _, err := net.SplitHostPort(hostname); err == nil {
+		hostname = host
+	}
+	if addr, err := netip.ParseAddr(hostname); err == nil {
+		return addr.Unmap()
+	}
+	addr, _ := netip.ParseAddr(config.IP)
+	return addr.Unmap()
+}
+
+// Admits reports whether the device found at hostname with config is
+// admitted.
+func (f *Filter) Admits(hostname string, config *exporter.ConfigResponse) bool {
+	addr := hostAddr(hostname, config)
+	admitted := len(f.include) == 0
+	for _, r := range f.include {
+		admitted = admitted || r.matches(addr, config)
+	}
+	for _, r := range f.exclude {
+		admitted = admitted && !r.matches(addr, config)
+	}
+	return admitted
+}
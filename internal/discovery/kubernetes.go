@@ -0,0 +1,343 @@
+package discovery
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+// The AwairDevice custom resource.
+const (
+	CRDGroup   = "awair.feld.github.io"
+	CRDVersion = "v1alpha1"
+	CRDPlural  = "awairdevices"
+)
+
+// serviceAccountDir holds the credentials of the pod's service account.
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// kubernetesWatchTimeout bounds a watch, after which the resources are
+// listed again, retrying devices which failed to be added.
+const kubernetesWatchTimeout = time.Minute
+
+// ReconcileFunc is called with all targets of a catalog, returning the
+// outcome of adding each by name. Targets missing from the result were
+// left alone, e.g. as another shard handles them.
+type ReconcileFunc func(targets []Target) map[string]error
+
+// awairDevice is an AwairDevice resource.
+type awairDevice struct {
+	Metadata struct {
+		Name       string `json:"name"`
+		Generation int64  `json:"generation"`
+	} `json:"metadata"`
+	Spec struct {
+		Hostname     string            `json:"hostname"`
+		Labels       map[string]string `json:"labels"`
+		Timeout      string            `json:"timeout"`
+		PollInterval string            `json:"pollInterval"`
+		Endpoints    []string          `json:"endpoints"`
+	} `json:"spec"`
+	Status deviceStatus `json:"status"`
+}
+
+type deviceStatus struct {
+	ObservedGeneration int64       `json:"observedGeneration"`
+	Conditions         []condition `json:"conditions"`
+}
+
+type condition struct {
+	Type               string `json:"type"`
+	Status             string `json:"status"`
+	Reason             string `json:"reason"`
+	Message            string `json:"message"`
+	LastTransitionTime string `json:"lastTransitionTime"`
+}
+
+// Kubernetes watches the AwairDevice resources of a namespace, each being
+// a device, and reports whether each device could be added in a Ready
+// condition of its status. Labels of the spec are kept if given to
+// NewKubernetes.
+type Kubernetes struct {
+	server    *url.URL
+	namespace string
+	token     string
+	labels    []string
+	reconcile ReconcileFunc
+	client    *http.Client
+	backoff   time.Duration
+	now       func() time.Time
+
+	targets  prometheus.Gauge
+	failures prometheus.Counter
+}
+
+// NewKubernetes returns a watch of the AwairDevice resources in namespace
+// on the API server at rawURL, authenticated by token if set.
+func NewKubernetes(rawURL, namespace, token string, client *http.Client, labels []string, reconcile ReconcileFunc) (*Kubernetes, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("kubernetes API URL %q is not http(s)", rawURL)
+	}
+	if namespace == "" {
+		return nil, fmt.Errorf("kubernetes namespace is not set")
+	}
+	return &Kubernetes{
+		server:    u,
+		namespace: namespace,
+		token:     token,
+		labels:    labels,
+		reconcile: reconcile,
+		client:    client,
+		backoff:   10 * time.Second,
+		now:       time.Now,
+		targets: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Namespace: "awair",
+				Subsystem: "discovery",
+				Name:      "kubernetes_targets",
+				Help:      "Number of devices supplied by AwairDevice resources",
+			},
+		),
+		failures: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: "awair",
+				Subsystem: "discovery",
+				Name:      "kubernetes_failures_total",
+				Help:      "Number of failed requests to the Kubernetes API",
+			},
+		),
+	}, nil
+}
+
+// NewKubernetesInCluster is NewKubernetes with the API server and
+// credentials of the pod's service account, in the pod's namespace unless
+// namespace is set.
+func NewKubernetesInCluster(namespace string, labels []string, reconcile ReconcileFunc) (*Kubernetes, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("not running in a Kubernetes cluster")
+	}
+	token, err := os.ReadFile(serviceAccountDir + "/token")
+	if err != nil {
+		return nil, err
+	}
+	ca, err := os.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificates in %s/ca.crt", serviceAccountDir)
+	}
+	if namespace == "" {
+		ns, err := os.ReadFile(serviceAccountDir + "/namespace")
+		if err != nil {
+			return nil, err
+		}
+		namespace = strings.TrimSpace(string(ns))
+	}
+	client := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+		Timeout:   kubernetesWatchTimeout + 30*time.Second,
+	}
+	return NewKubernetes("https://"+net.JoinHostPort(host, port), namespace, strings.TrimSpace(string(token)), client, labels, reconcile)
+}
+
+// Run lists the resources, reconciles them and waits for a change, until
+// ctx is done.
+func (k *Kubernetes) Run(ctx context.Context) {
+	for {
+		version, err := k.sync(ctx)
+		if err == nil {
+			err = k.watch(ctx, version)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			k.failures.Inc()
+			log.Error().Err(err).Str("namespace", k.namespace).Msg("Failed to watch AwairDevice resources.")
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(k.backoff):
+			}
+		}
+	}
+}
+
+func (k *Kubernetes) resourceURL(name string, query url.Values) string {
+	u := k.server.JoinPath("/apis", CRDGroup, CRDVersion, "namespaces", k.namespace, CRDPlural, name)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+func (k *Kubernetes) do(ctx context.Context, method, u, contentType string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if k.token != "" {
+		req.Header.Set("Authorization", "Bearer "+k.token)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("kubernetes API answered %s", resp.Status)
+	}
+	return resp, nil
+}
+
+// sync lists the resources, reconciles their devices and updates their
+// status, returning the resource version of the list.
+func (k *Kubernetes) sync(ctx context.Context) (string, error) {
+	resp, err := k.do(ctx, http.MethodGet, k.resourceURL("", nil), "", nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	list := struct {
+		Metadata struct {
+			ResourceVersion string `json:"resourceVersion"`
+		} `json:"metadata"`
+		Items []awairDevice `json:"items"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return "", err
+	}
+
+	targets := make([]Target, 0, len(list.Items))
+	invalid := map[string]error{}
+	for _, d := range list.Items {
+		t, err := k.target(d)
+		if err != nil {
+			invalid[d.Metadata.Name] = err
+			continue
+		}
+		targets = append(targets, t)
+	}
+	k.targets.Set(float64(len(targets)))
+	results := k.reconcile(targets)
+	for name, err := range invalid {
+		results[name] = err
+	}
+	for _, d := range list.Items {
+		err, ok := results[d.Metadata.Name]
+		if !ok {
+			continue
+		}
+		if err := k.updateStatus(ctx, d, err); err != nil {
+			k.failures.Inc()
+			log.Error().Err(err).Str("name", d.Metadata.Name).Msg("Failed to update AwairDevice status.")
+		}
+	}
+	return list.Metadata.ResourceVersion, nil
+}
+
+// target returns the device of the resource d.
+func (k *Kubernetes) target(d awairDevice) (Target, error) {
+	t := Target{Name: d.Metadata.Name, Hostname: d.Spec.Hostname, Labels: map[string]string{}, Endpoints: d.Spec.Endpoints}
+	if t.Hostname == "" {
+		return t, fmt.Errorf("spec.hostname is not set")
+	}
+	for _, l := range k.labels {
+		if value, ok := d.Spec.Labels[l]; ok {
+			t.Labels[l] = value
+		}
+	}
+	var err error
+	if d.Spec.Timeout != "" {
+		if t.Timeout, err = time.ParseDuration(d.Spec.Timeout); err != nil {
+			return t, fmt.Errorf("spec.timeout: %w", err)
+		}
+	}
+	if d.Spec.PollInterval != "" {
+		if t.PollInterval, err = time.ParseDuration(d.Spec.PollInterval); err != nil {
+			return t, fmt.Errorf("spec.pollInterval: %w", err)
+		}
+	}
+	return t, nil
+}
+
+// updateStatus sets the Ready condition of d by the outcome of adding its
+// device, unless it is already up to date.
+func (k *Kubernetes) updateStatus(ctx context.Context, d awairDevice, err error) error {
+	ready := condition{Type: "Ready", Status: "True", Reason: "Added", Message: "Device added"}
+	if err != nil {
+		ready = condition{Type: "Ready", Status: "False", Reason: "AddFailed", Message: err.Error()}
+	}
+	for _, c := range d.Status.Conditions {
+		if c.Type != ready.Type {
+			continue
+		}
+		if c.Status == ready.Status && c.Message == ready.Message && d.Status.ObservedGeneration == d.Metadata.Generation {
+			return nil
+		}
+		if c.Status == ready.Status {
+			ready.LastTransitionTime = c.LastTransitionTime
+		}
+	}
+	if ready.LastTransitionTime == "" {
+		ready.LastTransitionTime = k.now().UTC().Format(time.RFC3339)
+	}
+	body, _ := json.Marshal(map[string]deviceStatus{
+		"status": {ObservedGeneration: d.Metadata.Generation, Conditions: []condition{ready}},
+	})
+	resp, err := k.do(ctx, http.MethodPatch, k.resourceURL(d.Metadata.Name+"/status", nil), "application/merge-patch+json", body)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// watch returns once a resource changed, or the watch timed out.
+func (k *Kubernetes) watch(ctx context.Context, version string) error {
+	resp, err := k.do(ctx, http.MethodGet, k.resourceURL("", url.Values{
+		"watch":           {"1"},
+		"resourceVersion": {version},
+		"timeoutSeconds":  {fmt.Sprint(int(kubernetesWatchTimeout.Seconds()))},
+	}), "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// Any event prompts listing the resources again; the watch ending
+	// without one does so as well.
+	bufio.NewReader(resp.Body).ReadBytes('\n')
+	return nil
+}
+
+func (k *Kubernetes) Describe(ch chan<- *prometheus.Desc) {
+	k.targets.Describe(ch)
+	k.failures.Describe(ch)
+}
+
+func (k *Kubernetes) Collect(ch chan<- prometheus.Metric) {
+	k.targets.Collect(ch)
+	k.failures.Collect(ch)
+}
@@ -0,0 +1,82 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"github.com/tj/assert"
+)
+
+func TestKubernetes(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	const path = "/apis/awair.feld.github.io/v1alpha1/namespaces/home/awairdevices"
+	patches := map[string]map[string]deviceStatus{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal("Bearer secret", r.Header.Get("Authorization"))
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == path && r.URL.Query().Get("watch") == "":
+			w.Write([]byte(`{"metadata": {"resourceVersion": "7"}, "items": [
+				{"metadata": {"name": "bedroom", "generation": 2},
+				 "spec": {"hostname": "192.168.1.2", "labels": {"room": "bedroom", "ignored": "x"}, "pollInterval": "30s", "endpoints": ["air-data"]},
+				 "status": {"observedGeneration": 2, "conditions": [{"type": "Ready", "status": "True", "reason": "Added", "message": "Device added", "lastTransitionTime": "2024-08-01T00:00:00Z"}]}},
+				{"metadata": {"name": "attic", "generation": 1}, "spec": {"hostname": "192.168.1.3"}},
+				{"metadata": {"name": "broken", "generation": 1}, "spec": {"hostname": "192.168.1.4", "timeout": "soon"}},
+				{"metadata": {"name": "elsewhere", "generation": 1}, "spec": {"hostname": "192.168.1.5"}}
+			]}`))
+		case r.Method == http.MethodGet && r.URL.Path == path:
+			assert.Equal("7", r.URL.Query().Get("resourceVersion"))
+			w.Write([]byte(`{"type": "MODIFIED", "object": {}}` + "\n"))
+		case r.Method == http.MethodPatch:
+			assert.Equal("application/merge-patch+json", r.Header.Get("Content-Type"))
+			patch := map[string]deviceStatus{}
+			json.NewDecoder(r.Body).Decode(&patch)
+			patches[r.URL.Path] = patch
+			w.Write([]byte(`{}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reconciled := [][]Target{}
+	k, err := NewKubernetes(srv.URL, "home", "secret", srv.Client(), []string{"room"}, func(targets []Target) map[string]error {
+		reconciled = append(reconciled, targets)
+		if len(reconciled) == 2 {
+			cancel()
+		}
+		// elsewhere belongs to another shard.
+		return map[string]error{"bedroom": nil, "attic": errors.New("connection refused")}
+	})
+	require.Nil(err)
+	k.now = func() time.Time { return time.Date(2024, 8, 2, 12, 0, 0, 0, time.UTC) }
+	k.Run(ctx)
+
+	require.Len(reconciled, 2, "listed again after a change")
+	assert.Equal([]Target{
+		{Name: "bedroom", Hostname: "192.168.1.2", Labels: map[string]string{"room": "bedroom"}, PollInterval: 30 * time.Second, Endpoints: []string{"air-data"}},
+		{Name: "attic", Hostname: "192.168.1.3", Labels: map[string]string{}},
+		{Name: "elsewhere", Hostname: "192.168.1.5", Labels: map[string]string{}},
+	}, reconciled[0])
+	assert.Equal(3.0, testutil.ToFloat64(k.targets))
+
+	assert.Len(patches, 2, "only changed statuses are patched")
+	assert.Equal(deviceStatus{ObservedGeneration: 1, Conditions: []condition{{
+		Type: "Ready", Status: "False", Reason: "AddFailed", Message: "connection refused", LastTransitionTime: "2024-08-02T12:00:00Z",
+	}}}, patches[path+"/attic/status"]["status"])
+	assert.Equal("spec.timeout: time: invalid duration \"soon\"", patches[path+"/broken/status"]["status"].Conditions[0].Message)
+
+	_, err = NewKubernetes("kubernetes:443", "home", "", nil, nil, nil)
+	assert.NotNil(err)
+	_, err = NewKubernetes(srv.URL, "", "", nil, nil, nil)
+	assert.NotNil(err)
+}
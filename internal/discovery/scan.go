@@ -0,0 +1,240 @@
+// Package discovery finds Awair devices on the network.
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"prometheus-awair-exporter/internal/exporter"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+// maxScanHosts bounds the size of the ranges a Scanner probes, so a typo in
+// a prefix length doesn't sweep a whole network.
+const maxScanHosts = 1 << 16
+
+// FoundFunc is called for each device found, with the hostname it answers on.
+type FoundFunc func(hostname string, config *exporter.ConfigResponse)
+
+// Scanner discovers devices by probing every address of a set of IPv4
+// ranges for the Local API, for networks where mDNS is blocked.
+type Scanner struct {
+	prefixes []netip.Prefix
+	rate     float64
+	timeout  time.Duration
+	port     int
+	found    FoundFunc
+
+	mu     sync.Mutex
+	known  map[string]bool
+	filter *Filter
+
+	probes     prometheus.Counter
+	discovered prometheus.Counter
+	filtered   prometheus.Counter
+}
+
+// ParsePrefixes parses a comma separated list of IPv4 CIDR ranges.
+func ParsePrefixes(s string) ([]netip.Prefix, error) {
+	prefixes := []netip.Prefix{}
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(p)
+		if err != nil {
+			return nil, err
+		}
+		if !prefix.Addr().Is4() {
+			return nil, fmt.Errorf("range %s is not IPv4", p)
+		}
+		if hosts := 1 << (32 - prefix.Bits()); hosts > maxScanHosts {
+			return nil, fmt.Errorf("range %s has more than %d addresses", p, maxScanHosts)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// NewScanner returns a Scanner probing the addresses of prefixes at most
+// rate times per second, each probe bounded by timeout, and calling found
+// once for every device found.
+func NewScanner(prefixes []netip.Prefix, rate float64, timeout time.Duration, found FoundFunc) *Scanner {
+	return &Scanner{
+		prefixes: prefixes,
+		rate:     rate,
+		timeout:  timeout,
+		port:     80,
+		found:    found,
+		known:    map[string]bool{},
+		probes: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: "awair",
+				Subsystem: "discovery",
+				Name:      "probes_total",
+				Help:      "Number of addresses probed for an Awair device",
+			},
+		),
+		discovered: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: "awair",
+				Subsystem: "discovery",
+				Name:      "devices_discovered_total",
+				Help:      "Number of devices found by scanning",
+			},
+		),
+		filtered: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: "awair",
+				Subsystem: "discovery",
+				Name:      "devices_filtered_total",
+				Help:      "Number of devices found by scanning but left out by the discovery filter",
+			},
+		),
+	}
+}
+
+// Known marks hostname as a known device, e.g. a statically configured
+// one, which is not reported again.
+func (s *Scanner) Known(hostname string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.known[hostname] = true
+}
+
+// Forget forgets hostname, e.g. a device removed after not answering for a
+// while, so it is reported again once found.
+func (s *Scanner) Forget(hostname string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.known, hostname)
+}
+
+// Covers reports whether hostname is an address of the scanned ranges.
+func (s *Scanner) Covers(hostname string) bool {
+	host := hostname
+	if h, _, err := net.SplitHostPort(hostname); err == nil {
+		host = h
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	for _, prefix := range s.prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetFilter leaves out the devices f doesn't admit.
+func (s *Scanner) SetFilter(f *Filter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.filter = f
+}
+
+// Run scans immediately and then every interval until ctx is done.
+func (s *Scanner) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		s.Scan(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Scan probes every address once, in order and rate limited.
+func (s *Scanner) Scan(ctx context.Context) {
+	limit := time.NewTicker(time.Duration(float64(time.Second) / s.rate))
+	defer limit.Stop()
+	wg := sync.WaitGroup{}
+	defer wg.Wait()
+	for _, prefix := range s.prefixes {
+		for addr := prefix.Addr(); prefix.Contains(addr); addr = addr.Next() {
+			if prefix.Bits() < 31 && (addr == prefix.Addr() || !prefix.Contains(addr.Next())) {
+				// Skip the network and broadcast addresses.
+				continue
+			}
+			hostname := addr.String()
+			if s.port != 80 {
+				hostname = net.JoinHostPort(hostname, strconv.Itoa(s.port))
+			}
+			s.mu.Lock()
+			known := s.known[hostname]
+			s.mu.Unlock()
+			if known {
+				continue
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-limit.C:
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				s.probe(ctx, hostname)
+			}()
+		}
+	}
+}
+
+// probe reports the device at hostname if it answers like an Awair device.
+func (s *Scanner) probe(ctx context.Context, hostname string) {
+	s.probes.Inc()
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	config, err := exporter.GetDeviceConfigContext(ctx, hostname)
+	if err != nil || config.DeviceUUID == "" || config.FirmwareVersion == "" {
+		return
+	}
+	s.mu.Lock()
+	known := s.known[hostname]
+	s.known[hostname] = true
+	filter := s.filter
+	s.mu.Unlock()
+	if known {
+		return
+	}
+	if filter != nil && !filter.Admits(hostname, config) {
+		s.filtered.Inc()
+		log.Debug().
+			Str("hostname", hostname).
+			Str("device_uuid", config.DeviceUUID).
+			Msg("Left out Awair device by discovery filter.")
+		return
+	}
+	s.discovered.Inc()
+	log.Info().
+		Str("hostname", hostname).
+		Str("device_uuid", config.DeviceUUID).
+		Msg("Discovered Awair device.")
+	s.found(hostname, config)
+}
+
+func (s *Scanner) Describe(ch chan<- *prometheus.Desc) {
+	s.probes.Describe(ch)
+	s.discovered.Describe(ch)
+	s.filtered.Describe(ch)
+}
+
+func (s *Scanner) Collect(ch chan<- prometheus.Metric) {
+	s.probes.Collect(ch)
+	s.discovered.Collect(ch)
+	s.filtered.Collect(ch)
+}
@@ -0,0 +1,142 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"prometheus-awair-exporter/internal/config"
+	"prometheus-awair-exporter/internal/exporter"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"github.com/tj/assert"
+)
+
+func TestParsePrefixes(t *testing.T) {
+	assert := assert.New(t)
+	prefixes, err := ParsePrefixes("192.168.1.7/24, 10.0.0.0/30")
+	assert.Nil(err)
+	assert.Equal([]netip.Prefix{
+		netip.MustParsePrefix("192.168.1.0/24"),
+		netip.MustParsePrefix("10.0.0.0/30"),
+	}, prefixes)
+
+	_, err = ParsePrefixes("10.0.0.0/8")
+	assert.NotNil(err, "too large")
+	_, err = ParsePrefixes("fd00::/120")
+	assert.NotNil(err, "not IPv4")
+	_, err = ParsePrefixes("192.168.1.0")
+	assert.NotNil(err)
+}
+
+func TestScan(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/settings/config/data" {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"device_uuid": "awair-element_1", "fw_version": "1.4.0"}`)
+		}
+	}))
+	defer srv.Close()
+	u, err := url.Parse(srv.URL)
+	require.Nil(err)
+	port, err := strconv.Atoi(u.Port())
+	require.Nil(err)
+
+	found := map[string]string{}
+	// 127.0.0.2 refuses connections.
+	s := NewScanner([]netip.Prefix{netip.MustParsePrefix("127.0.0.0/30")}, 100, time.Second,
+		func(hostname string, config *exporter.ConfigResponse) {
+			found[hostname] = config.DeviceUUID
+		})
+	s.port = port
+	s.Scan(context.Background())
+	assert.Equal(map[string]string{u.Host: "awair-element_1"}, found)
+	assert.Equal(float64(2), testutil.ToFloat64(s.probes), "network and broadcast addresses are skipped")
+
+	s.Scan(context.Background())
+	assert.Len(found, 1, "known devices are reported once")
+	assert.Equal(float64(3), testutil.ToFloat64(s.probes))
+	assert.Equal(float64(1), testutil.ToFloat64(s.discovered))
+
+	assert.True(s.Covers(u.Host))
+	assert.False(s.Covers("192.168.1.2"))
+	assert.False(s.Covers("awair-elem-1416DC.local"))
+	s.Forget(u.Host)
+	s.Scan(context.Background())
+	assert.Equal(float64(2), testutil.ToFloat64(s.discovered), "forgotten devices are reported again")
+}
+
+func TestFilter(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	f, err := NewFilter(config.DiscoveryFilter{
+		Include: []config.DiscoveryRule{
+			{UUID: "awair-element_*", MACPrefix: "70:88:6b"},
+			{CIDR: "192.168.1.0/28"},
+		},
+		Exclude: []config.DiscoveryRule{{UUID: "awair-element_9"}},
+	})
+	require.Nil(err)
+
+	for _, c := range []struct {
+		hostname string
+		config   exporter.ConfigResponse
+		admitted bool
+	}{
+		{"192.168.1.50", exporter.ConfigResponse{DeviceUUID: "awair-element_1", WifiMAC: "70-88-6B-14-16-DC"}, true},
+		{"192.168.1.50", exporter.ConfigResponse{DeviceUUID: "awair-omni_1", WifiMAC: "70:88:6B:14:16:DC"}, false},
+		{"192.168.1.50", exporter.ConfigResponse{DeviceUUID: "awair-element_2"}, false},
+		{"192.168.1.5:8080", exporter.ConfigResponse{DeviceUUID: "awair-omni_1"}, true},
+		{"awair.local", exporter.ConfigResponse{DeviceUUID: "awair-omni_1", IP: "192.168.1.6"}, true},
+		{"192.168.1.5", exporter.ConfigResponse{DeviceUUID: "awair-element_9"}, false},
+	} {
+		assert.Equal(c.admitted, f.Admits(c.hostname, &c.config), c)
+	}
+
+	all, err := NewFilter(config.DiscoveryFilter{})
+	require.Nil(err)
+	assert.True(all.Admits("10.0.0.1", &exporter.ConfigResponse{}))
+
+	for _, cfg := range []config.DiscoveryFilter{
+		{Include: []config.DiscoveryRule{{}}},
+		{Exclude: []config.DiscoveryRule{{UUID: "awair-["}}},
+		{Exclude: []config.DiscoveryRule{{CIDR: "192.168.1.0"}}},
+	} {
+		_, err := NewFilter(cfg)
+		assert.NotNil(err, cfg)
+	}
+}
+
+func TestScanFilter(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"device_uuid": "awair-element_1", "fw_version": "1.4.0"}`)
+	}))
+	defer srv.Close()
+	u, err := url.Parse(srv.URL)
+	require.Nil(err)
+	port, err := strconv.Atoi(u.Port())
+	require.Nil(err)
+
+	found := 0
+	s := NewScanner([]netip.Prefix{netip.MustParsePrefix("127.0.0.1/32")}, 100, time.Second,
+		func(string, *exporter.ConfigResponse) { found++ })
+	s.port = port
+	f, err := NewFilter(config.DiscoveryFilter{Exclude: []config.DiscoveryRule{{CIDR: "127.0.0.0/8"}}})
+	require.Nil(err)
+	s.SetFilter(f)
+	s.Scan(context.Background())
+	assert.Equal(0, found)
+	assert.Equal(float64(1), testutil.ToFloat64(s.filtered))
+}
@@ -0,0 +1,124 @@
+package drift
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"prometheus-awair-exporter/internal/schedule"
+	"prometheus-awair-exporter/internal/state"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+// baselineKey is the key the overnight baselines are persisted under.
+const baselineKey = "baseline"
+
+// Baseline tracks the lowest CO2 reading of every device over each night,
+// in the local time of the device. With nobody around the level settles
+// towards outdoor air, so the latest overnight minimum is a simple proxy
+// for the calibration of the sensor and the air tightness of the building.
+type Baseline struct {
+	src   ReadingSource
+	store *state.Store
+	night schedule.Window
+	zones *schedule.Zones
+
+	mu       sync.Mutex
+	tonight  map[string]dayMinimum
+	baseline map[string]dayMinimum
+	// timezones are the time zones of the devices as of their latest
+	// reading.
+	timezones map[string]string
+
+	desc *prometheus.Desc
+}
+
+// NewBaseline returns a Baseline persisting the latest baselines in store,
+// taken over the hours of night in the time zones of zones.
+func NewBaseline(src ReadingSource, store *state.Store, night schedule.Window, zones *schedule.Zones) (*Baseline, error) {
+	b := &Baseline{
+		src:       src,
+		store:     store,
+		night:     night,
+		zones:     zones,
+		tonight:   map[string]dayMinimum{},
+		baseline:  map[string]dayMinimum{},
+		timezones: map[string]string{},
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName("awair", "co2", "overnight_baseline"),
+			"Lowest CO2 reading over the last completed night (ppm)",
+			[]string{"device_uuid"}, nil,
+		),
+	}
+	if _, err := store.Get(baselineKey, &b.baseline); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Run records the readings at interval until ctx is done.
+func (b *Baseline) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			b.record(now)
+		}
+	}
+}
+
+// record folds the current readings into the minima of the night. Once a
+// night is over its minima become the baselines, which are persisted.
+func (b *Baseline) record(now time.Time) {
+	readings := b.src.Readings()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, r := range readings {
+		b.timezones[r.Config.DeviceUUID] = r.Config.Timezone
+	}
+	completed := false
+	for uuid, m := range b.tonight {
+		if day, isNight := b.night.Day(b.zones.In(now, b.timezones[uuid])); !isNight || m.Day != day {
+			b.baseline[uuid] = m
+			delete(b.tonight, uuid)
+			completed = true
+		}
+	}
+	if completed {
+		if err := b.store.Set(baselineKey, b.baseline); err != nil {
+			log.Error().Err(err).Msg("Failed to persist overnight CO2 baselines")
+		}
+	}
+	for _, r := range readings {
+		// Devices without a CO2 sensor report 0.
+		if r.Values.CO2 <= 0 {
+			continue
+		}
+		day, isNight := b.night.Day(b.zones.In(now, r.Config.Timezone))
+		if !isNight {
+			continue
+		}
+		uuid := r.Config.DeviceUUID
+		if m, ok := b.tonight[uuid]; ok && m.Min <= r.Values.CO2 {
+			continue
+		}
+		b.tonight[uuid] = dayMinimum{Day: day, Min: r.Values.CO2}
+	}
+}
+
+func (b *Baseline) Describe(ch chan<- *prometheus.Desc) {
+	ch <- b.desc
+}
+
+func (b *Baseline) Collect(ch chan<- prometheus.Metric) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for uuid, m := range b.baseline {
+		ch <- prometheus.MustNewConstMetric(b.desc, prometheus.GaugeValue, m.Min, uuid)
+	}
+}
@@ -0,0 +1,74 @@
+package drift
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"prometheus-awair-exporter/internal/exporter"
+	"prometheus-awair-exporter/internal/schedule"
+	"prometheus-awair-exporter/internal/state"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"github.com/tj/assert"
+)
+
+func TestBaseline(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	path := filepath.Join(t.TempDir(), "state.json")
+	store, err := state.Open(path)
+	require.Nil(err)
+
+	values := &exporter.AwairValues{}
+	src := staticSource{
+		{Config: &exporter.ConfigResponse{DeviceUUID: "awair-element_1"}, Values: values},
+		{Config: &exporter.ConfigResponse{DeviceUUID: "awair-omni_3"}, Values: &exporter.AwairValues{}},
+	}
+	b, err := NewBaseline(src, store, schedule.Window{Start: 22, End: 5}, schedule.NewZones())
+	require.Nil(err)
+
+	evening := time.Date(2024, 3, 1, 21, 0, 0, 0, time.Local)
+	for hour, co2 := range []float64{400, 800, 520, 480, 600, 510, 900} {
+		values.CO2 = co2
+		b.record(evening.Add(time.Duration(hour) * time.Hour))
+	}
+	assert.Equal(0, testutil.CollectAndCount(b), "the night isn't over yet")
+
+	values.CO2 = 1200
+	b.record(evening.Add(8 * time.Hour))
+	expected := `
+# HELP awair_co2_overnight_baseline Lowest CO2 reading over the last completed night (ppm)
+# TYPE awair_co2_overnight_baseline gauge
+awair_co2_overnight_baseline{device_uuid="awair-element_1"} 480
+`
+	assert.Nil(testutil.CollectAndCompare(b, strings.NewReader(expected)))
+
+	// The baseline survives a restart.
+	store, err = state.Open(path)
+	require.Nil(err)
+	b, err = NewBaseline(src, store, schedule.Window{Start: 22, End: 5}, schedule.NewZones())
+	require.Nil(err)
+	assert.Nil(testutil.CollectAndCompare(b, strings.NewReader(expected)))
+}
+
+func TestBaselineTimezone(t *testing.T) {
+	store, err := state.Open(filepath.Join(t.TempDir(), "state.json"))
+	require.Nil(t, err)
+	values := &exporter.AwairValues{CO2: 500}
+	src := staticSource{{Config: &exporter.ConfigResponse{DeviceUUID: "awair-element_1", Timezone: "Asia/Tokyo"}, Values: values}}
+	b, err := NewBaseline(src, store, schedule.Window{Start: 22, End: 5}, schedule.NewZones())
+	require.Nil(t, err)
+
+	// 23:00 and 06:00 in Tokyo.
+	b.record(time.Date(2024, 3, 1, 14, 0, 0, 0, time.UTC))
+	values.CO2 = 900
+	b.record(time.Date(2024, 3, 1, 21, 0, 0, 0, time.UTC))
+	assert.Nil(t, testutil.CollectAndCompare(b, strings.NewReader(`
+# HELP awair_co2_overnight_baseline Lowest CO2 reading over the last completed night (ppm)
+# TYPE awair_co2_overnight_baseline gauge
+awair_co2_overnight_baseline{device_uuid="awair-element_1"} 500
+`)))
+}
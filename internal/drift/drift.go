@@ -0,0 +1,180 @@
+// Package drift detects sensors drifting away from their calibration.
+package drift
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"prometheus-awair-exporter/internal/exporter"
+	"prometheus-awair-exporter/internal/schedule"
+	"prometheus-awair-exporter/internal/state"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+// OutdoorCO2 is the CO2 level of outdoor air, which ventilated rooms
+// return close to when unoccupied, e.g. overnight.
+const OutdoorCO2 = 420
+
+// stateKey is the key the daily minima are persisted under.
+const stateKey = "drift"
+
+// ReadingSource provides the latest reading of every device.
+type ReadingSource interface {
+	Readings() []exporter.Reading
+}
+
+// dayMinimum is the lowest CO2 reading of a device on a day.
+type dayMinimum struct {
+	Day string  `json:"day"`
+	Min float64 `json:"min"`
+}
+
+// Detector tracks the daily minimum CO2 reading of every device, over the
+// days of its local time zone. A sensor
+// whose minimum stayed more than tolerance above OutdoorCO2 on each of the
+// last days days is suspected of drifting and should be recalibrated.
+type Detector struct {
+	src       ReadingSource
+	store     *state.Store
+	zones     *schedule.Zones
+	days      int
+	tolerance float64
+
+	mu        sync.Mutex
+	minima    map[string][]dayMinimum
+	suspected map[string]bool
+
+	lowest    *prometheus.Desc
+	suspicion *prometheus.Desc
+}
+
+// New returns a Detector persisting its history in store, with days in the
+// time zones of zones.
+func New(src ReadingSource, store *state.Store, zones *schedule.Zones, days int, tolerance float64) (*Detector, error) {
+	d := &Detector{
+		src:       src,
+		store:     store,
+		zones:     zones,
+		days:      days,
+		tolerance: tolerance,
+		minima:    map[string][]dayMinimum{},
+		suspected: map[string]bool{},
+		lowest: prometheus.NewDesc(
+			prometheus.BuildFQName("awair", "drift", "co2_minimum"),
+			"Lowest CO2 reading over the drift detection window (ppm)",
+			[]string{"device_uuid"}, nil,
+		),
+		suspicion: prometheus.NewDesc(
+			prometheus.BuildFQName("awair", "drift", "suspected"),
+			"Whether the sensor is suspected of drifting and should be recalibrated (1) or not (0)",
+			[]string{"device_uuid", "sensor"}, nil,
+		),
+	}
+	if _, err := store.Get(stateKey, &d.minima); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Run records the readings at interval until ctx is done.
+func (d *Detector) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			d.record(now)
+		}
+	}
+}
+
+// record folds the current readings into the daily minima. The history is
+// persisted when a day is completed.
+func (d *Detector) record(now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	rolledOver := false
+	for _, r := range d.src.Readings() {
+		// Devices without a CO2 sensor report 0.
+		if r.Values.CO2 <= 0 {
+			continue
+		}
+		uuid := r.Config.DeviceUUID
+		day := d.zones.In(now, r.Config.Timezone).Format("2006-01-02")
+		minima := d.minima[uuid]
+		if n := len(minima); n > 0 && minima[n-1].Day == day {
+			if r.Values.CO2 < minima[n-1].Min {
+				minima[n-1].Min = r.Values.CO2
+			}
+			continue
+		}
+		minima = append(minima, dayMinimum{Day: day, Min: r.Values.CO2})
+		if len(minima) > d.days+1 {
+			minima = minima[len(minima)-d.days-1:]
+		}
+		d.minima[uuid] = minima
+		rolledOver = true
+	}
+	if rolledOver {
+		if err := d.store.Set(stateKey, d.minima); err != nil {
+			log.Error().Err(err).Msg("Failed to persist drift detection history")
+		}
+	}
+
+	for uuid := range d.minima {
+		lowest, ok := d.lowestLocked(uuid)
+		suspected := ok && lowest > OutdoorCO2+d.tolerance
+		if suspected && !d.suspected[uuid] {
+			log.Warn().
+				Str("device_uuid", uuid).
+				Float64("co2_minimum", lowest).
+				Int("days", d.days).
+				Msg("CO2 readings haven't returned near outdoor levels for days, the sensor may have drifted and need recalibration.")
+		}
+		d.suspected[uuid] = suspected
+	}
+}
+
+// lowestLocked returns the lowest CO2 reading of the completed days in the
+// window, reporting false until there are enough of them.
+func (d *Detector) lowestLocked(uuid string) (float64, bool) {
+	minima := d.minima[uuid]
+	if len(minima) < d.days+1 {
+		return 0, false
+	}
+	complete := minima[len(minima)-d.days-1 : len(minima)-1]
+	lowest := complete[0].Min
+	for _, m := range complete[1:] {
+		if m.Min < lowest {
+			lowest = m.Min
+		}
+	}
+	return lowest, true
+}
+
+func (d *Detector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- d.lowest
+	ch <- d.suspicion
+}
+
+func (d *Detector) Collect(ch chan<- prometheus.Metric) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for uuid := range d.minima {
+		lowest, ok := d.lowestLocked(uuid)
+		if !ok {
+			continue
+		}
+		suspected := 0.0
+		if d.suspected[uuid] {
+			suspected = 1
+		}
+		ch <- prometheus.MustNewConstMetric(d.lowest, prometheus.GaugeValue, lowest, uuid)
+		ch <- prometheus.MustNewConstMetric(d.suspicion, prometheus.GaugeValue, suspected, uuid, "co2")
+	}
+}
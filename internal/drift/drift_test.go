@@ -0,0 +1,73 @@
+package drift
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"prometheus-awair-exporter/internal/exporter"
+	"prometheus-awair-exporter/internal/schedule"
+	"prometheus-awair-exporter/internal/state"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"github.com/tj/assert"
+)
+
+type staticSource []exporter.Reading
+
+func (s staticSource) Readings() []exporter.Reading {
+	return s
+}
+
+func TestDetector(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	path := filepath.Join(t.TempDir(), "state.json")
+	store, err := state.Open(path)
+	require.Nil(err)
+
+	drifting := &exporter.AwairValues{}
+	healthy := &exporter.AwairValues{}
+	src := staticSource{
+		{Config: &exporter.ConfigResponse{DeviceUUID: "awair-element_1"}, Values: drifting},
+		{Config: &exporter.ConfigResponse{DeviceUUID: "awair-element_2"}, Values: healthy},
+		{Config: &exporter.ConfigResponse{DeviceUUID: "awair-omni_3"}, Values: &exporter.AwairValues{}},
+	}
+	d, err := New(src, store, schedule.NewZones(), 3, 100)
+	require.Nil(err)
+
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.Local)
+	for day := 0; day < 3; day++ {
+		for hour, co2 := range []float64{900, 560, 700} {
+			drifting.CO2 = co2
+			healthy.CO2 = co2 - 100
+			d.record(start.AddDate(0, 0, day).Add(time.Duration(hour*3) * time.Hour))
+		}
+	}
+	assert.Equal(0, testutil.CollectAndCount(d), "three days are needed")
+
+	drifting.CO2, healthy.CO2 = 950, 850
+	d.record(start.AddDate(0, 0, 3))
+	err = testutil.CollectAndCompare(d, strings.NewReader(`
+# HELP awair_drift_co2_minimum Lowest CO2 reading over the drift detection window (ppm)
+# TYPE awair_drift_co2_minimum gauge
+awair_drift_co2_minimum{device_uuid="awair-element_1"} 560
+awair_drift_co2_minimum{device_uuid="awair-element_2"} 460
+# HELP awair_drift_suspected Whether the sensor is suspected of drifting and should be recalibrated (1) or not (0)
+# TYPE awair_drift_suspected gauge
+awair_drift_suspected{device_uuid="awair-element_1",sensor="co2"} 1
+awair_drift_suspected{device_uuid="awair-element_2",sensor="co2"} 0
+`))
+	assert.Nil(err)
+
+	// The completed days survive a restart.
+	store, err = state.Open(path)
+	require.Nil(err)
+	d, err = New(src, store, schedule.NewZones(), 3, 100)
+	require.Nil(err)
+	d.record(start.AddDate(0, 0, 3).Add(time.Hour))
+	assert.Equal(4, testutil.CollectAndCount(d))
+	assert.Len(d.minima["awair-element_1"], 4)
+}
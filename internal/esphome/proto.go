@@ -0,0 +1,92 @@
+package esphome
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// message is a protobuf message under construction. Fields at their
+// default value are left out, as proto3 does.
+type message []byte
+
+func (m message) tag(field, wireType int) message {
+	return binary.AppendUvarint(m, uint64(field<<3|wireType))
+}
+
+func (m message) uint(field int, v uint64) message {
+	if v == 0 {
+		return m
+	}
+	return binary.AppendUvarint(m.tag(field, 0), v)
+}
+
+func (m message) boolean(field int, v bool) message {
+	if !v {
+		return m
+	}
+	return m.uint(field, 1)
+}
+
+func (m message) str(field int, s string) message {
+	if s == "" {
+		return m
+	}
+	m = binary.AppendUvarint(m.tag(field, 2), uint64(len(s)))
+	return append(m, s...)
+}
+
+func (m message) fixed32(field int, v uint32) message {
+	if v == 0 {
+		return m
+	}
+	return binary.LittleEndian.AppendUint32(m.tag(field, 5), v)
+}
+
+func (m message) float(field int, v float32) message {
+	return m.fixed32(field, math.Float32bits(v))
+}
+
+var errTruncated = errors.New("truncated protobuf message")
+
+// stringField returns the first string field of the message b, skipping
+// fields of other types.
+func stringField(b []byte, field int) (string, error) {
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return "", errTruncated
+		}
+		b = b[n:]
+		switch key & 7 {
+		case 0:
+			if _, n = binary.Uvarint(b); n <= 0 {
+				return "", errTruncated
+			}
+			b = b[n:]
+		case 1:
+			if len(b) < 8 {
+				return "", errTruncated
+			}
+			b = b[8:]
+		case 2:
+			length, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < length {
+				return "", errTruncated
+			}
+			value := b[n : n+int(length)]
+			b = b[n+int(length):]
+			if int(key>>3) == field {
+				return string(value), nil
+			}
+		case 5:
+			if len(b) < 4 {
+				return "", errTruncated
+			}
+			b = b[4:]
+		default:
+			return "", errors.New("unsupported protobuf wire type")
+		}
+	}
+	return "", nil
+}
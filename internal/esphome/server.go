@@ -0,0 +1,443 @@
+// Package esphome serves the readings of the devices over the native API
+// of ESPHome, so Home Assistant adopts the exporter like an ESPHome node
+// without MQTT.
+package esphome
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"prometheus-awair-exporter/internal/exporter"
+	"prometheus-awair-exporter/internal/mdns"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+// ReadingSource provides the latest reading of every device by name.
+type ReadingSource interface {
+	NamedReadings() map[string]exporter.Reading
+}
+
+// Message types of the native API.
+const (
+	helloRequest           = 1
+	helloResponse          = 2
+	connectRequest         = 3
+	connectResponse        = 4
+	disconnectRequest      = 5
+	disconnectResponse     = 6
+	pingRequest            = 7
+	pingResponse           = 8
+	deviceInfoRequest      = 9
+	deviceInfoResponse     = 10
+	listEntitiesRequest    = 11
+	listEntitiesSensor     = 16
+	listEntitiesDone       = 19
+	subscribeStatesRequest = 20
+	sensorStateResponse    = 25
+	getTimeRequest         = 36
+	getTimeResponse        = 37
+)
+
+const (
+	apiVersionMajor       = 1
+	apiVersionMinor       = 10
+	esphomeVersion        = "2024.12.0"
+	stateClassMeasurement = 1
+	maxMessageSize        = 64 << 10
+)
+
+// sensor is a sensor of a device exposed as an entity.
+type sensor struct {
+	field       string
+	name        string
+	unit        string
+	deviceClass string
+	decimals    int
+}
+
+var sensors = []sensor{
+	{"score", "Score", "", "", 0},
+	{"temp", "Temperature", "°C", "temperature", 1},
+	{"humid", "Humidity", "%", "humidity", 1},
+	{"co2", "CO2", "ppm", "carbon_dioxide", 0},
+	{"voc", "VOC", "ppb", "volatile_organic_compounds_parts", 0},
+	{"pm25", "PM2.5", "µg/m³", "pm25", 0},
+	{"pm10_est", "PM10", "µg/m³", "pm10", 0},
+}
+
+// entity is a sensor of a device.
+type entity struct {
+	key      uint32
+	objectID string
+	device   string
+	sensor   sensor
+}
+
+var unsafeObjectID = regexp.MustCompile(`[^a-z0-9_-]`)
+
+func newEntity(device string, s sensor) entity {
+	objectID := unsafeObjectID.ReplaceAllString(strings.ToLower(device+"_"+s.field), "_")
+	h := fnv.New32()
+	h.Write([]byte(objectID))
+	return entity{key: h.Sum32(), objectID: objectID, device: device, sensor: s}
+}
+
+// Server serves the sensors of every device as the entities of a single
+// node. Devices keep their entities once seen, reporting a missing state
+// without a reading.
+type Server struct {
+	name         string
+	friendlyName string
+	mac          string
+	version      string
+	src          ReadingSource
+
+	mu       sync.Mutex
+	devices  map[string]bool
+	entities []entity
+	states   map[uint32]float32
+	conns    map[*conn]bool
+
+	connections *prometheus.Desc
+}
+
+// New returns a Server for the node name, e.g. awair-exporter, shown as
+// friendlyName. version is reported as the node's project version.
+func New(name, friendlyName, version string, src ReadingSource) (*Server, error) {
+	if name == "" || unsafeObjectID.MatchString(name) {
+		return nil, fmt.Errorf("node name %q may only hold lower case letters, digits, - and _", name)
+	}
+	// A stable, locally administered MAC address identifies the node.
+	sum := sha256.Sum256([]byte(name))
+	mac := sum[:6]
+	mac[0] = mac[0]&0xfe | 0x02
+	return &Server{
+		name:         name,
+		friendlyName: friendlyName,
+		mac:          net.HardwareAddr(mac).String(),
+		version:      version,
+		src:          src,
+		devices:      map[string]bool{},
+		states:       map[uint32]float32{},
+		conns:        map[*conn]bool{},
+		connections: prometheus.NewDesc(
+			prometheus.BuildFQName("awair", "esphome", "connections"),
+			"Number of open ESPHome native API connections",
+			nil, nil,
+		),
+	}, nil
+}
+
+// Run updates the entities and sends changed states to subscribed clients
+// at interval until ctx is done. New devices close the connections, so
+// clients reconnect and list the new entities.
+func (s *Server) Run(ctx context.Context, interval time.Duration) {
+	s.refresh()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.refresh()
+		}
+	}
+}
+
+// currentStates returns the value of every entity with a reading.
+func (s *Server) currentStates(entities []entity) map[uint32]float32 {
+	readings := s.src.NamedReadings()
+	states := map[uint32]float32{}
+	for _, e := range entities {
+		values := readings[e.device].Values
+		if values == nil {
+			continue
+		}
+		for _, f := range values.Fields() {
+			if f.Name == e.sensor.field {
+				states[e.key] = float32(f.Value)
+			}
+		}
+	}
+	return states
+}
+
+func (s *Server) refresh() {
+	s.mu.Lock()
+	added := false
+	for name := range s.src.NamedReadings() {
+		if !s.devices[name] {
+			s.devices[name] = true
+			added = true
+		}
+	}
+	if added {
+		names := make([]string, 0, len(s.devices))
+		for name := range s.devices {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		s.entities = []entity{}
+		for _, name := range names {
+			for _, sensor := range sensors {
+				s.entities = append(s.entities, newEntity(name, sensor))
+			}
+		}
+	}
+	entities := s.entities
+	previous := s.states
+	conns := []*conn{}
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.mu.Unlock()
+
+	states := s.currentStates(entities)
+	s.mu.Lock()
+	s.states = states
+	s.mu.Unlock()
+	for _, c := range conns {
+		if added {
+			c.Close()
+			continue
+		}
+		if !c.isSubscribed() {
+			continue
+		}
+		for _, e := range entities {
+			v, ok := states[e.key]
+			old, had := previous[e.key]
+			if ok == had && v == old {
+				continue
+			}
+			if err := c.send(sensorStateResponse, stateMessage(e.key, v, ok)); err != nil {
+				break
+			}
+		}
+	}
+}
+
+func stateMessage(key uint32, value float32, ok bool) message {
+	m := message{}.fixed32(1, key)
+	if !ok {
+		return m.boolean(3, true)
+	}
+	return m.float(2, value)
+}
+
+// Serve accepts native API connections on ln until ctx is done.
+func (s *Server) Serve(ctx context.Context, ln net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+		s.mu.Lock()
+		for c := range s.conns {
+			c.Close()
+		}
+		s.mu.Unlock()
+	}()
+	for {
+		nc, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go s.serve(nc)
+	}
+}
+
+// conn is a native API connection.
+type conn struct {
+	net.Conn
+
+	writeMu    sync.Mutex
+	subscribed bool
+}
+
+// send writes a message in the plaintext framing: a zero byte, the length
+// and the type of the message as varints, then the message.
+func (c *conn) send(typ int, m message) error {
+	frame := []byte{0}
+	frame = binary.AppendUvarint(frame, uint64(len(m)))
+	frame = binary.AppendUvarint(frame, uint64(typ))
+	frame = append(frame, m...)
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_, err := c.Write(frame)
+	return err
+}
+
+func (c *conn) isSubscribed() bool {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.subscribed
+}
+
+var errEncrypted = errors.New("client requested an encrypted connection, which isn't supported")
+
+// receive reads a message, returning its type and body.
+func receive(r *bufio.Reader) (int, []byte, error) {
+	preamble, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	if preamble != 0 {
+		return 0, nil, errEncrypted
+	}
+	length, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	typ, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	if length > maxMessageSize {
+		return 0, nil, fmt.Errorf("message of %d bytes too large", length)
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return int(typ), body, nil
+}
+
+func (s *Server) serve(nc net.Conn) {
+	c := &conn{Conn: nc}
+	s.mu.Lock()
+	s.conns[c] = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+		c.Close()
+	}()
+	logger := log.With().Str("remote", nc.RemoteAddr().String()).Logger()
+
+	r := bufio.NewReader(c)
+	for {
+		typ, body, err := receive(r)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				logger.Warn().Err(err).Msg("Closing ESPHome native API connection.")
+			}
+			return
+		}
+		switch typ {
+		case helloRequest:
+			client, _ := stringField(body, 1)
+			logger.Info().Str("client", client).Msg("ESPHome native API client connected.")
+			err = c.send(helloResponse, message{}.
+				uint(1, apiVersionMajor).
+				uint(2, apiVersionMinor).
+				str(3, "awair-exporter "+s.version).
+				str(4, s.name))
+		case connectRequest:
+			err = c.send(connectResponse, message{})
+		case disconnectRequest:
+			c.send(disconnectResponse, message{})
+			return
+		case pingRequest:
+			err = c.send(pingResponse, message{})
+		case deviceInfoRequest:
+			err = c.send(deviceInfoResponse, message{}.
+				str(2, s.name).
+				str(3, strings.ToUpper(s.mac)).
+				str(4, esphomeVersion).
+				str(6, "Awair Exporter").
+				str(8, "feld.awair-exporter").
+				str(9, s.version).
+				str(12, "Awair").
+				str(13, s.friendlyName))
+		case listEntitiesRequest:
+			s.mu.Lock()
+			entities := s.entities
+			s.mu.Unlock()
+			for _, e := range entities {
+				err = c.send(listEntitiesSensor, message{}.
+					str(1, e.objectID).
+					fixed32(2, e.key).
+					str(3, e.device+" "+e.sensor.name).
+					str(4, s.name+"-"+e.objectID).
+					str(6, e.sensor.unit).
+					uint(7, uint64(e.sensor.decimals)).
+					str(9, e.sensor.deviceClass).
+					uint(10, stateClassMeasurement))
+				if err != nil {
+					break
+				}
+			}
+			if err == nil {
+				err = c.send(listEntitiesDone, message{})
+			}
+		case subscribeStatesRequest:
+			s.mu.Lock()
+			entities, states := s.entities, s.states
+			s.mu.Unlock()
+			c.writeMu.Lock()
+			c.subscribed = true
+			c.writeMu.Unlock()
+			for _, e := range entities {
+				v, ok := states[e.key]
+				if err = c.send(sensorStateResponse, stateMessage(e.key, v, ok)); err != nil {
+					break
+				}
+			}
+		case getTimeRequest:
+			err = c.send(getTimeResponse, message{}.fixed32(1, uint32(time.Now().Unix())))
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Advertise announces the node listening on port by multicast DNS, so Home
+// Assistant discovers it, until ctx is done.
+func (s *Server) Advertise(ctx context.Context, port int) error {
+	mac := strings.ReplaceAll(s.mac, ":", "")
+	responder := mdns.New(mdns.Service{
+		Instance: s.name,
+		Type:     "_esphomelib._tcp",
+		Host:     s.name,
+		Port:     port,
+		TXT: func() []string {
+			return []string{
+				"friendly_name=" + s.friendlyName,
+				"version=" + esphomeVersion,
+				"mac=" + mac,
+				"platform=host",
+				"network=ethernet",
+			}
+		},
+	})
+	return responder.Run(ctx)
+}
+
+func (s *Server) Describe(ch chan<- *prometheus.Desc) {
+	ch <- s.connections
+}
+
+func (s *Server) Collect(ch chan<- prometheus.Metric) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ch <- prometheus.MustNewConstMetric(s.connections, prometheus.GaugeValue, float64(len(s.conns)))
+}
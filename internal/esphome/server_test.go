@@ -0,0 +1,193 @@
+package esphome
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"math"
+	"net"
+	"strings"
+	"testing"
+
+	"prometheus-awair-exporter/internal/exporter"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"github.com/tj/assert"
+)
+
+type staticSource map[string]exporter.Reading
+
+func (s staticSource) NamedReadings() map[string]exporter.Reading {
+	return s
+}
+
+// fields decodes a message into its varint, fixed32 and string fields.
+func fields(t *testing.T, b []byte) map[int]interface{} {
+	out := map[int]interface{}{}
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		require.True(t, n > 0)
+		b = b[n:]
+		switch key & 7 {
+		case 0:
+			v, n := binary.Uvarint(b)
+			require.True(t, n > 0)
+			out[int(key>>3)] = v
+			b = b[n:]
+		case 2:
+			length, n := binary.Uvarint(b)
+			require.True(t, n > 0)
+			out[int(key>>3)] = string(b[n : n+int(length)])
+			b = b[n+int(length):]
+		case 5:
+			out[int(key>>3)] = binary.LittleEndian.Uint32(b)
+			b = b[4:]
+		default:
+			t.Fatalf("unexpected wire type %d", key&7)
+		}
+	}
+	return out
+}
+
+// client is the client's side of a native API connection.
+type client struct {
+	t    *testing.T
+	conn *conn
+	r    *bufio.Reader
+}
+
+func dial(t *testing.T, addr string) *client {
+	nc, err := net.Dial("tcp", addr)
+	require.Nil(t, err)
+	t.Cleanup(func() { nc.Close() })
+	return &client{t: t, conn: &conn{Conn: nc}, r: bufio.NewReader(nc)}
+}
+
+func (c *client) receive(typ int) map[int]interface{} {
+	got, body, err := receive(c.r)
+	require.Nil(c.t, err)
+	require.Equal(c.t, typ, got)
+	return fields(c.t, body)
+}
+
+func TestStringField(t *testing.T) {
+	m := message{}.uint(1, 300).fixed32(2, 7).str(3, "aioesphomeapi").str(4, "other")
+	s, err := stringField(m, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, "aioesphomeapi", s)
+
+	s, err = stringField(m, 5)
+	assert.Nil(t, err)
+	assert.Equal(t, "", s)
+
+	_, err = stringField(m[:len(m)-1], 4)
+	assert.Equal(t, errTruncated, err)
+}
+
+func TestNewValidatesName(t *testing.T) {
+	_, err := New("Awair Exporter", "Awair", "test", staticSource{})
+	assert.NotNil(t, err)
+}
+
+func TestServer(t *testing.T) {
+	src := staticSource{
+		"Office": {Values: &exporter.AwairValues{Score: 91, Temp: 21.5, CO2: 600}},
+	}
+	s, err := New("awair-exporter", "Awair Exporter", "test", src)
+	require.Nil(t, err)
+	s.refresh()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Serve(ctx, ln)
+
+	c := dial(t, ln.Addr().String())
+	require.Nil(t, c.conn.send(helloRequest, message{}.str(1, "aioesphomeapi")))
+	hello := c.receive(helloResponse)
+	assert.Equal(t, uint64(apiVersionMajor), hello[1])
+	assert.Equal(t, "awair-exporter", hello[4])
+
+	require.Nil(t, c.conn.send(connectRequest, message{}))
+	c.receive(connectResponse)
+
+	require.Nil(t, c.conn.send(deviceInfoRequest, message{}))
+	info := c.receive(deviceInfoResponse)
+	assert.Equal(t, "awair-exporter", info[2])
+	assert.Equal(t, "Awair Exporter", info[13])
+	mac, err := net.ParseMAC(info[3].(string))
+	require.Nil(t, err)
+	assert.Equal(t, byte(0x02), mac[0]&0x03, "locally administered unicast address")
+
+	require.Nil(t, c.conn.send(listEntitiesRequest, message{}))
+	keys := map[string]uint32{}
+	for range sensors {
+		e := c.receive(listEntitiesSensor)
+		keys[e[1].(string)] = e[2].(uint32)
+		assert.True(t, strings.HasPrefix(e[3].(string), "Office "))
+	}
+	c.receive(listEntitiesDone)
+	assert.Contains(t, keys, "office_temp")
+	assert.Contains(t, keys, "office_pm10_est")
+
+	require.Nil(t, c.conn.send(subscribeStatesRequest, message{}))
+	states := map[uint32]map[int]interface{}{}
+	for range sensors {
+		state := c.receive(sensorStateResponse)
+		states[state[1].(uint32)] = state
+	}
+	assert.Equal(t, float32(21.5), math.Float32frombits(states[keys["office_temp"]][2].(uint32)))
+	assert.Equal(t, float32(600), math.Float32frombits(states[keys["office_co2"]][2].(uint32)))
+
+	// Only changed states are sent.
+	src["Office"].Values.CO2 = 650
+	s.refresh()
+	state := c.receive(sensorStateResponse)
+	assert.Equal(t, keys["office_co2"], state[1])
+	assert.Equal(t, float32(650), math.Float32frombits(state[2].(uint32)))
+
+	assert.Nil(t, testutil.CollectAndCompare(s, strings.NewReader(`
+# HELP awair_esphome_connections Number of open ESPHome native API connections
+# TYPE awair_esphome_connections gauge
+awair_esphome_connections 1
+`)))
+
+	// A new device closes the connection, so the client lists its entities.
+	src["Bedroom"] = exporter.Reading{}
+	s.refresh()
+	_, _, err = receive(c.r)
+	assert.NotNil(t, err)
+
+	c = dial(t, ln.Addr().String())
+	require.Nil(t, c.conn.send(listEntitiesRequest, message{}))
+	for range sensors {
+		e := c.receive(listEntitiesSensor)
+		assert.True(t, strings.HasPrefix(e[1].(string), "bedroom_"))
+	}
+	for range sensors {
+		c.receive(listEntitiesSensor)
+	}
+	c.receive(listEntitiesDone)
+
+	require.Nil(t, c.conn.send(subscribeStatesRequest, message{}))
+	state = c.receive(sensorStateResponse)
+	assert.Equal(t, uint64(1), state[3], "missing state without a reading")
+}
+
+func TestEncryptedConnectionRefused(t *testing.T) {
+	s, err := New("awair-exporter", "Awair Exporter", "test", staticSource{})
+	require.Nil(t, err)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Serve(ctx, ln)
+
+	c := dial(t, ln.Addr().String())
+	_, err = c.conn.Write([]byte{1, 0, 0})
+	require.Nil(t, err)
+	_, err = c.r.ReadByte()
+	assert.NotNil(t, err)
+}
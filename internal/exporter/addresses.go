@@ -0,0 +1,46 @@
+package exporter
+
+import (
+	"net/http"
+)
+
+// address is an address the device is reached at, with the client of its
+// requests.
+type address struct {
+	host   string
+	client *http.Client
+	// resolver re-resolves the host, nil for IP addresses.
+	resolver *resolver
+}
+
+// WithAddresses gives further addresses of the device, e.g. the IP address
+// of its Ethernet adapter besides that of its Wi-Fi, or its mDNS name. When
+// a request to the address which answered last fails to connect or times
+// out, the others are tried in order, the device's hostname first. Error
+// responses aren't retried elsewhere.
+func WithAddresses(addrs []string) Option {
+	return func(e *AwairExporter) {
+		e.alternates = addrs
+	}
+}
+
+// setupAddresses creates the addresses of the device: its hostname,
+// requested with the exporter's client, and those given to WithAddresses,
+// requested with clients of the same timeout.
+func (e *AwairExporter) setupAddresses() {
+	e.addresses = []*address{{host: e.hostname, client: e.client, resolver: e.resolver}}
+	for _, host := range e.alternates {
+		a := &address{host: host, client: &http.Client{Timeout: e.client.Timeout}}
+		if a.resolver = newResolver(host, e.resolveInterval); a.resolver != nil {
+			a.client.Transport = a.resolver.transport
+			a.resolver.logger = e.logger
+		}
+		e.addresses = append(e.addresses, a)
+	}
+}
+
+// Address returns the address which answered the latest request to the
+// device, its hostname until then.
+func (e *AwairExporter) Address() string {
+	return e.addresses[e.active.Load()].host
+}
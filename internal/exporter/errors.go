@@ -0,0 +1,164 @@
+package exporter
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Classes of errors reported by a device.
+const (
+	ErrorClassRateLimited = "rate_limited"
+	ErrorClassAPIDisabled = "api_disabled"
+	ErrorClassInternal    = "internal_error"
+	ErrorClassUnexpected  = "unexpected_response"
+	ErrorClassTooLarge    = "response_too_large"
+	ErrorClassTimeout     = "timeout"
+	ErrorClassDNS         = "dns"
+	ErrorClassConnection  = "connection"
+	ErrorClassDecode      = "decode"
+)
+
+// maxErrorBody is how much of an error response is kept for logging.
+const maxErrorBody = 256
+
+// maxResponseSize bounds the size of a device response, as the readings are
+// well below 1KiB. It protects the exporter from targets such as a router's
+// web UI sending large pages.
+const maxResponseSize = 64 << 10
+
+// DeviceError is an error response returned by a device, such as a non-200
+// status or a JSON error document.
+type DeviceError struct {
+	Endpoint   string
+	StatusCode int
+	Class      string
+	// Body is the start of the response body.
+	Body string
+}
+
+func (e *DeviceError) Error() string {
+	return fmt.Sprintf("%s: device returned %s (HTTP %d): %s", e.Endpoint, e.Class, e.StatusCode, e.Body)
+}
+
+// jsonContentTypes are the content types accepted for readings. Responses
+// without a content type are accepted too.
+var jsonContentTypes = map[string]bool{
+	"application/json": true,
+	"text/json":        true,
+	"text/plain":       true,
+}
+
+// RequestError is a failure to reach a device, classified as a timeout, DNS
+// or connection error.
+type RequestError struct {
+	Endpoint string
+	Class    string
+	Err      error
+}
+
+func newRequestError(endpoint string, err error) *RequestError {
+	class := ErrorClassConnection
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &dnsErr) && !dnsErr.IsTimeout:
+		class = ErrorClassDNS
+	case errors.As(err, &netErr) && netErr.Timeout():
+		class = ErrorClassTimeout
+	}
+	return &RequestError{Endpoint: endpoint, Class: class, Err: err}
+}
+
+func (e *RequestError) Error() string {
+	return fmt.Sprintf("%s: %s error: %s", e.Endpoint, e.Class, e.Err)
+}
+
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
+
+// DecodeError is a device response which couldn't be parsed.
+type DecodeError struct {
+	Endpoint string
+	Err      error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("%s: failed to decode response: %s", e.Endpoint, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorClass returns the class of an error returned by GetMetrics or
+// GetConfig, or an empty string for other errors.
+func ErrorClass(err error) string {
+	var deviceErr *DeviceError
+	var requestErr *RequestError
+	var decodeErr *DecodeError
+	switch {
+	case errors.As(err, &deviceErr):
+		return deviceErr.Class
+	case errors.As(err, &requestErr):
+		return requestErr.Class
+	case errors.As(err, &decodeErr):
+		return ErrorClassDecode
+	}
+	return ""
+}
+
+// classifyResponse returns the error class of a device response, or an
+// empty string if it looks like a valid reading.
+func classifyResponse(status int, contentType string, body []byte) string {
+	switch {
+	case status == http.StatusTooManyRequests:
+		return ErrorClassRateLimited
+	case status == http.StatusUnauthorized, status == http.StatusForbidden, status == http.StatusNotFound:
+		return ErrorClassAPIDisabled
+	case status >= 500:
+		return ErrorClassInternal
+	case status != http.StatusOK:
+		return ErrorClassUnexpected
+	}
+	if contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil || !jsonContentTypes[mediaType] {
+			return ErrorClassUnexpected
+		}
+	}
+
+	trimmed := bytes.TrimSpace(body)
+	if !bytes.HasPrefix(trimmed, []byte("{")) {
+		return ErrorClassUnexpected
+	}
+	doc := struct {
+		Error   string `json:"error"`
+		Message string `json:"message"`
+	}{}
+	if err := json.Unmarshal(trimmed, &doc); err != nil || doc.Error == "" {
+		return ""
+	}
+	message := strings.ToLower(doc.Error + " " + doc.Message)
+	switch {
+	case strings.Contains(message, "rate"), strings.Contains(message, "too many"):
+		return ErrorClassRateLimited
+	case strings.Contains(message, "disabled"), strings.Contains(message, "not enabled"):
+		return ErrorClassAPIDisabled
+	default:
+		return ErrorClassInternal
+	}
+}
+
+func truncateBody(body []byte) string {
+	if len(body) > maxErrorBody {
+		return string(body[:maxErrorBody]) + "..."
+	}
+	return string(body)
+}
@@ -0,0 +1,111 @@
+package exporter
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tj/assert"
+)
+
+func TestClassifyResponse(t *testing.T) {
+	assert := assert.New(t)
+	for _, c := range []struct {
+		status      int
+		contentType string
+		body        string
+		class       string
+	}{
+		{200, "application/json", `{"score": 89}`, ""},
+		{200, "", `{"score": 89}`, ""},
+		{200, "application/json; charset=utf-8", `{"score": 89}`, ""},
+		{429, "", `slow down`, ErrorClassRateLimited},
+		{404, "text/html", `Not Found`, ErrorClassAPIDisabled},
+		{500, "", `{"error": "sensor failure"}`, ErrorClassInternal},
+		{302, "", ``, ErrorClassUnexpected},
+		{200, "", `<html><body>Router login</body></html>`, ErrorClassUnexpected},
+		{200, "text/html", `{"score": 89}`, ErrorClassUnexpected},
+		{200, "", `{"error": "Too many requests"}`, ErrorClassRateLimited},
+		{200, "", `{"error": "Local API disabled"}`, ErrorClassAPIDisabled},
+		{200, "", `{"error": "failed to read sensor"}`, ErrorClassInternal},
+	} {
+		assert.Equal(c.class, classifyResponse(c.status, c.contentType, []byte(c.body)), fmt.Sprint(c.status, " ", c.body))
+	}
+}
+
+func TestGetMetricsErrorResponse(t *testing.T) {
+	assert := assert.New(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		fmt.Fprint(w, strings.Repeat("x", 1000))
+	}))
+	defer srv.Close()
+
+	e := newAwairExporter(strings.Replace(srv.URL, "http://", "", -1))
+	_, err := e.GetMetrics()
+	deviceErr := &DeviceError{}
+	assert.True(errors.As(err, &deviceErr))
+	assert.Equal(ErrorClassRateLimited, deviceErr.Class)
+	assert.Equal(http.StatusTooManyRequests, deviceErr.StatusCode)
+	assert.Equal(maxErrorBody+len("..."), len(deviceErr.Body))
+	assert.Equal(float64(1), testutil.ToFloat64(e.deviceErrors.WithLabelValues("air-data", ErrorClassRateLimited)))
+}
+
+func TestGetMetricsResponseTooLarge(t *testing.T) {
+	assert := assert.New(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"score": 89, "padding": "%s"}`, strings.Repeat("x", maxResponseSize))
+	}))
+	defer srv.Close()
+
+	e := newAwairExporter(strings.Replace(srv.URL, "http://", "", -1))
+	_, err := e.GetMetrics()
+	deviceErr := &DeviceError{}
+	assert.True(errors.As(err, &deviceErr))
+	assert.Equal(ErrorClassTooLarge, deviceErr.Class)
+	assert.Equal(float64(1), testutil.ToFloat64(e.deviceErrors.WithLabelValues("air-data", ErrorClassTooLarge)))
+}
+
+func TestErrorClasses(t *testing.T) {
+	assert := assert.New(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/air-data/latest":
+			fmt.Fprint(w, `{"score": "not a number"}`)
+		default:
+			time.Sleep(50 * time.Millisecond)
+			fmt.Fprint(w, `{}`)
+		}
+	}))
+	defer srv.Close()
+	e := newAwairExporter(strings.Replace(srv.URL, "http://", "", -1))
+
+	_, err := e.GetMetrics()
+	decodeErr := &DecodeError{}
+	assert.True(errors.As(err, &decodeErr))
+	assert.Equal(ErrorClassDecode, ErrorClass(err))
+	assert.Equal(float64(1), testutil.ToFloat64(e.deviceErrors.WithLabelValues("air-data", ErrorClassDecode)))
+
+	e.client.Timeout = 10 * time.Millisecond
+	_, err = e.GetConfig()
+	requestErr := &RequestError{}
+	assert.True(errors.As(err, &requestErr))
+	assert.Equal(ErrorClassTimeout, ErrorClass(err))
+	assert.Equal(float64(1), testutil.ToFloat64(e.deviceErrors.WithLabelValues("config", ErrorClassTimeout)))
+
+	_, err = newAwairExporter("not_a_real_host.invalid").GetConfig()
+	assert.Equal(ErrorClassDNS, ErrorClass(err))
+
+	srv.Close()
+	_, err = newAwairExporter(strings.Replace(srv.URL, "http://", "", -1)).GetConfig()
+	assert.Equal(ErrorClassConnection, ErrorClass(err))
+
+	assert.Equal("", ErrorClass(errors.New("other")))
+}
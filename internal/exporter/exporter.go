@@ -1,157 +1,28 @@
 package exporter
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
-	"strconv"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
-	"github.com/rs/zerolog/log"
+	"prometheus-awair-exporter/internal/recovery"
 
-	"github.com/prometheus/client_golang/prometheus"
-)
-
-var (
-	score = prometheus.NewDesc(
-		prometheus.BuildFQName("awair", "", "score"),
-		"Awair Score (0-100)",
-		[]string{
-			"device_uuid",
-		},
-		nil,
-	)
-
-	dew_point = prometheus.NewDesc(
-		prometheus.BuildFQName("awair", "", "dew_point"),
-		"The temperature at which water will condense and form into dew (ºC)",
-		[]string{
-			"device_uuid",
-		},
-		nil,
-	)
-
-	temp = prometheus.NewDesc(
-		prometheus.BuildFQName("awair", "", "temp"),
-		"Dry bulb temperature (ºC)",
-		[]string{
-			"device_uuid",
-		},
-		nil,
-	)
-
-	humidity = prometheus.NewDesc(
-		prometheus.BuildFQName("awair", "", "humidity"),
-		"Relative Humidity (%)",
-		[]string{
-			"device_uuid",
-		},
-		nil,
-	)
-
-	abs_humidity = prometheus.NewDesc(
-		prometheus.BuildFQName("awair", "", "absolute_humidity"),
-		"Absolute Humidity (g/m³)",
-		[]string{
-			"device_uuid",
-		},
-		nil,
-	)
-
-	co2 = prometheus.NewDesc(
-		prometheus.BuildFQName("awair", "", "co2"),
-		"Carbon Dioxide (ppm)",
-		[]string{
-			"device_uuid",
-		},
-		nil,
-	)
-
-	co2_estimated = prometheus.NewDesc(
-		prometheus.BuildFQName("awair", "", "co2_est"),
-		"Estimated Carbon Dioxide (ppm - calculated by the TVOC sensor)",
-		[]string{
-			"device_uuid",
-		},
-		nil,
-	)
-
-	co2_estimate_baseline = prometheus.NewDesc(
-		prometheus.BuildFQName("awair", "", "co2_est_baseline"),
-		"A unitless value that represents the baseline from which the TVOC sensor partially derives its estimated (e)CO₂output.",
-		[]string{
-			"device_uuid",
-		},
-		nil,
-	)
-
-	voc = prometheus.NewDesc(
-		prometheus.BuildFQName("awair", "", "voc"),
-		"Total Volatile Organic Compounds (ppb)",
-		[]string{
-			"device_uuid",
-		},
-		nil,
-	)
+	"github.com/rs/zerolog"
 
-	voc_baseline = prometheus.NewDesc(
-		prometheus.BuildFQName("awair", "", "voc_baseline"),
-		"A unitless value that represents the baseline from which the TVOC sensor partially derives its TVOC output.",
-		[]string{
-			"device_uuid",
-		},
-		nil,
-	)
-
-	voc_h2_raw = prometheus.NewDesc(
-		prometheus.BuildFQName("awair", "", "voc_h2_raw"),
-		"A unitless value that represents the Hydrogen gas signal from which the TVOC sensor partially derives its TVOC output.",
-		[]string{
-			"device_uuid",
-		},
-		nil,
-	)
-
-	voc_ethanol_raw = prometheus.NewDesc(
-		prometheus.BuildFQName("awair", "", "voc_ethanol_raw"),
-		"A unitless value that represents the Ethanol gas signal from which the TVOC sensor partially derives its TVOC output.",
-		[]string{
-			"device_uuid",
-		},
-		nil,
-	)
-
-	pm25 = prometheus.NewDesc(
-		prometheus.BuildFQName("awair", "", "pm25"),
-		"Particulate matter less than 2.5 microns in diameter (µg/m³)",
-		[]string{
-			"device_uuid",
-		},
-		nil,
-	)
-
-	pm10 = prometheus.NewDesc(
-		prometheus.BuildFQName("awair", "", "pm10"),
-		"Estimated particulate matter less than 10 microns in diameter (µg/m³ - calculated by the PM2.5 sensor)",
-		[]string{
-			"device_uuid",
-		},
-		nil,
-	)
-	info = prometheus.NewDesc(
-		prometheus.BuildFQName("awair", "", "device_info"),
-		"Info about the awair device",
-		[]string{
-			"device_uuid",
-			"firmware_version",
-			"voc_feature_set",
-		},
-		nil,
-	)
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 type AwairValues struct {
+	Timestamp      string  `json:"timestamp"`
 	Score          float64 `json:"score"`
 	DewPoint       float64 `json:"dew_point"`
 	Temp           float64 `json:"temp"`
@@ -166,6 +37,53 @@ type AwairValues struct {
 	VocEthanolRaw  float64 `json:"voc_ethanol_raw"`
 	PM25           float64 `json:"pm25"`
 	PM10Est        float64 `json:"pm10_est"`
+	// Lux and SPLA are the illuminance and A-weighted sound level only the
+	// Awair Omni reports, nil for other models.
+	Lux  *float64 `json:"lux,omitempty"`
+	SPLA *float64 `json:"spl_a,omitempty"`
+	// Absent are the fields of Fields the device's payload lacked, such as
+	// co2 on devices without a CO2 sensor, whose values are 0. Detected
+	// while decoding, so payloads can't set it.
+	Absent []string `json:"-"`
+	// Schema is the layout of the device's payload, e.g. current. Detected
+	// while decoding, so payloads can't set it.
+	Schema string `json:"-"`
+}
+
+// Has reports whether the field was present in the device's payload.
+func (v *AwairValues) Has(field string) bool {
+	for _, f := range v.Absent {
+		if f == field {
+			return false
+		}
+	}
+	return true
+}
+
+// Field is a single named sensor value of a reading.
+type Field struct {
+	Name  string
+	Value float64
+}
+
+// Fields returns the sensor values of v keyed by their Local API field name.
+func (v *AwairValues) Fields() []Field {
+	return []Field{
+		{"score", v.Score},
+		{"dew_point", v.DewPoint},
+		{"temp", v.Temp},
+		{"humid", v.Humidity},
+		{"abs_humid", v.AbsHumidity},
+		{"co2", v.CO2},
+		{"co2_est", v.CO2Est},
+		{"co2_est_baseline", v.CO2EstBaseline},
+		{"voc", v.Voc},
+		{"voc_baseline", v.VocBaseline},
+		{"voc_h2_raw", v.VocH2Raw},
+		{"voc_ethanol_raw", v.VocEthanolRaw},
+		{"pm25", v.PM25},
+		{"pm10_est", v.PM10Est},
+	}
 }
 
 type LEDSettings struct {
@@ -189,167 +107,823 @@ type ConfigResponse struct {
 
 type AwairExporter struct {
 	hostname string
+	client   *http.Client
+	strict   bool
+	metrics  *Metrics
+	derived  []DerivedMetrics
+	labels   map[string]string
+	aliases  map[string]string
+	// labelValues are the values of the extra labels metrics was
+	// created with, the one at nameIndex being resolved from aliases.
+	labelNames  []string
+	labelValues []string
+	nameIndex   int
+
+	// skipConfig reuses the config queried when connecting instead of
+	// querying it along with every reading.
+	skipConfig bool
+	// skipPower leaves out the power status of devices with a battery.
+	skipPower bool
+	power     *PowerStatus
+
+	// resolver re-resolves the hostname, nil for IP addresses.
+	resolver        *resolver
+	resolveInterval time.Duration
+	// source takes the readings in place of the Local API, if set.
+	source Source
+	// fallback takes the readings when the Local API fails, if set.
+	fallback *fallback
+	// deviceLabels provides labels resolved on every collection.
+	deviceLabels DeviceLabels
+
+	mu              sync.RWMutex
+	firmwareVersion string
+	deviceUUID      string
+	config          *ConfigResponse
+	// name is the name of the device in its fleet.
+	name string
+	// log is the logger of lines about the device.
+	log atomic.Pointer[zerolog.Logger]
+	// summary accumulates the outcomes of readings for summary lines.
+	summary summary
+
+	unknownFields *prometheus.CounterVec
+	seenUnknown   sync.Map
+	deviceErrors  *prometheus.CounterVec
+
+	pollInterval     time.Duration
+	intervalOverride IntervalOverride
+	latest           *sample
+	// pollFailed is set while the latest poll failed, so the latest
+	// sample is no longer exposed.
+	pollFailed bool
+	// generation counts the polls and ingested readings, failed ones too,
+	// changed being when the latest happened.
+	generation   uint64
+	changed      time.Time
+	publisher    Publisher
+	scoreSamples *prometheus.HistogramVec
+
+	freshness     time.Duration
+	cachedScrapes prometheus.Counter
+	shared        SharedCache
+	recoverer     *recovery.Recoverer
+	ingested      bool
+
+	watchdogIntervals int
+	// lastProgress is when the poll loop last finished a poll or a
+	// request, in Unix nanoseconds.
+	lastProgress atomic.Int64
+	// lastSeen is when the device, or its fallback, last answered, in
+	// Unix nanoseconds.
+	lastSeen       atomic.Int64
+	pollerRestarts prometheus.Counter
+
+	// wake is the wake strategy, and wakes counts its attempts.
+	wake  string
+	wakes *prometheus.CounterVec
+
+	up *prometheus.Desc
+
+	// discovery is the source the device was found by, e.g. scan.
+	discovery string
+
+	// alternates are the further addresses given to WithAddresses.
+	alternates []string
+	// addresses are the addresses the device is reached at, its hostname
+	// first, and active the index of the one which answered last.
+	addresses   []*address
+	active      atomic.Int32
+	addressInfo *prometheus.Desc
 }
 
-func NewAwairExporter(hostname string) (*AwairExporter, error) {
-	ex := &AwairExporter{
-		hostname: hostname,
+// Option configures optional behaviour of an AwairExporter.
+type Option func(*AwairExporter)
+
+// WithStrictMode enables reporting of fields returned by the device which
+// the exporter does not map, so new firmware capabilities are noticed.
+func WithStrictMode(strict bool) Option {
+	return func(e *AwairExporter) {
+		e.strict = strict
+	}
+}
+
+// WithDiscoverySource records the source the device was found by, e.g. its
+// static configuration, a network scan or the Awair Cloud, which decides
+// which of the devices of a Fleet with the same device UUID is collected.
+func WithDiscoverySource(source string) Option {
+	return func(e *AwairExporter) {
+		e.discovery = source
+	}
+}
+
+// WithLabels attaches the given static labels to the device's series,
+// e.g. the room, floor or building it is in, including the exporter's own
+// series about the device. Series of derived metrics don't carry them.
+func WithLabels(labels map[string]string) Option {
+	return func(e *AwairExporter) {
+		e.labels = labels
+	}
+}
+
+// WithAliases attaches a name label to the device's series, the alias of
+// its device UUID or else of its hostname, so dashboards can show e.g.
+// "bedroom" instead of the UUID. Devices without an alias keep the name
+// label given to WithLabels, if any. Series of derived metrics don't carry it.
+func WithAliases(aliases map[string]string) Option {
+	return func(e *AwairExporter) {
+		e.aliases = aliases
+	}
+}
+
+// DeviceLabels provides labels of devices which may change at runtime,
+// e.g. from an inventory, by device UUID.
+type DeviceLabels interface {
+	// LabelNames returns the names of the labels provided.
+	LabelNames() []string
+	// DeviceLabels returns the labels of the device with uuid.
+	DeviceLabels(uuid string) map[string]string
+}
+
+// WithDeviceLabels attaches the labels of l to the device's series, taking
+// the value of a label given to WithLabels or WithAliases only where those
+// leave it empty. Like aliases, they don't apply to the exporter's own
+// series about the device, nor to series of derived metrics.
+func WithDeviceLabels(l DeviceLabels) Option {
+	return func(e *AwairExporter) {
+		e.deviceLabels = l
+	}
+}
+
+// setupLabels creates the metrics with the extra labels of the options.
+func (e *AwairExporter) setupLabels() {
+	e.nameIndex = -1
+	names := make([]string, 0, len(e.labels)+1)
+	for name := range e.labels {
+		names = append(names, name)
+	}
+	if _, ok := e.labels["name"]; !ok && e.aliases != nil {
+		names = append(names, "name")
 	}
+	if e.deviceLabels != nil {
+		for _, name := range e.deviceLabels.LabelNames() {
+			if _, ok := e.labels[name]; !ok && !(name == "name" && e.aliases != nil) {
+				names = append(names, name)
+			}
+		}
+	}
+	if len(names) == 0 {
+		return
+	}
+	sort.Strings(names)
+	e.labelNames = names
+	e.labelValues = make([]string, len(names))
+	for i, name := range names {
+		e.labelValues[i] = e.labels[name]
+		if name == "name" && e.aliases != nil {
+			e.nameIndex = i
+		}
+	}
+	e.metrics = NewMetrics(names...)
+}
+
+// extraLabelValues returns the values of the extra labels for a reading of
+// the device with config, taken from the fallback if fallback is set.
+func (e *AwairExporter) extraLabelValues(config *ConfigResponse, fallback bool) []string {
+	if e.nameIndex < 0 && e.deviceLabels == nil && !fallback {
+		return e.labelValues
+	}
+	values := append([]string{}, e.labelValues...)
+	if fallback {
+		for i, name := range e.labelNames {
+			if name == e.fallback.label {
+				values[i] = e.fallback.value
+			}
+		}
+	}
+	if e.nameIndex >= 0 {
+		name, ok := e.aliases[config.DeviceUUID]
+		if !ok {
+			name, ok = e.aliases[e.hostname]
+		}
+		if ok {
+			values[e.nameIndex] = name
+		}
+	}
+	if e.deviceLabels != nil {
+		labels := e.deviceLabels.DeviceLabels(config.DeviceUUID)
+		for i, name := range e.labelNames {
+			if values[i] == "" {
+				values[i] = labels[name]
+			}
+		}
+	}
+	return values
+}
+
+// Endpoints are the device endpoints WithEndpoints selects from.
+var Endpoints = []string{"air-data", "config", "power-status"}
+
+// CheckEndpoints returns an error if endpoints names an unknown endpoint or
+// lacks the air-data endpoint readings are taken from.
+func CheckEndpoints(endpoints []string) error {
+	readings := false
+	for _, endpoint := range endpoints {
+		switch endpoint {
+		case "air-data":
+			readings = true
+		case "config", "power-status":
+		default:
+			return fmt.Errorf("unknown endpoint %q, known are %s", endpoint, strings.Join(Endpoints, ", "))
+		}
+	}
+	if !readings {
+		return fmt.Errorf("the air-data endpoint is required")
+	}
+	return nil
+}
+
+// WithEndpoints limits the endpoints queried for every reading, which must
+// pass CheckEndpoints. Without the config endpoint the config queried
+// first is reused, halving the requests to a device on a flaky link. The
+// power-status endpoint is only queried on devices with a battery.
+func WithEndpoints(endpoints []string) Option {
+	return func(e *AwairExporter) {
+		e.skipConfig, e.skipPower = true, true
+		for _, endpoint := range endpoints {
+			switch endpoint {
+			case "config":
+				e.skipConfig = false
+			case "power-status":
+				e.skipPower = false
+			}
+		}
+	}
+}
+
+// WithTimeout sets the timeout of requests to the device, 10s by default.
+func WithTimeout(timeout time.Duration) Option {
+	return func(e *AwairExporter) {
+		e.client = &http.Client{Timeout: timeout}
+	}
+}
+
+func NewAwairExporter(hostname string, opts ...Option) (*AwairExporter, error) {
+	ex := newAwairExporter(hostname, opts...)
 	config, err := ex.GetConfig()
 	if err != nil {
-		return nil, err
+		if ex.fallback == nil {
+			return nil, err
+		}
+		ex.logger().Warn().Err(err).Msg("Failed to connect to Awair device, taking its readings from the fallback meanwhile.")
+		return ex, nil
 	}
-	log.Info().
+	ex.logger().Info().
 		Interface("config", config).
 		Msg("Successfully connected to Awair device.")
 
 	return ex, nil
 }
 
+// newAwairExporter creates an exporter without connecting to the device.
+func newAwairExporter(hostname string, opts ...Option) *AwairExporter {
+	ex := &AwairExporter{
+		hostname:          hostname,
+		client:            &http.Client{Timeout: 10 * time.Second},
+		metrics:           NewMetrics(),
+		derived:           registeredDerivedMetrics(),
+		watchdogIntervals: DefaultWatchdogIntervals,
+		resolveInterval:   DefaultResolveInterval,
+	}
+	for _, opt := range opts {
+		opt(ex)
+	}
+	ex.updateLogger("", "")
+	ex.summary.reset(time.Now())
+	if ex.resolver = newResolver(hostname, ex.resolveInterval); ex.resolver != nil {
+		ex.client.Transport = ex.resolver.transport
+		ex.resolver.logger = ex.logger
+	}
+	ex.setupAddresses()
+	ex.setupLabels()
+
+	// The static labels of the device apply to the exporter's own series
+	// too, the hostname tells them apart when a Fleet holds several
+	// devices.
+	staticLabels := prometheus.Labels{}
+	deviceLabels := prometheus.Labels{}
+	for name, value := range ex.labels {
+		staticLabels[name] = value
+		deviceLabels[name] = value
+	}
+	deviceLabels["hostname"] = hostname
+	ex.pollerRestarts = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace:   "awair",
+			Name:        "poller_restarts_total",
+			Help:        "Number of times the watchdog restarted a poll loop which made no progress",
+			ConstLabels: deviceLabels,
+		},
+	)
+	ex.cachedScrapes = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace:   "awair",
+			Name:        "scrapes_cached_total",
+			Help:        "Number of scrapes served from a still fresh cached sample, saving a request to the device",
+			ConstLabels: deviceLabels,
+		},
+	)
+	ex.wakes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   "awair",
+			Name:        "wake_attempts_total",
+			Help:        "Number of attempts to wake the device up by the wake strategy, by whether it answered",
+			ConstLabels: deviceLabels,
+		},
+		[]string{
+			"strategy",
+			"outcome",
+		},
+	)
+	ex.unknownFields = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   "awair",
+			Name:        "unknown_fields_total",
+			Help:        "Number of times a field not mapped by the exporter was seen in a device response (strict mode only)",
+			ConstLabels: deviceLabels,
+		},
+		[]string{
+			"endpoint",
+			"field",
+		},
+	)
+	ex.deviceErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   "awair",
+			Name:        "device_errors_total",
+			Help:        "Number of failed device requests by class of error",
+			ConstLabels: deviceLabels,
+		},
+		[]string{
+			"endpoint",
+			"class",
+		},
+	)
+	ex.scoreSamples = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace:   "awair",
+			Name:        "score_samples",
+			Help:        "Distribution of Awair Scores observed by the background poller",
+			Buckets:     prometheus.LinearBuckets(10, 10, 9),
+			ConstLabels: staticLabels,
+			// Also exposed as a native histogram to scrapers
+			// negotiating the protobuf format.
+			NativeHistogramBucketFactor:    1.1,
+			NativeHistogramMaxBucketNumber: 100,
+		},
+		[]string{
+			"device_uuid",
+		},
+	)
+	ex.up = prometheus.NewDesc(
+		prometheus.BuildFQName("awair", "", "up"),
+		"Whether the latest query of the device succeeded (1) or failed (0)",
+		append([]string{"device_uuid"}, ex.labelNames...), nil,
+	)
+	ex.addressInfo = prometheus.NewDesc(
+		prometheus.BuildFQName("awair", "device", "address_info"),
+		"Address of a device with several which answered the latest request, always 1",
+		append([]string{"device_uuid", "address"}, ex.labelNames...), nil,
+	)
+	return ex
+}
+
 func (e *AwairExporter) Describe(ch chan<- *prometheus.Desc) {
-	ch <- score
-	ch <- dew_point
-	ch <- temp
-	ch <- humidity
-	ch <- abs_humidity
-	ch <- co2
-	ch <- co2_estimated
-	ch <- co2_estimate_baseline
-	ch <- voc
-	ch <- voc_baseline
-	ch <- voc_h2_raw
-	ch <- voc_ethanol_raw
-	ch <- pm25
-	ch <- pm10
-	ch <- info
+	e.metrics.Describe(ch)
+	for _, d := range e.derived {
+		d.Describe(ch)
+	}
+	ch <- e.up
+	ch <- e.addressInfo
+	e.unknownFields.Describe(ch)
+	e.deviceErrors.Describe(ch)
+	e.scoreSamples.Describe(ch)
+	e.cachedScrapes.Describe(ch)
+	e.pollerRestarts.Describe(ch)
+	e.wakes.Describe(ch)
 }
 
-func (e *AwairExporter) GetMetrics() (*AwairValues, error) {
-	uri := fmt.Sprintf("http://%s/air-data/latest", e.hostname)
-	log.Debug().
+// countError counts err by endpoint and class, returning it unchanged.
+func (e *AwairExporter) countError(err error) error {
+	endpoint := ""
+	var deviceErr *DeviceError
+	var requestErr *RequestError
+	var decodeErr *DecodeError
+	switch {
+	case errors.As(err, &deviceErr):
+		endpoint = deviceErr.Endpoint
+	case errors.As(err, &requestErr):
+		endpoint = requestErr.Endpoint
+	case errors.As(err, &decodeErr):
+		endpoint = decodeErr.Endpoint
+	}
+	e.deviceErrors.WithLabelValues(endpoint, ErrorClass(err)).Inc()
+	e.summary.countError(ErrorClass(err))
+	return err
+}
+
+// get retrieves path from the device, trying its other addresses when the
+// one which answered last fails. Failures are returned as a *RequestError,
+// error responses as a *DeviceError.
+func (e *AwairExporter) get(ctx context.Context, endpoint, path string) ([]byte, error) {
+	first := int(e.active.Load())
+	var err error
+	for i := range e.addresses {
+		n := (first + i) % len(e.addresses)
+		var body []byte
+		body, err = e.getWithRetry(ctx, e.addresses[n], endpoint, path)
+		var requestErr *RequestError
+		if errors.As(err, &requestErr) {
+			if ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+		if err == nil && n != first {
+			e.active.Store(int32(n))
+			e.logger().Info().
+				Str("previous", e.addresses[first].host).
+				Str("answered", e.addresses[n].host).
+				Msg("Device answered at another address.")
+		}
+		return body, err
+	}
+	return nil, e.countError(err)
+}
+
+// getFrom retrieves path from the device at address a. Failures are
+// returned as an uncounted *RequestError, error responses as a counted
+// *DeviceError.
+func (e *AwairExporter) getFrom(ctx context.Context, a *address, endpoint, path string) ([]byte, error) {
+	uri := fmt.Sprintf("http://%s%s", a.host, path)
+	e.logger().Debug().
 		Str("uri", uri).
-		Msg("Attempting to retrieve metrics from Awair device.")
+		Msg("Attempting to retrieve " + endpoint + " from Awair device.")
 
-	resp, err := http.Get(uri)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
 	if err != nil {
 		return nil, err
 	}
+	defer e.progressed()
+	if a.resolver != nil {
+		// Dialing reports resolution errors, this only refreshes outdated
+		// addresses of kept-alive connections.
+		a.resolver.addresses(ctx)
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		if a.resolver != nil {
+			a.resolver.expire()
+		}
+		return nil, newRequestError(endpoint, err)
+	}
 	defer resp.Body.Close()
 
-	body, err := ioutil.ReadAll(resp.Body)
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize+1))
 	if err != nil {
-		return nil, err
+		return nil, newRequestError(endpoint, err)
 	}
-	values := AwairValues{}
-	err = json.Unmarshal(body, &values)
-	if err != nil {
+	class := classifyResponse(resp.StatusCode, resp.Header.Get("Content-Type"), body)
+	if class == "" && len(body) > maxResponseSize {
+		class = ErrorClassTooLarge
+	}
+	if class != "" {
+		err := &DeviceError{
+			Endpoint:   endpoint,
+			StatusCode: resp.StatusCode,
+			Class:      class,
+			Body:       truncateBody(body),
+		}
+		e.countError(err)
+		e.logger().Warn().
+			Str("endpoint", endpoint).
+			Int("status", resp.StatusCode).
+			Str("class", class).
+			Str("body", err.Body).
+			Msg("Device returned an error response.")
 		return nil, err
 	}
-	return &values, nil
+	return body, nil
 }
 
-func (e *AwairExporter) GetConfig() (*ConfigResponse, error) {
-	uri := fmt.Sprintf("http://%s/settings/config/data", e.hostname)
-	log.Debug().
-		Str("uri", uri).
-		Msg("Attempting to retrieve config from Awair device.")
+func (e *AwairExporter) GetMetrics() (*AwairValues, error) {
+	return e.GetMetricsContext(context.Background())
+}
 
-	resp, err := http.Get(uri)
+// GetMetricsContext is GetMetrics with a context bounding the request.
+func (e *AwairExporter) GetMetricsContext(ctx context.Context) (*AwairValues, error) {
+	body, err := e.get(ctx, "air-data", "/air-data/latest")
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
+	e.mu.RLock()
+	profile := profileFor(e.firmwareVersion)
+	e.mu.RUnlock()
+	values, body, err := decodeValues(body, profile)
+	if err != nil {
+		return nil, e.countError(&DecodeError{Endpoint: "air-data", Err: err})
+	}
+	if e.strict {
+		e.checkUnknownFields("air-data", body, values)
+	}
+	return values, nil
+}
+
+// DecodeValues decodes an air-data payload of any firmware generation,
+// recording its schema in Schema and the fields it lacks in Absent.
+func DecodeValues(body []byte) (*AwairValues, error) {
+	values, _, err := decodeValues(body, nil)
+	return values, err
+}
+
+// decodeValues is DecodeValues of the payload of a device whose firmware
+// has the given profile, nil if unknown. It also returns the payload with
+// its fields renamed to those of the current schema.
+func decodeValues(body []byte, firmware *firmwareProfile) (*AwairValues, []byte, error) {
+	raw := map[string]json.RawMessage{}
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return nil, nil, err
+	}
+	profile := detectProfile(raw, firmware)
+	if len(profile.aliases) > 0 {
+		profile.normalize(raw)
+		normalized, err := json.Marshal(raw)
+		if err != nil {
+			return nil, nil, err
+		}
+		body = normalized
+	}
+	values := AwairValues{}
+	if err := json.Unmarshal(body, &values); err != nil {
+		return nil, nil, err
+	}
+	values.Schema = profile.name
+	for _, f := range values.Fields() {
+		if _, ok := raw[f.Name]; !ok {
+			values.Absent = append(values.Absent, f.Name)
+		}
+	}
+	return &values, body, nil
+}
+
+// GetDeviceConfig retrieves the config of the device at hostname without
+// creating an exporter for it, e.g. to audit or provision devices.
+func GetDeviceConfig(hostname string) (*ConfigResponse, error) {
+	return newAwairExporter(hostname).GetConfig()
+}
 
-	body, err := ioutil.ReadAll(resp.Body)
+// GetDeviceConfigContext is GetDeviceConfig with a context bounding the
+// request.
+func GetDeviceConfigContext(ctx context.Context, hostname string) (*ConfigResponse, error) {
+	return newAwairExporter(hostname).GetConfigContext(ctx)
+}
+
+func (e *AwairExporter) GetConfig() (*ConfigResponse, error) {
+	return e.GetConfigContext(context.Background())
+}
+
+// GetConfigContext is GetConfig with a context bounding the request.
+func (e *AwairExporter) GetConfigContext(ctx context.Context) (*ConfigResponse, error) {
+	body, err := e.get(ctx, "config", "/settings/config/data")
 	if err != nil {
 		return nil, err
 	}
 	config := ConfigResponse{}
 	err = json.Unmarshal(body, &config)
 	if err != nil {
-		return nil, err
+		return nil, e.countError(&DecodeError{Endpoint: "config", Err: err})
+	}
+	if e.strict {
+		e.checkUnknownFields("config", body, &config)
+	}
+	e.mu.Lock()
+	e.config = &config
+	if config.DeviceUUID != e.deviceUUID {
+		e.deviceUUID = config.DeviceUUID
+		e.updateLogger(e.deviceUUID, e.name)
+	}
+	if config.FirmwareVersion != e.firmwareVersion {
+		e.firmwareVersion = config.FirmwareVersion
+		profile := "permissive"
+		if p := profileFor(config.FirmwareVersion); p != nil {
+			profile = p.name
+		}
+		e.logger().Info().
+			Str("firmware_version", config.FirmwareVersion).
+			Str("profile", profile).
+			Msg("Selected firmware parsing profile.")
 	}
+	e.mu.Unlock()
 	return &config, nil
 }
 
-func (e *AwairExporter) Collect(ch chan<- prometheus.Metric) {
+// DeviceUUID returns the UUID the device reported in its last config.
+func (e *AwairExporter) DeviceUUID() string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.deviceUUID
+}
+
+// healthy reports whether the latest poll of the device succeeded. Devices
+// queried on scrape count as healthy.
+func (e *AwairExporter) healthy() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.pollInterval <= 0 {
+		return true
+	}
+	return e.latest != nil && !e.pollFailed
+}
+
+// stale reports whether s is a polled sample which is no longer current:
+// the latest poll failed, or none succeeded for a poll interval beyond the
+// request timeout, as when the poller is stalled.
+func (e *AwairExporter) stale(s *sample) bool {
+	if e.pollInterval <= 0 || e.ingested {
+		return false
+	}
+	e.mu.RLock()
+	failed := e.pollFailed
+	e.mu.RUnlock()
+	return failed || time.Since(s.at) > e.staleAfter()
+}
+
+// staleAfter is how long a polled sample stays current without a new one.
+func (e *AwairExporter) staleAfter() time.Duration {
+	return e.nextPollInterval() + e.client.Timeout
+}
+
+// fetch concurrently retrieves the latest readings and config from the device.
+func (e *AwairExporter) fetch(ctx context.Context) (*AwairValues, *ConfigResponse) {
+	if e.source != nil {
+		return e.fetchSource(ctx)
+	}
+	if e.wake == WakePrewarm {
+		e.prewarm(ctx)
+	}
 	values := &AwairValues{}
 	config := &ConfigResponse{}
 
 	wg := sync.WaitGroup{}
-	wg.Add(2)
+	wg.Add(3)
+	go func() {
+		e.fetchPower(ctx)
+		wg.Done()
+	}()
 	go func() {
 		var err error
-		values, err = e.GetMetrics()
+		values, err = e.GetMetricsContext(ctx)
 		if err != nil {
-			log.Error().Err(err).
+			e.logger().Error().Err(err).
+				Str("class", ErrorClass(err)).
 				Msg("Error retrieving Metrics from device")
 		}
 		wg.Done()
-		log.Debug().
+		e.logger().Debug().
 			Interface("metrics", values).
 			Msg("Metrics successfully retrieved")
 	}()
 	go func() {
+		e.mu.RLock()
+		cached := e.config
+		e.mu.RUnlock()
+		if e.skipConfig && cached != nil {
+			config = cached
+			wg.Done()
+			return
+		}
 		var err error
-		config, err = e.GetConfig()
+		config, err = e.GetConfigContext(ctx)
 		if err != nil {
-			log.Error().Err(err).
+			e.logger().Error().Err(err).
+				Str("class", ErrorClass(err)).
 				Msg("Error retrieving Metrics from device")
 		}
-		log.Debug().
+		e.logger().Debug().
 			Interface("config", config).
 			Msg("Config successfully retrieved")
 		wg.Done()
 	}()
 	wg.Wait()
+	return values, config
+}
 
-	ch <- prometheus.MustNewConstMetric(
-		score, prometheus.GaugeValue, values.Score, config.DeviceUUID,
-	)
-	ch <- prometheus.MustNewConstMetric(
-		dew_point, prometheus.GaugeValue, values.DewPoint, config.DeviceUUID,
-	)
-	ch <- prometheus.MustNewConstMetric(
-		temp, prometheus.GaugeValue, values.Temp, config.DeviceUUID,
-	)
-	ch <- prometheus.MustNewConstMetric(
-		humidity, prometheus.GaugeValue, values.Humidity, config.DeviceUUID,
-	)
-	ch <- prometheus.MustNewConstMetric(
-		abs_humidity, prometheus.GaugeValue, values.AbsHumidity, config.DeviceUUID,
-	)
-	ch <- prometheus.MustNewConstMetric(
-		co2, prometheus.GaugeValue, values.CO2, config.DeviceUUID,
-	)
-	ch <- prometheus.MustNewConstMetric(
-		co2_estimated, prometheus.GaugeValue, values.CO2Est, config.DeviceUUID,
-	)
-	ch <- prometheus.MustNewConstMetric(
-		co2_estimate_baseline, prometheus.GaugeValue, values.CO2EstBaseline, config.DeviceUUID,
-	)
-	ch <- prometheus.MustNewConstMetric(
-		voc, prometheus.GaugeValue, values.Voc, config.DeviceUUID,
-	)
-	ch <- prometheus.MustNewConstMetric(
-		voc_baseline, prometheus.GaugeValue, values.VocBaseline, config.DeviceUUID,
-	)
-	ch <- prometheus.MustNewConstMetric(
-		voc_h2_raw, prometheus.GaugeValue, values.VocH2Raw, config.DeviceUUID,
-	)
-	ch <- prometheus.MustNewConstMetric(
-		voc_ethanol_raw, prometheus.GaugeValue, values.VocEthanolRaw, config.DeviceUUID,
-	)
-	ch <- prometheus.MustNewConstMetric(
-		pm25, prometheus.GaugeValue, values.PM25, config.DeviceUUID,
-	)
-	ch <- prometheus.MustNewConstMetric(
-		pm10, prometheus.GaugeValue, values.PM10Est, config.DeviceUUID,
-	)
-	ch <- prometheus.MustNewConstMetric(
-		info, prometheus.GaugeValue, 1,
-		config.DeviceUUID,
-		config.FirmwareVersion,
-		strconv.Itoa(config.VocFeatureSet),
-	)
+func (e *AwairExporter) Collect(ch chan<- prometheus.Metric) {
+	e.collect(context.Background(), ch)
+}
+
+// collect is Collect giving up on querying the device once ctx is done,
+// which yields awair_up 0.
+func (e *AwairExporter) collect(ctx context.Context, ch chan<- prometheus.Metric) {
+	defer e.recoverer.Recover("collector", map[string]string{"hostname": e.hostname})
+	s, cached := e.sample(ctx)
+	up := s.values != nil && s.config != nil && !e.stale(s)
+	if up {
+		e.collectSample(ch, s, cached)
+	}
+	if !e.ingested {
+		// A failed query yields awair_up 0 instead of any device series.
+		value := 0.0
+		if up {
+			value = 1
+		}
+		uuid := e.DeviceUUID()
+		ch <- prometheus.MustNewConstMetric(e.up, prometheus.GaugeValue, value,
+			append([]string{uuid}, e.extraLabelValues(&ConfigResponse{DeviceUUID: uuid}, up && s.fallback)...)...)
+		if len(e.addresses) > 1 {
+			ch <- prometheus.MustNewConstMetric(e.addressInfo, prometheus.GaugeValue, 1,
+				append([]string{uuid, e.Address()}, e.extraLabelValues(&ConfigResponse{DeviceUUID: uuid}, false)...)...)
+		}
+	}
+	e.unknownFields.Collect(ch)
+	e.deviceErrors.Collect(ch)
+	e.scoreSamples.Collect(ch)
+	e.cachedScrapes.Collect(ch)
+	e.pollerRestarts.Collect(ch)
+	e.wakes.Collect(ch)
+}
+
+// collectSample emits the device series of s. Series of a cached sample are
+// timestamped with the time it was queried, so Prometheus doesn't attribute
+// a stale reading to the current scrape.
+func (e *AwairExporter) collectSample(ch chan<- prometheus.Metric, s *sample, cached bool) {
+	if s == nil || s.values == nil || s.config == nil {
+		return
+	}
+	out := ch
+	if cached {
+		timestamped := make(chan prometheus.Metric)
+		done := make(chan struct{})
+		go func() {
+			for m := range timestamped {
+				ch <- prometheus.NewMetricWithTimestamp(s.at, m)
+			}
+			close(done)
+		}()
+		defer func() {
+			close(timestamped)
+			<-done
+		}()
+		out = timestamped
+	}
+
+	e.metrics.CollectAt(out, s.at, s.values, s.config, e.extraLabelValues(s.config, s.fallback)...)
+	e.mu.RLock()
+	power := e.power
+	e.mu.RUnlock()
+	if power != nil && !s.fallback {
+		e.metrics.CollectPower(out, power, s.config, e.extraLabelValues(s.config, s.fallback)...)
+	}
+	for _, d := range e.derived {
+		d.Collect(out, s.values, s.config)
+	}
+}
+
+// Reading is the latest sample of a device, as served by the JSON API.
+type Reading struct {
+	Config *ConfigResponse `json:"config"`
+	Values *AwairValues    `json:"values"`
+}
+
+// SortReadings orders readings by device UUID, readings without a config
+// last, so they are served in the same order on every request.
+func SortReadings(readings []Reading) {
+	sort.SliceStable(readings, func(i, j int) bool {
+		a, b := readings[i].Config, readings[j].Config
+		if a == nil || b == nil {
+			return a != nil
+		}
+		return a.DeviceUUID < b.DeviceUUID
+	})
+}
+
+// Readings returns the latest sample of each device handled by the exporter,
+// omitting devices without one.
+func (e *AwairExporter) Readings() []Reading {
+	return e.readings(context.Background())
+}
+
+// readings is Readings giving up on querying the device once ctx is done.
+// Like Collect, it leaves out the sample of a failed or stalled poll.
+func (e *AwairExporter) readings(ctx context.Context) []Reading {
+	s, _ := e.sample(ctx)
+	if s.values == nil || s.config == nil || e.stale(s) {
+		return []Reading{}
+	}
+	return []Reading{
+		{
+			Config: s.config,
+			Values: s.values,
+		},
+	}
 }
@@ -1,6 +1,7 @@
 package exporter
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -8,14 +9,20 @@ import (
 	"time"
 
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 
 	"net/http"
 	"net/http/httptest"
 
+	"prometheus-awair-exporter/internal/recovery"
+
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
 	"github.com/stretchr/testify/require"
 
 	"github.com/rs/zerolog"
@@ -106,6 +113,7 @@ func TestGetMetrics(t *testing.T) {
 		VocEthanolRaw:  36,
 		PM25:           40,
 		PM10Est:        42,
+		Schema:         "current",
 	}
 	srv := getTestServer()
 	defer srv.Close()
@@ -218,6 +226,8 @@ func TestAllMetricsPopulated(t *testing.T) {
 	}{
 		{"abs_humidity_desc", regexp.MustCompile(`(?m)^# HELP awair_absolute_humidity .+$`)},
 		{"abs_humidity", regexp.MustCompile(`(?m)^awair_absolute_humidity.* 8.41$`)},
+		{"abs_humidity_deviation_desc", regexp.MustCompile(`(?m)^# HELP awair_absolute_humidity_deviation .+$`)},
+		{"abs_humidity_deviation", regexp.MustCompile(`(?m)^awair_absolute_humidity_deviation.* -0.02\d+$`)},
 		{"co2_desc", regexp.MustCompile(`(?m)^# HELP awair_co2 .*[a-zA-Z]+.*$`)},
 		{"co2", regexp.MustCompile(`(?m)^awair_co2.* 625$`)},
 		{"co2_est_desc", regexp.MustCompile(`(?m)^# HELP awair_co2_est .*[a-zA-Z]+.*$`)},
@@ -226,6 +236,11 @@ func TestAllMetricsPopulated(t *testing.T) {
 		{"co2_est_baseline", regexp.MustCompile(`(?m)^awair_co2_est_baseline.* +35252$`)},
 		{"device_info_desc", regexp.MustCompile(`(?m)^# HELP awair_device_info .*[a-zA-Z]+.*$`)},
 		{"device_info", regexp.MustCompile(`(?m)^awair_device_info{device_uuid=".+",firmware_version="1.+",voc_feature_set=".+".*} 1$`)},
+		{"led_brightness", regexp.MustCompile(`(?m)^awair_led_brightness{device_uuid=".+".*} 179$`)},
+		{"payload_schema", regexp.MustCompile(`(?m)^awair_payload_schema{device_uuid=".+",schema="current".*} 1$`)},
+		{"led_info", regexp.MustCompile(`(?m)^awair_led_info{device_uuid=".+",mode="sleep".*} 1$`)},
+		{"display_mode", regexp.MustCompile(`(?m)^awair_display_mode{device_uuid=".+",mode="score".*} 1$`)},
+		{"display_mode_inactive", regexp.MustCompile(`(?m)^awair_display_mode{device_uuid=".+",mode="clock".*} 0$`)},
 		{"dew_point_desc", regexp.MustCompile(`(?m)^# HELP awair_dew_point .*[a-zA-Z]+.*$$`)},
 		{"dew_point", regexp.MustCompile(`(?m)^awair_dew_point.* 8.95$`)},
 		{"humidity_desc", regexp.MustCompile(`(?m)^# HELP awair_humidity .*[a-zA-Z]+.*$`)},
@@ -253,3 +268,972 @@ func TestAllMetricsPopulated(t *testing.T) {
 		})
 	}
 }
+
+func TestAbsoluteHumidity(t *testing.T) {
+	assert := assert.New(t)
+	assert.InDelta(8.43, AbsoluteHumidity(21.13, 45.7), 0.01)
+	assert.InDelta(17.3, AbsoluteHumidity(20, 100), 0.1)
+	assert.Equal(0.0, AbsoluteHumidity(25, 0))
+}
+
+func TestWithLabels(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	srv := getTestServer()
+	defer srv.Close()
+
+	e, err := NewAwairExporter(strings.TrimPrefix(srv.URL, "http://"),
+		WithLabels(map[string]string{"room": "bedroom", "floor": "1"}),
+		WithTimeout(time.Second),
+	)
+	require.Nil(err)
+	assert.Equal(time.Second, e.client.Timeout)
+	e.countError(&DeviceError{Endpoint: "air-data", StatusCode: 500, Class: ErrorClassInternal})
+	err = testutil.CollectAndCompare(e, strings.NewReader(`
+# HELP awair_score Awair Score (0-100)
+# TYPE awair_score gauge
+awair_score{device_uuid="awair-element_1",floor="1",room="bedroom"} 89
+# HELP awair_device_errors_total Number of failed device requests by class of error
+# TYPE awair_device_errors_total counter
+awair_device_errors_total{class="internal_error",endpoint="air-data",floor="1",hostname="`+strings.TrimPrefix(srv.URL, "http://")+`",room="bedroom"} 1
+`), "awair_score", "awair_device_errors_total")
+	assert.Nil(err)
+
+	reg := prometheus.NewPedanticRegistry()
+	require.Nil(reg.Register(e))
+	_, err = reg.Gather()
+	assert.Nil(err)
+}
+
+func TestWithAliases(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	srv := getTestServer()
+	defer srv.Close()
+	hostname := strings.TrimPrefix(srv.URL, "http://")
+
+	for _, tc := range []struct {
+		aliases  map[string]string
+		labels   map[string]string
+		expected string
+	}{
+		{map[string]string{"awair-element_1": "bedroom", hostname: "office"}, nil, `{device_uuid="awair-element_1",name="bedroom"}`},
+		{map[string]string{hostname: "office"}, map[string]string{"room": "2"}, `{device_uuid="awair-element_1",name="office",room="2"}`},
+		{map[string]string{}, map[string]string{"name": "hall"}, `{device_uuid="awair-element_1",name="hall"}`},
+		{map[string]string{}, nil, `{device_uuid="awair-element_1",name=""}`},
+	} {
+		e, err := NewAwairExporter(hostname, WithLabels(tc.labels), WithAliases(tc.aliases))
+		require.Nil(err)
+		err = testutil.CollectAndCompare(e, strings.NewReader(`
+# HELP awair_score Awair Score (0-100)
+# TYPE awair_score gauge
+awair_score`+tc.expected+` 89
+`), "awair_score")
+		assert.Nil(err, tc.expected)
+	}
+}
+
+type staticDeviceLabels map[string]map[string]string
+
+func (l staticDeviceLabels) LabelNames() []string {
+	return []string{"name", "room_type"}
+}
+
+func (l staticDeviceLabels) DeviceLabels(uuid string) map[string]string {
+	return l[uuid]
+}
+
+func TestWithDeviceLabels(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	srv := getTestServer()
+	defer srv.Close()
+	hostname := strings.TrimPrefix(srv.URL, "http://")
+	labels := staticDeviceLabels{"awair-element_1": {"name": "Bedroom", "room_type": "bedroom"}}
+
+	for _, tc := range []struct {
+		aliases  map[string]string
+		labels   staticDeviceLabels
+		expected string
+	}{
+		{nil, labels, `{device_uuid="awair-element_1",name="Bedroom",room_type="bedroom"}`},
+		{map[string]string{hostname: "office"}, labels, `{device_uuid="awair-element_1",name="office",room_type="bedroom"}`},
+		{nil, staticDeviceLabels{}, `{device_uuid="awair-element_1",name="",room_type=""}`},
+	} {
+		e, err := NewAwairExporter(hostname, WithAliases(tc.aliases), WithDeviceLabels(tc.labels))
+		require.Nil(err)
+		err = testutil.CollectAndCompare(e, strings.NewReader(`
+# HELP awair_score Awair Score (0-100)
+# TYPE awair_score gauge
+awair_score`+tc.expected+` 89
+`), "awair_score")
+		assert.Nil(err, tc.expected)
+	}
+}
+
+func TestProbe(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	srv := getTestServer()
+	defer srv.Close()
+
+	c, err := Probe(context.Background(), strings.TrimPrefix(srv.URL, "http://"))
+	require.Nil(err)
+	err = testutil.CollectAndCompare(c, strings.NewReader(`
+# HELP awair_score Awair Score (0-100)
+# TYPE awair_score gauge
+awair_score{device_uuid="awair-element_1"} 89
+`), "awair_score")
+	assert.Nil(err)
+
+	_, err = Probe(context.Background(), "not_a_real_host.not_a_host")
+	assert.NotNil(err)
+}
+
+func TestStrictModeUnknownFields(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/settings/config/data":
+			fmt.Fprint(w, `{"device_uuid": "awair-omni_1", "new_setting": true}`)
+		case "/air-data/latest":
+			fmt.Fprint(w, `{"timestamp": "", "score": 89, "lux": 12.5, "co": 0.4}`)
+		case "/settings/power-status":
+			fmt.Fprint(w, `{"battery": 100, "plugged": true}`)
+		}
+	}))
+	defer srv.Close()
+
+	e, err := NewAwairExporter(strings.Replace(srv.URL, "http://", "", -1), WithStrictMode(true))
+	require.Nil(err)
+	_, err = e.GetMetrics()
+	require.Nil(err)
+
+	assert.Equal(float64(1), testutil.ToFloat64(e.unknownFields.WithLabelValues("air-data", "co")))
+	assert.Equal(float64(1), testutil.ToFloat64(e.unknownFields.WithLabelValues("config", "new_setting")))
+	assert.Equal(float64(0), testutil.ToFloat64(e.unknownFields.WithLabelValues("air-data", "timestamp")))
+	assert.Equal(float64(0), testutil.ToFloat64(e.unknownFields.WithLabelValues("air-data", "lux")))
+
+	err = testutil.CollectAndCompare(e, strings.NewReader(`
+# HELP awair_illuminance_lux Ambient light (lux)
+# TYPE awair_illuminance_lux gauge
+awair_illuminance_lux{device_uuid="awair-omni_1"} 12.5
+`), "awair_illuminance_lux", "awair_sound_level_db")
+	assert.Nil(err, "only the fields reported are exposed")
+}
+
+func TestDeviceLogger(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	out := &strings.Builder{}
+	defer func(l zerolog.Logger) { log.Logger = l }(log.Logger)
+	log.Logger = zerolog.New(out)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/settings/config/data":
+			fmt.Fprint(w, `{"device_uuid": "awair-element_1"}`)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+	address := strings.Replace(srv.URL, "http://", "", -1)
+	e, err := NewAwairExporter(address)
+	require.Nil(err)
+	NewFleet().Add("bedroom", e)
+
+	out.Reset()
+	_, err = e.GetMetrics()
+	assert.NotNil(err)
+	assert.Contains(out.String(), fmt.Sprintf(`"address":%q,"device_uuid":"awair-element_1","name":"bedroom"`, address))
+}
+
+func TestLogSummary(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	out := &strings.Builder{}
+	defer func(l zerolog.Logger) { log.Logger = l }(log.Logger)
+	log.Logger = zerolog.New(out)
+
+	srv := getTestServer()
+	defer srv.Close()
+	e, err := exporterFromTestServer(srv)
+	require.Nil(err)
+	e.fetchNow(context.Background())
+	e.fetchNow(context.Background())
+	srv.Close()
+	e.fetchNow(context.Background())
+
+	out.Reset()
+	e.logSummary()
+	assert.Contains(out.String(), `"readings":3,"failed":1,"errors":{"connection":2}`)
+	assert.Contains(out.String(), `"success_rate":0.6666666666666666`)
+	assert.Contains(out.String(), `"avg_latency":`)
+
+	out.Reset()
+	e.logSummary()
+	assert.Contains(out.String(), `"readings":0,"failed":0,"errors":{}`)
+	assert.NotContains(out.String(), "success_rate")
+}
+
+// staticSource returns the same reading, or err.
+type staticSource struct {
+	values *AwairValues
+	err    error
+}
+
+func (s staticSource) Fetch(context.Context) (*AwairValues, *ConfigResponse, error) {
+	if s.err != nil {
+		return nil, nil, s.err
+	}
+	return s.values, &ConfigResponse{DeviceUUID: "awair-element_1"}, nil
+}
+
+func TestWithFallback(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	var failing atomic.Bool
+	device := getTestServer()
+	defer device.Close()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		device.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	fallback := WithFallback(staticSource{values: &AwairValues{CO2: 900}}, "source", "cloud")
+	labels := WithLabels(map[string]string{"source": "local"})
+	fresh := WithFreshness(0)
+
+	e, err := NewAwairExporter(strings.Replace(srv.URL, "http://", "", -1), labels, fresh, fallback)
+	require.Nil(err)
+	assert.Nil(testutil.CollectAndCompare(e, strings.NewReader(`
+# HELP awair_co2 Carbon Dioxide (ppm)
+# TYPE awair_co2 gauge
+awair_co2{device_uuid="awair-element_1",source="local"} 625
+`), "awair_co2"))
+
+	failing.Store(true)
+	assert.Nil(testutil.CollectAndCompare(e, strings.NewReader(`
+# HELP awair_co2 Carbon Dioxide (ppm)
+# TYPE awair_co2 gauge
+awair_co2{device_uuid="awair-element_1",source="cloud"} 900
+# HELP awair_up Whether the latest query of the device succeeded (1) or failed (0)
+# TYPE awair_up gauge
+awair_up{device_uuid="awair-element_1",source="cloud"} 1
+`), "awair_co2", "awair_up"))
+
+	// Without the device reachable when connecting, readings are taken from
+	// the fallback right away.
+	e, err = NewAwairExporter(strings.Replace(srv.URL, "http://", "", -1), labels, fresh, fallback)
+	require.Nil(err)
+	assert.Nil(testutil.CollectAndCompare(e, strings.NewReader(`
+# HELP awair_up Whether the latest query of the device succeeded (1) or failed (0)
+# TYPE awair_up gauge
+awair_up{device_uuid="awair-element_1",source="cloud"} 1
+`), "awair_up"))
+
+	e, err = NewAwairExporter(strings.Replace(srv.URL, "http://", "", -1), labels, fresh,
+		WithFallback(staticSource{err: fmt.Errorf("offline")}, "source", "cloud"))
+	require.Nil(err)
+	assert.Nil(testutil.CollectAndCompare(e, strings.NewReader(`
+# HELP awair_up Whether the latest query of the device succeeded (1) or failed (0)
+# TYPE awair_up gauge
+awair_up{device_uuid="",source="local"} 0
+`), "awair_up"))
+}
+
+func TestWithAddresses(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	wifi := getTestServer()
+	defer wifi.Close()
+	ethernet := getTestServer()
+	gone := httptest.NewServer(nil)
+	gone.Close()
+	host := func(s *httptest.Server) string {
+		return strings.Replace(s.URL, "http://", "", -1)
+	}
+
+	e, err := NewAwairExporter(host(gone), WithFreshness(0), WithAddresses([]string{host(ethernet), host(wifi)}))
+	require.Nil(err)
+	assert.Equal(host(ethernet), e.Address())
+	assert.Nil(testutil.CollectAndCompare(e, strings.NewReader(fmt.Sprintf(`
+# HELP awair_device_address_info Address of a device with several which answered the latest request, always 1
+# TYPE awair_device_address_info gauge
+awair_device_address_info{address="%s",device_uuid="awair-element_1"} 1
+`, host(ethernet))), "awair_device_address_info"))
+
+	ethernet.Close()
+	assert.Equal(1, testutil.CollectAndCount(e, "awair_score"))
+	assert.Equal(host(wifi), e.Address())
+	assert.Equal(0.0, testutil.ToFloat64(e.deviceErrors.WithLabelValues("air-data", ErrorClassConnection)),
+		"failures answered at another address aren't counted")
+
+	wifi.Close()
+	assert.Equal(0, testutil.CollectAndCount(e, "awair_score"))
+	assert.Equal(host(wifi), e.Address())
+
+	// A single address exposes none.
+	srv := getTestServer()
+	defer srv.Close()
+	single, err := exporterFromTestServer(srv)
+	require.Nil(err)
+	assert.Equal(0, testutil.CollectAndCount(single, "awair_device_address_info"))
+}
+
+func TestFirmwareProfiles(t *testing.T) {
+	assert := assert.New(t)
+	tests := []struct {
+		firmware string
+		profile  string
+	}{
+		{"1.2.8", "current"},
+		{"1.0", "current"},
+		{"0.9.1", ""},
+		{"", ""},
+		{"beta-3", ""},
+	}
+	for _, tt := range tests {
+		p := profileFor(tt.firmware)
+		if tt.profile == "" {
+			assert.Nil(p, "firmware %q", tt.firmware)
+			continue
+		}
+		require.NotNil(t, p, "firmware %q", tt.firmware)
+		assert.Equal(tt.profile, p.name, "firmware %q", tt.firmware)
+	}
+}
+
+func TestDecodeValuesSchemas(t *testing.T) {
+	assert := assert.New(t)
+	// A profile of a layout with other field names, as profiles of future
+	// layouts would add.
+	defer func(profiles []firmwareProfile) { firmwareProfiles = profiles }(firmwareProfiles)
+	firmwareProfiles = append(firmwareProfiles, firmwareProfile{
+		name:       "renamed",
+		minVersion: []int{0, 0, 0},
+		aliases:    map[string]string{"humidity": "humid"},
+	})
+	tests := []struct {
+		body     string
+		firmware *firmwareProfile
+		schema   string
+		humidity float64
+	}{
+		{`{"score": 89, "humid": 45.7}`, nil, "current", 45.7},
+		{`{"score": 89, "humidity": 45.7}`, nil, "renamed", 45.7},
+		{`{"score": 89, "humidity": 45.7}`, profileFor("1.2.8"), "renamed", 45.7},
+		{`{"score": 89}`, profileFor("0.9.1"), "renamed", 0},
+		{`{"score": 89}`, nil, "current", 0},
+		{`{"score": 89, "humid": 45.7, "humidity": 50}`, nil, "renamed", 45.7},
+	}
+	for _, tt := range tests {
+		values, _, err := decodeValues([]byte(tt.body), tt.firmware)
+		require.Nil(t, err, tt.body)
+		assert.Equal(tt.schema, values.Schema, tt.body)
+		assert.Equal(float64(89), values.Score, tt.body)
+		assert.Equal(tt.humidity, values.Humidity, tt.body)
+		assert.Equal(tt.humidity != 0, values.Has("humid"), "humid of %s", tt.body)
+	}
+}
+
+func TestDeviceTimeOffset(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	deviceTime := time.Now().Add(-90 * time.Second).UTC().Format(time.RFC3339Nano)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/settings/config/data":
+			fmt.Fprint(w, `{"device_uuid": "awair-element_1"}`)
+		case "/air-data/latest":
+			fmt.Fprintf(w, `{"timestamp": %q, "score": 89}`, deviceTime)
+		}
+	}))
+	defer srv.Close()
+
+	e, err := exporterFromTestServer(srv)
+	require.Nil(err)
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(e)
+	families, err := reg.Gather()
+	require.Nil(err)
+
+	found := false
+	for _, mf := range families {
+		if mf.GetName() != "awair_device_time_offset_seconds" {
+			continue
+		}
+		found = true
+		assert.InDelta(-90, mf.GetMetric()[0].GetGauge().GetValue(), 5)
+	}
+	assert.True(found, "awair_device_time_offset_seconds not emitted")
+}
+
+func TestDeviceTimeOffsetOfCachedSample(t *testing.T) {
+	at := time.Now().Add(-time.Minute)
+	values := &AwairValues{Timestamp: at.Add(-90 * time.Second).UTC().Format(time.RFC3339Nano)}
+	ch := make(chan prometheus.Metric, 64)
+	NewMetrics().CollectAt(ch, at, values, &ConfigResponse{DeviceUUID: "awair-element_1"})
+	close(ch)
+	found := false
+	for m := range ch {
+		if !strings.Contains(m.Desc().String(), `"awair_device_time_offset_seconds"`) {
+			continue
+		}
+		found = true
+		pb := &dto.Metric{}
+		require.Nil(t, m.Write(pb))
+		assert.InDelta(t, -90, pb.GetGauge().GetValue(), 1, "measured against the fetch, not the scrape")
+	}
+	assert.True(t, found, "awair_device_time_offset_seconds not emitted")
+}
+
+func TestPollerScoreSamples(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	srv := getTestServer()
+	defer srv.Close()
+
+	e, err := NewAwairExporter(strings.Replace(srv.URL, "http://", "", -1), WithPollInterval(10*time.Millisecond))
+	require.Nil(err)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		e.Poll(ctx)
+		close(done)
+	}()
+	require.Eventually(func() bool {
+		return testutil.CollectAndCount(e.scoreSamples) == 1
+	}, time.Second, 5*time.Millisecond)
+	cancel()
+	<-done
+
+	s, cached := e.sample(context.Background())
+	assert.False(cached)
+	assert.Equal(float64(89), s.values.Score)
+	assert.Equal("awair-element_1", s.config.DeviceUUID)
+
+	m := &dto.Metric{}
+	require.Nil(e.scoreSamples.WithLabelValues("awair-element_1").(prometheus.Histogram).Write(m))
+	assert.GreaterOrEqual(m.GetHistogram().GetSampleCount(), uint64(1))
+}
+
+func TestUp(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	srv := getTestServer()
+
+	hostname := strings.Replace(srv.URL, "http://", "", -1)
+	scraped, err := NewAwairExporter(hostname, WithFreshness(0), WithLabels(map[string]string{"room": "office"}))
+	require.Nil(err)
+	polled, err := NewAwairExporter(hostname, WithPollInterval(time.Minute))
+	require.Nil(err)
+	polled.poll(context.Background())
+
+	up := func(e *AwairExporter) string {
+		metrics, err := testutil.CollectAndFormat(e, expfmt.TypeTextPlain, "awair_up")
+		require.Nil(err)
+		return string(metrics)
+	}
+	assert.Contains(up(scraped), `awair_up{device_uuid="awair-element_1",room="office"} 1`)
+	assert.Contains(up(polled), `awair_up{device_uuid="awair-element_1"} 1`)
+
+	srv.Close()
+	polled.poll(context.Background())
+	for _, e := range []*AwairExporter{scraped, polled} {
+		assert.Contains(up(e), `awair_up{device_uuid="awair-element_1"`)
+		assert.Contains(up(e), `} 0`)
+		assert.Equal(0, testutil.CollectAndCount(e, "awair_score"), "no stale or zero-valued readings")
+	}
+}
+
+func TestReadingsAfterFailedPoll(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	srv := getTestServer()
+	e, err := NewAwairExporter(strings.Replace(srv.URL, "http://", "", -1), WithPollInterval(time.Minute))
+	require.Nil(err)
+	e.poll(context.Background())
+	assert.Len(e.Readings(), 1)
+
+	srv.Close()
+	e.poll(context.Background())
+	assert.Empty(e.Readings(), "the last good sample isn't served once a poll failed")
+
+	e.mu.Lock()
+	e.pollFailed = false
+	e.latest = &sample{values: &AwairValues{Score: 89}, config: &ConfigResponse{DeviceUUID: "awair-element_1"}, at: time.Now().Add(-time.Hour)}
+	e.mu.Unlock()
+	assert.Empty(e.Readings(), "nor a sample of a stalled poller")
+}
+
+func TestLastModifiedOfFailedPoll(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	srv := getTestServer()
+	e, err := NewAwairExporter(strings.Replace(srv.URL, "http://", "", -1), WithPollInterval(time.Minute))
+	require.Nil(err)
+	modified, _ := e.LastModified()
+	assert.True(modified.IsZero(), "scrapes query the device until a poll succeeds")
+
+	e.poll(context.Background())
+	polled, version := e.LastModified()
+	assert.False(polled.IsZero())
+
+	e.mu.Lock()
+	e.latest.at = time.Now().Add(-time.Hour)
+	e.mu.Unlock()
+	_, staleVersion := e.LastModified()
+	assert.NotEqual(version, staleVersion, "a stalled poller changes awair_up")
+
+	srv.Close()
+	e.poll(context.Background())
+	failed, failedVersion := e.LastModified()
+	assert.NotEqual(staleVersion, failedVersion, "so does a failed poll")
+	assert.False(failed.Before(polled))
+}
+
+var heatIndex = prometheus.NewDesc(
+	"awair_test_heat_index",
+	"Test derived metric",
+	[]string{"device_uuid"},
+	nil,
+)
+
+type heatIndexMetrics struct{}
+
+func (heatIndexMetrics) Describe(ch chan<- *prometheus.Desc) {
+	ch <- heatIndex
+}
+
+func (heatIndexMetrics) Collect(ch chan<- prometheus.Metric, values *AwairValues, config *ConfigResponse) {
+	ch <- prometheus.MustNewConstMetric(heatIndex, prometheus.GaugeValue, values.Temp+1, config.DeviceUUID)
+}
+
+func TestDerivedMetrics(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	srv := getTestServer()
+	defer srv.Close()
+
+	e, err := NewAwairExporter(strings.Replace(srv.URL, "http://", "", -1), WithDerivedMetrics(heatIndexMetrics{}))
+	require.Nil(err)
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(e)
+
+	expected := `
+# HELP awair_test_heat_index Test derived metric
+# TYPE awair_test_heat_index gauge
+awair_test_heat_index{device_uuid="awair-element_1"} 22.13
+`
+	assert.Nil(testutil.GatherAndCompare(reg, strings.NewReader(expected), "awair_test_heat_index"))
+}
+
+func TestFreshSampleCached(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	srv := getTestServer()
+	defer srv.Close()
+
+	requests := int32(0)
+	counting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/air-data/latest" {
+			atomic.AddInt32(&requests, 1)
+		}
+		srv.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer counting.Close()
+
+	e, err := NewAwairExporter(strings.Replace(counting.URL, "http://", "", -1), WithFreshness(time.Minute))
+	require.Nil(err)
+
+	first := testutil.CollectAndCount(e, "awair_score")
+	second := testutil.CollectAndCount(e, "awair_score")
+	assert.Equal(1, first)
+	assert.Equal(1, second)
+	assert.Equal(int32(1), atomic.LoadInt32(&requests))
+	assert.Equal(float64(1), testutil.ToFloat64(e.cachedScrapes))
+
+	s, cached := e.sample(context.Background())
+	assert.True(cached)
+	ch := make(chan prometheus.Metric, 100)
+	e.collectSample(ch, s, cached)
+	close(ch)
+	for m := range ch {
+		pb := &dto.Metric{}
+		require.Nil(m.Write(pb))
+		assert.Equal(s.at.UnixMilli(), pb.GetTimestampMs())
+	}
+
+	e, err = NewAwairExporter(strings.Replace(counting.URL, "http://", "", -1), WithFreshness(0))
+	require.Nil(err)
+	atomic.StoreInt32(&requests, 0)
+	testutil.CollectAndCount(e, "awair_score")
+	testutil.CollectAndCount(e, "awair_score")
+	assert.Equal(int32(2), atomic.LoadInt32(&requests))
+}
+
+func TestWithEndpoints(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	srv := getTestServer()
+	defer srv.Close()
+
+	requests := int32(0)
+	counting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/settings/config/data" {
+			atomic.AddInt32(&requests, 1)
+		}
+		srv.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer counting.Close()
+
+	e, err := NewAwairExporter(strings.TrimPrefix(counting.URL, "http://"), WithFreshness(0), WithEndpoints([]string{"air-data"}))
+	require.Nil(err)
+	assert.Equal(1, testutil.CollectAndCount(e, "awair_device_info"))
+	assert.Equal(1, testutil.CollectAndCount(e, "awair_device_info"))
+	assert.Equal(int32(1), atomic.LoadInt32(&requests), "only queried when connecting")
+
+	assert.Nil(CheckEndpoints([]string{"air-data", "config", "power-status"}))
+	assert.NotNil(CheckEndpoints([]string{"config"}))
+	assert.NotNil(CheckEndpoints([]string{"air-data", "settings"}))
+}
+
+func TestModelSensors(t *testing.T) {
+	assert := assert.New(t)
+	srv := getTestServer()
+	defer srv.Close()
+
+	mint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/settings/config/data" {
+			fmt.Fprint(w, `{"device_uuid": "awair-mint_3", "fw_version": "1.4.0", "voc_feature_set": 34}`)
+			return
+		}
+		srv.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer mint.Close()
+
+	e, err := NewAwairExporter(strings.TrimPrefix(mint.URL, "http://"), WithFreshness(0))
+	require.Nil(t, err)
+	assert.Equal(0, testutil.CollectAndCount(e, "awair_co2"), "a Mint has no CO2 sensor")
+	assert.Equal(1, testutil.CollectAndCount(e, "awair_voc"))
+	assert.Equal(1, testutil.CollectAndCount(e, "awair_pm25"))
+	assert.Nil(testutil.CollectAndCompare(e, strings.NewReader(`
+# HELP awair_device_info Info about the awair device
+# TYPE awair_device_info gauge
+awair_device_info{device_uuid="awair-mint_3",firmware_version="1.4.0",model="awair-mint",voc_feature_set="34"} 1
+`), "awair_device_info"))
+
+	unknown := ModelOf("awair-future_1")
+	assert.Nil(unknown)
+	assert.True(unknown.Has("co2"), "devices of unknown models have all sensors")
+	glow := ModelOf("awair-glow-c_4")
+	assert.True(glow.Has("dew_point"))
+	assert.False(glow.Has("pm10_est"))
+}
+
+func TestAbsentSensors(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	srv := getTestServer()
+	defer srv.Close()
+
+	glow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/air-data/latest" {
+			fmt.Fprint(w, `{"timestamp": "", "score": 95, "dew_point": 8.2, "temp": 21.5, "humid": 42, "voc": 120}`)
+			return
+		}
+		srv.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer glow.Close()
+
+	e, err := NewAwairExporter(strings.TrimPrefix(glow.URL, "http://"), WithFreshness(0))
+	require.Nil(err)
+	values, err := e.GetMetrics()
+	require.Nil(err)
+	assert.Equal([]string{"abs_humid", "co2", "co2_est", "co2_est_baseline", "voc_baseline", "voc_h2_raw", "voc_ethanol_raw", "pm25", "pm10_est"}, values.Absent)
+	assert.False(values.Has("co2"))
+	assert.True(values.Has("voc"))
+
+	assert.Equal(0, testutil.CollectAndCount(e, "awair_co2"), "absent from the payload")
+	assert.Equal(0, testutil.CollectAndCount(e, "awair_pm25"))
+	assert.Equal(1, testutil.CollectAndCount(e, "awair_voc"))
+
+	decoded, err := DecodeValues([]byte(`{"score": 95, "co2": 600, "absent": ["co2"], "schema": "spoofed"}`))
+	require.Nil(err)
+	assert.True(decoded.Has("co2"), "payloads can't mark fields absent")
+	assert.Equal("current", decoded.Schema, "payloads can't set the schema")
+}
+
+func TestPowerStatus(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	srv := getTestServer()
+	defer srv.Close()
+
+	plugged := atomic.Bool{}
+	omni := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/settings/config/data":
+			fmt.Fprint(w, `{"device_uuid": "awair-omni_2", "fw_version": "1.3.0"}`)
+		case "/settings/power-status":
+			fmt.Fprintf(w, `{"battery": 87, "voltage": 4.1, "plugged": %t}`, plugged.Load())
+		default:
+			srv.Config.Handler.ServeHTTP(w, r)
+		}
+	}))
+	defer omni.Close()
+
+	e, err := NewAwairExporter(strings.TrimPrefix(omni.URL, "http://"), WithFreshness(0))
+	require.Nil(err)
+	expected := `
+# HELP awair_battery_percent Charge of the device's battery (%)
+# TYPE awair_battery_percent gauge
+awair_battery_percent{device_uuid="awair-omni_2"} 87
+# HELP awair_battery_voltage Voltage of the device's battery (V)
+# TYPE awair_battery_voltage gauge
+awair_battery_voltage{device_uuid="awair-omni_2"} 4.1
+# HELP awair_power_plugged Whether the device is plugged into power (1) or runs on its battery (0)
+# TYPE awair_power_plugged gauge
+awair_power_plugged{device_uuid="awair-omni_2"} 0
+`
+	assert.Nil(testutil.CollectAndCompare(e, strings.NewReader(expected), "awair_battery_percent", "awair_battery_voltage", "awair_power_plugged"))
+	plugged.Store(true)
+	assert.Nil(testutil.CollectAndCompare(e, strings.NewReader(strings.Replace(expected, "} 0", "} 1", 1)), "awair_power_plugged"))
+
+	e, err = NewAwairExporter(strings.TrimPrefix(omni.URL, "http://"), WithEndpoints([]string{"air-data"}))
+	require.Nil(err)
+	assert.Equal(0, testutil.CollectAndCount(e, "awair_battery_percent"), "left out by endpoints")
+
+	element, err := exporterFromTestServer(srv)
+	require.Nil(err)
+	assert.Equal(0, testutil.CollectAndCount(element, "awair_battery_percent"), "only queried on models with a battery")
+	assert.Equal(0, testutil.CollectAndCount(element.deviceErrors), "no failed requests")
+}
+
+func TestWithWake(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	srv := getTestServer()
+	defer srv.Close()
+
+	// The device drops the first request for readings after every sleep.
+	asleep := atomic.Bool{}
+	sleepy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/air-data/latest" && asleep.Swap(false) {
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		srv.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer sleepy.Close()
+	// Requests on kept-alive connections are retried by the transport.
+	sleepy.Config.SetKeepAlivesEnabled(false)
+	hostname := strings.TrimPrefix(sleepy.URL, "http://")
+
+	e, err := NewAwairExporter(hostname, WithFreshness(0))
+	require.Nil(err)
+	asleep.Store(true)
+	assert.Equal(0, testutil.CollectAndCount(e, "awair_co2"), "fails without a wake strategy")
+
+	e, err = NewAwairExporter(hostname, WithFreshness(0), WithWake(WakeRetry))
+	require.Nil(err)
+	asleep.Store(true)
+	assert.Equal(1, testutil.CollectAndCount(e, "awair_co2"))
+	assert.Equal(float64(1), testutil.ToFloat64(e.wakes.WithLabelValues(WakeRetry, "answered")))
+
+	e, err = NewAwairExporter(hostname, WithFreshness(0), WithWake(WakePrewarm))
+	require.Nil(err)
+	testutil.CollectAndCount(e)
+	assert.Equal(float64(1), testutil.ToFloat64(e.wakes.WithLabelValues(WakePrewarm, "answered")))
+
+	assert.Nil(CheckWake(""))
+	assert.EqualError(CheckWake("ping"), `unknown wake strategy "ping", expected retry or prewarm`)
+}
+
+// memoryCache is a SharedCache for tests, ignoring expiry.
+type memoryCache struct {
+	mu     sync.Mutex
+	values map[string][]byte
+}
+
+func (c *memoryCache) Get(key string) ([]byte, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok := c.values[key]
+	return v, ok, nil
+}
+
+func (c *memoryCache) Set(key string, value []byte, _ time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[key] = value
+	return nil
+}
+
+func (c *memoryCache) Lock(key string, _ time.Duration) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.values[key]; ok {
+		return false, nil
+	}
+	c.values[key] = []byte("1")
+	return true, nil
+}
+
+func TestSharedCache(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	srv := getTestServer()
+	defer srv.Close()
+
+	requests := int32(0)
+	counting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/air-data/latest" {
+			atomic.AddInt32(&requests, 1)
+		}
+		srv.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer counting.Close()
+
+	cache := &memoryCache{values: map[string][]byte{}}
+	hostname := strings.Replace(counting.URL, "http://", "", -1)
+	first, err := NewAwairExporter(hostname, WithSharedCache(cache), WithFreshness(10*time.Second))
+	require.Nil(err)
+	second, err := NewAwairExporter(hostname, WithSharedCache(cache), WithFreshness(10*time.Second))
+	require.Nil(err)
+
+	s, cached := first.sample(context.Background())
+	assert.False(cached)
+	assert.Equal(float64(89), s.values.Score)
+	s, cached = second.sample(context.Background())
+	assert.True(cached)
+	assert.Equal(float64(89), s.values.Score)
+	assert.Equal("current", s.values.Schema)
+	assert.Equal("awair-element_1", s.config.DeviceUUID)
+	assert.Equal(int32(1), atomic.LoadInt32(&requests))
+	assert.Equal(float64(1), testutil.ToFloat64(second.cachedScrapes))
+}
+
+type panickingMetrics struct{}
+
+func (panickingMetrics) Describe(ch chan<- *prometheus.Desc) {}
+
+func (panickingMetrics) Collect(ch chan<- prometheus.Metric, values *AwairValues, config *ConfigResponse) {
+	panic("derived metric bug")
+}
+
+func TestCollectRecoversPanic(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	srv := getTestServer()
+	defer srv.Close()
+
+	r := recovery.New()
+	e, err := NewAwairExporter(strings.Replace(srv.URL, "http://", "", -1),
+		WithDerivedMetrics(panickingMetrics{}), WithRecoverer(r))
+	require.Nil(err)
+	assert.NotPanics(func() {
+		testutil.CollectAndCount(e)
+	})
+	assert.Equal(1, testutil.CollectAndCount(r, "awair_exporter_panics_total"))
+}
+
+// blockingPublisher blocks the first Publish until released, wedging the
+// poll loop past its requests.
+type blockingPublisher struct {
+	calls   int32
+	release chan struct{}
+}
+
+func (p *blockingPublisher) Publish([]Reading) {
+	if atomic.AddInt32(&p.calls, 1) == 1 {
+		<-p.release
+	}
+}
+
+func TestWatchdogRestartsWedgedPoller(t *testing.T) {
+	require := require.New(t)
+	srv := getTestServer()
+	defer srv.Close()
+
+	p := &blockingPublisher{release: make(chan struct{})}
+	defer close(p.release)
+	e, err := NewAwairExporter(strings.Replace(srv.URL, "http://", "", -1),
+		WithPollInterval(10*time.Millisecond), WithWatchdog(2), WithTimeout(50*time.Millisecond), WithPublisher(p))
+	require.Nil(err)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		e.Poll(ctx)
+		close(done)
+	}()
+	require.Eventually(func() bool {
+		return testutil.ToFloat64(e.pollerRestarts) >= 1 && atomic.LoadInt32(&p.calls) > 1
+	}, time.Second, 5*time.Millisecond, "restarted and polling again")
+	cancel()
+	<-done
+}
+
+func TestWatchdogWaitsForRequestTimeout(t *testing.T) {
+	require := require.New(t)
+	srv := getTestServer()
+	defer srv.Close()
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		srv.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer slow.Close()
+
+	e, err := NewAwairExporter(strings.Replace(slow.URL, "http://", "", -1),
+		WithPollInterval(10*time.Millisecond), WithWatchdog(2), WithTimeout(time.Second))
+	require.Nil(err)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		e.Poll(ctx)
+		close(done)
+	}()
+	time.Sleep(500 * time.Millisecond)
+	cancel()
+	<-done
+	require.Equal(float64(0), testutil.ToFloat64(e.pollerRestarts), "slow but healthy polls aren't restarted")
+}
+
+func TestWatchdogWaitsForEveryRequest(t *testing.T) {
+	require := require.New(t)
+	srv := getTestServer()
+	defer srv.Close()
+	hang := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	})
+	hanging, alsoHanging := httptest.NewServer(hang), httptest.NewServer(hang)
+	defer hanging.Close()
+	defer alsoHanging.Close()
+	host := func(s *httptest.Server) string { return strings.TrimPrefix(s.URL, "http://") }
+
+	// The first poll times out at two addresses before the third answers,
+	// taking longer than the watchdog allows a single request.
+	e, err := NewAwairExporter(host(hanging),
+		WithAddresses([]string{host(alsoHanging), host(srv)}),
+		WithPollInterval(10*time.Millisecond), WithWatchdog(5), WithTimeout(200*time.Millisecond))
+	require.Nil(err)
+	// Creating the exporter found the address answering, start over.
+	e.active.Store(0)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		e.Poll(ctx)
+		close(done)
+	}()
+	require.Eventually(func() bool { return e.healthy() }, 2*time.Second, 10*time.Millisecond)
+	cancel()
+	<-done
+	require.Equal(float64(0), testutil.ToFloat64(e.pollerRestarts), "polls failing over across addresses aren't restarted")
+}
@@ -0,0 +1,567 @@
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+// member is a device of a Fleet, tracking the Collect calls in flight
+// against it so it can be removed cleanly.
+type member struct {
+	name     string
+	exporter *AwairExporter
+	inflight sync.WaitGroup
+	// cancel stops the background poller of a device added with
+	// AddPolled.
+	cancel context.CancelFunc
+	// added is when the device was added.
+	added time.Time
+}
+
+// DefaultTombstoneRetention is how long a removed device is exposed as
+// removed.
+const DefaultTombstoneRetention = time.Hour
+
+// tombstone records the removal of a device.
+type tombstone struct {
+	uuid string
+	at   time.Time
+}
+
+// Fleet is a collector for a set of devices which may change at runtime,
+// e.g. through discovery. Each Collect call sees a consistent snapshot of
+// the devices, and Remove waits for collections still reading the removed
+// device's last sample before returning. Devices with the same device UUID,
+// e.g. found by a scan and in the Awair Cloud, are collected once.
+//
+// As the devices aren't known upfront, Fleet is registered as an unchecked
+// collector.
+type Fleet struct {
+	mu      sync.RWMutex
+	members map[string]*member
+
+	// concurrency limits the devices collected at once, unless 0.
+	concurrency int
+	// deadline bounds the collection of each device, unless 0.
+	deadline time.Duration
+
+	// tombstones are the devices removed within the retention, by name.
+	tombstones map[string]tombstone
+	retention  time.Duration
+	removed    *prometheus.Desc
+
+	// priority orders the discovery sources of devices with the same
+	// device UUID, the most preferred first.
+	priority []string
+	// activeMu guards active, the names of the devices collected of those
+	// with the same device UUID, by device UUID.
+	activeMu     sync.Mutex
+	active       map[string]string
+	activeSource *prometheus.Desc
+
+	// ingestLimit caps the devices Ingest adds.
+	ingestLimit int
+}
+
+// DefaultSourcePriority is the order in which the devices of the same device
+// UUID found by several discovery sources are preferred: the configured
+// ones, those of service catalogs and the admin API, then those found by
+// scanning, and those read from the Awair Cloud last.
+var DefaultSourcePriority = []string{"static", "kubernetes", "consul", "api", "state", "scan", "cloud"}
+
+// FleetOption configures a Fleet.
+type FleetOption func(*Fleet)
+
+// WithCollectConcurrency limits the number of devices collected at once,
+// so a scrape of many devices doesn't query all of them at the same time.
+// It limits queries for Readings likewise. 0, the default, collects all
+// devices at once.
+func WithCollectConcurrency(n int) FleetOption {
+	return func(f *Fleet) {
+		f.concurrency = n
+	}
+}
+
+// WithCollectDeadline bounds the time a device queried on scrape may take,
+// counted from the start of its collection. A device exceeding it is
+// exposed as down, keeping the scrape within the scrape timeout, and left
+// out of Readings.
+func WithCollectDeadline(deadline time.Duration) FleetOption {
+	return func(f *Fleet) {
+		f.deadline = deadline
+	}
+}
+
+// WithTombstoneRetention sets how long a removed device is exposed as
+// awair_device_removed, DefaultTombstoneRetention by default. 0 exposes
+// none.
+func WithTombstoneRetention(retention time.Duration) FleetOption {
+	return func(f *Fleet) {
+		f.retention = retention
+	}
+}
+
+// WithSourcePriority sets the order in which devices of the same device UUID
+// are preferred by the source they were found by, DefaultSourcePriority by
+// default. Sources not listed come last.
+func WithSourcePriority(sources []string) FleetOption {
+	return func(f *Fleet) {
+		f.priority = sources
+	}
+}
+
+// DefaultIngestLimit is the number of devices Ingest adds at most.
+const DefaultIngestLimit = 100
+
+// WithIngestLimit caps the devices Ingest adds, DefaultIngestLimit by
+// default, so pushes for ever new names can't grow the fleet without
+// bound. 0 adds none.
+func WithIngestLimit(n int) FleetOption {
+	return func(f *Fleet) {
+		f.ingestLimit = n
+	}
+}
+
+// NewFleet returns an empty Fleet.
+func NewFleet(opts ...FleetOption) *Fleet {
+	f := &Fleet{
+		members:     map[string]*member{},
+		tombstones:  map[string]tombstone{},
+		retention:   DefaultTombstoneRetention,
+		ingestLimit: DefaultIngestLimit,
+		removed: prometheus.NewDesc(
+			prometheus.BuildFQName("awair", "device", "removed"),
+			"Unix time at which the device was removed from the exporter, exposed for a while after its series end",
+			[]string{"device_uuid", "name"}, nil,
+		),
+		priority: DefaultSourcePriority,
+		active:   map[string]string{},
+		activeSource: prometheus.NewDesc(
+			prometheus.BuildFQName("awair", "device", "source_active"),
+			"Whether the device is the one collected (1) or standing by (0) of those found by several sources with the same device UUID",
+			[]string{"device_uuid", "name", "source"}, nil,
+		),
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+	return f
+}
+
+// Add adds e to the fleet under name, replacing and draining any device
+// previously added under the same name.
+func (f *Fleet) Add(name string, e *AwairExporter) {
+	f.add(&member{name: name, exporter: e})
+}
+
+// AddPolled is Add for a device polled in the background, which keeps
+// polling until the device is removed or ctx is done.
+func (f *Fleet) AddPolled(ctx context.Context, name string, e *AwairExporter) {
+	ctx, cancel := context.WithCancel(ctx)
+	f.add(&member{name: name, exporter: e, cancel: cancel})
+	go e.Poll(ctx)
+}
+
+func (f *Fleet) add(m *member) {
+	m.exporter.setName(m.name)
+	m.added = time.Now()
+	f.mu.Lock()
+	old := f.members[m.name]
+	f.members[m.name] = m
+	delete(f.tombstones, m.name)
+	f.mu.Unlock()
+	if old != nil {
+		old.stop()
+	}
+}
+
+// stop stops the member's poller and waits for collections still using it.
+func (m *member) stop() {
+	if m.cancel != nil {
+		m.cancel()
+	}
+	m.inflight.Wait()
+}
+
+// Remove removes the device added under name, whose series end rather
+// than being left down, and which is exposed as awair_device_removed for
+// the tombstone retention. It reports whether the device was present, and
+// returns once no Collect call is using it anymore.
+func (f *Fleet) Remove(name string) bool {
+	f.mu.Lock()
+	m, ok := f.members[name]
+	delete(f.members, name)
+	if ok && f.retention > 0 {
+		f.tombstones[name] = tombstone{uuid: m.exporter.DeviceUUID(), at: time.Now()}
+	}
+	f.mu.Unlock()
+	if ok {
+		m.stop()
+		m.exporter.logger().Info().Msg("Removed device.")
+	}
+	return ok
+}
+
+// Unseen returns how long ago the device added under name last answered,
+// or was added if it never did, reporting false if there is none.
+func (f *Fleet) Unseen(name string) (time.Duration, bool) {
+	f.mu.RLock()
+	m, ok := f.members[name]
+	f.mu.RUnlock()
+	if !ok {
+		return 0, false
+	}
+	seen := m.added
+	if last := m.exporter.lastSeen.Load(); last > seen.UnixNano() {
+		seen = time.Unix(0, last)
+	}
+	return time.Since(seen), true
+}
+
+// DeviceInfo identifies a device of a fleet.
+type DeviceInfo struct {
+	Name       string `json:"name"`
+	Hostname   string `json:"hostname"`
+	DeviceUUID string `json:"device_uuid"`
+	// Address is the address the device answered the Local API at last,
+	// empty for devices whose readings are taken elsewhere.
+	Address string `json:"address,omitempty"`
+	// Source is the discovery source the device was found by, if known.
+	Source string `json:"source,omitempty"`
+	// Standby is set for a device whose device UUID another device of the
+	// fleet is collected for.
+	Standby bool `json:"standby,omitempty"`
+}
+
+// Devices returns the devices in the fleet, ordered by name.
+func (f *Fleet) Devices() []DeviceInfo {
+	members := f.snapshot()
+	dups := f.duplicates(members)
+	devices := make([]DeviceInfo, 0, len(members))
+	for _, m := range members {
+		d := DeviceInfo{
+			Name:       m.name,
+			Hostname:   m.exporter.hostname,
+			DeviceUUID: m.exporter.DeviceUUID(),
+			Source:     m.exporter.discovery,
+			Standby:    dups.standby(m),
+		}
+		if m.exporter.source == nil && !m.exporter.ingested {
+			d.Address = m.exporter.Address()
+		}
+		devices = append(devices, d)
+		m.inflight.Done()
+	}
+	return devices
+}
+
+// RemoveDevice removes the device with the given UUID like Remove,
+// reporting whether it was present.
+func (f *Fleet) RemoveDevice(uuid string) bool {
+	for _, d := range f.Devices() {
+		if d.DeviceUUID == uuid {
+			return f.Remove(d.Name)
+		}
+	}
+	return false
+}
+
+// Names returns the names of the devices in the fleet, sorted.
+func (f *Fleet) Names() []string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	names := make([]string, 0, len(f.members))
+	for name := range f.members {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Len returns the number of devices in the fleet.
+func (f *Fleet) Len() int {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return len(f.members)
+}
+
+// snapshot returns the current devices ordered by name, marking a collection in flight on
+// each. The caller must call Done on every returned member's inflight group.
+func (f *Fleet) snapshot() []*member {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	names := make([]string, 0, len(f.members))
+	for name := range f.members {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	members := make([]*member, 0, len(names))
+	for _, name := range names {
+		m := f.members[name]
+		m.inflight.Add(1)
+		members = append(members, m)
+	}
+	return members
+}
+
+// Describe sends no descriptors, making Fleet an unchecked collector.
+func (f *Fleet) Describe(ch chan<- *prometheus.Desc) {}
+
+// duplicates are the devices of a fleet with the same device UUID, by
+// device UUID.
+type duplicates map[string]*duplicate
+
+// duplicate is the devices with the same device UUID, the active one being
+// collected.
+type duplicate struct {
+	members []*member
+	active  *member
+}
+
+// standby reports whether m is a device standing by for another one with
+// the same device UUID.
+func (d duplicates) standby(m *member) bool {
+	dup, ok := d[m.exporter.DeviceUUID()]
+	return ok && dup.active != m
+}
+
+// rank returns the position of the discovery source of m in the priority.
+func (f *Fleet) rank(m *member) int {
+	for i, source := range f.priority {
+		if m.exporter.discovery == source {
+			return i
+		}
+	}
+	return len(f.priority)
+}
+
+// duplicates returns the members, ordered by name, sharing a device UUID
+// with another one. Of those, the first healthy one by the priority of
+// their source is active, or the first one if none is, so a device found
+// locally and in the Awair Cloud is collected once, from the cloud only
+// while its Local API fails.
+func (f *Fleet) duplicates(members []*member) duplicates {
+	groups := map[string][]*member{}
+	for _, m := range members {
+		if uuid := m.exporter.DeviceUUID(); uuid != "" {
+			groups[uuid] = append(groups[uuid], m)
+		}
+	}
+	dups := duplicates{}
+	active := map[string]string{}
+	for uuid, group := range groups {
+		if len(group) < 2 {
+			continue
+		}
+		sort.SliceStable(group, func(i, j int) bool {
+			return f.rank(group[i]) < f.rank(group[j])
+		})
+		dup := &duplicate{members: group, active: group[0]}
+		for _, m := range group {
+			if m.exporter.healthy() {
+				dup.active = m
+				break
+			}
+		}
+		dups[uuid] = dup
+		active[uuid] = dup.active.name
+	}
+	f.activeMu.Lock()
+	for uuid, name := range active {
+		if previous, ok := f.active[uuid]; ok && previous != name {
+			log.Info().Str("device_uuid", uuid).Str("previous", previous).Str("name", name).
+				Msg("Switched duplicate device collected.")
+		}
+	}
+	f.active = active
+	f.activeMu.Unlock()
+	return dups
+}
+
+func (f *Fleet) Collect(ch chan<- prometheus.Metric) {
+	f.collectTombstones(ch)
+	members := f.snapshot()
+	dups := f.duplicates(members)
+	for uuid, dup := range dups {
+		for _, m := range dup.members {
+			value := 0.0
+			if m == dup.active {
+				value = 1
+			}
+			ch <- prometheus.MustNewConstMetric(f.activeSource, prometheus.GaugeValue, value, uuid, m.name, m.exporter.discovery)
+		}
+	}
+	f.fanOut(members, dups, func(m *member) {
+		f.collect(m, ch)
+	})
+}
+
+// fanOut calls fn for every member but those standing by for a duplicate,
+// concurrently up to the fleet's concurrency, marking each member done.
+func (f *Fleet) fanOut(members []*member, dups duplicates, fn func(m *member)) {
+	var slots chan struct{}
+	if f.concurrency > 0 {
+		slots = make(chan struct{}, f.concurrency)
+	}
+	wg := sync.WaitGroup{}
+	for _, m := range members {
+		if dups.standby(m) {
+			m.inflight.Done()
+			continue
+		}
+		wg.Add(1)
+		go func(m *member) {
+			defer wg.Done()
+			defer m.inflight.Done()
+			if slots != nil {
+				slots <- struct{}{}
+				defer func() { <-slots }()
+			}
+			fn(m)
+		}(m)
+	}
+	wg.Wait()
+}
+
+// collectTombstones exposes the devices removed within the retention,
+// forgetting older ones.
+func (f *Fleet) collectTombstones(ch chan<- prometheus.Metric) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for name, t := range f.tombstones {
+		if time.Since(t.at) > f.retention {
+			delete(f.tombstones, name)
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(f.removed, prometheus.GaugeValue, float64(t.at.Unix()), t.uuid, name)
+	}
+}
+
+// deviceContext returns the context of a query of a device, bounded by the
+// fleet's deadline.
+func (f *Fleet) deviceContext() (context.Context, context.CancelFunc) {
+	if f.deadline > 0 {
+		return context.WithTimeout(context.Background(), f.deadline)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// collect collects m within the fleet's deadline.
+func (f *Fleet) collect(m *member, ch chan<- prometheus.Metric) {
+	ctx, cancel := f.deviceContext()
+	defer cancel()
+	m.exporter.collect(ctx, ch)
+}
+
+// readings returns the readings of every member but those standing by for
+// a duplicate, in the order of members, querying devices like Collect.
+func (f *Fleet) readings() ([]*member, [][]Reading) {
+	members := f.snapshot()
+	dups := f.duplicates(members)
+	readings := make([][]Reading, len(members))
+	index := make(map[*member]int, len(members))
+	for i, m := range members {
+		index[m] = i
+	}
+	f.fanOut(members, dups, func(m *member) {
+		ctx, cancel := f.deviceContext()
+		defer cancel()
+		readings[index[m]] = m.exporter.readings(ctx)
+	})
+	return members, readings
+}
+
+// Device returns a collector for the device added under name only,
+// reporting false if there is none. Like Fleet, it is an unchecked
+// collector, and removing the device waits for its collections.
+func (f *Fleet) Device(name string) (prometheus.Collector, bool) {
+	f.mu.RLock()
+	_, ok := f.members[name]
+	f.mu.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	return deviceCollector{fleet: f, name: name}, true
+}
+
+// deviceCollector collects a single device of a fleet.
+type deviceCollector struct {
+	fleet *Fleet
+	name  string
+}
+
+func (d deviceCollector) Describe(ch chan<- *prometheus.Desc) {}
+
+func (d deviceCollector) Collect(ch chan<- prometheus.Metric) {
+	d.fleet.mu.RLock()
+	m, ok := d.fleet.members[d.name]
+	if ok {
+		m.inflight.Add(1)
+	}
+	d.fleet.mu.RUnlock()
+	if !ok {
+		return
+	}
+	defer m.inflight.Done()
+	d.fleet.collect(m, ch)
+}
+
+// LastModified returns the latest LastModified of the devices in the fleet,
+// or zero if any of them has none, with a version combining theirs.
+func (f *Fleet) LastModified() (time.Time, uint64) {
+	members := f.snapshot()
+	dups := f.duplicates(members)
+	last := time.Time{}
+	unknown := false
+	h := fnv.New64a()
+	for _, m := range members {
+		if dups.standby(m) {
+			m.inflight.Done()
+			continue
+		}
+		modified, version := m.exporter.LastModified()
+		m.inflight.Done()
+		unknown = unknown || modified.IsZero()
+		if modified.After(last) {
+			last = modified
+		}
+		fmt.Fprintf(h, "%s:%x\n", m.name, version)
+	}
+	if unknown {
+		return time.Time{}, 0
+	}
+	return last, h.Sum64()
+}
+
+// Readings returns the latest sample of every device in the fleet but those
+// standing by for a duplicate. Devices are queried concurrently and within
+// the deadline, as on Collect.
+func (f *Fleet) Readings() []Reading {
+	_, all := f.readings()
+	readings := []Reading{}
+	for _, r := range all {
+		readings = append(readings, r...)
+	}
+	return readings
+}
+
+// NamedReadings returns the latest sample of every device in the fleet,
+// keyed by the name it was added under. Devices without a sample, or
+// standing by for a duplicate, are left out.
+func (f *Fleet) NamedReadings() map[string]Reading {
+	members, all := f.readings()
+	readings := map[string]Reading{}
+	for i, r := range all {
+		if len(r) > 0 {
+			readings[members[i].name] = r[0]
+		}
+	}
+	return readings
+}
@@ -0,0 +1,372 @@
+package exporter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/expfmt"
+	"github.com/stretchr/testify/require"
+	"github.com/tj/assert"
+)
+
+func TestFleetAddRemove(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	srv := getTestServer()
+	defer srv.Close()
+
+	e, err := exporterFromTestServer(srv)
+	require.Nil(err)
+
+	f := NewFleet()
+	reg := prometheus.NewPedanticRegistry()
+	require.Nil(reg.Register(f))
+	assert.Equal(0, testutil.CollectAndCount(f, "awair_score"))
+
+	f.Add("bedroom", e)
+	assert.Equal([]string{"bedroom"}, f.Names())
+	modified, _ := f.LastModified()
+	assert.True(modified.IsZero(), "devices queried on scrape are never unmodified")
+	assert.Equal(1, testutil.CollectAndCount(f, "awair_score"))
+	assert.Len(f.Readings(), 1)
+	assert.Equal("awair-element_1", f.NamedReadings()["bedroom"].Config.DeviceUUID)
+	_, err = reg.Gather()
+	assert.Nil(err)
+
+	c, ok := f.Device("bedroom")
+	assert.True(ok)
+	assert.Equal(1, testutil.CollectAndCount(c, "awair_score"))
+	_, ok = f.Device("office")
+	assert.False(ok)
+
+	unseen, ok := f.Unseen("bedroom")
+	assert.True(ok)
+	assert.Less(unseen, time.Second)
+
+	assert.True(f.Remove("bedroom"))
+	assert.False(f.Remove("bedroom"))
+	assert.Equal(0, f.Len())
+	assert.Equal(0, testutil.CollectAndCount(f, "awair_score"))
+	assert.Equal(0, testutil.CollectAndCount(c, "awair_score"))
+	_, ok = f.Unseen("bedroom")
+	assert.False(ok)
+}
+
+func TestFleetTombstones(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	srv := getTestServer()
+	defer srv.Close()
+
+	e, err := exporterFromTestServer(srv)
+	require.Nil(err)
+	f := NewFleet()
+	f.Add("bedroom", e)
+	testutil.CollectAndCount(f)
+	require.True(f.Remove("bedroom"))
+
+	metrics, err := testutil.CollectAndFormat(f, expfmt.TypeTextPlain, "awair_device_removed")
+	require.Nil(err)
+	assert.Contains(string(metrics), `awair_device_removed{device_uuid="awair-element_1",name="bedroom"}`)
+	assert.Equal(0, testutil.CollectAndCount(f, "awair_up"), "series of the device end")
+
+	f.Add("bedroom", e)
+	assert.Equal(0, testutil.CollectAndCount(f, "awair_device_removed"), "a device added again is no longer removed")
+	require.True(f.Remove("bedroom"))
+	f.tombstones["bedroom"] = tombstone{uuid: "awair-element_1", at: time.Now().Add(-2 * DefaultTombstoneRetention)}
+	assert.Equal(0, testutil.CollectAndCount(f, "awair_device_removed"), "expired")
+	assert.Len(f.tombstones, 0)
+
+	f = NewFleet(WithTombstoneRetention(0))
+	f.Add("bedroom", e)
+	f.Remove("bedroom")
+	assert.Equal(0, testutil.CollectAndCount(f, "awair_device_removed"))
+}
+
+func TestFleetDuplicates(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	srv := getTestServer()
+	defer srv.Close()
+
+	f := NewFleet()
+	for name, source := range map[string]string{"192.168.1.2": "scan", "bedroom": "static", "awair-element_1": "cloud"} {
+		e, err := NewAwairExporter(strings.Replace(srv.URL, "http://", "", -1), WithDiscoverySource(source))
+		require.Nil(err)
+		f.Add(name, e)
+	}
+	reg := prometheus.NewPedanticRegistry()
+	require.Nil(reg.Register(f))
+	_, err := reg.Gather()
+	assert.Nil(err, "collected once")
+	assert.Equal(1, testutil.CollectAndCount(f, "awair_score"))
+	assert.Len(f.Readings(), 1)
+	assert.Equal([]string{"bedroom"}, keys(f.NamedReadings()))
+	assert.Nil(testutil.CollectAndCompare(f, strings.NewReader(`
+# HELP awair_device_source_active Whether the device is the one collected (1) or standing by (0) of those found by several sources with the same device UUID
+# TYPE awair_device_source_active gauge
+awair_device_source_active{device_uuid="awair-element_1",name="192.168.1.2",source="scan"} 0
+awair_device_source_active{device_uuid="awair-element_1",name="awair-element_1",source="cloud"} 0
+awair_device_source_active{device_uuid="awair-element_1",name="bedroom",source="static"} 1
+`), "awair_device_source_active"))
+	standby := map[string]bool{}
+	for _, d := range f.Devices() {
+		standby[d.Name] = d.Standby
+	}
+	assert.Equal(map[string]bool{"192.168.1.2": true, "awair-element_1": true, "bedroom": false}, standby)
+
+	// The preferred device failing, the next healthy one is collected.
+	f.members["bedroom"].exporter.pollInterval = time.Minute
+	f.members["bedroom"].exporter.pollFailed = true
+	assert.Equal([]string{"192.168.1.2"}, keys(f.NamedReadings()))
+
+	f = NewFleet(WithSourcePriority([]string{"cloud"}))
+	for name, source := range map[string]string{"bedroom": "static", "awair-element_1": "cloud"} {
+		e, err := NewAwairExporter(strings.Replace(srv.URL, "http://", "", -1), WithDiscoverySource(source))
+		require.Nil(err)
+		f.Add(name, e)
+	}
+	assert.Equal([]string{"awair-element_1"}, keys(f.NamedReadings()))
+}
+
+func keys(m map[string]Reading) []string {
+	names := []string{}
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestFleetRemoveDrainsCollect(t *testing.T) {
+	require := require.New(t)
+	srv := getTestServer()
+	defer srv.Close()
+
+	release := make(chan struct{})
+	requested := make(chan struct{}, 1)
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/air-data/latest" {
+			requested <- struct{}{}
+			<-release
+		}
+		srv.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer slow.Close()
+
+	e, err := NewAwairExporter(strings.Replace(slow.URL, "http://", "", -1), WithFreshness(0))
+	require.Nil(err)
+	f := NewFleet()
+	f.Add("bedroom", e)
+
+	ch := make(chan prometheus.Metric, 100)
+	collected := make(chan struct{})
+	go func() {
+		f.Collect(ch)
+		close(collected)
+	}()
+	<-requested
+
+	removed := make(chan struct{})
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		f.Remove("bedroom")
+		close(removed)
+	}()
+	select {
+	case <-removed:
+		t.Fatal("Remove returned while a Collect was in flight")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	wg.Wait()
+	<-collected
+	require.NotZero(len(ch))
+}
+
+func TestFleetIngest(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	srv := getTestServer()
+	defer srv.Close()
+
+	f := NewFleet()
+	e, err := exporterFromTestServer(srv)
+	require.Nil(err)
+	f.Add("polled", e)
+	assert.NotNil(f.Ingest("polled", &AwairValues{Score: 70}, nil))
+
+	pushed := NewIngestedExporter("pushed")
+	assert.Equal(0, testutil.CollectAndCount(pushed, "awair_score"))
+	assert.Len(pushed.Readings(), 0)
+	modified, _ := pushed.LastModified()
+	assert.True(modified.IsZero())
+
+	require.Nil(f.Ingest("pushed", &AwairValues{Score: 70}, nil))
+	assert.Equal([]string{"polled", "pushed"}, f.Names())
+	c, ok := f.Device("pushed")
+	require.True(ok)
+	assert.Nil(testutil.CollectAndCompare(c, strings.NewReader(`
+# HELP awair_score Awair Score (0-100)
+# TYPE awair_score gauge
+awair_score{device_uuid="pushed"} 70
+`), "awair_score"))
+	readings := f.Readings()
+	assert.Len(readings, 2)
+
+	require.Nil(f.Ingest("pushed-too", &AwairValues{Score: 60}, nil))
+	reg := prometheus.NewPedanticRegistry()
+	require.Nil(reg.Register(f))
+	_, err = reg.Gather()
+	assert.Nil(err, "devices' own series must not collide")
+	assert.NotNil(f.Ingest("pushed", nil, nil))
+}
+
+func TestFleetIngestLimit(t *testing.T) {
+	assert := assert.New(t)
+	f := NewFleet(WithIngestLimit(1))
+	assert.Nil(f.Ingest("garage", &AwairValues{Score: 70}, nil))
+	assert.Equal(ErrIngestLimit, f.Ingest("shed", &AwairValues{Score: 60}, nil))
+	assert.Nil(f.Ingest("garage", &AwairValues{Score: 65}, nil), "known devices keep accepting readings")
+	assert.Equal([]string{"garage"}, f.Names())
+}
+
+func TestFleetMultipleDevices(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	srv := getTestServer()
+	defer srv.Close()
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/settings/config/data":
+			w.Write([]byte(`{"device_uuid": "awair-element_2", "fw_version": "1.1.4"}`))
+		case "/air-data/latest":
+			w.Write([]byte(`{"score": 70, "co2": 900}`))
+		}
+	}))
+	defer other.Close()
+
+	f := NewFleet()
+	for name, s := range map[string]*httptest.Server{"bedroom": srv, "office": other} {
+		e, err := exporterFromTestServer(s)
+		require.Nil(err)
+		f.Add(name, e)
+	}
+
+	reg := prometheus.NewPedanticRegistry()
+	require.Nil(reg.Register(f))
+	err := testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP awair_score Awair Score (0-100)
+# TYPE awair_score gauge
+awair_score{device_uuid="awair-element_1"} 89
+awair_score{device_uuid="awair-element_2"} 70
+`), "awair_score")
+	assert.Nil(err)
+}
+
+func TestFleetCollectLimits(t *testing.T) {
+	assert := assert.New(t)
+	mu := sync.Mutex{}
+	running, maxRunning := 0, 0
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			running--
+			mu.Unlock()
+		}()
+		select {
+		case <-r.Context().Done():
+		case <-time.After(2 * time.Second):
+		}
+	}))
+	defer slow.Close()
+
+	f := NewFleet(WithCollectConcurrency(2), WithCollectDeadline(100*time.Millisecond))
+	for _, name := range []string{"a", "b", "c", "d"} {
+		f.Add(name, newAwairExporter(strings.TrimPrefix(slow.URL, "http://")))
+	}
+
+	start := time.Now()
+	ch := make(chan prometheus.Metric)
+	go func() {
+		f.Collect(ch)
+		close(ch)
+	}()
+	up := 0
+	for m := range ch {
+		if strings.Contains(m.Desc().String(), `"awair_up"`) {
+			up++
+		}
+	}
+	assert.Equal(4, up, "exposed awair_up of every device")
+	assert.Less(time.Since(start), time.Second, "gave up on slow devices")
+	assert.LessOrEqual(maxRunning, 4, "queried at most two devices, with two requests each, at once")
+	assert.Greater(maxRunning, 0)
+}
+
+func TestFleetReadingsLimits(t *testing.T) {
+	assert := assert.New(t)
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(2 * time.Second):
+		}
+	}))
+	defer slow.Close()
+
+	f := NewFleet(WithCollectConcurrency(2), WithCollectDeadline(100*time.Millisecond))
+	for _, name := range []string{"a", "b", "c", "d"} {
+		f.Add(name, newAwairExporter(strings.TrimPrefix(slow.URL, "http://")))
+	}
+
+	start := time.Now()
+	assert.Empty(f.Readings())
+	assert.Empty(f.NamedReadings())
+	assert.Less(time.Since(start), time.Second, "queried devices concurrently within the deadline")
+}
+
+func TestFleetAddPolledRemoveDevice(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	srv := getTestServer()
+	defer srv.Close()
+
+	e, err := NewAwairExporter(strings.TrimPrefix(srv.URL, "http://"), WithPollInterval(5*time.Millisecond))
+	require.Nil(err)
+	f := NewFleet()
+	f.AddPolled(context.Background(), "bedroom", e)
+	assert.Equal([]DeviceInfo{{
+		Name:       "bedroom",
+		Hostname:   strings.TrimPrefix(srv.URL, "http://"),
+		DeviceUUID: "awair-element_1",
+		Address:    strings.TrimPrefix(srv.URL, "http://"),
+	}}, f.Devices())
+	require.Eventually(func() bool {
+		modified, _ := e.LastModified()
+		return !modified.IsZero()
+	}, time.Second, time.Millisecond)
+
+	assert.False(f.RemoveDevice("awair-element_2"))
+	assert.True(f.RemoveDevice("awair-element_1"))
+	assert.Equal(0, f.Len())
+	_, stopped := e.LastModified()
+	time.Sleep(50 * time.Millisecond)
+	_, version := e.LastModified()
+	assert.Equal(stopped, version, "removing the device stops its poller")
+}
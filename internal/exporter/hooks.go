@@ -0,0 +1,43 @@
+package exporter
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DerivedMetrics computes additional metrics from each device reading,
+// letting forks add their own derived series without patching Collect.
+type DerivedMetrics interface {
+	// Describe sends the descriptors of every metric Collect may emit.
+	Describe(ch chan<- *prometheus.Desc)
+	// Collect emits metrics derived from a single device reading.
+	Collect(ch chan<- prometheus.Metric, values *AwairValues, config *ConfigResponse)
+}
+
+var (
+	derivedMu sync.RWMutex
+	derived   []DerivedMetrics
+)
+
+// RegisterDerivedMetrics adds d to every AwairExporter created afterwards.
+// It is intended to be called from an init function.
+func RegisterDerivedMetrics(d DerivedMetrics) {
+	derivedMu.Lock()
+	defer derivedMu.Unlock()
+	derived = append(derived, d)
+}
+
+func registeredDerivedMetrics() []DerivedMetrics {
+	derivedMu.RLock()
+	defer derivedMu.RUnlock()
+	return append([]DerivedMetrics(nil), derived...)
+}
+
+// WithDerivedMetrics adds d to the exporter in addition to any registered
+// with RegisterDerivedMetrics.
+func WithDerivedMetrics(d ...DerivedMetrics) Option {
+	return func(e *AwairExporter) {
+		e.derived = append(e.derived, d...)
+	}
+}
@@ -0,0 +1,71 @@
+package exporter
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// NewIngestedExporter creates an exporter for a device whose readings are
+// pushed to the exporter, e.g. by a relay on a VLAN the exporter can't
+// reach, instead of being polled. It serves nothing until the first Ingest.
+func NewIngestedExporter(name string, opts ...Option) *AwairExporter {
+	e := newAwairExporter(name, opts...)
+	e.ingested = true
+	return e
+}
+
+// Ingest stores a pushed reading, which is then served exactly like a
+// polled one. A missing config is replaced by one carrying the exporter's
+// name as device UUID.
+func (e *AwairExporter) Ingest(values *AwairValues, config *ConfigResponse) error {
+	if !e.ingested {
+		return fmt.Errorf("device %s is polled, not ingested", e.hostname)
+	}
+	if values == nil {
+		return fmt.Errorf("reading for %s has no values", e.hostname)
+	}
+	if config == nil {
+		config = &ConfigResponse{DeviceUUID: e.hostname}
+	}
+	e.mu.Lock()
+	e.latest = &sample{values: values, config: config, at: time.Now()}
+	e.generation++
+	e.changed = e.latest.at
+	e.deviceUUID = config.DeviceUUID
+	e.mu.Unlock()
+	e.scoreSamples.WithLabelValues(config.DeviceUUID).Observe(values.Score)
+	if e.publisher != nil {
+		e.publisher.Publish(e.Readings())
+	}
+	return nil
+}
+
+// ErrIngestLimit is returned by Ingest for a new device once the fleet has
+// as many ingested devices as its ingest limit.
+var ErrIngestLimit = errors.New("too many ingested devices")
+
+// Ingest stores a pushed reading for the device named name, adding an
+// ingested device created with opts if there is none.
+func (f *Fleet) Ingest(name string, values *AwairValues, config *ConfigResponse, opts ...Option) error {
+	f.mu.Lock()
+	m, ok := f.members[name]
+	if !ok {
+		ingested := 0
+		for _, m := range f.members {
+			if m.exporter.ingested {
+				ingested++
+			}
+		}
+		if ingested >= f.ingestLimit {
+			f.mu.Unlock()
+			return ErrIngestLimit
+		}
+		m = &member{name: name, exporter: NewIngestedExporter(name, opts...)}
+		f.members[name] = m
+	}
+	m.inflight.Add(1)
+	f.mu.Unlock()
+	defer m.inflight.Done()
+	return m.exporter.Ingest(values, config)
+}
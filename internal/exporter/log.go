@@ -0,0 +1,38 @@
+package exporter
+
+import (
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// logger returns the logger of lines about the device, which carry its
+// device UUID, name and address, so the lines of a fleet tell which device
+// they are about.
+func (e *AwairExporter) logger() *zerolog.Logger {
+	if l := e.log.Load(); l != nil {
+		return l
+	}
+	return &log.Logger
+}
+
+// updateLogger sets the device UUID and name of the lines of logger, empty
+// ones being left out. It is called whenever either changes.
+func (e *AwairExporter) updateLogger(uuid, name string) {
+	c := log.With().Str("address", e.hostname)
+	if uuid != "" {
+		c = c.Str("device_uuid", uuid)
+	}
+	if name != "" {
+		c = c.Str("name", name)
+	}
+	l := c.Logger()
+	e.log.Store(&l)
+}
+
+// setName sets the name the device was added to a fleet under.
+func (e *AwairExporter) setName(name string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.name = name
+	e.updateLogger(e.deviceUUID, name)
+}
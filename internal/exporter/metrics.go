@@ -0,0 +1,398 @@
+package exporter
+
+import (
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics holds the descriptors for the series emitted for each device.
+// Extra label names are appended to every descriptor, allowing callers such
+// as the federation collector to attach their own dimensions.
+type Metrics struct {
+	score                 *prometheus.Desc
+	dew_point             *prometheus.Desc
+	temp                  *prometheus.Desc
+	humidity              *prometheus.Desc
+	abs_humidity          *prometheus.Desc
+	abs_humidity_error    *prometheus.Desc
+	co2                   *prometheus.Desc
+	co2_estimated         *prometheus.Desc
+	co2_estimate_baseline *prometheus.Desc
+	voc                   *prometheus.Desc
+	voc_baseline          *prometheus.Desc
+	voc_h2_raw            *prometheus.Desc
+	voc_ethanol_raw       *prometheus.Desc
+	pm25                  *prometheus.Desc
+	pm10                  *prometheus.Desc
+	illuminance           *prometheus.Desc
+	sound_level           *prometheus.Desc
+	device_time_offset    *prometheus.Desc
+	info                  *prometheus.Desc
+	payload_schema        *prometheus.Desc
+	led_brightness        *prometheus.Desc
+	led_info              *prometheus.Desc
+	display_mode          *prometheus.Desc
+	battery_percent       *prometheus.Desc
+	battery_voltage       *prometheus.Desc
+	power_plugged         *prometheus.Desc
+}
+
+// displayModes are the modes of the device's display, exposed with 0
+// unless active so changes show as series switching between 0 and 1.
+var displayModes = []string{"score", "temp", "humid", "co2", "voc", "pm25", "clock"}
+
+func NewMetrics(extraLabels ...string) *Metrics {
+	labels := func(names ...string) []string {
+		return append(names, extraLabels...)
+	}
+	return &Metrics{
+		score: prometheus.NewDesc(
+			prometheus.BuildFQName("awair", "", "score"),
+			"Awair Score (0-100)",
+			labels(
+				"device_uuid",
+			),
+			nil,
+		),
+		dew_point: prometheus.NewDesc(
+			prometheus.BuildFQName("awair", "", "dew_point"),
+			"The temperature at which water will condense and form into dew (ºC)",
+			labels(
+				"device_uuid",
+			),
+			nil,
+		),
+		temp: prometheus.NewDesc(
+			prometheus.BuildFQName("awair", "", "temp"),
+			"Dry bulb temperature (ºC)",
+			labels(
+				"device_uuid",
+			),
+			nil,
+		),
+		humidity: prometheus.NewDesc(
+			prometheus.BuildFQName("awair", "", "humidity"),
+			"Relative Humidity (%)",
+			labels(
+				"device_uuid",
+			),
+			nil,
+		),
+		abs_humidity: prometheus.NewDesc(
+			prometheus.BuildFQName("awair", "", "absolute_humidity"),
+			"Absolute Humidity (g/m³)",
+			labels(
+				"device_uuid",
+			),
+			nil,
+		),
+		abs_humidity_error: prometheus.NewDesc(
+			prometheus.BuildFQName("awair", "", "absolute_humidity_deviation"),
+			"Absolute humidity reported by the device minus the one computed from temperature and relative humidity (g/m³)",
+			labels(
+				"device_uuid",
+			),
+			nil,
+		),
+		co2: prometheus.NewDesc(
+			prometheus.BuildFQName("awair", "", "co2"),
+			"Carbon Dioxide (ppm)",
+			labels(
+				"device_uuid",
+			),
+			nil,
+		),
+		co2_estimated: prometheus.NewDesc(
+			prometheus.BuildFQName("awair", "", "co2_est"),
+			"Estimated Carbon Dioxide (ppm - calculated by the TVOC sensor)",
+			labels(
+				"device_uuid",
+			),
+			nil,
+		),
+		co2_estimate_baseline: prometheus.NewDesc(
+			prometheus.BuildFQName("awair", "", "co2_est_baseline"),
+			"A unitless value that represents the baseline from which the TVOC sensor partially derives its estimated (e)CO₂output.",
+			labels(
+				"device_uuid",
+			),
+			nil,
+		),
+		voc: prometheus.NewDesc(
+			prometheus.BuildFQName("awair", "", "voc"),
+			"Total Volatile Organic Compounds (ppb)",
+			labels(
+				"device_uuid",
+			),
+			nil,
+		),
+		voc_baseline: prometheus.NewDesc(
+			prometheus.BuildFQName("awair", "", "voc_baseline"),
+			"A unitless value that represents the baseline from which the TVOC sensor partially derives its TVOC output.",
+			labels(
+				"device_uuid",
+			),
+			nil,
+		),
+		voc_h2_raw: prometheus.NewDesc(
+			prometheus.BuildFQName("awair", "", "voc_h2_raw"),
+			"A unitless value that represents the Hydrogen gas signal from which the TVOC sensor partially derives its TVOC output.",
+			labels(
+				"device_uuid",
+			),
+			nil,
+		),
+		voc_ethanol_raw: prometheus.NewDesc(
+			prometheus.BuildFQName("awair", "", "voc_ethanol_raw"),
+			"A unitless value that represents the Ethanol gas signal from which the TVOC sensor partially derives its TVOC output.",
+			labels(
+				"device_uuid",
+			),
+			nil,
+		),
+		pm25: prometheus.NewDesc(
+			prometheus.BuildFQName("awair", "", "pm25"),
+			"Particulate matter less than 2.5 microns in diameter (µg/m³)",
+			labels(
+				"device_uuid",
+			),
+			nil,
+		),
+		pm10: prometheus.NewDesc(
+			prometheus.BuildFQName("awair", "", "pm10"),
+			"Estimated particulate matter less than 10 microns in diameter (µg/m³ - calculated by the PM2.5 sensor)",
+			labels(
+				"device_uuid",
+			),
+			nil,
+		),
+		illuminance: prometheus.NewDesc(
+			prometheus.BuildFQName("awair", "illuminance", "lux"),
+			"Ambient light (lux)",
+			labels(
+				"device_uuid",
+			),
+			nil,
+		),
+		sound_level: prometheus.NewDesc(
+			prometheus.BuildFQName("awair", "sound_level", "db"),
+			"A-weighted sound pressure level (dBA)",
+			labels(
+				"device_uuid",
+			),
+			nil,
+		),
+		device_time_offset: prometheus.NewDesc(
+			prometheus.BuildFQName("awair", "", "device_time_offset_seconds"),
+			"Difference between the device clock (timestamp of the latest reading) and the exporter clock (s)",
+			labels(
+				"device_uuid",
+			),
+			nil,
+		),
+		info: prometheus.NewDesc(
+			prometheus.BuildFQName("awair", "", "device_info"),
+			"Info about the awair device",
+			labels(
+				"device_uuid",
+				"firmware_version",
+				"model",
+				"voc_feature_set",
+			),
+			nil,
+		),
+		payload_schema: prometheus.NewDesc(
+			prometheus.BuildFQName("awair", "payload", "schema"),
+			"Layout of the device's air-data payload detected, by the firmware generation using it",
+			labels(
+				"device_uuid",
+				"schema",
+			),
+			nil,
+		),
+		led_brightness: prometheus.NewDesc(
+			prometheus.BuildFQName("awair", "led", "brightness"),
+			"Brightness of the device's LEDs as configured",
+			labels(
+				"device_uuid",
+			),
+			nil,
+		),
+		led_info: prometheus.NewDesc(
+			prometheus.BuildFQName("awair", "led", "info"),
+			"LED mode of the device, e.g. auto, manual or sleep",
+			labels(
+				"device_uuid",
+				"mode",
+			),
+			nil,
+		),
+		display_mode: prometheus.NewDesc(
+			prometheus.BuildFQName("awair", "display", "mode"),
+			"Whether the device's display shows the given reading, score or clock (1) or not (0)",
+			labels(
+				"device_uuid",
+				"mode",
+			),
+			nil,
+		),
+		battery_percent: prometheus.NewDesc(
+			prometheus.BuildFQName("awair", "battery", "percent"),
+			"Charge of the device's battery (%)",
+			labels(
+				"device_uuid",
+			),
+			nil,
+		),
+		battery_voltage: prometheus.NewDesc(
+			prometheus.BuildFQName("awair", "battery", "voltage"),
+			"Voltage of the device's battery (V)",
+			labels(
+				"device_uuid",
+			),
+			nil,
+		),
+		power_plugged: prometheus.NewDesc(
+			prometheus.BuildFQName("awair", "power", "plugged"),
+			"Whether the device is plugged into power (1) or runs on its battery (0)",
+			labels(
+				"device_uuid",
+			),
+			nil,
+		),
+	}
+}
+
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	ch <- m.score
+	ch <- m.dew_point
+	ch <- m.temp
+	ch <- m.humidity
+	ch <- m.abs_humidity
+	ch <- m.abs_humidity_error
+	ch <- m.co2
+	ch <- m.co2_estimated
+	ch <- m.co2_estimate_baseline
+	ch <- m.voc
+	ch <- m.voc_baseline
+	ch <- m.voc_h2_raw
+	ch <- m.voc_ethanol_raw
+	ch <- m.pm25
+	ch <- m.pm10
+	ch <- m.illuminance
+	ch <- m.sound_level
+	ch <- m.device_time_offset
+	ch <- m.info
+	ch <- m.payload_schema
+	ch <- m.led_brightness
+	ch <- m.led_info
+	ch <- m.display_mode
+	ch <- m.battery_percent
+	ch <- m.battery_voltage
+	ch <- m.power_plugged
+}
+
+// Collect emits the series for a single device reading. extraLabelValues
+// must match the extra labels the Metrics were created with.
+func (m *Metrics) Collect(ch chan<- prometheus.Metric, values *AwairValues, config *ConfigResponse, extraLabelValues ...string) {
+	m.CollectAt(ch, time.Now(), values, config, extraLabelValues...)
+}
+
+// CollectAt is Collect of a reading fetched at the given time, against
+// which the device clock offset is measured rather than the time of the
+// scrape, e.g. for cached or polled readings.
+func (m *Metrics) CollectAt(ch chan<- prometheus.Metric, at time.Time, values *AwairValues, config *ConfigResponse, extraLabelValues ...string) {
+	labels := func(names ...string) []string {
+		return append(names, extraLabelValues...)
+	}
+	// Devices only report the sensors of their model, the others as 0 or
+	// not at all.
+	model := ModelOf(config.DeviceUUID)
+	gauge := func(desc *prometheus.Desc, field string, value float64) {
+		if model.Has(field) && values.Has(field) {
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value, labels(config.DeviceUUID)...)
+		}
+	}
+	gauge(m.score, "score", values.Score)
+	gauge(m.dew_point, "dew_point", values.DewPoint)
+	gauge(m.temp, "temp", values.Temp)
+	gauge(m.humidity, "humid", values.Humidity)
+	gauge(m.abs_humidity, "abs_humid", values.AbsHumidity)
+	// A sanity check on the firmware's calculation, which may change
+	// across versions. Firmware not reporting it is skipped.
+	if values.AbsHumidity > 0 {
+		gauge(m.abs_humidity_error, "abs_humid", values.AbsHumidity-AbsoluteHumidity(values.Temp, values.Humidity))
+	}
+	gauge(m.co2, "co2", values.CO2)
+	gauge(m.co2_estimated, "co2_est", values.CO2Est)
+	gauge(m.co2_estimate_baseline, "co2_est_baseline", values.CO2EstBaseline)
+	gauge(m.voc, "voc", values.Voc)
+	gauge(m.voc_baseline, "voc_baseline", values.VocBaseline)
+	gauge(m.voc_h2_raw, "voc_h2_raw", values.VocH2Raw)
+	gauge(m.voc_ethanol_raw, "voc_ethanol_raw", values.VocEthanolRaw)
+	gauge(m.pm25, "pm25", values.PM25)
+	gauge(m.pm10, "pm10_est", values.PM10Est)
+	if values.Lux != nil {
+		gauge(m.illuminance, "lux", *values.Lux)
+	}
+	if values.SPLA != nil {
+		gauge(m.sound_level, "spl_a", *values.SPLA)
+	}
+	if ts, err := time.Parse(time.RFC3339Nano, values.Timestamp); err == nil {
+		ch <- prometheus.MustNewConstMetric(
+			m.device_time_offset, prometheus.GaugeValue, ts.Sub(at).Seconds(), labels(config.DeviceUUID)...,
+		)
+	}
+	ch <- prometheus.MustNewConstMetric(
+		m.info, prometheus.GaugeValue, 1,
+		labels(
+			config.DeviceUUID,
+			config.FirmwareVersion,
+			ModelName(config.DeviceUUID),
+			strconv.Itoa(config.VocFeatureSet),
+		)...,
+	)
+	// Readings of the Cloud API or converted by sinks have no schema.
+	if values.Schema != "" {
+		ch <- prometheus.MustNewConstMetric(
+			m.payload_schema, prometheus.GaugeValue, 1, labels(config.DeviceUUID, values.Schema)...,
+		)
+	}
+	// Only the Local API reports the LED settings.
+	if config.LED.Mode != "" {
+		ch <- prometheus.MustNewConstMetric(
+			m.led_brightness, prometheus.GaugeValue, float64(config.LED.Brightness), labels(config.DeviceUUID)...,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			m.led_info, prometheus.GaugeValue, 1, labels(config.DeviceUUID, config.LED.Mode)...,
+		)
+	}
+	if config.Display != "" {
+		known := false
+		for _, mode := range displayModes {
+			value := 0.0
+			if mode == config.Display {
+				value, known = 1, true
+			}
+			ch <- prometheus.MustNewConstMetric(
+				m.display_mode, prometheus.GaugeValue, value, labels(config.DeviceUUID, mode)...,
+			)
+		}
+		if !known {
+			ch <- prometheus.MustNewConstMetric(
+				m.display_mode, prometheus.GaugeValue, 1, labels(config.DeviceUUID, config.Display)...,
+			)
+		}
+	}
+}
+
+// AbsoluteHumidity computes the absolute humidity in g/m³ from the
+// temperature in °C and the relative humidity in %, using the Magnus
+// formula for the saturation vapour pressure.
+func AbsoluteHumidity(temp, humidity float64) float64 {
+	saturation := 6.112 * math.Exp(17.67*temp/(temp+243.5))
+	return saturation * humidity * 2.1674 / (273.15 + temp)
+}
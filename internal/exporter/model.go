@@ -0,0 +1,78 @@
+package exporter
+
+import (
+	"strings"
+)
+
+// Model is a device model and the sensors it has.
+type Model struct {
+	// Name is the prefix of the device UUIDs of the model, e.g.
+	// awair-element.
+	Name string
+	// Sensors are the sensors of the model: temp, humid, co2, voc, pm25,
+	// lux and spl_a.
+	Sensors []string
+	// Battery is set for models with a battery, whose power status is
+	// queried along with their readings.
+	Battery bool
+}
+
+// Models are the known device models.
+var Models = []Model{
+	{Name: "awair-element", Sensors: []string{"temp", "humid", "co2", "voc", "pm25"}},
+	{Name: "awair-omni", Sensors: []string{"temp", "humid", "co2", "voc", "pm25", "lux", "spl_a"}, Battery: true},
+	{Name: "awair-mint", Sensors: []string{"temp", "humid", "voc", "pm25", "lux"}},
+	{Name: "awair-r2", Sensors: []string{"temp", "humid", "co2", "voc", "pm25"}},
+	{Name: "awair-glow-c", Sensors: []string{"temp", "humid", "voc"}},
+}
+
+// fieldSensors maps the fields of a reading to the sensor they are taken
+// or derived from.
+var fieldSensors = map[string]string{
+	"dew_point":        "humid",
+	"abs_humid":        "humid",
+	"co2_est":          "voc",
+	"co2_est_baseline": "voc",
+	"voc_baseline":     "voc",
+	"voc_h2_raw":       "voc",
+	"voc_ethanol_raw":  "voc",
+	"pm10_est":         "pm25",
+}
+
+// ModelName returns the model of a device from its UUID, e.g.
+// awair-element for awair-element_1234.
+func ModelName(deviceUUID string) string {
+	name, _, _ := strings.Cut(deviceUUID, "_")
+	return name
+}
+
+// ModelOf returns the model of the device with the UUID, or nil for
+// devices of unknown models.
+func ModelOf(deviceUUID string) *Model {
+	name := ModelName(deviceUUID)
+	for i := range Models {
+		if Models[i].Name == name {
+			return &Models[i]
+		}
+	}
+	return nil
+}
+
+// Has reports whether the model has the sensor a field of a reading is
+// taken from, e.g. co2. Devices of unknown models are assumed to have all
+// sensors, the score doesn't need any.
+func (m *Model) Has(field string) bool {
+	if m == nil || field == "score" {
+		return true
+	}
+	sensor, ok := fieldSensors[field]
+	if !ok {
+		sensor = field
+	}
+	for _, s := range m.Sensors {
+		if s == sensor {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,204 @@
+package exporter
+
+import (
+	"context"
+	"time"
+
+	"prometheus-awair-exporter/internal/recovery"
+)
+
+// sample is a single reading captured by the background poller.
+type sample struct {
+	values *AwairValues
+	config *ConfigResponse
+	at     time.Time
+	// fallback is set if the reading was taken from the fallback.
+	fallback bool
+}
+
+// WithPollInterval enables a background poller which queries the device
+// every interval. Collect then serves the most recent polled sample instead
+// of querying the device on every scrape.
+func WithPollInterval(interval time.Duration) Option {
+	return func(e *AwairExporter) {
+		e.pollInterval = interval
+	}
+}
+
+// IntervalOverride changes the poll interval at runtime, e.g. polling more
+// often during a smoke event.
+type IntervalOverride interface {
+	// PollInterval returns the interval to poll at instead of normal.
+	PollInterval(normal time.Duration) time.Duration
+}
+
+// WithIntervalOverride lets o change the poll interval after every poll.
+// It has no effect without a poll interval.
+func WithIntervalOverride(o IntervalOverride) Option {
+	return func(e *AwairExporter) {
+		e.intervalOverride = o
+	}
+}
+
+// nextPollInterval returns how long to wait for the next poll.
+func (e *AwairExporter) nextPollInterval() time.Duration {
+	if e.intervalOverride == nil {
+		return e.pollInterval
+	}
+	if interval := e.intervalOverride.PollInterval(e.pollInterval); interval > 0 {
+		return interval
+	}
+	return e.pollInterval
+}
+
+// WithFreshness sets how long a sample queried on scrape is served from
+// cache before the device is queried again, e.g. 10s, as the device only
+// refreshes its local data every ~10 seconds. Caching is disabled by
+// default, as cached samples carry their timestamps.
+func WithFreshness(freshness time.Duration) Option {
+	return func(e *AwairExporter) {
+		e.freshness = freshness
+	}
+}
+
+// Publisher receives the readings captured by each background poll.
+type Publisher interface {
+	Publish(readings []Reading)
+}
+
+// WithPublisher hands every polled sample to p, e.g. to feed push sinks.
+func WithPublisher(p Publisher) Option {
+	return func(e *AwairExporter) {
+		e.publisher = p
+	}
+}
+
+// Poll queries the device every poll interval until ctx is cancelled. It
+// returns immediately if no poll interval is configured. With a watchdog,
+// a poll loop which stops making progress is cancelled and restarted.
+func (e *AwairExporter) Poll(ctx context.Context) {
+	if e.pollInterval <= 0 {
+		return
+	}
+	if e.watchdogIntervals <= 0 {
+		e.pollLoop(ctx)
+		return
+	}
+	e.superviseLoop(ctx)
+}
+
+func (e *AwairExporter) pollLoop(ctx context.Context) {
+	for {
+		e.safePoll(ctx)
+		e.progressed()
+		timer := time.NewTimer(e.nextPollInterval())
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// WithRecoverer reports panics of the poller and collector through r,
+// keeping the exporter alive.
+func WithRecoverer(r *recovery.Recoverer) Option {
+	return func(e *AwairExporter) {
+		e.recoverer = r
+	}
+}
+
+func (e *AwairExporter) safePoll(ctx context.Context) {
+	defer e.recoverer.Recover("poller", map[string]string{"hostname": e.hostname})
+	e.poll(ctx)
+}
+
+func (e *AwairExporter) poll(ctx context.Context) {
+	s, shared := e.fetchSample(ctx)
+	if ctx.Err() != nil {
+		return
+	}
+	e.mu.Lock()
+	e.pollFailed = s.values == nil || s.config == nil
+	if !e.pollFailed {
+		e.latest = s
+	}
+	e.generation++
+	e.changed = time.Now()
+	e.mu.Unlock()
+	if s.values == nil || s.config == nil {
+		return
+	}
+	if shared {
+		// The replica which queried the device observes and publishes it.
+		return
+	}
+	e.scoreSamples.WithLabelValues(s.config.DeviceUUID).Observe(s.values.Score)
+	e.logger().Debug().
+		Float64("score", s.values.Score).
+		Msg("Polled Awair device.")
+	if e.publisher != nil {
+		e.publisher.Publish(e.Readings())
+	}
+}
+
+// LastModified returns when the background poller or ingestion last
+// changed what is exposed of the device, a failed poll included, and a
+// version of it. It returns zero while the device is queried on scrape,
+// as its data may change on every request.
+func (e *AwairExporter) LastModified() (time.Time, uint64) {
+	if e.pollInterval <= 0 && !e.ingested {
+		return time.Time{}, 0
+	}
+	e.mu.RLock()
+	latest, changed, version := e.latest, e.changed, e.generation<<1
+	e.mu.RUnlock()
+	if latest == nil {
+		// Scrapes query the device until a poll succeeds.
+		return time.Time{}, 0
+	}
+	if e.stale(latest) {
+		// A sample going stale changes what is exposed without a poll.
+		version |= 1
+		if at := latest.at.Add(e.staleAfter()); at.After(changed) && at.Before(time.Now()) {
+			changed = at
+		}
+	}
+	return changed, version
+}
+
+// sample returns the latest polled sample, falling back to querying the
+// device directly when polling is disabled or hasn't succeeded yet. Without
+// polling, a queried sample is reused while it is still fresh and reported
+// as cached, as are samples taken from replicas through the shared cache.
+// Querying the device is abandoned once ctx is done.
+func (e *AwairExporter) sample(ctx context.Context) (s *sample, cached bool) {
+	e.mu.RLock()
+	latest := e.latest
+	e.mu.RUnlock()
+	if e.ingested {
+		if latest == nil {
+			return &sample{}, false
+		}
+		return latest, false
+	}
+	if latest != nil && e.pollInterval > 0 {
+		return latest, false
+	}
+	if latest != nil && time.Since(latest.at) < e.freshness {
+		e.cachedScrapes.Inc()
+		return latest, true
+	}
+
+	s, shared := e.fetchSample(ctx)
+	if shared {
+		e.cachedScrapes.Inc()
+	}
+	if s.values != nil && s.config != nil && e.pollInterval <= 0 {
+		e.mu.Lock()
+		e.latest = s
+		e.mu.Unlock()
+	}
+	return s, shared
+}
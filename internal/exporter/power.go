@@ -0,0 +1,62 @@
+package exporter
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// PowerStatus is the response of the power-status endpoint of devices with
+// a battery, the Awair Omni.
+type PowerStatus struct {
+	Battery float64 `json:"battery"`
+	Voltage float64 `json:"voltage"`
+	Plugged bool    `json:"plugged"`
+}
+
+// GetPowerStatusContext retrieves the battery and power supply state of
+// the device.
+func (e *AwairExporter) GetPowerStatusContext(ctx context.Context) (*PowerStatus, error) {
+	body, err := e.get(ctx, "power-status", "/settings/power-status")
+	if err != nil {
+		return nil, err
+	}
+	status := PowerStatus{}
+	if err := json.Unmarshal(body, &status); err != nil {
+		return nil, e.countError(&DecodeError{Endpoint: "power-status", Err: err})
+	}
+	if e.strict {
+		e.checkUnknownFields("power-status", body, &status)
+	}
+	return &status, nil
+}
+
+// fetchPower updates the power status of devices with a battery. A failure
+// leaves out the power series rather than failing the reading.
+func (e *AwairExporter) fetchPower(ctx context.Context) {
+	if model := ModelOf(e.DeviceUUID()); e.skipPower || model == nil || !model.Battery {
+		return
+	}
+	status, err := e.GetPowerStatusContext(ctx)
+	if err != nil {
+		e.logger().Warn().Err(err).
+			Str("class", ErrorClass(err)).
+			Msg("Error retrieving power status from device")
+	}
+	e.mu.Lock()
+	e.power = status
+	e.mu.Unlock()
+}
+
+// CollectPower emits the series of a power status, labelled like Collect.
+func (m *Metrics) CollectPower(ch chan<- prometheus.Metric, status *PowerStatus, config *ConfigResponse, extraLabelValues ...string) {
+	labels := append([]string{config.DeviceUUID}, extraLabelValues...)
+	plugged := 0.0
+	if status.Plugged {
+		plugged = 1
+	}
+	ch <- prometheus.MustNewConstMetric(m.battery_percent, prometheus.GaugeValue, status.Battery, labels...)
+	ch <- prometheus.MustNewConstMetric(m.battery_voltage, prometheus.GaugeValue, status.Voltage, labels...)
+	ch <- prometheus.MustNewConstMetric(m.power_plugged, prometheus.GaugeValue, plugged, labels...)
+}
@@ -0,0 +1,45 @@
+package exporter
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// probe serves a single sample taken by Probe.
+type probe struct {
+	e *AwairExporter
+	s *sample
+}
+
+// Probe queries the device at hostname once, returning a collector serving
+// that reading, for multi-target scrapes naming the device in the request
+// instead of binding the exporter to it at startup.
+func Probe(ctx context.Context, hostname string, opts ...Option) (prometheus.Collector, error) {
+	e := newAwairExporter(hostname, opts...)
+	// The config selects the firmware profile the readings are parsed with.
+	config, err := e.GetConfigContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	values, err := e.GetMetricsContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &probe{e: e, s: &sample{values: values, config: config, at: time.Now()}}, nil
+}
+
+func (p *probe) Describe(ch chan<- *prometheus.Desc) {
+	p.e.metrics.Describe(ch)
+	for _, d := range p.e.derived {
+		d.Describe(ch)
+	}
+	p.e.unknownFields.Describe(ch)
+}
+
+func (p *probe) Collect(ch chan<- prometheus.Metric) {
+	defer p.e.recoverer.Recover("collector", map[string]string{"hostname": p.e.hostname})
+	p.e.collectSample(ch, p.s, false)
+	p.e.unknownFields.Collect(ch)
+}
@@ -0,0 +1,109 @@
+package exporter
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// firmwareProfile describes how the air-data payload of a range of firmware
+// releases maps onto AwairValues. Its name is the schema of the payload
+// exposed as awair_payload_schema.
+type firmwareProfile struct {
+	name string
+	// minVersion is the first firmware release (inclusive) the profile applies to.
+	minVersion []int
+	// aliases maps field names returned by the device to the names expected
+	// by AwairValues.
+	aliases map[string]string
+}
+
+// firmwareProfiles are ordered newest first; the first profile whose
+// minVersion is satisfied is used. Only the layout of the Local API's
+// documentation is known; profiles of other layouts are added along with
+// a payload captured from a device using them.
+var firmwareProfiles = []firmwareProfile{
+	{
+		name:       "current",
+		minVersion: []int{1, 0, 0},
+	},
+}
+
+// parseFirmwareVersion parses a dotted firmware version such as "1.2.8".
+func parseFirmwareVersion(v string) ([]int, bool) {
+	if v == "" {
+		return nil, false
+	}
+	parts := strings.Split(v, ".")
+	version := make([]int, len(parts))
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			return nil, false
+		}
+		version[i] = n
+	}
+	return version, true
+}
+
+func versionAtLeast(v, min []int) bool {
+	for i := range min {
+		n := 0
+		if i < len(v) {
+			n = v[i]
+		}
+		if n != min[i] {
+			return n > min[i]
+		}
+	}
+	return true
+}
+
+// profileFor returns the profile matching firmware, or nil when the version
+// is unknown, in which case the permissive decoder should be used.
+func profileFor(firmware string) *firmwareProfile {
+	v, ok := parseFirmwareVersion(firmware)
+	if !ok {
+		return nil
+	}
+	for i := range firmwareProfiles {
+		if versionAtLeast(v, firmwareProfiles[i].minVersion) {
+			return &firmwareProfiles[i]
+		}
+	}
+	return nil
+}
+
+// detectProfile returns the profile of the layout of an air-data payload.
+// Fields only a profile's aliases name identify it regardless of the
+// firmware, as devices may report a release whose layout they don't use.
+// Otherwise the profile of the firmware is used, or the current one if it
+// is unknown.
+func detectProfile(raw map[string]json.RawMessage, firmware *firmwareProfile) *firmwareProfile {
+	for i := range firmwareProfiles {
+		for from := range firmwareProfiles[i].aliases {
+			if _, ok := raw[from]; ok {
+				return &firmwareProfiles[i]
+			}
+		}
+	}
+	if firmware != nil {
+		return firmware
+	}
+	return &firmwareProfiles[0]
+}
+
+// normalize rewrites aliased fields in raw to their canonical names. Fields
+// already present under their canonical name take precedence.
+func (p *firmwareProfile) normalize(raw map[string]json.RawMessage) {
+	for from, to := range p.aliases {
+		value, ok := raw[from]
+		if !ok {
+			continue
+		}
+		delete(raw, from)
+		if _, exists := raw[to]; !exists {
+			raw[to] = value
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package exporter
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultResolveInterval is how long the addresses of a device's hostname
+// are used before it is resolved again.
+const DefaultResolveInterval = time.Minute
+
+// WithResolveInterval resolves the hostname of the device again before a
+// request once interval passed since it was last resolved, so a device is
+// followed to the address a new DHCP lease gives it. Zero resolves it before
+// every request. Devices given by IP address aren't affected.
+func WithResolveInterval(interval time.Duration) Option {
+	return func(e *AwairExporter) {
+		e.resolveInterval = interval
+	}
+}
+
+// resolver dials the addresses a hostname resolved to, resolving it again
+// when they are older than interval or a request failed.
+type resolver struct {
+	host      string
+	port      string
+	interval  time.Duration
+	lookup    func(ctx context.Context, host string) ([]string, error)
+	transport *http.Transport
+	// logger returns the logger of the device.
+	logger func() *zerolog.Logger
+
+	mu       sync.Mutex
+	addrs    []string
+	resolved time.Time
+}
+
+// newResolver returns a resolver for hostname, with or without a port, or
+// nil if it is an IP address.
+func newResolver(hostname string, interval time.Duration) *resolver {
+	host, port, err := net.SplitHostPort(hostname)
+	if err != nil {
+		host, port = hostname, "80"
+	}
+	if net.ParseIP(host) != nil {
+		return nil
+	}
+	r := &resolver{
+		host:     host,
+		port:     port,
+		interval: interval,
+		lookup:   net.DefaultResolver.LookupHost,
+		logger:   func() *zerolog.Logger { return &log.Logger },
+	}
+	r.transport = http.DefaultTransport.(*http.Transport).Clone()
+	r.transport.DialContext = r.dial
+	return r
+}
+
+// addresses returns the addresses of the host, resolving it if they are
+// outdated. When they change, idle connections to the old ones are closed.
+// When resolving fails, the previous addresses are kept.
+func (r *resolver) addresses(ctx context.Context) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.addrs != nil && time.Since(r.resolved) < r.interval {
+		return r.addrs, nil
+	}
+	addrs, err := r.lookup(ctx, r.host)
+	if err != nil {
+		if r.addrs != nil {
+			r.logger().Warn().Err(err).Msg("Failed to resolve device, keeping its previous addresses.")
+			return r.addrs, nil
+		}
+		return nil, err
+	}
+	if r.addrs != nil && !sameAddrs(r.addrs, addrs) {
+		r.logger().Info().
+			Strs("previous", r.addrs).
+			Strs("addresses", addrs).
+			Msg("Device resolves to new addresses.")
+		r.transport.CloseIdleConnections()
+	}
+	r.addrs, r.resolved = addrs, time.Now()
+	return addrs, nil
+}
+
+// expire resolves the host again before the next request.
+func (r *resolver) expire() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.resolved = time.Time{}
+}
+
+func (r *resolver) dial(ctx context.Context, network, _ string) (net.Conn, error) {
+	addrs, err := r.addresses(ctx)
+	if err != nil {
+		return nil, err
+	}
+	dialer := net.Dialer{}
+	for _, addr := range addrs {
+		var conn net.Conn
+		if conn, err = dialer.DialContext(ctx, network, net.JoinHostPort(addr, r.port)); err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+func sameAddrs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,82 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tj/assert"
+)
+
+func TestResolver(t *testing.T) {
+	serve := func(addr string, score int) *httptest.Server {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			t.Skipf("can't listen on %s: %v", addr, err)
+		}
+		srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, `{"score": %d}`, score)
+		}))
+		srv.Listener = ln
+		srv.Start()
+		t.Cleanup(srv.Close)
+		return srv
+	}
+	first := serve("127.0.0.1:0", 80)
+	_, port, _ := net.SplitHostPort(first.Listener.Addr().String())
+	serve("127.0.0.2:"+port, 90)
+
+	var mu sync.Mutex
+	addr, lookups := "127.0.0.1", 0
+	setAddr := func(a string) {
+		mu.Lock()
+		defer mu.Unlock()
+		addr = a
+	}
+	e := newAwairExporter("awair-elem-1416DC.test:"+port, WithResolveInterval(time.Hour))
+	require.NotNil(t, e.resolver)
+	e.resolver.lookup = func(ctx context.Context, host string) ([]string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		lookups++
+		if addr == "" {
+			return nil, errors.New("no such host")
+		}
+		return []string{addr}, nil
+	}
+
+	values, err := e.GetMetrics()
+	require.Nil(t, err)
+	assert.Equal(t, float64(80), values.Score)
+
+	// Within the interval, the previous addresses are used.
+	setAddr("127.0.0.2")
+	values, err = e.GetMetrics()
+	require.Nil(t, err)
+	assert.Equal(t, float64(80), values.Score)
+	assert.Equal(t, 1, lookups)
+
+	// Once it passed, connections move to the new address.
+	e.resolver.expire()
+	values, err = e.GetMetrics()
+	require.Nil(t, err)
+	assert.Equal(t, float64(90), values.Score)
+
+	// Failing to resolve keeps the previous addresses.
+	setAddr("")
+	e.resolver.expire()
+	values, err = e.GetMetrics()
+	require.Nil(t, err)
+	assert.Equal(t, float64(90), values.Score)
+	assert.Equal(t, 3, lookups)
+
+	assert.Nil(t, newAwairExporter("192.168.1.2").resolver)
+	assert.Nil(t, newAwairExporter("[::1]:8080").resolver)
+}
@@ -0,0 +1,141 @@
+package exporter
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// SharedCache stores the latest sample of each device for exporter replicas
+// behind a load balancer, so they serve consistent data and only one of
+// them queries each device.
+type SharedCache interface {
+	// Get returns the value of key, reporting false if it doesn't exist.
+	Get(key string) ([]byte, bool, error)
+	// Set sets key to value, expiring after ttl.
+	Set(key string, value []byte, ttl time.Duration) error
+	// Lock sets key if it doesn't exist, reporting whether it did so.
+	Lock(key string, ttl time.Duration) (bool, error)
+}
+
+// WithSharedCache shares samples with other replicas through c.
+func WithSharedCache(c SharedCache) Option {
+	return func(e *AwairExporter) {
+		e.shared = c
+	}
+}
+
+// sharedSample is a sample as stored in the SharedCache.
+type sharedSample struct {
+	Values   *AwairValues    `json:"values"`
+	Config   *ConfigResponse `json:"config"`
+	At       time.Time       `json:"at"`
+	Fallback bool            `json:"fallback,omitempty"`
+	// Absent and Schema aren't encoded with Values.
+	Absent []string `json:"absent,omitempty"`
+	Schema string   `json:"schema,omitempty"`
+}
+
+// sharedWait bounds how long a replica waits for the one holding the lock
+// to store a new sample before querying the device itself.
+const sharedWait = 2 * time.Second
+
+// fetchSample queries the device, or with a shared cache, takes the sample
+// another replica stored while it is still fresh. It reports whether the
+// sample came from another replica.
+func (e *AwairExporter) fetchSample(ctx context.Context) (s *sample, shared bool) {
+	window := e.pollInterval
+	if window <= 0 {
+		window = e.freshness
+	}
+	if e.shared == nil || window <= 0 {
+		return e.fetchNow(ctx), false
+	}
+	logger := e.logger()
+
+	if s := e.sharedSample(window); s != nil {
+		return s, true
+	}
+	locked, err := e.shared.Lock("lock:"+e.hostname, window)
+	if err != nil {
+		logger.Warn().Err(err).Msg("Failed to lock shared cache, querying device.")
+		return e.fetchNow(ctx), false
+	}
+	if !locked {
+		// Another replica is querying the device, wait for its sample.
+		deadline := time.Now().Add(sharedWait)
+		for time.Now().Before(deadline) {
+			time.Sleep(100 * time.Millisecond)
+			if s := e.sharedSample(window); s != nil {
+				return s, true
+			}
+		}
+		logger.Warn().Msg("No sample was shared in time, querying device.")
+		return e.fetchNow(ctx), false
+	}
+
+	s = e.fetchNow(ctx)
+	if s.values == nil || s.config == nil {
+		return s, false
+	}
+	body, err := json.Marshal(sharedSample{
+		Values:   s.values,
+		Config:   s.config,
+		At:       s.at,
+		Fallback: s.fallback,
+		Absent:   s.values.Absent,
+		Schema:   s.values.Schema,
+	})
+	if err == nil {
+		err = e.shared.Set("sample:"+e.hostname, body, window)
+	}
+	if err != nil {
+		logger.Warn().Err(err).Msg("Failed to store sample in shared cache.")
+	}
+	return s, false
+}
+
+// sharedSample returns the sample stored by any replica if it is younger
+// than window.
+func (e *AwairExporter) sharedSample(window time.Duration) *sample {
+	body, ok, err := e.shared.Get("sample:" + e.hostname)
+	if err != nil {
+		e.logger().Warn().Err(err).Msg("Failed to read shared cache.")
+		return nil
+	}
+	if !ok {
+		return nil
+	}
+	stored := sharedSample{}
+	if err := json.Unmarshal(body, &stored); err != nil {
+		e.logger().Warn().Err(err).Msg("Invalid sample in shared cache.")
+		return nil
+	}
+	if stored.Values == nil || stored.Config == nil || time.Since(stored.At) >= window {
+		return nil
+	}
+	stored.Values.Absent, stored.Values.Schema = stored.Absent, stored.Schema
+	return &sample{values: stored.Values, config: stored.Config, at: stored.At, fallback: stored.Fallback}
+}
+
+// fetchNow queries the device, or its fallback if that fails.
+func (e *AwairExporter) fetchNow(ctx context.Context) *sample {
+	start := time.Now()
+	values, config := e.fetch(ctx)
+	e.progressed()
+	fallback := false
+	if (values == nil || config == nil) && e.fallback != nil && ctx.Err() == nil {
+		values, config = e.fetchFallback(ctx)
+		fallback = true
+	}
+	e.summary.observe(time.Since(start), values != nil && config != nil)
+	if values != nil && config != nil {
+		e.lastSeen.Store(time.Now().UnixNano())
+	}
+	return &sample{
+		values:   values,
+		config:   config,
+		at:       time.Now(),
+		fallback: fallback,
+	}
+}
@@ -0,0 +1,93 @@
+package exporter
+
+import (
+	"context"
+)
+
+// Source takes the readings of a device in place of its Local API, e.g.
+// from the Awair Cloud for a device out of reach.
+type Source interface {
+	// Fetch returns the latest reading of the device. Errors which aren't
+	// a *DeviceError or *DecodeError are counted as failed requests.
+	Fetch(ctx context.Context) (*AwairValues, *ConfigResponse, error)
+}
+
+// NewSourcedExporter creates an exporter for the device with uuid whose
+// readings are taken from src. Unlike NewAwairExporter, it doesn't query
+// the device upfront.
+func NewSourcedExporter(uuid string, src Source, opts ...Option) *AwairExporter {
+	e := newAwairExporter(uuid, opts...)
+	e.source = src
+	e.deviceUUID = uuid
+	e.updateLogger(uuid, "")
+	return e
+}
+
+// fetchSource takes a reading from the source.
+func (e *AwairExporter) fetchSource(ctx context.Context) (*AwairValues, *ConfigResponse) {
+	values, config, err := e.source.Fetch(ctx)
+	if err != nil {
+		if ErrorClass(err) == "" {
+			err = newRequestError("source", err)
+		}
+		e.countError(err)
+		e.logger().Error().Err(err).
+			Str("class", ErrorClass(err)).
+			Msg("Error retrieving reading from source")
+		return nil, nil
+	}
+	e.mu.Lock()
+	e.config = config
+	if config.DeviceUUID != e.deviceUUID {
+		e.deviceUUID = config.DeviceUUID
+		e.updateLogger(e.deviceUUID, e.name)
+	}
+	e.mu.Unlock()
+	return values, config
+}
+
+// fallback takes the readings of a device whenever its Local API fails.
+type fallback struct {
+	src Source
+	// label is set to value on readings taken from src.
+	label, value string
+}
+
+// WithFallback takes the readings from src, e.g. from the Awair Cloud,
+// whenever the Local API fails, including when connecting, so a device
+// dropping off the local network keeps reporting. Readings taken from src
+// carry value as their label named label, which WithLabels must set, e.g.
+// source="cloud" rather than source="local".
+func WithFallback(src Source, label, value string) Option {
+	return func(e *AwairExporter) {
+		e.fallback = &fallback{src: src, label: label, value: value}
+	}
+}
+
+// fetchFallback takes a reading from the fallback. The config queried from
+// the device last is kept, if any, as the fallback only knows the device
+// UUID.
+func (e *AwairExporter) fetchFallback(ctx context.Context) (*AwairValues, *ConfigResponse) {
+	values, config, err := e.fallback.src.Fetch(ctx)
+	if err != nil {
+		if ErrorClass(err) == "" {
+			err = newRequestError("fallback", err)
+		}
+		e.countError(err)
+		e.logger().Error().Err(err).
+			Str("class", ErrorClass(err)).
+			Msg("Error retrieving reading from fallback")
+		return nil, nil
+	}
+	e.logger().Debug().Msg("Took reading from fallback.")
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.config != nil {
+		return values, e.config
+	}
+	if e.deviceUUID == "" {
+		e.deviceUUID = config.DeviceUUID
+		e.updateLogger(e.deviceUUID, e.name)
+	}
+	return values, config
+}
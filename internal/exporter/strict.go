@@ -0,0 +1,51 @@
+package exporter
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+// knownFields returns the set of top-level JSON keys which v maps.
+func knownFields(v interface{}) map[string]bool {
+	t := reflect.TypeOf(v)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	fields := make(map[string]bool, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[strings.ToLower(name)] = true
+	}
+	return fields
+}
+
+// checkUnknownFields logs and counts any top-level fields in body which
+// are not mapped by v. Each field is only logged once per exporter.
+func (e *AwairExporter) checkUnknownFields(endpoint string, body []byte, v interface{}) {
+	raw := map[string]json.RawMessage{}
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return
+	}
+	known := knownFields(v)
+	for field := range raw {
+		if known[strings.ToLower(field)] {
+			continue
+		}
+		e.unknownFields.WithLabelValues(endpoint, field).Inc()
+		if _, seen := e.seenUnknown.LoadOrStore(endpoint+"/"+field, true); !seen {
+			e.logger().Warn().
+				Str("endpoint", endpoint).
+				Str("field", field).
+				RawJSON("value", raw[field]).
+				Msg("Device returned a field not mapped by the exporter.")
+		}
+	}
+}
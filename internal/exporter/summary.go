@@ -0,0 +1,99 @@
+package exporter
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// summaryCounts are the outcomes of the readings of a device since a time.
+type summaryCounts struct {
+	since    time.Time
+	readings int
+	failed   int
+	latency  time.Duration
+	// errors count the errors by class.
+	errors map[string]int
+}
+
+// summary accumulates the outcomes of the readings of a device between
+// summary lines.
+type summary struct {
+	mu     sync.Mutex
+	counts summaryCounts
+}
+
+// observe counts a reading which took latency.
+func (s *summary) observe(latency time.Duration, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counts.readings++
+	s.counts.latency += latency
+	if !ok {
+		s.counts.failed++
+	}
+}
+
+// countError counts an error of class.
+func (s *summary) countError(class string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.counts.errors == nil {
+		s.counts.errors = map[string]int{}
+	}
+	s.counts.errors[class]++
+}
+
+// reset returns the counts and starts over.
+func (s *summary) reset(now time.Time) summaryCounts {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counts := s.counts
+	s.counts = summaryCounts{since: now}
+	return counts
+}
+
+// logSummary logs the outcomes of the readings of the device since the
+// previous summary line, and starts over.
+func (e *AwairExporter) logSummary() {
+	counts := e.summary.reset(time.Now())
+	errors := zerolog.Dict()
+	for class, n := range counts.errors {
+		errors.Int(class, n)
+	}
+	line := e.logger().Info().
+		Time("since", counts.since).
+		Int("readings", counts.readings).
+		Int("failed", counts.failed).
+		Dict("errors", errors)
+	if counts.readings > 0 {
+		line = line.
+			Float64("success_rate", float64(counts.readings-counts.failed)/float64(counts.readings)).
+			Dur("avg_latency", counts.latency/time.Duration(counts.readings))
+	}
+	line.Msg("Device summary.")
+}
+
+// LogSummaries logs a summary line per device every interval until ctx is
+// done, with the success rate, average latency and errors by class of the
+// readings taken since the previous one, so quiet deployments still leave
+// a trail. Devices whose readings are pushed are left out.
+func (f *Fleet) LogSummaries(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, m := range f.snapshot() {
+				if !m.exporter.ingested {
+					m.exporter.logSummary()
+				}
+				m.inflight.Done()
+			}
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Wake strategies for devices on aggressive Wi-Fi power saving, which miss
+// the first request after sleeping: retrying a request which got no answer
+// once right away, or connecting to the device before every reading to wake
+// it up.
+const (
+	WakeRetry   = "retry"
+	WakePrewarm = "prewarm"
+)
+
+// prewarmTimeout bounds the connection waking a device up, whose outcome
+// doesn't matter.
+const prewarmTimeout = time.Second
+
+// CheckWake returns an error if strategy is neither empty nor a known wake
+// strategy.
+func CheckWake(strategy string) error {
+	switch strategy {
+	case "", WakeRetry, WakePrewarm:
+		return nil
+	}
+	return fmt.Errorf("unknown wake strategy %q, expected %s or %s", strategy, WakeRetry, WakePrewarm)
+}
+
+// WithWake sets the wake strategy of the device, which must pass
+// CheckWake. Without one, a request the device misses fails the reading.
+func WithWake(strategy string) Option {
+	return func(e *AwairExporter) {
+		e.wake = strategy
+	}
+}
+
+// prewarm connects to the address which answered last to wake the device
+// up before a reading, counting whether it answered.
+func (e *AwairExporter) prewarm(ctx context.Context) {
+	host := e.Address()
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, "80")
+	}
+	ctx, cancel := context.WithTimeout(ctx, prewarmTimeout)
+	defer cancel()
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", host)
+	if err != nil {
+		e.wakes.WithLabelValues(WakePrewarm, "missed").Inc()
+		e.logger().Debug().Err(err).Msg("Device missed the connection waking it up.")
+		return
+	}
+	conn.Close()
+	e.wakes.WithLabelValues(WakePrewarm, "answered").Inc()
+}
+
+// getWithRetry is getFrom, retrying a request the device got no answer to
+// once right away with the retry wake strategy.
+func (e *AwairExporter) getWithRetry(ctx context.Context, a *address, endpoint, path string) ([]byte, error) {
+	body, err := e.getFrom(ctx, a, endpoint, path)
+	if e.wake != WakeRetry || !isRequestError(err) || ctx.Err() != nil {
+		return body, err
+	}
+	e.logger().Debug().Err(err).Str("endpoint", endpoint).Msg("Device missed a request, retrying.")
+	body, err = e.getFrom(ctx, a, endpoint, path)
+	outcome := "answered"
+	if isRequestError(err) {
+		outcome = "missed"
+	}
+	e.wakes.WithLabelValues(WakeRetry, outcome).Inc()
+	return body, err
+}
+
+// isRequestError reports whether err is a failure to reach the device.
+func isRequestError(err error) bool {
+	var requestErr *RequestError
+	return errors.As(err, &requestErr)
+}
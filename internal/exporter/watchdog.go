@@ -0,0 +1,64 @@
+package exporter
+
+import (
+	"context"
+	"time"
+)
+
+// DefaultWatchdogIntervals is the number of poll intervals without a
+// completed poll after which the poll loop is restarted.
+const DefaultWatchdogIntervals = 3
+
+// WithWatchdog restarts the poll loop when it made no progress, finishing
+// neither a poll nor a request to the device, within intervals poll
+// intervals plus the request timeout, e.g. as a poll hangs past its
+// requests. Zero disables the watchdog.
+func WithWatchdog(intervals int) Option {
+	return func(e *AwairExporter) {
+		e.watchdogIntervals = intervals
+	}
+}
+
+// superviseLoop runs the poll loop until ctx is cancelled, restarting it
+// whenever it is wedged.
+func (e *AwairExporter) superviseLoop(ctx context.Context) {
+	for {
+		loopCtx, cancel := context.WithCancel(ctx)
+		e.progressed()
+		go e.pollLoop(loopCtx)
+		wedged := e.watch(loopCtx)
+		cancel()
+		if !wedged {
+			return
+		}
+		e.pollerRestarts.Inc()
+		e.logger().Warn().
+			Int("intervals", e.watchdogIntervals).
+			Msg("Poll loop made no progress, restarting it.")
+	}
+}
+
+// progressed records that the poll loop made progress.
+func (e *AwairExporter) progressed() {
+	e.lastProgress.Store(time.Now().UnixNano())
+}
+
+// watch reports true once the poll loop made no progress for the watchdog
+// intervals, or false once ctx is cancelled. As every request finished is
+// progress, a slow but healthy poll may make as many requests as it needs,
+// across addresses and retries, each taking up to the request timeout.
+func (e *AwairExporter) watch(ctx context.Context) bool {
+	deadline := time.Duration(e.watchdogIntervals)*e.pollInterval + e.client.Timeout
+	ticker := time.NewTicker(e.pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+			if time.Since(time.Unix(0, e.lastProgress.Load())) > deadline {
+				return true
+			}
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package exposition
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/rs/zerolog/log"
+)
+
+// Budget guards the series gathered from a Gatherer against cardinality
+// explosions, e.g. through per-device labels on a large fleet. It is
+// itself a collector of the series it emitted and dropped.
+type Budget struct {
+	g prometheus.Gatherer
+	// maxSeries caps the series gathered, unless 0.
+	maxSeries int
+	// maxLabelValues is the number of distinct values of a label above
+	// which a warning is logged, unless 0.
+	maxLabelValues int
+
+	mu      sync.Mutex
+	emitted int
+	// over are the labels whose values currently exceed the budget.
+	over map[string]bool
+
+	emittedDesc *prometheus.Desc
+	dropped     prometheus.Counter
+}
+
+// NewBudget returns a Budget gathering from g at most maxSeries series and
+// warning about labels with more than maxLabelValues distinct values. 0
+// disables either.
+func NewBudget(g prometheus.Gatherer, maxSeries, maxLabelValues int) *Budget {
+	return &Budget{
+		g:              g,
+		maxSeries:      maxSeries,
+		maxLabelValues: maxLabelValues,
+		over:           map[string]bool{},
+		emittedDesc: prometheus.NewDesc(
+			prometheus.BuildFQName("awair", "", "series_emitted"),
+			"Number of series served by the latest exposition",
+			nil, nil,
+		),
+		dropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "awair",
+			Name:      "series_dropped_total",
+			Help:      "Number of series left out of expositions as they exceeded the series limit",
+		}),
+	}
+}
+
+// Gather gathers the metric families of g. Families which would take the
+// series beyond the limit are left out whole, so no family is served
+// incomplete.
+func (b *Budget) Gather() ([]*dto.MetricFamily, error) {
+	mfs, err := b.g.Gather()
+	kept := mfs[:0]
+	emitted, dropped := 0, 0
+	values := map[string]map[string]bool{}
+	for _, mf := range mfs {
+		n := len(mf.GetMetric())
+		if b.maxSeries > 0 && emitted+n > b.maxSeries {
+			dropped += n
+			continue
+		}
+		emitted += n
+		kept = append(kept, mf)
+		if b.maxLabelValues <= 0 {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if values[l.GetName()] == nil {
+					values[l.GetName()] = map[string]bool{}
+				}
+				values[l.GetName()][l.GetValue()] = true
+			}
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.emitted = emitted
+	if dropped > 0 {
+		b.dropped.Add(float64(dropped))
+		log.Warn().
+			Int("limit", b.maxSeries).
+			Int("dropped", dropped).
+			Msg("Series exceed the series limit, leaving out metric families.")
+	}
+	for name := range b.over {
+		if len(values[name]) <= b.maxLabelValues {
+			delete(b.over, name)
+		}
+	}
+	for name, v := range values {
+		if len(v) > b.maxLabelValues && !b.over[name] {
+			b.over[name] = true
+			log.Warn().
+				Str("label", name).
+				Int("values", len(v)).
+				Int("budget", b.maxLabelValues).
+				Msg("Label exceeds its budget of distinct values.")
+		}
+	}
+	return kept, err
+}
+
+func (b *Budget) Describe(ch chan<- *prometheus.Desc) {
+	ch <- b.emittedDesc
+	b.dropped.Describe(ch)
+}
+
+// Collect emits the series of the previous exposition, as the current one
+// is still being gathered.
+func (b *Budget) Collect(ch chan<- prometheus.Metric) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ch <- prometheus.MustNewConstMetric(b.emittedDesc, prometheus.GaugeValue, float64(b.emitted))
+	b.dropped.Collect(ch)
+}
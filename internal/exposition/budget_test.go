@@ -0,0 +1,48 @@
+package exposition
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"github.com/tj/assert"
+)
+
+func TestBudget(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+	co2 := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "awair_co2", Help: "CO2"}, []string{"device_uuid"})
+	info := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "awair_device_info", Help: "Info"}, []string{"device_uuid", "firmware_version"})
+	for _, uuid := range []string{"awair-element_1", "awair-element_2", "awair-element_3"} {
+		co2.WithLabelValues(uuid).Set(600)
+		info.WithLabelValues(uuid, "1.2.8").Set(1)
+	}
+	reg.MustRegister(co2, info)
+
+	b := NewBudget(reg, 4, 2)
+	reg.MustRegister(b)
+	mfs, err := b.Gather()
+	require.Nil(t, err)
+	names := []string{}
+	for _, mf := range mfs {
+		names = append(names, mf.GetName())
+	}
+	// awair_device_info would exceed the limit and is left out whole.
+	assert.Equal(t, []string{"awair_co2", "awair_series_dropped_total"}, names)
+	assert.Equal(t, map[string]bool{"device_uuid": true}, b.over)
+
+	assert.Nil(t, testutil.CollectAndCompare(b, strings.NewReader(`
+# HELP awair_series_dropped_total Number of series left out of expositions as they exceeded the series limit
+# TYPE awair_series_dropped_total counter
+awair_series_dropped_total 4
+# HELP awair_series_emitted Number of series served by the latest exposition
+# TYPE awair_series_emitted gauge
+awair_series_emitted 4
+`)))
+
+	unlimited := NewBudget(reg, 0, 0)
+	mfs, err = unlimited.Gather()
+	require.Nil(t, err)
+	assert.Len(t, mfs, 4)
+}
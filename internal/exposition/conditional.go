@@ -0,0 +1,56 @@
+package exposition
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Modified reports when the data served last changed.
+type Modified interface {
+	// LastModified returns when the data served last changed, or zero if
+	// unknown, and its version, which changes with every change even
+	// within the same second.
+	LastModified() (time.Time, uint64)
+}
+
+// NewConditionalHandler answers conditional requests to h with 304 Not
+// Modified while m reports no new data since the client's copy, saving
+// bandwidth for frequent pollers. The ETag is weak, as h may serve several
+// formats.
+func NewConditionalHandler(m Modified, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		modified, version := m.LastModified()
+		if modified.IsZero() || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		etag := fmt.Sprintf(`W/"%x"`, version)
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+		w.Header().Add("Vary", "Accept")
+		if notModified(r, etag, modified) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func notModified(r *http.Request, etag string, modified time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, tag := range strings.Split(inm, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" {
+		t, err := http.ParseTime(ims)
+		return err == nil && !modified.Truncate(time.Second).After(t)
+	}
+	return false
+}
@@ -0,0 +1,62 @@
+package exposition
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tj/assert"
+)
+
+type staticModified struct {
+	at      time.Time
+	version uint64
+}
+
+func (m *staticModified) LastModified() (time.Time, uint64) {
+	return m.at, m.version
+}
+
+func TestConditionalHandler(t *testing.T) {
+	assert := assert.New(t)
+	modified := staticModified{time.Unix(1700000000, 500), 1}
+	h := NewConditionalHandler(&modified, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "awair_score 89")
+	}))
+
+	get := func(header, value string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		if header != "" {
+			r.Header.Set(header, value)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	w := get("", "")
+	assert.Equal(http.StatusOK, w.Code)
+	etag := w.Header().Get("ETag")
+	lastModified := w.Header().Get("Last-Modified")
+	assert.NotEqual("", etag)
+	assert.Equal("Tue, 14 Nov 2023 22:13:20 GMT", lastModified)
+
+	assert.Equal(http.StatusNotModified, get("If-None-Match", etag).Code)
+	assert.Equal(http.StatusNotModified, get("If-None-Match", `"other", `+etag).Code)
+	assert.Equal(http.StatusOK, get("If-None-Match", `"other"`).Code)
+	assert.Equal(http.StatusNotModified, get("If-Modified-Since", lastModified).Code)
+
+	modified.version = 2
+	assert.Equal(http.StatusOK, get("If-None-Match", etag).Code, "a change within the same second")
+
+	modified = staticModified{time.Unix(1700000010, 0), 3}
+	assert.Equal(http.StatusOK, get("If-None-Match", etag).Code)
+	assert.Equal(http.StatusOK, get("If-Modified-Since", lastModified).Code)
+
+	modified = staticModified{}
+	w = get("If-None-Match", etag)
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("", w.Header().Get("ETag"))
+}
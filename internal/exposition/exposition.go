@@ -0,0 +1,75 @@
+package exposition
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/expfmt"
+	"github.com/rs/zerolog/log"
+)
+
+// NewHandler serves the metrics gathered by g. Scrapers negotiating
+// OpenMetrics receive `_created` samples for counters and histograms, every
+// other format (including the protobuf format required for native
+// histograms) is served by promhttp.
+func NewHandler(g prometheus.Gatherer) http.Handler {
+	fallback := promhttp.HandlerFor(g, promhttp.HandlerOpts{
+		EnableOpenMetrics: true,
+	})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		format := expfmt.NegotiateIncludingOpenMetrics(r.Header)
+		if format.FormatType() != expfmt.TypeOpenMetrics {
+			fallback.ServeHTTP(w, r)
+			return
+		}
+
+		mfs, err := g.Gather()
+		if err != nil {
+			log.Error().Err(err).Msg("Error gathering metrics")
+			if len(mfs) == 0 {
+				http.Error(w, "An error has occurred while gathering metrics:\n\n"+err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", string(format))
+		enc := expfmt.NewEncoder(w, format, expfmt.WithCreatedLines())
+		for _, mf := range mfs {
+			if err := enc.Encode(mf); err != nil {
+				log.Error().Err(err).Msg("Error encoding metric family")
+				return
+			}
+		}
+		if closer, ok := enc.(expfmt.Closer); ok {
+			if err := closer.Close(); err != nil {
+				log.Error().Err(err).Msg("Error finishing OpenMetrics exposition")
+			}
+		}
+	})
+}
+
+// DeviceCollectors looks up the collector of a single device by name.
+type DeviceCollectors interface {
+	Device(name string) (prometheus.Collector, bool)
+}
+
+// NewDeviceHandler serves the metrics of the device named by the path
+// below prefix, e.g. /metrics/device/bedroom, for selective scrapes and
+// debugging.
+func NewDeviceHandler(prefix string, devices DeviceCollectors) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, prefix)
+		c, ok := devices.Device(name)
+		if name == "" || !ok {
+			http.NotFound(w, r)
+			return
+		}
+		reg := prometheus.NewRegistry()
+		if err := reg.Register(c); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		NewHandler(reg).ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,128 @@
+package exposition
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	"github.com/stretchr/testify/require"
+	"github.com/tj/assert"
+)
+
+func getTestServer() *httptest.Server {
+	reg := prometheus.NewPedanticRegistry()
+	counter := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "test_events_total",
+		Help: "Test counter",
+	})
+	counter.Inc()
+	reg.MustRegister(counter)
+	return httptest.NewServer(NewHandler(reg))
+}
+
+func scrape(t *testing.T, url string, accept string) (*http.Response, []byte) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	require.Nil(t, err)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.Nil(t, err)
+	return resp, body
+}
+
+func TestOpenMetricsCreated(t *testing.T) {
+	assert := assert.New(t)
+	srv := getTestServer()
+	defer srv.Close()
+
+	resp, body := scrape(t, srv.URL, "application/openmetrics-text;version=1.0.0")
+	assert.Equal(expfmt.TypeOpenMetrics, expfmt.Format(resp.Header.Get("Content-Type")).FormatType())
+	assert.Regexp(regexp.MustCompile(`(?m)^test_events_total 1\.0$`), string(body))
+	assert.Regexp(regexp.MustCompile(`(?m)^test_events_created \d+`), string(body))
+	assert.Regexp(regexp.MustCompile(`(?m)^# EOF$`), string(body))
+}
+
+func TestFormatNegotiation(t *testing.T) {
+	assert := assert.New(t)
+	srv := getTestServer()
+	defer srv.Close()
+
+	resp, body := scrape(t, srv.URL, "")
+	assert.Equal(expfmt.TypeTextPlain, expfmt.Format(resp.Header.Get("Content-Type")).FormatType())
+	assert.Regexp(regexp.MustCompile(`(?m)^test_events_total 1$`), string(body))
+
+	resp, _ = scrape(t, srv.URL, "application/vnd.google.protobuf;proto=io.prometheus.client.MetricFamily;encoding=delimited")
+	assert.Equal(expfmt.TypeProtoDelim, expfmt.Format(resp.Header.Get("Content-Type")).FormatType())
+}
+
+type deviceCollectors map[string]prometheus.Collector
+
+func (d deviceCollectors) Device(name string) (prometheus.Collector, bool) {
+	c, ok := d[name]
+	return c, ok
+}
+
+func TestDeviceHandler(t *testing.T) {
+	assert := assert.New(t)
+	bedroom := prometheus.NewGauge(prometheus.GaugeOpts{Name: "awair_score", Help: "Score"})
+	bedroom.Set(89)
+	srv := httptest.NewServer(NewDeviceHandler("/metrics/device/", deviceCollectors{"bedroom": bedroom}))
+	defer srv.Close()
+
+	resp, body := scrape(t, srv.URL+"/metrics/device/bedroom", "")
+	assert.Equal(http.StatusOK, resp.StatusCode)
+	assert.Regexp(regexp.MustCompile(`(?m)^awair_score 89$`), string(body))
+
+	resp, _ = scrape(t, srv.URL+"/metrics/device/office", "")
+	assert.Equal(http.StatusNotFound, resp.StatusCode)
+	resp, _ = scrape(t, srv.URL+"/metrics/device/", "")
+	assert.Equal(http.StatusNotFound, resp.StatusCode)
+}
+
+// shuffledCollector emits its series in map iteration order.
+type shuffledCollector struct {
+	desc   *prometheus.Desc
+	values map[string]float64
+}
+
+func (c shuffledCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c shuffledCollector) Collect(ch chan<- prometheus.Metric) {
+	for device, v := range c.values {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, v, device)
+	}
+}
+
+func TestDeterministicOrder(t *testing.T) {
+	assert := assert.New(t)
+	reg := prometheus.NewPedanticRegistry()
+	c := shuffledCollector{
+		desc:   prometheus.NewDesc("awair_score", "Score", []string{"device_uuid"}, nil),
+		values: map[string]float64{},
+	}
+	for i := 0; i < 20; i++ {
+		c.values[fmt.Sprintf("awair-element_%d", i)] = float64(i)
+	}
+	reg.MustRegister(c)
+	srv := httptest.NewServer(NewHandler(reg))
+	defer srv.Close()
+
+	for _, accept := range []string{"", "application/openmetrics-text;version=1.0.0"} {
+		_, first := scrape(t, srv.URL, accept)
+		for i := 0; i < 5; i++ {
+			_, body := scrape(t, srv.URL, accept)
+			assert.Equal(string(first), string(body))
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package exposition
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+// ProbeFunc queries the device at target, returning a collector serving
+// the reading.
+type ProbeFunc func(ctx context.Context, target string) (prometheus.Collector, error)
+
+// NewProbeHandler serves the metrics of the device named by the target
+// query parameter, e.g. /probe?target=192.168.1.2, following the
+// multi-target exporter pattern of the blackbox and SNMP exporters. A failed
+// probe is reported by awair_probe_success rather than an error status, so
+// the scrape itself stays up.
+func NewProbeHandler(probe ProbeFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target := r.URL.Query().Get("target")
+		if target == "" {
+			http.Error(w, "target parameter is missing", http.StatusBadRequest)
+			return
+		}
+		success := prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "awair",
+			Name:      "probe_success",
+			Help:      "Whether the probe of the target succeeded",
+		})
+		duration := prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "awair",
+			Name:      "probe_duration_seconds",
+			Help:      "Duration of the probe of the target in seconds",
+		})
+		reg := prometheus.NewRegistry()
+		reg.MustRegister(success, duration)
+
+		start := time.Now()
+		c, err := probe(r.Context(), target)
+		duration.Set(time.Since(start).Seconds())
+		if err == nil {
+			err = reg.Register(c)
+		}
+		if err != nil {
+			log.Error().Err(err).Str("target", target).Msg("Probe failed.")
+		} else {
+			success.Set(1)
+		}
+		NewHandler(reg).ServeHTTP(w, r)
+	})
+}
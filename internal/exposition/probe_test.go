@@ -0,0 +1,40 @@
+package exposition
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tj/assert"
+)
+
+func TestProbeHandler(t *testing.T) {
+	assert := assert.New(t)
+	srv := httptest.NewServer(NewProbeHandler(func(_ context.Context, target string) (prometheus.Collector, error) {
+		if target != "192.168.1.2" {
+			return nil, errors.New("unreachable")
+		}
+		g := prometheus.NewGauge(prometheus.GaugeOpts{Name: "awair_score", Help: "Awair Score (0-100)"})
+		g.Set(89)
+		return g, nil
+	}))
+	defer srv.Close()
+
+	resp, body := scrape(t, srv.URL+"/probe?target=192.168.1.2", "")
+	assert.Equal(http.StatusOK, resp.StatusCode)
+	assert.Contains(string(body), "awair_probe_success 1\n")
+	assert.Contains(string(body), "awair_score 89\n")
+	assert.Contains(string(body), "awair_probe_duration_seconds ")
+
+	resp, body = scrape(t, srv.URL+"/probe?target=192.168.1.3", "")
+	assert.Equal(http.StatusOK, resp.StatusCode)
+	assert.Contains(string(body), "awair_probe_success 0\n")
+	assert.False(strings.Contains(string(body), "awair_score"))
+
+	resp, _ = scrape(t, srv.URL+"/probe", "")
+	assert.Equal(http.StatusBadRequest, resp.StatusCode)
+}
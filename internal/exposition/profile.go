@@ -0,0 +1,89 @@
+package exposition
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Profiles map profile names to the patterns of the metric names they
+// serve, e.g. awair_co2 or awair_voc*.
+type Profiles map[string][]string
+
+// DefaultProfiles are the profiles available without configuration.
+// minimal serves the sensor readings and whether the devices are up, e.g.
+// for a remote write agent paying per series.
+var DefaultProfiles = Profiles{
+	"minimal": {
+		"awair_up",
+		"awair_score",
+		"awair_temp",
+		"awair_humidity",
+		"awair_co2",
+		"awair_voc",
+		"awair_pm25",
+		"awair_pm10",
+	},
+}
+
+// profileGatherer gathers the metric families of g whose name matches one
+// of patterns.
+type profileGatherer struct {
+	g        prometheus.Gatherer
+	patterns []string
+}
+
+func (p profileGatherer) Gather() ([]*dto.MetricFamily, error) {
+	mfs, err := p.g.Gather()
+	selected := mfs[:0]
+	for _, mf := range mfs {
+		for _, pattern := range p.patterns {
+			if ok, _ := path.Match(pattern, mf.GetName()); ok {
+				selected = append(selected, mf)
+				break
+			}
+		}
+	}
+	return selected, err
+}
+
+// NewProfileHandler serves the metrics gathered by g like NewHandler, or
+// only those of the profile named by the profile parameter, e.g.
+// /metrics?profile=minimal. profiles add to or override DefaultProfiles.
+func NewProfileHandler(g prometheus.Gatherer, profiles Profiles) (http.Handler, error) {
+	handlers := map[string]http.Handler{}
+	add := func(profiles Profiles) error {
+		for name, patterns := range profiles {
+			for _, pattern := range patterns {
+				if _, err := path.Match(pattern, ""); err != nil {
+					return fmt.Errorf("profile %s: invalid pattern %q", name, pattern)
+				}
+			}
+			handlers[name] = NewHandler(profileGatherer{g: g, patterns: patterns})
+		}
+		return nil
+	}
+	if err := add(DefaultProfiles); err != nil {
+		return nil, err
+	}
+	if err := add(profiles); err != nil {
+		return nil, err
+	}
+	all := NewHandler(g)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Query().Get("profile")
+		if name == "" {
+			all.ServeHTTP(w, r)
+			return
+		}
+		h, ok := handlers[name]
+		if !ok {
+			http.Error(w, fmt.Sprintf("unknown profile %q", name), http.StatusNotFound)
+			return
+		}
+		h.ServeHTTP(w, r)
+	}), nil
+}
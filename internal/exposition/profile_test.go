@@ -0,0 +1,42 @@
+package exposition
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+	"github.com/tj/assert"
+)
+
+func TestProfileHandler(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+	for _, name := range []string{"awair_co2", "awair_voc", "awair_voc_baseline", "awair_device_errors_total"} {
+		g := prometheus.NewGauge(prometheus.GaugeOpts{Name: name, Help: name})
+		reg.MustRegister(g)
+	}
+	h, err := NewProfileHandler(reg, Profiles{"voc": {"awair_voc*"}})
+	require.Nil(t, err)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	_, body := scrape(t, srv.URL, "")
+	assert.Contains(t, string(body), "awair_device_errors_total 0")
+
+	_, body = scrape(t, srv.URL+"?profile=minimal", "")
+	assert.Contains(t, string(body), "awair_co2 0")
+	assert.Contains(t, string(body), "awair_voc 0")
+	assert.NotContains(t, string(body), "awair_voc_baseline")
+	assert.NotContains(t, string(body), "awair_device_errors_total")
+
+	_, body = scrape(t, srv.URL+"?profile=voc", "")
+	assert.Contains(t, string(body), "awair_voc_baseline 0")
+	assert.NotContains(t, string(body), "awair_co2")
+
+	resp, _ := scrape(t, srv.URL+"?profile=unknown", "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	_, err = NewProfileHandler(reg, Profiles{"broken": {"awair_["}})
+	assert.NotNil(t, err)
+}
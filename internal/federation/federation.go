@@ -0,0 +1,171 @@
+package federation
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"prometheus-awair-exporter/internal/api"
+	"prometheus-awair-exporter/internal/exporter"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	upstream_up = prometheus.NewDesc(
+		prometheus.BuildFQName("awair", "upstream", "up"),
+		"Whether the last sync with the upstream awair-exporter succeeded",
+		[]string{
+			"site",
+		},
+		nil,
+	)
+
+	upstream_last_sync = prometheus.NewDesc(
+		prometheus.BuildFQName("awair", "upstream", "last_sync_timestamp_seconds"),
+		"Unix time of the last successful sync with the upstream awair-exporter",
+		[]string{
+			"site",
+		},
+		nil,
+	)
+
+	upstream_devices = prometheus.NewDesc(
+		prometheus.BuildFQName("awair", "upstream", "devices"),
+		"Number of devices reported by the upstream awair-exporter during the last sync",
+		[]string{
+			"site",
+		},
+		nil,
+	)
+)
+
+// Upstream is another awair-exporter instance whose JSON API is federated.
+type Upstream struct {
+	Site string
+	URL  string
+}
+
+// ParseUpstreams parses a comma separated list of site=url pairs.
+func ParseUpstreams(s string) ([]Upstream, error) {
+	upstreams := []Upstream{}
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		site, url, ok := strings.Cut(entry, "=")
+		if !ok || site == "" || url == "" {
+			return nil, fmt.Errorf("invalid upstream %q, expected site=url", entry)
+		}
+		upstreams = append(upstreams, Upstream{
+			Site: site,
+			URL:  strings.TrimSuffix(url, "/"),
+		})
+	}
+	return upstreams, nil
+}
+
+// Federator scrapes the JSON API of other awair-exporter instances and
+// re-exposes their devices with a site label.
+type Federator struct {
+	upstreams []Upstream
+	client    *http.Client
+	metrics   *exporter.Metrics
+
+	mu       sync.Mutex
+	lastSync map[string]time.Time
+}
+
+func NewFederator(upstreams []Upstream) *Federator {
+	return &Federator{
+		upstreams: upstreams,
+		client:    &http.Client{Timeout: 10 * time.Second},
+		metrics:   exporter.NewMetrics("site"),
+		lastSync:  map[string]time.Time{},
+	}
+}
+
+func (f *Federator) Describe(ch chan<- *prometheus.Desc) {
+	f.metrics.Describe(ch)
+	ch <- upstream_up
+	ch <- upstream_last_sync
+	ch <- upstream_devices
+}
+
+func (f *Federator) Collect(ch chan<- prometheus.Metric) {
+	wg := sync.WaitGroup{}
+	for _, u := range f.upstreams {
+		wg.Add(1)
+		go func(u Upstream) {
+			defer wg.Done()
+			readings, err := f.fetch(u)
+			if err != nil {
+				log.Error().Err(err).
+					Str("site", u.Site).
+					Str("url", u.URL).
+					Msg("Error retrieving readings from upstream exporter")
+			}
+			f.collectHealth(ch, u, readings, err == nil)
+			for _, r := range readings {
+				if r.Values == nil || r.Config == nil {
+					continue
+				}
+				f.metrics.Collect(ch, r.Values, r.Config, u.Site)
+			}
+		}(u)
+	}
+	wg.Wait()
+}
+
+// collectHealth emits the health of an upstream, so a broken exporter can be
+// told apart from all of its devices being down.
+func (f *Federator) collectHealth(ch chan<- prometheus.Metric, u Upstream, readings []exporter.Reading, ok bool) {
+	up := 0.0
+	f.mu.Lock()
+	if ok {
+		up = 1
+		f.lastSync[u.Site] = time.Now()
+	}
+	lastSync, synced := f.lastSync[u.Site]
+	f.mu.Unlock()
+
+	ch <- prometheus.MustNewConstMetric(
+		upstream_up, prometheus.GaugeValue, up, u.Site,
+	)
+	if synced {
+		ch <- prometheus.MustNewConstMetric(
+			upstream_last_sync, prometheus.GaugeValue, float64(lastSync.UnixNano())/1e9, u.Site,
+		)
+	}
+	if ok {
+		ch <- prometheus.MustNewConstMetric(
+			upstream_devices, prometheus.GaugeValue, float64(len(readings)), u.Site,
+		)
+	}
+}
+
+func (f *Federator) fetch(u Upstream) ([]exporter.Reading, error) {
+	// The upstream may show its readings in other units.
+	resp, err := f.client.Get(u.URL + "/api/v1/readings?units=metric")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	served := []api.Reading{}
+	if err := json.NewDecoder(resp.Body).Decode(&served); err != nil {
+		return nil, err
+	}
+	readings := make([]exporter.Reading, len(served))
+	for i, r := range served {
+		readings[i] = r.Reading()
+	}
+	return readings, nil
+}
@@ -0,0 +1,123 @@
+package federation
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"prometheus-awair-exporter/internal/api"
+	"prometheus-awair-exporter/internal/exporter"
+	"prometheus-awair-exporter/internal/units"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/require"
+	"github.com/tj/assert"
+)
+
+func init() {
+	log.Logger = zerolog.New(io.Discard)
+}
+
+func getUpstreamServer(t *testing.T, uuid string) *httptest.Server {
+	device := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/settings/config/data":
+			fmt.Fprintf(w, `{"device_uuid": %q, "fw_version": "1.2.8"}`, uuid)
+		case "/air-data/latest":
+			fmt.Fprint(w, `{"score": 89, "co2": 625}`)
+		}
+	}))
+	t.Cleanup(device.Close)
+	e, err := exporter.NewAwairExporter(strings.Replace(device.URL, "http://", "", -1))
+	require.Nil(t, err)
+
+	router := http.NewServeMux()
+	router.Handle("/api/v1/readings", api.NewReadingsHandler(e, units.Metric))
+	srv := httptest.NewServer(router)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestParseUpstreams(t *testing.T) {
+	assert := assert.New(t)
+	upstreams, err := ParseUpstreams("floor1=http://a:8080/, floor2=http://b:8080")
+	assert.Nil(err)
+	assert.Equal([]Upstream{
+		{Site: "floor1", URL: "http://a:8080"},
+		{Site: "floor2", URL: "http://b:8080"},
+	}, upstreams)
+
+	_, err = ParseUpstreams("http://a:8080")
+	assert.NotNil(err)
+}
+
+func TestFederatorCollect(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	floor1 := getUpstreamServer(t, "awair-element_1")
+	floor2 := getUpstreamServer(t, "awair-element_2")
+
+	f := NewFederator([]Upstream{
+		{Site: "floor1", URL: floor1.URL},
+		{Site: "floor2", URL: floor2.URL},
+		{Site: "broken", URL: "http://not_a_real_host.not_a_host"},
+	})
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(f)
+	families, err := reg.Gather()
+	require.Nil(err)
+
+	sites := map[string]string{}
+	for _, mf := range families {
+		if mf.GetName() != "awair_co2" {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			labels := map[string]string{}
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			sites[labels["site"]] = labels["device_uuid"]
+			assert.Equal(float64(625), m.GetGauge().GetValue())
+		}
+	}
+	assert.Equal(map[string]string{
+		"floor1": "awair-element_1",
+		"floor2": "awair-element_2",
+	}, sites)
+}
+
+func TestFederatorUpstreamHealth(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	floor1 := getUpstreamServer(t, "awair-element_1")
+
+	f := NewFederator([]Upstream{
+		{Site: "floor1", URL: floor1.URL},
+		{Site: "broken", URL: "http://not_a_real_host.not_a_host"},
+	})
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(f)
+	families, err := reg.Gather()
+	require.Nil(err)
+
+	values := map[string]map[string]float64{}
+	for _, mf := range families {
+		if !strings.HasPrefix(mf.GetName(), "awair_upstream_") {
+			continue
+		}
+		values[mf.GetName()] = map[string]float64{}
+		for _, m := range mf.GetMetric() {
+			values[mf.GetName()][m.GetLabel()[0].GetValue()] = m.GetGauge().GetValue()
+		}
+	}
+	assert.Equal(map[string]float64{"floor1": 1, "broken": 0}, values["awair_upstream_up"])
+	assert.Equal(map[string]float64{"floor1": 1}, values["awair_upstream_devices"])
+	assert.Contains(values["awair_upstream_last_sync_timestamp_seconds"], "floor1")
+	assert.NotContains(values["awair_upstream_last_sync_timestamp_seconds"], "broken")
+}
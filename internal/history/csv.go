@@ -0,0 +1,138 @@
+// Package history reads historical readings, such as the CSV files exported
+// from the Awair cloud dashboard.
+package history
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"prometheus-awair-exporter/internal/exporter"
+)
+
+// columns maps the normalized CSV column names of cloud exports to the
+// Local API field names. Local API names are accepted as well.
+var columns = map[string]string{
+	"timestamp":   "timestamp",
+	"time":        "timestamp",
+	"date":        "timestamp",
+	"temperature": "temp",
+	"humidity":    "humid",
+	"pm2.5":       "pm25",
+	"pm10":        "pm10_est",
+	"dew point":   "dew_point",
+}
+
+// timeLayouts are the timestamp formats found in exports, tried in order.
+// Timestamps without a zone are taken as UTC.
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02T15:04:05",
+}
+
+// ReadCSV reads the readings of a CSV export. The first row names the
+// columns, e.g. "Timestamp(UTC)", "Score" or "CO2(ppm)"; units in
+// parentheses are ignored and unknown columns skipped. It returns the
+// readings with their timestamp normalized to RFC 3339 and the names of
+// the fields present in the file.
+func ReadCSV(r io.Reader) ([]exporter.AwairValues, []string, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading header: %w", err)
+	}
+
+	known := map[string]bool{}
+	for _, f := range (&exporter.AwairValues{}).Fields() {
+		known[f.Name] = true
+	}
+	fieldOf := make([]string, len(header))
+	fields := []string{}
+	timestampColumn := -1
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if unit := strings.Index(name, "("); unit >= 0 {
+			name = strings.TrimSpace(name[:unit])
+		}
+		if mapped, ok := columns[name]; ok {
+			name = mapped
+		}
+		switch {
+		case name == "timestamp":
+			timestampColumn = i
+		case known[name]:
+			fieldOf[i] = name
+			fields = append(fields, name)
+		}
+	}
+	if timestampColumn < 0 {
+		return nil, nil, errors.New("no timestamp column")
+	}
+	if len(fields) == 0 {
+		return nil, nil, errors.New("no sensor columns")
+	}
+
+	readings := []exporter.AwairValues{}
+	for {
+		row, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return readings, fields, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		line, _ := cr.FieldPos(0)
+		if timestampColumn >= len(row) {
+			return nil, nil, fmt.Errorf("line %d: missing timestamp", line)
+		}
+		ts, err := parseTime(row[timestampColumn])
+		if err != nil {
+			return nil, nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		values := map[string]interface{}{"timestamp": ts.UTC().Format(time.RFC3339Nano)}
+		for i, cell := range row {
+			cell = strings.TrimSpace(cell)
+			if i >= len(fieldOf) || fieldOf[i] == "" || cell == "" {
+				continue
+			}
+			v, err := strconv.ParseFloat(cell, 64)
+			if err != nil {
+				return nil, nil, fmt.Errorf("line %d: %s: %w", line, fieldOf[i], err)
+			}
+			values[fieldOf[i]] = v
+		}
+		reading, err := decode(values)
+		if err != nil {
+			return nil, nil, err
+		}
+		readings = append(readings, reading)
+	}
+}
+
+func parseTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unknown timestamp format %q", s)
+}
+
+// decode converts values keyed by Local API field name into a reading.
+func decode(values map[string]interface{}) (exporter.AwairValues, error) {
+	reading := exporter.AwairValues{}
+	data, err := json.Marshal(values)
+	if err != nil {
+		return reading, err
+	}
+	return reading, json.Unmarshal(data, &reading)
+}
@@ -0,0 +1,33 @@
+package history
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tj/assert"
+)
+
+func TestReadCSV(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	readings, fields, err := ReadCSV(strings.NewReader("\ufeffTimestamp(UTC),Score,Temperature(°C),Humidity(%),CO2(ppm),VOC(ppb),PM2.5(μg/m³),Comment\n" +
+		"2023-05-01 10:00:00,88,21.5,45.2,612,150,3,\n" +
+		"2023-05-01T10:05:00.000+02:00,90,21.6,,600,140,2,ventilated\n"))
+	require.Nil(err)
+	assert.Equal([]string{"score", "temp", "humid", "co2", "voc", "pm25"}, fields)
+	require.Len(readings, 2)
+	assert.Equal("2023-05-01T10:00:00Z", readings[0].Timestamp)
+	assert.Equal(float64(88), readings[0].Score)
+	assert.Equal(45.2, readings[0].Humidity)
+	assert.Equal(float64(3), readings[0].PM25)
+	assert.Equal("2023-05-01T08:05:00Z", readings[1].Timestamp)
+	assert.Equal(float64(0), readings[1].Humidity)
+
+	_, _, err = ReadCSV(strings.NewReader("Score,CO2\n88,612\n"))
+	assert.EqualError(err, "no timestamp column")
+	_, _, err = ReadCSV(strings.NewReader("Timestamp,Score\nyesterday,88\n"))
+	assert.EqualError(err, `line 2: unknown timestamp format "yesterday"`)
+	_, _, err = ReadCSV(strings.NewReader("Timestamp,Score\n2023-05-01 10:00:00,high\n"))
+	assert.NotNil(err)
+}
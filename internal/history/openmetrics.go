@@ -0,0 +1,127 @@
+package history
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"prometheus-awair-exporter/internal/exporter"
+	"prometheus-awair-exporter/internal/remotewrite"
+)
+
+// metricNames maps the Local API field names to the names of the series the
+// exporter serves them as.
+var metricNames = map[string]string{
+	"score":            "awair_score",
+	"dew_point":        "awair_dew_point",
+	"temp":             "awair_temp",
+	"humid":            "awair_humidity",
+	"abs_humid":        "awair_absolute_humidity",
+	"co2":              "awair_co2",
+	"co2_est":          "awair_co2_est",
+	"co2_est_baseline": "awair_co2_est_baseline",
+	"voc":              "awair_voc",
+	"voc_baseline":     "awair_voc_baseline",
+	"voc_h2_raw":       "awair_voc_h2_raw",
+	"voc_ethanol_raw":  "awair_voc_ethanol_raw",
+	"pm25":             "awair_pm25",
+	"pm10_est":         "awair_pm10",
+	"lux":              "awair_illuminance_lux",
+	"spl_a":            "awair_sound_level_db",
+}
+
+// MetricName returns the name of the series the exporter serves field as.
+func MetricName(field string) (string, bool) {
+	name, ok := metricNames[field]
+	return name, ok
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// sample is the values of the fields of a reading at its time.
+type sample struct {
+	at     time.Time
+	values map[string]float64
+}
+
+// timeline returns the samples of readings in time order, readings with the
+// same timestamp only once.
+func timeline(readings []exporter.AwairValues) ([]sample, error) {
+	samples := []sample{}
+	for _, r := range readings {
+		at, err := time.Parse(time.RFC3339Nano, r.Timestamp)
+		if err != nil {
+			return nil, fmt.Errorf("reading without valid timestamp: %w", err)
+		}
+		values := map[string]float64{}
+		for _, f := range r.Fields() {
+			values[f.Name] = f.Value
+		}
+		samples = append(samples, sample{at, values})
+	}
+	sort.SliceStable(samples, func(i, j int) bool {
+		return samples[i].at.Before(samples[j].at)
+	})
+	unique := samples[:0]
+	for i, s := range samples {
+		if i > 0 && s.at.Equal(samples[i-1].at) {
+			continue
+		}
+		unique = append(unique, s)
+	}
+	return unique, nil
+}
+
+// WriteOpenMetrics writes the given fields of readings as the series the
+// exporter would have served for the device, timestamped with each
+// reading's time, in the OpenMetrics format read by
+// `promtool tsdb create-blocks-from openmetrics`. Readings are written in
+// time order, readings with the same timestamp only once.
+func WriteOpenMetrics(w io.Writer, deviceUUID string, readings []exporter.AwairValues, fields []string) error {
+	samples, err := timeline(readings)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	labels := fmt.Sprintf(`{device_uuid="%s"}`, labelEscaper.Replace(deviceUUID))
+	for _, field := range fields {
+		name, ok := metricNames[field]
+		if !ok {
+			return fmt.Errorf("unknown field %q", field)
+		}
+		fmt.Fprintf(bw, "# TYPE %s gauge\n", name)
+		for _, s := range samples {
+			ts := strconv.FormatFloat(float64(s.at.UnixMilli())/1000, 'f', -1, 64)
+			fmt.Fprintf(bw, "%s%s %s %s\n", name, labels, strconv.FormatFloat(s.values[field], 'g', -1, 64), ts)
+		}
+	}
+	fmt.Fprint(bw, "# EOF\n")
+	return bw.Flush()
+}
+
+// RemoteWriteSeries returns the given fields of readings as the series the
+// exporter would have served for the device, for a remote write endpoint.
+// Readings with the same timestamp are taken only once.
+func RemoteWriteSeries(deviceUUID string, readings []exporter.AwairValues, fields []string) ([]remotewrite.Series, error) {
+	samples, err := timeline(readings)
+	if err != nil {
+		return nil, err
+	}
+	series := []remotewrite.Series{}
+	for _, field := range fields {
+		name, ok := metricNames[field]
+		if !ok {
+			return nil, fmt.Errorf("unknown field %q", field)
+		}
+		s := remotewrite.Series{Labels: []remotewrite.Label{{Name: "__name__", Value: name}, {Name: "device_uuid", Value: deviceUUID}}}
+		for _, smp := range samples {
+			s.Samples = append(s.Samples, remotewrite.Sample{Time: smp.at, Value: smp.values[field]})
+		}
+		series = append(series, s)
+	}
+	return series, nil
+}
@@ -0,0 +1,54 @@
+package history
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"prometheus-awair-exporter/internal/remotewrite"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tj/assert"
+)
+
+func TestWriteOpenMetrics(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	readings, fields, err := ReadCSV(strings.NewReader("Timestamp,Score,CO2\n" +
+		"2023-05-01 10:05:00,90,600.5\n" +
+		"2023-05-01 10:00:00,88,612\n" +
+		"2023-05-01 10:00:00,88,612\n"))
+	require.Nil(err)
+
+	out := &strings.Builder{}
+	require.Nil(WriteOpenMetrics(out, `awair-"1"`, readings, fields))
+	assert.Equal(`# TYPE awair_score gauge
+awair_score{device_uuid="awair-\"1\""} 88 1682935200
+awair_score{device_uuid="awair-\"1\""} 90 1682935500
+# TYPE awair_co2 gauge
+awair_co2{device_uuid="awair-\"1\""} 612 1682935200
+awair_co2{device_uuid="awair-\"1\""} 600.5 1682935500
+# EOF
+`, out.String())
+}
+
+func TestRemoteWriteSeries(t *testing.T) {
+	readings, _, err := ReadCSV(strings.NewReader("Timestamp,CO2\n" +
+		"2023-05-01 10:05:00,600.5\n" +
+		"2023-05-01 10:00:00,612\n" +
+		"2023-05-01 10:00:00,612\n"))
+	require.Nil(t, err)
+
+	series, err := RemoteWriteSeries("awair-element_1", readings, []string{"co2"})
+	require.Nil(t, err)
+	assert.Equal(t, []remotewrite.Series{{
+		Labels: []remotewrite.Label{{Name: "__name__", Value: "awair_co2"}, {Name: "device_uuid", Value: "awair-element_1"}},
+		Samples: []remotewrite.Sample{
+			{Time: time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC), Value: 612},
+			{Time: time.Date(2023, 5, 1, 10, 5, 0, 0, time.UTC), Value: 600.5},
+		},
+	}}, series)
+
+	_, err = RemoteWriteSeries("awair-element_1", readings, []string{"radon"})
+	assert.NotNil(t, err)
+}
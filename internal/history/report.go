@@ -0,0 +1,152 @@
+package history
+
+import (
+	"html/template"
+	"io"
+	"sort"
+	"time"
+
+	"prometheus-awair-exporter/internal/exporter"
+	"prometheus-awair-exporter/internal/units"
+)
+
+// maxSampleSpan caps the time a single reading accounts for, so gaps in
+// the history don't count as hours above a threshold.
+const maxSampleSpan = 15 * time.Minute
+
+// Thresholds are the levels above which time is counted in a summary.
+type Thresholds struct {
+	CO2  float64 `json:"co2"`
+	PM25 float64 `json:"pm25"`
+}
+
+// DefaultThresholds follow common indoor air quality guidelines.
+var DefaultThresholds = Thresholds{CO2: 1000, PM25: 35}
+
+// Summary condenses the readings of a device or zone over a period.
+type Summary struct {
+	Name           string    `json:"name"`
+	From           time.Time `json:"from"`
+	To             time.Time `json:"to"`
+	Samples        int       `json:"samples"`
+	AvgScore       float64   `json:"avg_score"`
+	AvgTemp        float64   `json:"avg_temp"`
+	AvgCO2         float64   `json:"avg_co2"`
+	MaxCO2         float64   `json:"max_co2"`
+	AvgPM25        float64   `json:"avg_pm25"`
+	HoursAboveCO2  float64   `json:"hours_above_co2"`
+	HoursAbovePM25 float64   `json:"hours_above_pm25"`
+	// ScoreBands holds the share of time spent with a good (80 and
+	// above), fair (60 to 79) and poor (below 60) Awair score, in percent.
+	ScoreBands map[string]float64 `json:"score_bands"`
+}
+
+// Summarize condenses readings, which need not be ordered, into a summary.
+// Readings without a valid timestamp are skipped.
+func Summarize(name string, readings []exporter.AwairValues, t Thresholds) Summary {
+	type sample struct {
+		at time.Time
+		v  *exporter.AwairValues
+	}
+	samples := []sample{}
+	for i := range readings {
+		if at, err := time.Parse(time.RFC3339Nano, readings[i].Timestamp); err == nil {
+			samples = append(samples, sample{at, &readings[i]})
+		}
+	}
+	sort.SliceStable(samples, func(i, j int) bool {
+		return samples[i].at.Before(samples[j].at)
+	})
+
+	s := Summary{Name: name, Samples: len(samples), ScoreBands: map[string]float64{"good": 0, "fair": 0, "poor": 0}}
+	if len(samples) == 0 {
+		return s
+	}
+	s.From, s.To = samples[0].at, samples[len(samples)-1].at
+	var total time.Duration
+	for i, smp := range samples {
+		s.AvgScore += smp.v.Score
+		s.AvgTemp += smp.v.Temp
+		s.AvgCO2 += smp.v.CO2
+		s.AvgPM25 += smp.v.PM25
+		if smp.v.CO2 > s.MaxCO2 {
+			s.MaxCO2 = smp.v.CO2
+		}
+
+		span := maxSampleSpan
+		if i+1 < len(samples) {
+			if gap := samples[i+1].at.Sub(smp.at); gap < span {
+				span = gap
+			}
+		}
+		total += span
+		if smp.v.CO2 > t.CO2 {
+			s.HoursAboveCO2 += span.Hours()
+		}
+		if smp.v.PM25 > t.PM25 {
+			s.HoursAbovePM25 += span.Hours()
+		}
+		switch {
+		case smp.v.Score >= 80:
+			s.ScoreBands["good"] += span.Hours()
+		case smp.v.Score >= 60:
+			s.ScoreBands["fair"] += span.Hours()
+		default:
+			s.ScoreBands["poor"] += span.Hours()
+		}
+	}
+	n := float64(len(samples))
+	s.AvgScore /= n
+	s.AvgTemp /= n
+	s.AvgCO2 /= n
+	s.AvgPM25 /= n
+	for band, hours := range s.ScoreBands {
+		s.ScoreBands[band] = 100 * hours / total.Hours()
+	}
+	return s
+}
+
+// Convert returns a copy of s with its values, summarized in metric
+// units, converted to u.
+func (s Summary) Convert(u units.System) Summary {
+	s.AvgTemp = u.Convert("temp", s.AvgTemp)
+	return s
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: right; }
+th:first-child, td:first-child { text-align: left; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<table>
+<tr><th>Device</th><th>Period</th><th>Avg score</th><th>Avg temperature ({{.TempUnit}})</th><th>Avg CO₂ (ppm)</th><th>Max CO₂ (ppm)</th><th>Hours CO₂ &gt; {{.Thresholds.CO2}}</th><th>Avg PM2.5 (µg/m³)</th><th>Hours PM2.5 &gt; {{.Thresholds.PM25}}</th><th>Good / fair / poor score</th></tr>
+{{- range .Summaries}}
+<tr><td>{{.Name}}</td><td>{{.From.Format "2006-01-02 15:04"}} – {{.To.Format "2006-01-02 15:04"}}</td><td>{{printf "%.0f" .AvgScore}}</td><td>{{printf "%.1f" .AvgTemp}}</td><td>{{printf "%.0f" .AvgCO2}}</td><td>{{printf "%.0f" .MaxCO2}}</td><td>{{printf "%.1f" .HoursAboveCO2}}</td><td>{{printf "%.1f" .AvgPM25}}</td><td>{{printf "%.1f" .HoursAbovePM25}}</td><td>{{printf "%.0f" (index .ScoreBands "good")}}% / {{printf "%.0f" (index .ScoreBands "fair")}}% / {{printf "%.0f" (index .ScoreBands "poor")}}%</td></tr>
+{{- end}}
+</table>
+</body>
+</html>
+`))
+
+// WriteHTML renders summaries as an HTML report in the units of u.
+func WriteHTML(w io.Writer, title string, t Thresholds, u units.System, summaries []Summary) error {
+	converted := make([]Summary, len(summaries))
+	for i, s := range summaries {
+		converted[i] = s.Convert(u)
+	}
+	return reportTemplate.Execute(w, struct {
+		Title      string
+		Thresholds Thresholds
+		TempUnit   string
+		Summaries  []Summary
+	}{title, t, u.Symbol("temp"), converted})
+}
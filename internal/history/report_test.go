@@ -0,0 +1,47 @@
+package history
+
+import (
+	"strings"
+	"testing"
+
+	"prometheus-awair-exporter/internal/units"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tj/assert"
+)
+
+func TestSummarize(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	readings, _, err := ReadCSV(strings.NewReader("Timestamp,Score,Temp,CO2,PM2.5\n" +
+		"2023-05-01 10:30:00,50,22,1400,40\n" +
+		"2023-05-01 10:00:00,90,20,600,5\n" +
+		"2023-05-01 10:15:00,70,21,1100,5\n" +
+		"2023-05-01 14:00:00,90,21,800,5\n"))
+	require.Nil(err)
+
+	s := Summarize("bedroom", readings, DefaultThresholds)
+	assert.Equal(4, s.Samples)
+	assert.Equal("2023-05-01T10:00:00Z", s.From.Format("2006-01-02T15:04:05Z07:00"))
+	assert.Equal(975.0, s.AvgCO2)
+	assert.Equal(21.0, s.AvgTemp)
+	assert.InDelta(69.8, s.Convert(units.Imperial).AvgTemp, 0.001)
+	assert.Equal(1400.0, s.MaxCO2)
+	// The reading before the gap only accounts for 15 minutes.
+	assert.Equal(0.5, s.HoursAboveCO2)
+	assert.Equal(0.25, s.HoursAbovePM25)
+	assert.Equal(50.0, s.ScoreBands["good"])
+	assert.Equal(25.0, s.ScoreBands["fair"])
+	assert.Equal(25.0, s.ScoreBands["poor"])
+
+	empty := Summarize("office", nil, DefaultThresholds)
+	assert.Equal(0, empty.Samples)
+
+	out := &strings.Builder{}
+	require.Nil(WriteHTML(out, "Weekly <report>", DefaultThresholds, units.Imperial, []Summary{s}))
+	assert.Contains(out.String(), "<title>Weekly &lt;report&gt;</title>")
+	assert.Contains(out.String(), "<td>bedroom</td>")
+	assert.Contains(out.String(), "<td>975</td>")
+	assert.Contains(out.String(), "<th>Avg temperature (°F)</th>")
+	assert.Contains(out.String(), "<td>69.8</td>")
+}
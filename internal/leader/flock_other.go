@@ -0,0 +1,18 @@
+//go:build !unix
+
+package leader
+
+import (
+	"errors"
+	"os"
+)
+
+var errUnsupported = errors.New("file locks are not supported on this platform")
+
+func tryLock(f *os.File) error {
+	return errUnsupported
+}
+
+func unlock(f *os.File) error {
+	return errUnsupported
+}
@@ -0,0 +1,91 @@
+// Package leader elects a single active replica among exporters sharing a
+// lock, so push outputs aren't published twice by HA pairs.
+package leader
+
+import (
+	"context"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+// Elector reports whether this replica currently is the leader.
+type Elector interface {
+	IsLeader() bool
+}
+
+// FileLock elects the replica holding an exclusive lock on a file, e.g. on
+// a volume shared by an active/passive pair. The lock is released by the
+// kernel if the leader dies, letting the standby take over.
+type FileLock struct {
+	path   string
+	retry  time.Duration
+	leader atomic.Bool
+	gauge  prometheus.Gauge
+}
+
+// NewFileLock returns an elector locking path, retrying every retry
+// interval while another replica holds the lock.
+func NewFileLock(path string, retry time.Duration) *FileLock {
+	return &FileLock{
+		path:  path,
+		retry: retry,
+		gauge: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Namespace: "awair",
+				Name:      "leader",
+				Help:      "Whether this replica is the leader publishing to push outputs",
+			},
+		),
+	}
+}
+
+func (l *FileLock) IsLeader() bool {
+	return l.leader.Load()
+}
+
+// Run tries to acquire the lock until ctx is cancelled, then releases it.
+func (l *FileLock) Run(ctx context.Context) {
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		log.Error().Err(err).Str("file", l.path).Msg("Failed to open leader lock file, staying passive.")
+		return
+	}
+	defer f.Close()
+
+	ticker := time.NewTicker(l.retry)
+	defer ticker.Stop()
+	for {
+		err := tryLock(f)
+		if err == nil {
+			break
+		}
+		log.Debug().Err(err).Str("file", l.path).Msg("Leader lock is held by another replica.")
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+
+	l.leader.Store(true)
+	l.gauge.Set(1)
+	log.Info().Str("file", l.path).Msg("Acquired leader lock, publishing to push outputs.")
+	<-ctx.Done()
+	l.leader.Store(false)
+	l.gauge.Set(0)
+	if err := unlock(f); err != nil {
+		log.Error().Err(err).Str("file", l.path).Msg("Failed to release leader lock.")
+	}
+}
+
+func (l *FileLock) Describe(ch chan<- *prometheus.Desc) {
+	l.gauge.Describe(ch)
+}
+
+func (l *FileLock) Collect(ch chan<- prometheus.Metric) {
+	l.gauge.Collect(ch)
+}
@@ -0,0 +1,42 @@
+package leader
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"github.com/tj/assert"
+)
+
+func TestFileLockFailover(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	path := filepath.Join(t.TempDir(), "leader.lock")
+
+	active := NewFileLock(path, 5*time.Millisecond)
+	standby := NewFileLock(path, 5*time.Millisecond)
+
+	activeCtx, stopActive := context.WithCancel(context.Background())
+	activeDone := make(chan struct{})
+	go func() {
+		active.Run(activeCtx)
+		close(activeDone)
+	}()
+	require.Eventually(active.IsLeader, time.Second, time.Millisecond)
+
+	standbyCtx, stopStandby := context.WithCancel(context.Background())
+	defer stopStandby()
+	go standby.Run(standbyCtx)
+	time.Sleep(20 * time.Millisecond)
+	assert.False(standby.IsLeader())
+	assert.Equal(float64(0), testutil.ToFloat64(standby.gauge))
+	assert.Equal(float64(1), testutil.ToFloat64(active.gauge))
+
+	stopActive()
+	<-activeDone
+	assert.False(active.IsLeader())
+	require.Eventually(standby.IsLeader, time.Second, time.Millisecond)
+}
@@ -0,0 +1,244 @@
+// Package mdns advertises services of the exporter by multicast DNS
+// service discovery, e.g. for smart home hubs to find its bridges.
+package mdns
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// DNS record types and classes of the advertisement.
+const (
+	dnsA       = 1
+	dnsPTR     = 12
+	dnsTXT     = 16
+	dnsSRV     = 33
+	dnsClassIN = 1
+	// dnsCacheFlush marks records only the exporter answers for.
+	dnsCacheFlush = 0x8000
+)
+
+const servicesName = "_services._dns-sd._udp.local"
+
+var group = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// Service is a service instance to advertise.
+type Service struct {
+	// Instance is the name of the instance, e.g. Awair Bridge, which may
+	// hold spaces but no dots.
+	Instance string
+	// Type is the service type, e.g. _hap._tcp.
+	Type string
+	// Host is the host name, without the .local domain.
+	Host string
+	Port int
+	// TXT returns the key=value pairs of the TXT record.
+	TXT func() []string
+}
+
+func (s Service) typeName() string {
+	return s.Type + ".local"
+}
+
+func (s Service) instanceName() string {
+	return strings.ReplaceAll(s.Instance, ".", " ") + "." + s.typeName()
+}
+
+func (s Service) hostName() string {
+	return s.Host + ".local"
+}
+
+// Responder answers queries for its services and announces them.
+type Responder struct {
+	services []Service
+	changed  chan struct{}
+}
+
+// New returns a Responder for services.
+func New(services ...Service) *Responder {
+	return &Responder{services: services, changed: make(chan struct{}, 1)}
+}
+
+// Announce announces the services anew, e.g. as their TXT records changed.
+func (r *Responder) Announce() {
+	select {
+	case r.changed <- struct{}{}:
+	default:
+	}
+}
+
+// appendName appends the DNS encoding of name, whose first label may hold
+// spaces, but no dots.
+func appendName(buf []byte, name string) []byte {
+	for _, label := range strings.Split(name, ".") {
+		buf = append(buf, byte(len(label)))
+		buf = append(buf, label...)
+	}
+	return append(buf, 0)
+}
+
+// readName reads the name at off of msg, following compression pointers,
+// returning it and the offset after it.
+func readName(msg []byte, off int) (string, int, error) {
+	labels := []string{}
+	end := -1
+	for jumps := 0; jumps < 16; {
+		if off >= len(msg) {
+			return "", 0, errors.New("truncated name")
+		}
+		n := int(msg[off])
+		switch {
+		case n == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			return strings.Join(labels, "."), end, nil
+		case n&0xc0 == 0xc0:
+			if off+1 >= len(msg) {
+				return "", 0, errors.New("truncated name")
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3fff)
+			jumps++
+		default:
+			if off+1+n > len(msg) {
+				return "", 0, errors.New("truncated name")
+			}
+			labels = append(labels, string(msg[off+1:off+1+n]))
+			off += 1 + n
+		}
+	}
+	return "", 0, errors.New("too many compression pointers")
+}
+
+// queried reports whether the query asks for any record of the services.
+func (r *Responder) queried(query []byte) bool {
+	if len(query) < 12 || query[2]&0x80 != 0 {
+		return false
+	}
+	names := map[string]bool{servicesName: true}
+	for _, s := range r.services {
+		names[strings.ToLower(s.typeName())] = true
+		names[strings.ToLower(s.instanceName())] = true
+		names[strings.ToLower(s.hostName())] = true
+	}
+	off := 12
+	for i := 0; i < int(binary.BigEndian.Uint16(query[4:])); i++ {
+		name, next, err := readName(query, off)
+		if err != nil || next+4 > len(query) {
+			return false
+		}
+		off = next + 4
+		if names[strings.ToLower(name)] {
+			return true
+		}
+	}
+	return false
+}
+
+// response returns the response advertising the services on ips.
+func (r *Responder) response(ips []net.IP) []byte {
+	msg := []byte{0, 0, 0x84, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	count := 0
+	record := func(name string, typ, class uint16, ttl uint32, data []byte) {
+		msg = appendName(msg, name)
+		msg = binary.BigEndian.AppendUint16(msg, typ)
+		msg = binary.BigEndian.AppendUint16(msg, class)
+		msg = binary.BigEndian.AppendUint32(msg, ttl)
+		msg = binary.BigEndian.AppendUint16(msg, uint16(len(data)))
+		msg = append(msg, data...)
+		count++
+	}
+	hosts := map[string]bool{}
+	for _, s := range r.services {
+		txt := []byte{}
+		for _, kv := range s.TXT() {
+			txt = append(txt, byte(len(kv)))
+			txt = append(txt, kv...)
+		}
+		srv := binary.BigEndian.AppendUint16(nil, 0)
+		srv = binary.BigEndian.AppendUint16(srv, 0)
+		srv = binary.BigEndian.AppendUint16(srv, uint16(s.Port))
+		srv = appendName(srv, s.hostName())
+
+		record(servicesName, dnsPTR, dnsClassIN, 4500, appendName(nil, s.typeName()))
+		record(s.typeName(), dnsPTR, dnsClassIN, 4500, appendName(nil, s.instanceName()))
+		record(s.instanceName(), dnsSRV, dnsClassIN|dnsCacheFlush, 120, srv)
+		record(s.instanceName(), dnsTXT, dnsClassIN|dnsCacheFlush, 4500, txt)
+		if hosts[s.hostName()] {
+			continue
+		}
+		hosts[s.hostName()] = true
+		for _, ip := range ips {
+			record(s.hostName(), dnsA, dnsClassIN|dnsCacheFlush, 120, ip.To4())
+		}
+	}
+	binary.BigEndian.PutUint16(msg[6:], uint16(count))
+	return msg
+}
+
+// localIPs returns the IPv4 addresses of the host but loopback.
+func localIPs() []net.IP {
+	ips := []net.IP{}
+	addrs, _ := net.InterfaceAddrs()
+	for _, addr := range addrs {
+		if n, ok := addr.(*net.IPNet); ok && !n.IP.IsLoopback() && n.IP.To4() != nil {
+			ips = append(ips, n.IP.To4())
+		}
+	}
+	return ips
+}
+
+// Run announces the services and answers queries for them until ctx is
+// done, announcing them anew on Announce and every hour.
+func (r *Responder) Run(ctx context.Context) error {
+	pc, err := net.ListenMulticastUDP("udp4", nil, group)
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		pc.Close()
+	}()
+	send := func() {
+		if _, err := pc.WriteToUDP(r.response(localIPs()), group); err != nil && ctx.Err() == nil {
+			log.Error().Err(err).Msg("Failed to send mDNS response.")
+		}
+	}
+
+	go func() {
+		send()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-r.changed:
+				send()
+			case <-time.After(time.Hour):
+				send()
+			}
+		}
+	}()
+
+	buf := make([]byte, 9000)
+	for {
+		n, _, err := pc.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if r.queried(buf[:n]) {
+			send()
+		}
+	}
+}
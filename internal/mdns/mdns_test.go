@@ -0,0 +1,43 @@
+package mdns
+
+import (
+	"net"
+	"testing"
+
+	"github.com/tj/assert"
+)
+
+func TestResponder(t *testing.T) {
+	assert := assert.New(t)
+	r := New(Service{
+		Instance: "Awair Bridge",
+		Type:     "_hap._tcp",
+		Host:     "A37A49DBBC0C",
+		Port:     51826,
+		TXT:      func() []string { return []string{"sf=1"} },
+	})
+
+	query := func(name string) []byte {
+		q := appendName([]byte{0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0}, name)
+		return append(q, 0, dnsPTR, 0, dnsClassIN)
+	}
+	assert.True(r.queried(query("_hap._tcp.local")))
+	assert.True(r.queried(query("a37a49dbbc0c.local")))
+	assert.True(r.queried(query("_services._dns-sd._udp.local")))
+	assert.False(r.queried(query("_airplay._tcp.local")))
+	assert.False(r.queried([]byte{0, 0, 0x84, 0}), "responses are ignored")
+
+	// A pointer to the name of an earlier question.
+	q := query("_airplay._tcp.local")
+	q[5] = 2
+	q = append(q, 0xc0, 12+9, 0, dnsPTR, 0, dnsClassIN)
+	assert.False(r.queried(q))
+
+	resp := r.response([]net.IP{net.IPv4(192, 168, 1, 10)})
+	assert.Equal([]byte{0, 5}, resp[6:8])
+	assert.Contains(string(resp), "\x0cAwair Bridge\x04_hap\x04_tcp\x05local\x00")
+	assert.Contains(string(resp), "\x0cA37A49DBBC0C\x05local\x00")
+	assert.Contains(string(resp), "\x04sf=1")
+	assert.Contains(string(resp), string([]byte{0xca, 0x72}), "port")
+	assert.Contains(string(resp), string([]byte{192, 168, 1, 10}))
+}
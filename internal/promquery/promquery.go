@@ -0,0 +1,124 @@
+// Package promquery is a minimal client for the range queries of the
+// Prometheus HTTP API.
+package promquery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config locates a Prometheus server and how to authenticate to it.
+type Config struct {
+	URL         string `yaml:"url"`
+	BearerToken string `yaml:"bearer_token,omitempty"`
+	Username    string `yaml:"username,omitempty"`
+	Password    string `yaml:"password,omitempty"`
+}
+
+// Point is a sample of a series.
+type Point struct {
+	Time  time.Time
+	Value float64
+}
+
+// Series is a series returned by a range query.
+type Series struct {
+	Labels map[string]string
+	Points []Point
+}
+
+// Client queries a Prometheus server.
+type Client struct {
+	cfg    Config
+	client *http.Client
+}
+
+// New returns a client for the server described by cfg.
+func New(cfg Config) (*Client, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported scheme %q in Prometheus URL", u.Scheme)
+	}
+	cfg.URL = strings.TrimSuffix(cfg.URL, "/")
+	return &Client{cfg: cfg, client: &http.Client{Timeout: 30 * time.Second}}, nil
+}
+
+type response struct {
+	Status string `json:"status"`
+	Error  string `json:"error"`
+	Data   struct {
+		ResultType string `json:"resultType"`
+		Result     []struct {
+			Metric map[string]string `json:"metric"`
+			Values [][2]interface{}  `json:"values"`
+		} `json:"result"`
+	} `json:"data"`
+}
+
+// QueryRange evaluates query from start to end at every step.
+func (c *Client) QueryRange(ctx context.Context, query string, start, end time.Time, step time.Duration) ([]Series, error) {
+	params := url.Values{
+		"query": {query},
+		"start": {strconv.FormatInt(start.Unix(), 10)},
+		"end":   {strconv.FormatInt(end.Unix(), 10)},
+		"step":  {strconv.FormatFloat(step.Seconds(), 'f', -1, 64)},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.cfg.URL+"/api/v1/query_range?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case c.cfg.BearerToken != "":
+		req.Header.Set("Authorization", "Bearer "+c.cfg.BearerToken)
+	case c.cfg.Username != "":
+		req.SetBasicAuth(c.cfg.Username, c.cfg.Password)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 8<<20))
+	if err != nil {
+		return nil, err
+	}
+	r := response{}
+	if err := json.Unmarshal(body, &r); err != nil {
+		return nil, fmt.Errorf("unexpected response with status %s: %w", resp.Status, err)
+	}
+	if r.Status != "success" {
+		return nil, fmt.Errorf("query failed: %s", r.Error)
+	}
+	if r.Data.ResultType != "matrix" {
+		return nil, fmt.Errorf("unexpected result type %q", r.Data.ResultType)
+	}
+
+	series := make([]Series, 0, len(r.Data.Result))
+	for _, result := range r.Data.Result {
+		s := Series{Labels: result.Metric, Points: make([]Point, 0, len(result.Values))}
+		for _, v := range result.Values {
+			ts, ok := v[0].(float64)
+			value, ok2 := v[1].(string)
+			if !ok || !ok2 {
+				return nil, fmt.Errorf("malformed sample %v", v)
+			}
+			f, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, err
+			}
+			s.Points = append(s.Points, Point{Time: time.UnixMilli(int64(ts * 1000)), Value: f})
+		}
+		series = append(series, s)
+	}
+	return series, nil
+}
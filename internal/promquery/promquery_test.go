@@ -0,0 +1,52 @@
+package promquery
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tj/assert"
+)
+
+func TestQueryRange(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, "Unauthorized")
+			return
+		}
+		assert.Equal("/api/v1/query_range", r.URL.Path)
+		assert.Equal("awair_co2", r.URL.Query().Get("query"))
+		assert.Equal("900", r.URL.Query().Get("step"))
+		fmt.Fprint(w, `{"status": "success", "data": {"resultType": "matrix", "result": [
+			{"metric": {"device_uuid": "awair-element_1"}, "values": [[1700000000, "600"], [1700000900.5, "612.5"]]}
+		]}}`)
+	}))
+	defer srv.Close()
+
+	c, err := New(Config{URL: srv.URL + "/", BearerToken: "secret"})
+	require.Nil(err)
+	end := time.Unix(1700003600, 0)
+	series, err := c.QueryRange(context.Background(), "awair_co2", end.Add(-time.Hour), end, 15*time.Minute)
+	require.Nil(err)
+	require.Len(series, 1)
+	assert.Equal("awair-element_1", series[0].Labels["device_uuid"])
+	assert.Equal([]Point{
+		{time.Unix(1700000000, 0), 600},
+		{time.UnixMilli(1700000900500), 612.5},
+	}, series[0].Points)
+
+	c, err = New(Config{URL: srv.URL})
+	require.Nil(err)
+	_, err = c.QueryRange(context.Background(), "awair_co2", end.Add(-time.Hour), end, 15*time.Minute)
+	assert.NotNil(err)
+
+	_, err = New(Config{URL: "ftp://prometheus"})
+	assert.NotNil(err)
+}
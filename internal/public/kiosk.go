@@ -0,0 +1,178 @@
+package public
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"prometheus-awair-exporter/internal/units"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Trend is the direction a value moved in over the trend window.
+type Trend string
+
+const (
+	Up     Trend = "up"
+	Down   Trend = "down"
+	Steady Trend = "steady"
+)
+
+var arrows = map[Trend]string{Up: "↑", Down: "↓", Steady: "→"}
+
+// deadBands are the changes below which a field counts as steady, so sensor
+// noise doesn't flip the arrows.
+var deadBands = map[string]float64{
+	"score": 2,
+	"temp":  0.3,
+	"humid": 2,
+	"co2":   50,
+	"voc":   50,
+	"pm25":  2,
+}
+
+// observation is the values of a device at a point in time.
+type observation struct {
+	at     time.Time
+	values map[string]float64
+}
+
+// Trends samples the readings of src to tell which way values are moving.
+type Trends struct {
+	src    ReadingSource
+	window time.Duration
+
+	mu      sync.Mutex
+	history map[string][]observation
+}
+
+// NewTrends returns Trends comparing the current values to those up to
+// window ago.
+func NewTrends(src ReadingSource, window time.Duration) *Trends {
+	return &Trends{src: src, window: window, history: map[string][]observation{}}
+}
+
+// Run samples the readings at interval until ctx is done.
+func (t *Trends) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	t.record(time.Now())
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			t.record(now)
+		}
+	}
+}
+
+// record samples the current readings, dropping samples older than the
+// window and devices which are gone.
+func (t *Trends) record(now time.Time) {
+	readings := t.src.NamedReadings()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for name := range t.history {
+		if _, ok := readings[name]; !ok {
+			delete(t.history, name)
+		}
+	}
+	for name, r := range readings {
+		values := map[string]float64{}
+		for _, f := range r.Values.Fields() {
+			values[f.Name] = f.Value
+		}
+		kept := []observation{}
+		for _, o := range t.history[name] {
+			if now.Sub(o.at) <= t.window {
+				kept = append(kept, o)
+			}
+		}
+		t.history[name] = append(kept, observation{at: now, values: values})
+	}
+}
+
+// trend compares v, the current value of field, with the oldest sample of
+// the device in the window.
+func (t *Trends) trend(name, field string, v float64) Trend {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	h := t.history[name]
+	if len(h) == 0 {
+		return Steady
+	}
+	switch delta := v - h[0].values[field]; {
+	case delta > deadBands[field]:
+		return Up
+	case delta < -deadBands[field]:
+		return Down
+	default:
+		return Steady
+	}
+}
+
+// NewKioskHandler serves the current values of fields for every device in
+// the units of u, with the direction they are moving in, pre-rendered for
+// e-ink and microcontroller displays: plain text lines by default, or a
+// flat JSON object per device with ?format=json.
+func NewKioskHandler(src ReadingSource, trends *Trends, fields []string, u units.System) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		readings := src.NamedReadings()
+		names := make([]string, 0, len(readings))
+		for name := range readings {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		type row struct {
+			field string
+			value float64
+			trend Trend
+		}
+		rows := map[string][]row{}
+		for _, name := range names {
+			values := map[string]float64{}
+			for _, f := range readings[name].Values.Fields() {
+				values[f.Name] = f.Value
+			}
+			for _, field := range fields {
+				rows[name] = append(rows[name], row{field, values[field], trends.trend(name, field, values[field])})
+			}
+		}
+
+		if r.URL.Query().Get("format") == "json" {
+			devices := make([]map[string]interface{}, 0, len(names))
+			for _, name := range names {
+				d := map[string]interface{}{"name": name}
+				for _, row := range rows[name] {
+					d[row.field] = json.Number(strconv.FormatFloat(u.Convert(row.field, row.value), 'f', metrics[row.field].decimals, 64))
+					d[row.field+"_trend"] = row.trend
+				}
+				devices = append(devices, d)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			if err := json.NewEncoder(w).Encode(devices); err != nil {
+				log.Error().Err(err).Msg("Failed to encode kiosk readings")
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		b := &strings.Builder{}
+		for _, name := range names {
+			fmt.Fprintln(b, name)
+			for _, row := range rows[name] {
+				fmt.Fprintf(b, "%s %s %s\n", metrics[row.field].label, format(u, row.field, row.value), arrows[row.trend])
+			}
+		}
+		fmt.Fprint(w, b.String())
+	})
+}
@@ -0,0 +1,64 @@
+package public
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"prometheus-awair-exporter/internal/exporter"
+	"prometheus-awair-exporter/internal/units"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tj/assert"
+)
+
+func TestKioskHandler(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	src := staticSource{
+		"Kitchen": {
+			Config: &exporter.ConfigResponse{DeviceUUID: "awair-element_2"},
+			Values: &exporter.AwairValues{Score: 90, Temp: 22, CO2: 500},
+		},
+	}
+	trends := NewTrends(src, 15*time.Minute)
+	now := time.Now()
+	trends.record(now.Add(-20 * time.Minute))
+	src["Kitchen"].Values.CO2 = 700
+	src["Kitchen"].Values.Temp = 21.9
+	trends.record(now.Add(-10 * time.Minute))
+	src["Kitchen"].Values.CO2 = 900
+	src["Kitchen"].Values.Score = 80
+	trends.record(now)
+	h := NewKioskHandler(src, trends, []string{"score", "temp", "co2"}, units.Metric)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/kiosk", nil))
+	assert.Equal("text/plain; charset=utf-8", w.Header().Get("Content-Type"))
+	// The sample from 20 minutes ago is outside the window.
+	assert.Equal("Kitchen\nScore 80 ↓\nTemperature 21.9 °C →\nCO₂ 900 ppm ↑\n", w.Body.String())
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/kiosk?format=json", nil))
+	devices := []map[string]interface{}{}
+	require.Nil(json.Unmarshal(w.Body.Bytes(), &devices))
+	assert.Equal([]map[string]interface{}{{
+		"name":        "Kitchen",
+		"score":       float64(80),
+		"score_trend": "down",
+		"temp":        21.9,
+		"temp_trend":  "steady",
+		"co2":         float64(900),
+		"co2_trend":   "up",
+	}}, devices)
+
+	w = httptest.NewRecorder()
+	NewKioskHandler(src, trends, []string{"temp"}, units.Imperial).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/kiosk", nil))
+	assert.Equal("Kitchen\nTemperature 71.4 °F →\n", w.Body.String())
+
+	delete(src, "Kitchen")
+	trends.record(now)
+	assert.Empty(trends.history)
+}
@@ -0,0 +1,197 @@
+// Package public serves a read-only status page of the air quality per
+// room, without device identifiers, for wall displays and sharing with
+// building occupants.
+package public
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"prometheus-awair-exporter/internal/exporter"
+	"prometheus-awair-exporter/internal/units"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ReadingSource provides the latest reading of every device, keyed by the
+// device's name, e.g. the room it is in.
+type ReadingSource interface {
+	NamedReadings() map[string]exporter.Reading
+}
+
+// Level rates a value as good, fair or poor.
+type Level string
+
+const (
+	Good Level = "good"
+	Fair Level = "fair"
+	Poor Level = "poor"
+)
+
+// band is a range of values, bounds inclusive.
+type band struct{ min, max float64 }
+
+func (b band) contains(v float64) bool {
+	return v >= b.min && v <= b.max
+}
+
+// metric describes how a field is shown and rated.
+type metric struct {
+	label    string
+	decimals int
+	good     band
+	fair     band
+}
+
+// metrics are the fields the page can show, keyed by Local API name. Levels
+// follow the bands of the Awair app, in metric units.
+var metrics = map[string]metric{
+	"score": {label: "Score", good: band{80, 100}, fair: band{60, 100}},
+	"temp":  {label: "Temperature", decimals: 1, good: band{20, 25}, fair: band{18, 27}},
+	"humid": {label: "Humidity", good: band{40, 50}, fair: band{30, 60}},
+	"co2":   {label: "CO₂", good: band{0, 1000}, fair: band{0, 1500}},
+	"voc":   {label: "Chemicals", good: band{0, 333}, fair: band{0, 1000}},
+	"pm25":  {label: "PM2.5", good: band{0, 15}, fair: band{0, 35}},
+}
+
+// format formats v, a value of field in metric units, in the units of u.
+func format(u units.System, field string, v float64) string {
+	value := strconv.FormatFloat(u.Convert(field, v), 'f', metrics[field].decimals, 64)
+	if symbol := u.Symbol(field); symbol != "" {
+		value += " " + symbol
+	}
+	return value
+}
+
+// DefaultMetrics are the fields shown unless configured otherwise.
+var DefaultMetrics = []string{"score", "temp", "humid", "co2", "pm25"}
+
+// Rate returns the level of value v of field, reporting false for fields
+// the page can't show.
+func Rate(field string, v float64) (Level, bool) {
+	m, ok := metrics[field]
+	switch {
+	case !ok:
+		return "", false
+	case m.good.contains(v):
+		return Good, true
+	case m.fair.contains(v):
+		return Fair, true
+	default:
+		return Poor, true
+	}
+}
+
+// ParseMetrics parses a comma separated list of fields to show.
+func ParseMetrics(s string) ([]string, error) {
+	fields := []string{}
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		if _, ok := metrics[f]; !ok {
+			known := make([]string, 0, len(metrics))
+			for name := range metrics {
+				known = append(known, name)
+			}
+			sort.Strings(known)
+			return nil, fmt.Errorf("unknown metric %q (available: %s)", f, strings.Join(known, ", "))
+		}
+		fields = append(fields, f)
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("no metrics selected")
+	}
+	return fields, nil
+}
+
+type cell struct {
+	Label string
+	Value string
+	Level Level
+	Trend template.HTML
+}
+
+type room struct {
+	Name  string
+	Cells []cell
+}
+
+var page = template.Must(template.New("public").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="60">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Air quality</title>
+<style>
+body { font-family: sans-serif; background: #fafafa; }
+.room { display: inline-block; vertical-align: top; margin: 1em; padding: 1em; background: #fff; border-radius: 8px; }
+.value { margin: .3em 0; padding: .3em .6em; border-radius: 4px; color: #fff; }
+.good { background: #2e9e44; }
+.fair { background: #e8a317; }
+.poor { background: #d0312d; }
+.trend { margin-left: .6em; vertical-align: middle; }
+</style>
+</head>
+<body>
+<h1>Air quality</h1>
+{{- range .}}
+<div class="room">
+<h2>{{.Name}}</h2>
+{{- range .Cells}}
+<div class="value {{.Level}}">{{.Label}}: {{.Value}}{{.Trend}}</div>
+{{- end}}
+</div>
+{{- else}}
+<p>No readings yet.</p>
+{{- end}}
+</body>
+</html>
+`))
+
+// NewHandler serves the status page, showing fields for every device of
+// src by name in the units of u. With a history source, each value comes
+// with a chart of its recent trend.
+func NewHandler(src ReadingSource, fields []string, hist HistorySource, u units.System) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		readings := src.NamedReadings()
+		trends := map[string]map[string][]float64{}
+		if hist != nil {
+			for _, field := range fields {
+				values, err := hist.History(r.Context(), field)
+				if err != nil {
+					log.Warn().Err(err).Str("field", field).Msg("Failed to query trend history")
+					continue
+				}
+				trends[field] = values
+			}
+		}
+		rooms := make([]room, 0, len(readings))
+		for name, reading := range readings {
+			values := map[string]float64{}
+			for _, f := range reading.Values.Fields() {
+				values[f.Name] = f.Value
+			}
+			rm := room{Name: name}
+			for _, field := range fields {
+				level, _ := Rate(field, values[field])
+				trend := sparkline(trends[field][reading.Config.DeviceUUID])
+				rm.Cells = append(rm.Cells, cell{Label: metrics[field].label, Value: format(u, field, values[field]), Level: level, Trend: trend})
+			}
+			rooms = append(rooms, rm)
+		}
+		sort.Slice(rooms, func(i, j int) bool {
+			return rooms[i].Name < rooms[j].Name
+		})
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := page.Execute(w, rooms); err != nil {
+			log.Error().Err(err).Msg("Failed to render public status page")
+		}
+	})
+}
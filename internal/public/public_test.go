@@ -0,0 +1,81 @@
+package public
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"prometheus-awair-exporter/internal/exporter"
+	"prometheus-awair-exporter/internal/units"
+
+	"github.com/tj/assert"
+)
+
+type staticSource map[string]exporter.Reading
+
+func (s staticSource) NamedReadings() map[string]exporter.Reading {
+	return s
+}
+
+func TestRate(t *testing.T) {
+	assert := assert.New(t)
+	for _, c := range []struct {
+		field string
+		value float64
+		level Level
+	}{
+		{"score", 85, Good},
+		{"score", 65, Fair},
+		{"score", 40, Poor},
+		{"co2", 600, Good},
+		{"co2", 1200, Fair},
+		{"co2", 2000, Poor},
+		{"humid", 35, Fair},
+		{"humid", 20, Poor},
+	} {
+		level, ok := Rate(c.field, c.value)
+		assert.True(ok)
+		assert.Equal(c.level, level, "%s %v", c.field, c.value)
+	}
+	_, ok := Rate("voc_h2_raw", 1)
+	assert.False(ok)
+}
+
+func TestParseMetrics(t *testing.T) {
+	assert := assert.New(t)
+	fields, err := ParseMetrics("co2, pm25")
+	assert.Nil(err)
+	assert.Equal([]string{"co2", "pm25"}, fields)
+	_, err = ParseMetrics("co2,radon")
+	assert.NotNil(err)
+	_, err = ParseMetrics("")
+	assert.NotNil(err)
+}
+
+func TestHandler(t *testing.T) {
+	assert := assert.New(t)
+	src := staticSource{
+		"Meeting room": {
+			Config: &exporter.ConfigResponse{DeviceUUID: "awair-element_1"},
+			Values: &exporter.AwairValues{Score: 55, Temp: 21.13, CO2: 1800},
+		},
+		"Kitchen": {
+			Config: &exporter.ConfigResponse{DeviceUUID: "awair-element_2"},
+			Values: &exporter.AwairValues{Score: 90, Temp: 22, CO2: 500},
+		},
+	}
+	w := httptest.NewRecorder()
+	NewHandler(src, []string{"score", "temp", "co2"}, nil, units.Metric).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/public", nil))
+	body := w.Body.String()
+
+	assert.Equal("text/html; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.NotContains(body, "awair-element", "device identifiers are not shown")
+	assert.Contains(body, `<div class="value poor">CO₂: 1800 ppm</div>`)
+	assert.Contains(body, `<div class="value good">Temperature: 21.1 °C</div>`)
+	assert.Less(strings.Index(body, "Kitchen"), strings.Index(body, "Meeting room"), "rooms are sorted by name")
+
+	w = httptest.NewRecorder()
+	NewHandler(src, []string{"temp"}, nil, units.Imperial).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/public", nil))
+	assert.Contains(w.Body.String(), `<div class="value good">Temperature: 70.0 °F</div>`, "rated in metric units")
+}
@@ -0,0 +1,107 @@
+package public
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"strings"
+	"sync"
+	"time"
+
+	"prometheus-awair-exporter/internal/history"
+	"prometheus-awair-exporter/internal/promquery"
+)
+
+// HistorySource provides the recent values of a field, keyed by device UUID.
+type HistorySource interface {
+	History(ctx context.Context, field string) (map[string][]float64, error)
+}
+
+// RangeQuerier runs range queries, e.g. a *promquery.Client.
+type RangeQuerier interface {
+	QueryRange(ctx context.Context, query string, start, end time.Time, step time.Duration) ([]promquery.Series, error)
+}
+
+// PrometheusHistory queries the series the exporter serves from the
+// Prometheus server scraping it, so the status page can show trends
+// without viewers having access to Prometheus. Results are cached for a
+// step.
+type PrometheusHistory struct {
+	querier RangeQuerier
+	window  time.Duration
+	step    time.Duration
+
+	mu    sync.Mutex
+	cache map[string]cachedHistory
+}
+
+type cachedHistory struct {
+	at     time.Time
+	values map[string][]float64
+}
+
+// NewPrometheusHistory returns a HistorySource of the past window, sampled
+// every step.
+func NewPrometheusHistory(querier RangeQuerier, window, step time.Duration) *PrometheusHistory {
+	return &PrometheusHistory{querier: querier, window: window, step: step, cache: map[string]cachedHistory{}}
+}
+
+func (p *PrometheusHistory) History(ctx context.Context, field string) (map[string][]float64, error) {
+	name, ok := history.MetricName(field)
+	if !ok {
+		return nil, fmt.Errorf("unknown field %q", field)
+	}
+	now := time.Now()
+	p.mu.Lock()
+	cached, ok := p.cache[field]
+	p.mu.Unlock()
+	if ok && now.Sub(cached.at) < p.step {
+		return cached.values, nil
+	}
+
+	series, err := p.querier.QueryRange(ctx, name, now.Add(-p.window), now, p.step)
+	if err != nil {
+		return nil, err
+	}
+	values := map[string][]float64{}
+	for _, s := range series {
+		uuid := s.Labels["device_uuid"]
+		for _, point := range s.Points {
+			values[uuid] = append(values[uuid], point.Value)
+		}
+	}
+	p.mu.Lock()
+	p.cache[field] = cachedHistory{at: now, values: values}
+	p.mu.Unlock()
+	return values, nil
+}
+
+// sparkline renders values as a small inline SVG line chart.
+func sparkline(values []float64) template.HTML {
+	if len(values) < 2 {
+		return ""
+	}
+	const width, height = 120.0, 24.0
+	min, max := values[0], values[0]
+	for _, v := range values {
+		if v < min {
+			min = v
+		}
+		if v > max {
+			max = v
+		}
+	}
+	span := max - min
+	if span == 0 {
+		span = 1
+	}
+	points := make([]string, len(values))
+	for i, v := range values {
+		x := width * float64(i) / float64(len(values)-1)
+		y := height - height*(v-min)/span
+		points[i] = fmt.Sprintf("%.1f,%.1f", x, y)
+	}
+	return template.HTML(fmt.Sprintf(
+		`<svg class="trend" width="%.0f" height="%.0f" viewBox="-1 -1 %.0f %.0f"><polyline fill="none" stroke="currentColor" points="%s"/></svg>`,
+		width, height, width+2, height+2, strings.Join(points, " ")))
+}
@@ -0,0 +1,54 @@
+package public
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"prometheus-awair-exporter/internal/exporter"
+	"prometheus-awair-exporter/internal/promquery"
+	"prometheus-awair-exporter/internal/units"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tj/assert"
+)
+
+type fakeQuerier struct {
+	queries []string
+}
+
+func (q *fakeQuerier) QueryRange(_ context.Context, query string, start, end time.Time, step time.Duration) ([]promquery.Series, error) {
+	q.queries = append(q.queries, query)
+	return []promquery.Series{{
+		Labels: map[string]string{"device_uuid": "awair-element_1"},
+		Points: []promquery.Point{{Time: start, Value: 600}, {Time: end, Value: 900}},
+	}}, nil
+}
+
+func TestPrometheusHistory(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	q := &fakeQuerier{}
+	hist := NewPrometheusHistory(q, 24*time.Hour, 15*time.Minute)
+
+	values, err := hist.History(context.Background(), "co2")
+	require.Nil(err)
+	assert.Equal(map[string][]float64{"awair-element_1": {600, 900}}, values)
+	_, err = hist.History(context.Background(), "co2")
+	require.Nil(err)
+	assert.Equal([]string{"awair_co2"}, q.queries, "results are cached for a step")
+	_, err = hist.History(context.Background(), "co")
+	assert.NotNil(err)
+
+	src := staticSource{"Kitchen": {
+		Config: &exporter.ConfigResponse{DeviceUUID: "awair-element_1"},
+		Values: &exporter.AwairValues{CO2: 900},
+	}}
+	w := httptest.NewRecorder()
+	NewHandler(src, []string{"co2"}, hist, units.Metric).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/public", nil))
+	assert.Contains(w.Body.String(), `CO₂: 900 ppm<svg class="trend"`)
+	assert.Contains(w.Body.String(), `points="0.0,24.0 120.0,0.0"`)
+	assert.NotContains(w.Body.String(), "awair-element")
+}
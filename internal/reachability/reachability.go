@@ -0,0 +1,248 @@
+// Package reachability probes whether devices answer on the network, at a
+// faster interval than their readings are polled, so network problems show
+// within seconds of occurring.
+package reachability
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"prometheus-awair-exporter/internal/exporter"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+// Probe methods: connecting to the Local API's port, or an ICMP echo
+// request, which needs the CAP_NET_RAW capability.
+const (
+	MethodTCP  = "tcp"
+	MethodICMP = "icmp"
+)
+
+// DeviceSource lists the devices to probe, those with an address.
+type DeviceSource interface {
+	Devices() []exporter.DeviceInfo
+}
+
+// result is the outcome of the latest probe of a device.
+type result struct {
+	device    exporter.DeviceInfo
+	reachable bool
+	duration  time.Duration
+}
+
+// Prober probes the devices every interval, and is a collector of the
+// outcome of the latest probe of each.
+type Prober struct {
+	src     DeviceSource
+	method  string
+	timeout time.Duration
+	probe   func(ctx context.Context, address string) error
+
+	mu      sync.Mutex
+	results map[string]result
+
+	reachable *prometheus.Desc
+	duration  *prometheus.Desc
+}
+
+// New returns a Prober of the devices of src, with method, e.g. MethodTCP,
+// giving up on a device after timeout.
+func New(src DeviceSource, method string, timeout time.Duration) (*Prober, error) {
+	p := &Prober{
+		src:     src,
+		method:  method,
+		timeout: timeout,
+		results: map[string]result{},
+		reachable: prometheus.NewDesc(
+			prometheus.BuildFQName("awair", "device", "reachable"),
+			"Whether the device answered the latest reachability probe (1) or not (0)",
+			[]string{"device_uuid", "name"}, nil,
+		),
+		duration: prometheus.NewDesc(
+			prometheus.BuildFQName("awair", "device", "reachability_probe_duration_seconds"),
+			"Duration of the latest reachability probe of the device which it answered",
+			[]string{"device_uuid", "name"}, nil,
+		),
+	}
+	switch method {
+	case MethodTCP:
+		p.probe = p.probeTCP
+	case MethodICMP:
+		p.probe = p.probeICMP
+	default:
+		return nil, fmt.Errorf("unknown reachability probe method %q, expected %s or %s", method, MethodTCP, MethodICMP)
+	}
+	return p, nil
+}
+
+// Run probes the devices every interval until ctx is done.
+func (p *Prober) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		p.probeAll(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// probeAll probes all devices with an address at once, logging those
+// becoming unreachable or reachable again, and forgets the devices which
+// are gone.
+func (p *Prober) probeAll(ctx context.Context) {
+	devices := []exporter.DeviceInfo{}
+	for _, d := range p.src.Devices() {
+		if d.Address != "" {
+			devices = append(devices, d)
+		}
+	}
+	results := make([]result, len(devices))
+	wg := sync.WaitGroup{}
+	for i, d := range devices {
+		wg.Add(1)
+		go func(i int, d exporter.DeviceInfo) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, p.timeout)
+			defer cancel()
+			start := time.Now()
+			err := p.probe(ctx, d.Address)
+			results[i] = result{device: d, reachable: err == nil, duration: time.Since(start)}
+			if err != nil {
+				log.Debug().Err(err).Str("name", d.Name).Str("address", d.Address).Msg("Reachability probe failed.")
+			}
+		}(i, d)
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	previous := p.results
+	p.results = map[string]result{}
+	for _, r := range results {
+		if last, ok := previous[r.device.Name]; ok && last.reachable != r.reachable {
+			event := log.Warn()
+			msg := "Device became unreachable."
+			if r.reachable {
+				event, msg = log.Info(), "Device is reachable again."
+			}
+			event.Str("name", r.device.Name).Str("address", r.device.Address).Str("method", p.method).Msg(msg)
+		}
+		p.results[r.device.Name] = r
+	}
+}
+
+// probeTCP connects to the address, at port 80 unless it has a port.
+func (p *Prober) probeTCP(ctx context.Context, address string) error {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, "80")
+	}
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", address)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// echoSeq numbers the ICMP echo requests, telling their replies apart.
+var echoSeq atomic.Uint32
+
+// probeICMP sends an ICMP echo request to the address, ignoring any port,
+// and waits for the reply.
+func (p *Prober) probeICMP(ctx context.Context, address string) error {
+	host := address
+	if h, _, err := net.SplitHostPort(address); err == nil {
+		host = h
+	}
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip4", host)
+	if err != nil {
+		return err
+	}
+	dst := &net.IPAddr{IP: ips[0]}
+	conn, err := net.ListenPacket("ip4:icmp", "0.0.0.0")
+	if err != nil {
+		return fmt.Errorf("listening for ICMP, which requires CAP_NET_RAW: %w", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	id, seq := uint16(os.Getpid()), uint16(echoSeq.Add(1))
+	if _, err := conn.WriteTo(echoRequest(id, seq), dst); err != nil {
+		return err
+	}
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		if ip, ok := from.(*net.IPAddr); ok && ip.IP.Equal(dst.IP) && isEchoReply(buf[:n], id, seq) {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+}
+
+// echoRequest returns an ICMP echo request message.
+func echoRequest(id, seq uint16) []byte {
+	msg := []byte{8, 0, 0, 0, 0, 0, 0, 0, 'a', 'w', 'a', 'i', 'r'}
+	binary.BigEndian.PutUint16(msg[4:], id)
+	binary.BigEndian.PutUint16(msg[6:], seq)
+	binary.BigEndian.PutUint16(msg[2:], checksum(msg))
+	return msg
+}
+
+// isEchoReply reports whether msg is the ICMP echo reply to the request
+// with id and seq.
+func isEchoReply(msg []byte, id, seq uint16) bool {
+	return len(msg) >= 8 && msg[0] == 0 && msg[1] == 0 &&
+		binary.BigEndian.Uint16(msg[4:]) == id && binary.BigEndian.Uint16(msg[6:]) == seq
+}
+
+// checksum returns the Internet checksum of b.
+func checksum(b []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(b[i:]))
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
+
+func (p *Prober) Describe(ch chan<- *prometheus.Desc) {
+	ch <- p.reachable
+	ch <- p.duration
+}
+
+func (p *Prober) Collect(ch chan<- prometheus.Metric) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, r := range p.results {
+		value := 0.0
+		if r.reachable {
+			value = 1
+			ch <- prometheus.MustNewConstMetric(p.duration, prometheus.GaugeValue, r.duration.Seconds(), r.device.DeviceUUID, r.device.Name)
+		}
+		ch <- prometheus.MustNewConstMetric(p.reachable, prometheus.GaugeValue, value, r.device.DeviceUUID, r.device.Name)
+	}
+}
@@ -0,0 +1,64 @@
+package reachability
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"prometheus-awair-exporter/internal/exporter"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"github.com/tj/assert"
+)
+
+type fakeDevices []exporter.DeviceInfo
+
+func (f fakeDevices) Devices() []exporter.DeviceInfo {
+	return f
+}
+
+func TestProber(t *testing.T) {
+	up, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer up.Close()
+	down, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	down.Close()
+
+	devices := fakeDevices{
+		{Name: "bedroom", DeviceUUID: "awair-element_1", Address: up.Addr().String()},
+		{Name: "attic", DeviceUUID: "awair-element_2", Address: down.Addr().String()},
+		{Name: "office", DeviceUUID: "awair-element_3"},
+	}
+	p, err := New(devices, MethodTCP, time.Second)
+	require.Nil(t, err)
+	p.probeAll(context.Background())
+
+	expected := `
+# HELP awair_device_reachable Whether the device answered the latest reachability probe (1) or not (0)
+# TYPE awair_device_reachable gauge
+awair_device_reachable{device_uuid="awair-element_1",name="bedroom"} 1
+awair_device_reachable{device_uuid="awair-element_2",name="attic"} 0
+`
+	assert.Nil(t, testutil.CollectAndCompare(p, strings.NewReader(expected), "awair_device_reachable"))
+	assert.Equal(t, 1, testutil.CollectAndCount(p, "awair_device_reachability_probe_duration_seconds"))
+
+	p.src = devices[:1]
+	p.probeAll(context.Background())
+	assert.Equal(t, 1, testutil.CollectAndCount(p, "awair_device_reachable"), "removed devices are forgotten")
+
+	_, err = New(devices, "udp", time.Second)
+	assert.EqualError(t, err, `unknown reachability probe method "udp", expected tcp or icmp`)
+}
+
+func TestEchoRequest(t *testing.T) {
+	msg := echoRequest(0x1234, 7)
+	assert.Equal(t, uint16(0), checksum(msg), "the checksum of a message with its checksum is 0")
+	reply := append([]byte{0, 0}, msg[2:]...)
+	assert.True(t, isEchoReply(reply, 0x1234, 7))
+	assert.False(t, isEchoReply(reply, 0x1234, 8))
+	assert.False(t, isEchoReply(msg, 0x1234, 7))
+}
@@ -0,0 +1,84 @@
+// Package recovery keeps the exporter alive when a poller, collector or
+// HTTP handler panics, reporting the panic instead.
+package recovery
+
+import (
+	"net/http"
+	"runtime/debug"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+// Recoverer reports recovered panics in logs and a counter. A nil
+// Recoverer still recovers and logs panics.
+type Recoverer struct {
+	panics *prometheus.CounterVec
+}
+
+func New() *Recoverer {
+	return &Recoverer{
+		panics: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "awair_exporter",
+				Name:      "panics_total",
+				Help:      "Number of panics recovered, by component",
+			},
+			[]string{"component"},
+		),
+	}
+}
+
+// Recover recovers a panic of the calling goroutine and reports it with the
+// stack and the given context, e.g. the device's hostname. It must be
+// deferred directly:
+//
+//	defer r.Recover("poller", map[string]string{"hostname": hostname})
+func (r *Recoverer) Recover(component string, fields map[string]string) {
+	v := recover()
+	if v == nil {
+		return
+	}
+	r.report(component, v, fields)
+}
+
+// Handler wraps h, answering requests which panic with a 500 instead of
+// dropping the connection.
+func (r *Recoverer) Handler(component string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			r.report(component, v, map[string]string{"path": req.URL.Path})
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}()
+		h.ServeHTTP(w, req)
+	})
+}
+
+func (r *Recoverer) report(component string, v interface{}, fields map[string]string) {
+	if r != nil {
+		r.panics.WithLabelValues(component).Inc()
+	}
+	event := log.Error().
+		Str("component", component).
+		Interface("panic", v).
+		Str("stack", string(debug.Stack()))
+	for k, v := range fields {
+		event = event.Str(k, v)
+	}
+	event.Msg("Recovered from panic.")
+}
+
+func (r *Recoverer) Describe(ch chan<- *prometheus.Desc) {
+	r.panics.Describe(ch)
+}
+
+func (r *Recoverer) Collect(ch chan<- prometheus.Metric) {
+	r.panics.Collect(ch)
+}
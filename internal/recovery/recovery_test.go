@@ -0,0 +1,49 @@
+package recovery
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tj/assert"
+)
+
+func TestRecover(t *testing.T) {
+	assert := assert.New(t)
+	r := New()
+
+	func() {
+		defer r.Recover("poller", map[string]string{"hostname": "192.168.1.2"})
+		panic("boom")
+	}()
+	func() {
+		defer r.Recover("poller", nil)
+	}()
+	assert.Equal(float64(1), testutil.ToFloat64(r.panics.WithLabelValues("poller")))
+
+	var nilRecoverer *Recoverer
+	func() {
+		defer nilRecoverer.Recover("poller", nil)
+		panic("boom")
+	}()
+}
+
+func TestHandler(t *testing.T) {
+	assert := assert.New(t)
+	r := New()
+	h := r.Handler("http", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		panic("boom")
+	}))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(http.StatusInternalServerError, rec.Code)
+	assert.Equal(float64(1), testutil.ToFloat64(r.panics.WithLabelValues("http")))
+
+	assert.Panics(func() {
+		r.Handler("http", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			panic(http.ErrAbortHandler)
+		})).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	})
+}
@@ -0,0 +1,130 @@
+package redis
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tj/assert"
+)
+
+// fakeRedis serves GET, SET (with NX), AUTH and SELECT from memory.
+type fakeRedis struct {
+	mu       sync.Mutex
+	values   map[string]string
+	commands [][]string
+}
+
+func (f *fakeRedis) serve(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			r := bufio.NewReader(conn)
+			for {
+				args, err := readCommand(r)
+				if err != nil {
+					return
+				}
+				fmt.Fprint(conn, f.handle(args))
+			}
+		}()
+	}
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+	args := []string{}
+	for i := 0; i < n; i++ {
+		if _, err := r.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		arg, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, strings.TrimSuffix(arg, "\r\n"))
+	}
+	return args, nil
+}
+
+func (f *fakeRedis) handle(args []string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.commands = append(f.commands, args)
+	switch args[0] {
+	case "GET":
+		v, ok := f.values[args[1]]
+		if !ok {
+			return "$-1\r\n"
+		}
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(v), v)
+	case "SET":
+		if len(args) > 3 && args[3] == "NX" {
+			if _, ok := f.values[args[1]]; ok {
+				return "$-1\r\n"
+			}
+		}
+		f.values[args[1]] = args[2]
+		return "+OK\r\n"
+	case "AUTH", "SELECT":
+		return "+OK\r\n"
+	}
+	return "-ERR unknown command\r\n"
+}
+
+func TestClient(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(err)
+	defer l.Close()
+	f := &fakeRedis{values: map[string]string{}}
+	go f.serve(l)
+
+	c, err := New("redis://:secret@"+l.Addr().String()+"/2", "awair:")
+	require.Nil(err)
+
+	_, ok, err := c.Get("sample")
+	assert.Nil(err)
+	assert.False(ok)
+
+	assert.Nil(c.Set("sample", []byte(`{"score":89}`), 10*time.Second))
+	v, ok, err := c.Get("sample")
+	assert.Nil(err)
+	assert.True(ok)
+	assert.Equal(`{"score":89}`, string(v))
+
+	locked, err := c.Lock("lock", time.Second)
+	assert.Nil(err)
+	assert.True(locked)
+	locked, err = c.Lock("lock", time.Second)
+	assert.Nil(err)
+	assert.False(locked)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	assert.Equal([]string{"AUTH", "secret"}, f.commands[0])
+	assert.Equal([]string{"SELECT", "2"}, f.commands[1])
+	assert.Equal([]string{"SET", "awair:sample", `{"score":89}`, "PX", "10000"}, f.commands[3])
+}
+
+func TestNewInvalidURL(t *testing.T) {
+	_, err := New("http://localhost", "")
+	assert.NotNil(t, err)
+	_, err = New("redis://localhost/db", "")
+	assert.NotNil(t, err)
+}
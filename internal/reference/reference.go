@@ -0,0 +1,189 @@
+// Package reference compares devices with reference instruments, to help
+// calibrating them.
+package reference
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"prometheus-awair-exporter/internal/config"
+	"prometheus-awair-exporter/internal/exporter"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultSensors are compared unless a pair lists its own.
+var DefaultSensors = []string{"co2", "pm25"}
+
+// DefaultWindow is the period compared unless a pair sets its own.
+const DefaultWindow = 24 * time.Hour
+
+// ReadingSource provides the latest reading of every device by name.
+type ReadingSource interface {
+	NamedReadings() map[string]exporter.Reading
+}
+
+// sample is the difference between device and reference at a time.
+type sample struct {
+	at    time.Time
+	diffs map[string]float64
+}
+
+type pair struct {
+	config.Reference
+	samples []sample
+	// last identifies the reference reading compared last, so a manual
+	// entry is only compared once.
+	last string
+}
+
+// Comparator periodically compares the readings of each device with those
+// of its reference, exposing the bias, the mean difference, and the
+// standard deviation of the difference per sensor over the pair's window.
+type Comparator struct {
+	src ReadingSource
+
+	mu    sync.Mutex
+	pairs []*pair
+
+	bias      *prometheus.Desc
+	deviation *prometheus.Desc
+	count     *prometheus.Desc
+}
+
+// New returns a Comparator for the given pairs, filling in defaults.
+func New(src ReadingSource, refs []config.Reference) (*Comparator, error) {
+	known := map[string]bool{}
+	for _, f := range (&exporter.AwairValues{}).Fields() {
+		known[f.Name] = true
+	}
+	c := &Comparator{src: src}
+	for _, ref := range refs {
+		if ref.Device == "" || ref.Reference == "" {
+			return nil, fmt.Errorf("reference needs both a device and a reference")
+		}
+		if len(ref.Sensors) == 0 {
+			ref.Sensors = DefaultSensors
+		}
+		for _, s := range ref.Sensors {
+			if !known[s] {
+				return nil, fmt.Errorf("unknown sensor %q in reference of %s", s, ref.Device)
+			}
+		}
+		if ref.Window <= 0 {
+			ref.Window = DefaultWindow
+		}
+		c.pairs = append(c.pairs, &pair{Reference: ref})
+	}
+
+	labels := []string{"device_uuid", "reference", "sensor"}
+	c.bias = prometheus.NewDesc(
+		prometheus.BuildFQName("awair", "reference", "bias"),
+		"Mean difference between the device's and the reference's reading over the comparison window",
+		labels, nil,
+	)
+	c.deviation = prometheus.NewDesc(
+		prometheus.BuildFQName("awair", "reference", "deviation"),
+		"Standard deviation of the difference between the device's and the reference's reading over the comparison window",
+		labels, nil,
+	)
+	c.count = prometheus.NewDesc(
+		prometheus.BuildFQName("awair", "reference", "samples"),
+		"Number of readings compared over the comparison window",
+		[]string{"device_uuid", "reference"}, nil,
+	)
+	return c, nil
+}
+
+// Run compares the readings at interval until ctx is done.
+func (c *Comparator) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			c.record(now)
+		}
+	}
+}
+
+func fields(v *exporter.AwairValues) map[string]float64 {
+	values := map[string]float64{}
+	for _, f := range v.Fields() {
+		values[f.Name] = f.Value
+	}
+	return values
+}
+
+// record compares the current readings of every pair whose reference has a
+// reading not compared yet.
+func (c *Comparator) record(now time.Time) {
+	readings := c.src.NamedReadings()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, p := range c.pairs {
+		device, ok := readings[p.Device]
+		ref, refOK := readings[p.Reference.Reference]
+		kept := p.samples[:0]
+		for _, s := range p.samples {
+			if now.Sub(s.at) <= p.Window {
+				kept = append(kept, s)
+			}
+		}
+		p.samples = kept
+		if !ok || !refOK {
+			continue
+		}
+		refValues := fields(ref.Values)
+		id := fmt.Sprint(ref.Values.Timestamp, refValues)
+		if id == p.last {
+			continue
+		}
+		p.last = id
+		deviceValues := fields(device.Values)
+		diffs := map[string]float64{}
+		for _, sensor := range p.Sensors {
+			diffs[sensor] = deviceValues[sensor] - refValues[sensor]
+		}
+		p.samples = append(p.samples, sample{at: now, diffs: diffs})
+	}
+}
+
+func (c *Comparator) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.bias
+	ch <- c.deviation
+	ch <- c.count
+}
+
+func (c *Comparator) Collect(ch chan<- prometheus.Metric) {
+	readings := c.src.NamedReadings()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, p := range c.pairs {
+		device, ok := readings[p.Device]
+		if !ok || len(p.samples) == 0 {
+			continue
+		}
+		uuid := device.Config.DeviceUUID
+		n := float64(len(p.samples))
+		ch <- prometheus.MustNewConstMetric(c.count, prometheus.GaugeValue, n, uuid, p.Reference.Reference)
+		for _, sensor := range p.Sensors {
+			mean := 0.0
+			for _, s := range p.samples {
+				mean += s.diffs[sensor]
+			}
+			mean /= n
+			variance := 0.0
+			for _, s := range p.samples {
+				variance += (s.diffs[sensor] - mean) * (s.diffs[sensor] - mean)
+			}
+			ch <- prometheus.MustNewConstMetric(c.bias, prometheus.GaugeValue, mean, uuid, p.Reference.Reference, sensor)
+			ch <- prometheus.MustNewConstMetric(c.deviation, prometheus.GaugeValue, math.Sqrt(variance/n), uuid, p.Reference.Reference, sensor)
+		}
+	}
+}
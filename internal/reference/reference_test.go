@@ -0,0 +1,72 @@
+package reference
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"prometheus-awair-exporter/internal/config"
+	"prometheus-awair-exporter/internal/exporter"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"github.com/tj/assert"
+)
+
+type staticSource map[string]exporter.Reading
+
+func (s staticSource) NamedReadings() map[string]exporter.Reading {
+	return s
+}
+
+func TestComparator(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	src := staticSource{
+		"bedroom": {
+			Config: &exporter.ConfigResponse{DeviceUUID: "awair-element_1"},
+			Values: &exporter.AwairValues{CO2: 620},
+		},
+	}
+	c, err := New(src, []config.Reference{{Device: "bedroom", Reference: "lab", Sensors: []string{"co2"}, Window: time.Hour}})
+	require.Nil(err)
+
+	now := time.Now()
+	c.record(now.Add(-2 * time.Hour))
+	assert.Equal(0, testutil.CollectAndCount(c), "nothing to compare without a reference reading")
+
+	src["lab"] = exporter.Reading{Values: &exporter.AwairValues{CO2: 600}}
+	c.record(now.Add(-70 * time.Minute))
+	c.record(now.Add(-65 * time.Minute))
+	assert.Len(c.pairs[0].samples, 1, "a reference reading is compared once")
+
+	src["lab"] = exporter.Reading{Values: &exporter.AwairValues{CO2: 590}}
+	src["bedroom"].Values.CO2 = 630
+	c.record(now.Add(-40 * time.Minute))
+	src["lab"] = exporter.Reading{Values: &exporter.AwairValues{CO2: 570}}
+	src["bedroom"].Values.CO2 = 630
+	c.record(now)
+
+	// The first sample is outside the window: differences 40 and 60.
+	err = testutil.CollectAndCompare(c, strings.NewReader(`
+# HELP awair_reference_bias Mean difference between the device's and the reference's reading over the comparison window
+# TYPE awair_reference_bias gauge
+awair_reference_bias{device_uuid="awair-element_1",reference="lab",sensor="co2"} 50
+# HELP awair_reference_deviation Standard deviation of the difference between the device's and the reference's reading over the comparison window
+# TYPE awair_reference_deviation gauge
+awair_reference_deviation{device_uuid="awair-element_1",reference="lab",sensor="co2"} 10
+# HELP awair_reference_samples Number of readings compared over the comparison window
+# TYPE awair_reference_samples gauge
+awair_reference_samples{device_uuid="awair-element_1",reference="lab"} 2
+`))
+	assert.Nil(err)
+
+	_, err = New(src, []config.Reference{{Device: "bedroom", Reference: "lab", Sensors: []string{"radon"}}})
+	assert.NotNil(err)
+	_, err = New(src, []config.Reference{{Device: "bedroom"}})
+	assert.NotNil(err)
+	c, err = New(src, []config.Reference{{Device: "bedroom", Reference: "lab"}})
+	require.Nil(err)
+	assert.Equal(DefaultSensors, c.pairs[0].Sensors)
+	assert.Equal(DefaultWindow, c.pairs[0].Window)
+}
@@ -0,0 +1,162 @@
+// Package remotewrite pushes samples to an endpoint of the Prometheus remote
+// write protocol, such as Prometheus with --web.enable-remote-write-receiver,
+// Mimir or VictoriaMetrics.
+package remotewrite
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+)
+
+// maxAttempts bounds how often a write rejected by an overloaded or
+// failing endpoint is tried.
+const maxAttempts = 4
+
+// maxBatchSamples bounds the number of samples written per request, as
+// endpoints limit the size of requests.
+const maxBatchSamples = 10000
+
+// Label is a label of a series, __name__ for its metric name.
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Sample is a value at a time.
+type Sample struct {
+	Time  time.Time
+	Value float64
+}
+
+// Series are the samples of a series, in time order.
+type Series struct {
+	Labels  []Label
+	Samples []Sample
+}
+
+// message is a protobuf message under construction.
+type message []byte
+
+func (m message) tag(field, wireType int) message {
+	return binary.AppendUvarint(m, uint64(field<<3|wireType))
+}
+
+func (m message) bytes(field int, b []byte) message {
+	m = binary.AppendUvarint(m.tag(field, 2), uint64(len(b)))
+	return append(m, b...)
+}
+
+// Encode returns the snappy compressed WriteRequest protobuf message of
+// series, with the labels of each sorted by name as the protocol requires.
+func Encode(series []Series) []byte {
+	req := message{}
+	for _, s := range series {
+		labels := append([]Label(nil), s.Labels...)
+		sort.Slice(labels, func(i, j int) bool { return labels[i].Name < labels[j].Name })
+		ts := message{}
+		for _, l := range labels {
+			label := message{}.bytes(1, []byte(l.Name)).bytes(2, []byte(l.Value))
+			ts = ts.bytes(1, label)
+		}
+		for _, smp := range s.Samples {
+			sample := binary.LittleEndian.AppendUint64(message{}.tag(1, 1), math.Float64bits(smp.Value))
+			sample = binary.AppendUvarint(message(sample).tag(2, 0), uint64(smp.Time.UnixMilli()))
+			ts = ts.bytes(2, sample)
+		}
+		req = req.bytes(1, ts)
+	}
+	return snappy.Encode(nil, req)
+}
+
+// Client writes to a remote write endpoint. Credentials in the URL are sent
+// by basic authentication.
+type Client struct {
+	url    string
+	client *http.Client
+	// backoff is the wait before the second attempt of a write, doubling
+	// with every further one.
+	backoff time.Duration
+}
+
+// New returns a Client writing to url, e.g.
+// http://prometheus:9090/api/v1/write.
+func New(url string) *Client {
+	return &Client{url: url, client: &http.Client{Timeout: time.Minute}, backoff: time.Second}
+}
+
+// Write writes series, in requests of at most maxBatchSamples samples which
+// keep the samples of each series in order. Requests are retried while the
+// endpoint answers with a server error or 429. Other error responses, e.g.
+// for samples too old for the endpoint to accept, fail right away.
+func (c *Client) Write(ctx context.Context, series []Series) error {
+	batch, n := []Series{}, 0
+	for _, s := range series {
+		for samples := s.Samples; len(samples) > 0; {
+			take := maxBatchSamples - n
+			if take > len(samples) {
+				take = len(samples)
+			}
+			batch = append(batch, Series{Labels: s.Labels, Samples: samples[:take]})
+			samples, n = samples[take:], n+take
+			if n == maxBatchSamples {
+				if err := c.send(ctx, Encode(batch)); err != nil {
+					return err
+				}
+				batch, n = batch[:0], 0
+			}
+		}
+	}
+	if n == 0 {
+		return nil
+	}
+	return c.send(ctx, Encode(batch))
+}
+
+// send posts body, retrying as Write does.
+func (c *Client) send(ctx context.Context, body []byte) error {
+	wait := c.backoff
+	for attempt := 1; ; attempt++ {
+		retry, err := c.post(ctx, body)
+		if err == nil || !retry || attempt == maxAttempts {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+// post sends body once, reporting whether a failure is worth retrying.
+func (c *Client) post(ctx context.Context, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+		return retry, fmt.Errorf("remote write failed: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return false, nil
+}
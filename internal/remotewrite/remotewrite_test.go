@@ -0,0 +1,66 @@
+package remotewrite
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/stretchr/testify/require"
+	"github.com/tj/assert"
+)
+
+func TestEncode(t *testing.T) {
+	body, err := snappy.Decode(nil, Encode([]Series{{
+		Labels:  []Label{{"job", "a"}, {"__name__", "m"}},
+		Samples: []Sample{{time.UnixMilli(1000), 1.5}},
+	}}))
+	require.Nil(t, err)
+	label := func(name, value string) string {
+		return "\x0a" + string(rune(len(name))) + name + "\x12" + string(rune(len(value))) + value
+	}
+	labels := "\x0a\x0d" + label("__name__", "m") + "\x0a\x08" + label("job", "a")
+	sample := "\x12\x0c\x09\x00\x00\x00\x00\x00\x00\xf8\x3f\x10\xe8\x07"
+	series := labels + sample
+	assert.Equal(t, "\x0a"+string(rune(len(series)))+series, string(body))
+}
+
+func TestWrite(t *testing.T) {
+	requests := 0
+	bodies := [][]byte{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		assert.Equal(t, "snappy", r.Header.Get("Content-Encoding"))
+		assert.Equal(t, "application/x-protobuf", r.Header.Get("Content-Type"))
+		if requests == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, body)
+	}))
+	defer srv.Close()
+	c := New(srv.URL)
+	c.backoff = time.Millisecond
+
+	samples := make([]Sample, maxBatchSamples+1)
+	for i := range samples {
+		samples[i] = Sample{time.UnixMilli(int64(i)), float64(i)}
+	}
+	series := []Series{{Labels: []Label{{"__name__", "m"}}, Samples: samples}}
+	require.Nil(t, c.Write(context.Background(), series))
+	assert.Equal(t, 3, requests, "retried, then split in two")
+	require.Len(t, bodies, 2)
+	assert.Equal(t, Encode([]Series{{Labels: series[0].Labels, Samples: samples[:maxBatchSamples]}}), bodies[0])
+	assert.Equal(t, Encode([]Series{{Labels: series[0].Labels, Samples: samples[maxBatchSamples:]}}), bodies[1])
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "out of bounds", http.StatusBadRequest)
+	}))
+	defer failing.Close()
+	err := New(failing.URL).Write(context.Background(), series)
+	assert.EqualError(t, err, "remote write failed: 400 Bad Request: out of bounds")
+}
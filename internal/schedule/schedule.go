@@ -0,0 +1,182 @@
+// Package schedule evaluates times in the local time zone of devices and
+// runs jobs at times given as cron expressions, for the features acting on
+// the time of day, such as the overnight CO2 baseline and the digests,
+// rather than each keeping its own clock.
+package schedule
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Zones resolves the time zones devices report in their config, such as
+// America/Los_Angeles. Devices without a known time zone, e.g. those read
+// from the Awair Cloud, use the exporter's.
+type Zones struct {
+	fallback *time.Location
+
+	mu        sync.Mutex
+	locations map[string]*time.Location
+}
+
+// NewZones returns Zones falling back to the exporter's local time zone.
+func NewZones() *Zones {
+	return &Zones{fallback: time.Local, locations: map[string]*time.Location{}}
+}
+
+// In returns t in the time zone tz, named as in the tz database.
+func (z *Zones) In(t time.Time, tz string) time.Time {
+	if tz == "" {
+		return t.In(z.fallback)
+	}
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	loc, ok := z.locations[tz]
+	if !ok {
+		var err error
+		if loc, err = time.LoadLocation(tz); err != nil {
+			log.Warn().Err(err).Str("timezone", tz).Msg("Unknown device time zone, using the exporter's.")
+			loc = z.fallback
+		}
+		z.locations[tz] = loc
+	}
+	return t.In(loc)
+}
+
+// Window is the span of local hours [Start, End), wrapping around midnight
+// if Start is after End, e.g. a night.
+type Window struct {
+	Start int
+	End   int
+}
+
+// ParseWindow parses a window given as start-end hours, e.g. 0-6 or 22-5.
+func ParseWindow(s string) (Window, error) {
+	start, end, ok := strings.Cut(s, "-")
+	if !ok {
+		return Window{}, fmt.Errorf("window %q is not given as start-end hours", s)
+	}
+	w := Window{}
+	var err error
+	if w.Start, err = strconv.Atoi(start); err != nil || w.Start < 0 || w.Start > 23 {
+		return Window{}, fmt.Errorf("window %q has an invalid start hour", s)
+	}
+	if w.End, err = strconv.Atoi(end); err != nil || w.End < 0 || w.End > 23 {
+		return Window{}, fmt.Errorf("window %q has an invalid end hour", s)
+	}
+	if w.Start == w.End {
+		return Window{}, fmt.Errorf("window %q is empty", s)
+	}
+	return w, nil
+}
+
+// Day returns the day of the window t falls in, named after the day it
+// ends on, reporting false outside of the window. The hours are those of
+// the location of t.
+func (w Window) Day(t time.Time) (string, bool) {
+	hour := t.Hour()
+	if w.Start < w.End {
+		return t.Format("2006-01-02"), hour >= w.Start && hour < w.End
+	}
+	if hour >= w.Start {
+		return t.AddDate(0, 0, 1).Format("2006-01-02"), true
+	}
+	return t.Format("2006-01-02"), hour < w.End
+}
+
+// job is a function run at the times of a cron expression.
+type job struct {
+	name string
+	cron *Cron
+	loc  *time.Location
+	fn   func(now time.Time)
+	next time.Time
+}
+
+// Scheduler runs jobs at the times of their cron expressions, one at a
+// time, so jobs need not be safe for concurrent use with each other.
+type Scheduler struct {
+	now func() time.Time
+
+	mu   sync.Mutex
+	jobs []*job
+	wake chan struct{}
+}
+
+// New returns a Scheduler without jobs.
+func New() *Scheduler {
+	return &Scheduler{now: time.Now, wake: make(chan struct{}, 1)}
+}
+
+// Add runs fn, named name in logs, at the times of c in loc, e.g. the local
+// time zone of the exporter.
+func (s *Scheduler) Add(name string, c *Cron, loc *time.Location, fn func(now time.Time)) {
+	s.mu.Lock()
+	s.jobs = append(s.jobs, &job{name: name, cron: c, loc: loc, fn: fn, next: c.Next(s.now().In(loc))})
+	s.mu.Unlock()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run runs the jobs as they become due until ctx is done.
+func (s *Scheduler) Run(ctx context.Context) {
+	for {
+		timer := time.NewTimer(s.untilNext())
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-s.wake:
+			timer.Stop()
+		case <-timer.C:
+			s.runDue()
+		}
+	}
+}
+
+// untilNext returns the time until the next job is due, an hour without
+// jobs.
+func (s *Scheduler) untilNext() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	wait := time.Hour
+	now := s.now()
+	for _, j := range s.jobs {
+		if j.next.IsZero() {
+			continue
+		}
+		if d := j.next.Sub(now); d < wait {
+			wait = d
+		}
+	}
+	if wait < 0 {
+		wait = 0
+	}
+	return wait
+}
+
+// runDue runs the jobs which are due, in the order they were added.
+func (s *Scheduler) runDue() {
+	now := s.now()
+	s.mu.Lock()
+	due := []*job{}
+	for _, j := range s.jobs {
+		if !j.next.IsZero() && !now.Before(j.next) {
+			due = append(due, j)
+			j.next = j.cron.Next(now.In(j.loc))
+		}
+	}
+	s.mu.Unlock()
+	for _, j := range due {
+		log.Debug().Str("job", j.name).Time("next", j.next).Msg("Running scheduled job")
+		j.fn(now)
+	}
+}
@@ -0,0 +1,115 @@
+package schedule
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tj/assert"
+)
+
+func TestParseWindow(t *testing.T) {
+	assert := assert.New(t)
+	w, err := ParseWindow("22-5")
+	assert.Nil(err)
+	assert.Equal(Window{Start: 22, End: 5}, w)
+	for _, s := range []string{"", "6", "a-6", "0-24", "3-3"} {
+		_, err := ParseWindow(s)
+		assert.NotNil(err, s)
+	}
+}
+
+func TestWindowDay(t *testing.T) {
+	assert := assert.New(t)
+	at := func(day, hour int) time.Time {
+		return time.Date(2024, 3, day, hour, 30, 0, 0, time.Local)
+	}
+	wrapping := Window{Start: 22, End: 5}
+	day, ok := wrapping.Day(at(1, 23))
+	assert.True(ok)
+	assert.Equal("2024-03-02", day)
+	day, ok = wrapping.Day(at(2, 4))
+	assert.True(ok)
+	assert.Equal("2024-03-02", day)
+	_, ok = wrapping.Day(at(2, 5))
+	assert.False(ok)
+
+	early := Window{Start: 0, End: 6}
+	day, ok = early.Day(at(2, 0))
+	assert.True(ok)
+	assert.Equal("2024-03-02", day)
+	_, ok = early.Day(at(2, 23))
+	assert.False(ok)
+}
+
+func TestZones(t *testing.T) {
+	assert := assert.New(t)
+	z := NewZones()
+	noon := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	assert.Equal(21, z.In(noon, "Asia/Tokyo").Hour())
+	assert.Equal(time.Local, z.In(noon, "").Location())
+	assert.Equal(time.Local, z.In(noon, "Mars/Olympus_Mons").Location())
+}
+
+func TestParseCron(t *testing.T) {
+	for _, s := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "@yearly"} {
+		_, err := ParseCron(s)
+		assert.NotNil(t, err, s)
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	assert := assert.New(t)
+	// A Friday.
+	from := time.Date(2024, 3, 1, 7, 45, 30, 0, time.UTC)
+	for expr, next := range map[string]time.Time{
+		"*/15 * * * *":     time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC),
+		"30 7 * * 1-5":     time.Date(2024, 3, 4, 7, 30, 0, 0, time.UTC),
+		"@daily":           time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC),
+		"@weekly":          time.Date(2024, 3, 3, 0, 0, 0, 0, time.UTC),
+		"0 0 * * 7":        time.Date(2024, 3, 3, 0, 0, 0, 0, time.UTC),
+		"0 9 1,15 * *":     time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC),
+		"0 6 1,15 * *":     time.Date(2024, 3, 15, 6, 0, 0, 0, time.UTC),
+		"0 9 15 * 6":       time.Date(2024, 3, 2, 9, 0, 0, 0, time.UTC),
+		"0 0 29 2 *":       time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC),
+		"45 7 * * *":       time.Date(2024, 3, 2, 7, 45, 0, 0, time.UTC),
+		"5/20 22-23 * * *": time.Date(2024, 3, 1, 22, 5, 0, 0, time.UTC),
+	} {
+		c, err := ParseCron(expr)
+		require.Nil(t, err, expr)
+		assert.Equal(next, c.Next(from), expr)
+	}
+	c, err := ParseCron("0 0 30 2 *")
+	require.Nil(t, err)
+	assert.True(c.Next(from).IsZero())
+
+	// Local to the location of the time given.
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	require.Nil(t, err)
+	c, err = ParseCron("@daily")
+	require.Nil(t, err)
+	assert.Equal(time.Date(2024, 3, 1, 15, 0, 0, 0, time.UTC), c.Next(from.In(tokyo)).UTC())
+}
+
+func TestScheduler(t *testing.T) {
+	now := time.Date(2024, 3, 1, 23, 59, 59, 0, time.UTC)
+	s := New()
+	s.now = func() time.Time { return now }
+	daily, err := ParseCron("@daily")
+	require.Nil(t, err)
+	ran := make(chan time.Time, 1)
+	s.Add("daily", daily, time.UTC, func(at time.Time) { ran <- at })
+	assert.Equal(t, time.Second, s.untilNext())
+
+	s.runDue()
+	assert.Len(t, ran, 0, "not due yet")
+	now = now.Add(time.Second)
+	s.runDue()
+	assert.Equal(t, now, <-ran)
+	assert.Equal(t, time.Hour, s.untilNext(), "checked again within the hour")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s.Run(ctx)
+}
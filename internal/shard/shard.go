@@ -0,0 +1,58 @@
+// Package shard splits devices between exporter replicas.
+package shard
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+)
+
+// Shard is the n-th of m replicas, numbered from 1. The zero value owns
+// every device.
+type Shard struct {
+	N, M int
+}
+
+// Parse parses a shard given as "n/m", e.g. "2/3". An empty string returns
+// the zero Shard.
+func Parse(s string) (Shard, error) {
+	if s == "" {
+		return Shard{}, nil
+	}
+	n, m, ok := strings.Cut(s, "/")
+	if !ok {
+		return Shard{}, fmt.Errorf("invalid shard %q, expected n/m", s)
+	}
+	shard := Shard{}
+	var err error
+	if shard.N, err = strconv.Atoi(n); err != nil {
+		return Shard{}, fmt.Errorf("invalid shard %q: %w", s, err)
+	}
+	if shard.M, err = strconv.Atoi(m); err != nil {
+		return Shard{}, fmt.Errorf("invalid shard %q: %w", s, err)
+	}
+	if shard.M < 1 || shard.N < 1 || shard.N > shard.M {
+		return Shard{}, fmt.Errorf("invalid shard %q, n must be between 1 and m", s)
+	}
+	return shard, nil
+}
+
+func (s Shard) String() string {
+	if s.M == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d/%d", s.N, s.M)
+}
+
+// Owns reports whether the device with the given UUID is handled by this
+// shard. Every replica hashes UUIDs the same way, so each device is owned
+// by exactly one of them.
+func (s Shard) Owns(deviceUUID string) bool {
+	if s.M <= 1 {
+		return true
+	}
+	h := fnv.New32a()
+	h.Write([]byte(deviceUUID))
+	return int(h.Sum32()%uint32(s.M)) == s.N-1
+}
@@ -0,0 +1,48 @@
+package shard
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/tj/assert"
+)
+
+func TestParse(t *testing.T) {
+	assert := assert.New(t)
+
+	s, err := Parse("2/3")
+	assert.Nil(err)
+	assert.Equal(Shard{N: 2, M: 3}, s)
+	assert.Equal("2/3", s.String())
+
+	s, err = Parse("")
+	assert.Nil(err)
+	assert.Equal(Shard{}, s)
+
+	for _, invalid := range []string{"2", "0/3", "4/3", "a/3", "1/b", "1/0"} {
+		_, err := Parse(invalid)
+		assert.NotNil(err, invalid)
+	}
+}
+
+func TestOwns(t *testing.T) {
+	assert := assert.New(t)
+
+	shards := []Shard{{1, 3}, {2, 3}, {3, 3}}
+	counts := make([]int, len(shards))
+	for i := 0; i < 300; i++ {
+		uuid := fmt.Sprintf("awair-element_%d", i)
+		owners := 0
+		for j, s := range shards {
+			if s.Owns(uuid) {
+				owners++
+				counts[j]++
+			}
+		}
+		assert.Equal(1, owners, uuid)
+		assert.True(Shard{}.Owns(uuid))
+	}
+	for _, c := range counts {
+		assert.Greater(c, 50)
+	}
+}
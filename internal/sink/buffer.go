@@ -0,0 +1,259 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// BufferOptions control how undelivered batches are held for a sink.
+type BufferOptions struct {
+	// Size is the maximum number of batches held.
+	Size int
+	// DropNewest discards incoming batches when the buffer is full, rather
+	// than the oldest buffered batch.
+	DropNewest bool
+	// RetryInterval is the delay between delivery attempts of a batch.
+	RetryInterval time.Duration
+	// MaxRetries is the number of retries before a batch is given up on,
+	// zero retries forever.
+	MaxRetries int
+	// File optionally persists undelivered batches across restarts.
+	File string
+	// DeadLetterFile optionally records batches given up on, for replay.
+	DeadLetterFile string
+}
+
+// bufferParams are the sink parameters consumed by the buffer rather than by
+// the sink itself.
+var bufferParams = []string{"buffer_size", "drop_policy", "retry_interval", "max_retries", "buffer_file", "dead_letter_file"}
+
+func parseBufferOptions(params map[string]string) (BufferOptions, error) {
+	opts := BufferOptions{
+		Size:           100,
+		RetryInterval:  5 * time.Second,
+		File:           params["buffer_file"],
+		DeadLetterFile: params["dead_letter_file"],
+	}
+	var err error
+	if v := params["buffer_size"]; v != "" {
+		if opts.Size, err = strconv.Atoi(v); err != nil || opts.Size < 1 {
+			return BufferOptions{}, fmt.Errorf("invalid buffer_size %q", v)
+		}
+	}
+	switch params["drop_policy"] {
+	case "", "oldest":
+	case "newest":
+		opts.DropNewest = true
+	default:
+		return BufferOptions{}, fmt.Errorf("invalid drop_policy %q, expected oldest or newest", params["drop_policy"])
+	}
+	if v := params["retry_interval"]; v != "" {
+		if opts.RetryInterval, err = time.ParseDuration(v); err != nil {
+			return BufferOptions{}, fmt.Errorf("invalid retry_interval: %w", err)
+		}
+	}
+	if v := params["max_retries"]; v != "" {
+		if opts.MaxRetries, err = strconv.Atoi(v); err != nil || opts.MaxRetries < 0 {
+			return BufferOptions{}, fmt.Errorf("invalid max_retries %q", v)
+		}
+	}
+	for _, key := range bufferParams {
+		delete(params, key)
+	}
+	return opts, nil
+}
+
+type batch struct {
+	Records  []Record `json:"records"`
+	attempts int
+}
+
+// bufferedSink is a sink together with its bounded queue of batches.
+type bufferedSink struct {
+	name   string
+	sink   Sink
+	filter Filter
+	opts   BufferOptions
+
+	mu      sync.Mutex
+	pending []*batch
+	notify  chan struct{}
+	// dirty signals persist that pending changed since it was last saved.
+	dirty chan struct{}
+}
+
+func newBufferedSink(cfg Config, s Sink) *bufferedSink {
+	opts := cfg.Buffer
+	if opts.Size < 1 {
+		opts.Size = 1
+	}
+	if opts.RetryInterval <= 0 {
+		opts.RetryInterval = 5 * time.Second
+	}
+	return &bufferedSink{
+		name:   cfg.Name,
+		sink:   s,
+		filter: cfg.Filter,
+		opts:   opts,
+		notify: make(chan struct{}, 1),
+		dirty:  make(chan struct{}, 1),
+	}
+}
+
+// push queues records, returning false if a batch had to be dropped.
+func (b *bufferedSink) push(records []Record) bool {
+	b.mu.Lock()
+	ok := true
+	if len(b.pending) >= b.opts.Size {
+		ok = false
+		if b.opts.DropNewest {
+			b.mu.Unlock()
+			return ok
+		}
+		b.pending = b.pending[1:]
+	}
+	b.pending = append(b.pending, &batch{Records: records})
+	b.mu.Unlock()
+
+	b.changed()
+	select {
+	case b.notify <- struct{}{}:
+	default:
+	}
+	return ok
+}
+
+// head returns the oldest batch without removing it.
+func (b *bufferedSink) head() (*batch, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.pending) == 0 {
+		return nil, false
+	}
+	return b.pending[0], true
+}
+
+// failed records a failed attempt of bt, returning its count.
+func (b *bufferedSink) failed(bt *batch) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	bt.attempts++
+	return bt.attempts
+}
+
+// remove removes bt if it is still the oldest batch, returning false if
+// it was dropped meanwhile to make room for newer ones.
+func (b *bufferedSink) remove(bt *batch) bool {
+	b.mu.Lock()
+	if len(b.pending) == 0 || b.pending[0] != bt {
+		b.mu.Unlock()
+		return false
+	}
+	b.pending = b.pending[1:]
+	b.mu.Unlock()
+	b.changed()
+	return true
+}
+
+func (b *bufferedSink) len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.pending)
+}
+
+// changed schedules saving the pending batches to the buffer file, if any.
+func (b *bufferedSink) changed() {
+	if b.opts.File == "" {
+		return
+	}
+	select {
+	case b.dirty <- struct{}{}:
+	default:
+	}
+}
+
+// persist saves the pending batches to the buffer file whenever they
+// change until ctx is cancelled, so they survive a crash without queueing
+// or delivering waiting on the disk. Changes made while a save is under
+// way are saved together by the next one.
+func (b *bufferedSink) persist(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			b.flush()
+			return
+		case <-b.dirty:
+			b.flush()
+		}
+	}
+}
+
+// flush saves the pending batches to the buffer file, replacing it
+// atomically, leaving either the previous or the new batches.
+func (b *bufferedSink) flush() {
+	b.mu.Lock()
+	pending := append([]*batch(nil), b.pending...)
+	b.mu.Unlock()
+	if err := b.save(pending); err != nil {
+		log.Error().Err(err).
+			Str("sink", b.name).
+			Msg("Failed to persist sink buffer")
+	}
+}
+
+func (b *bufferedSink) save(pending []*batch) error {
+	data, err := json.Marshal(pending)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(b.opts.File), filepath.Base(b.opts.File)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), b.opts.File)
+}
+
+func (b *bufferedSink) load() error {
+	data, err := os.ReadFile(b.opts.File)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	pending := []*batch{}
+	if err := json.Unmarshal(data, &pending); err != nil {
+		return err
+	}
+	if len(pending) > b.opts.Size {
+		pending = pending[len(pending)-b.opts.Size:]
+	}
+	b.mu.Lock()
+	b.pending = pending
+	b.mu.Unlock()
+	if len(pending) > 0 {
+		b.notify <- struct{}{}
+	}
+	return nil
+}
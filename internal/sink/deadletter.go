@@ -0,0 +1,86 @@
+package sink
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// DeadLetter is a batch a sink permanently failed to deliver, kept for
+// manual replay.
+type DeadLetter struct {
+	Sink     string    `json:"sink"`
+	FailedAt time.Time `json:"failed_at"`
+	Error    string    `json:"error"`
+	Records  []Record  `json:"records"`
+}
+
+// deadLetterMu serialises appends, as several sinks may share a file.
+var deadLetterMu sync.Mutex
+
+// appendDeadLetter appends d as a JSON line to file.
+func appendDeadLetter(file string, d DeadLetter) error {
+	data, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	deadLetterMu.Lock()
+	defer deadLetterMu.Unlock()
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ReadDeadLetters reads the batches recorded in a dead-letter file.
+func ReadDeadLetters(file string) ([]DeadLetter, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	letters := []DeadLetter{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		d := DeadLetter{}
+		if err := json.Unmarshal(scanner.Bytes(), &d); err != nil {
+			return nil, err
+		}
+		letters = append(letters, d)
+	}
+	return letters, scanner.Err()
+}
+
+// WriteDeadLetters replaces the contents of a dead-letter file.
+func WriteDeadLetters(file string, letters []DeadLetter) error {
+	deadLetterMu.Lock()
+	defer deadLetterMu.Unlock()
+	tmp := file + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	for _, d := range letters {
+		if err := enc.Encode(d); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
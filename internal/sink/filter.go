@@ -0,0 +1,102 @@
+package sink
+
+import (
+	"fmt"
+	"strings"
+
+	"prometheus-awair-exporter/internal/exporter"
+)
+
+// Record is a single device reading as delivered to a sink, after the sink's
+// filter has selected its fields and shaped its labels.
+type Record struct {
+	DeviceUUID string
+	Timestamp  string
+	Labels     map[string]string
+	Fields     []exporter.Field
+}
+
+// Filter selects the devices, fields and labels a sink receives. Empty
+// selections pass everything through.
+type Filter struct {
+	Metrics      map[string]bool
+	Devices      map[string]bool
+	Labels       map[string]bool
+	StaticLabels map[string]string
+}
+
+// filterParams are the sink parameters consumed by the filter rather than by
+// the sink itself. Multiple values are separated by "|".
+var filterParams = []string{"metrics", "devices", "labels", "static_labels"}
+
+func parseFilter(params map[string]string) (Filter, error) {
+	set := func(key string) map[string]bool {
+		if params[key] == "" {
+			return nil
+		}
+		values := map[string]bool{}
+		for _, v := range strings.Split(params[key], "|") {
+			values[strings.TrimSpace(v)] = true
+		}
+		return values
+	}
+	f := Filter{
+		Metrics: set("metrics"),
+		Devices: set("devices"),
+		Labels:  set("labels"),
+	}
+	if params["static_labels"] != "" {
+		f.StaticLabels = map[string]string{}
+		for _, pair := range strings.Split(params["static_labels"], "|") {
+			key, value, ok := strings.Cut(pair, ":")
+			if !ok || strings.TrimSpace(key) == "" {
+				return Filter{}, fmt.Errorf("invalid static label %q, expected key:value", pair)
+			}
+			f.StaticLabels[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+	for _, key := range filterParams {
+		delete(params, key)
+	}
+	return f, nil
+}
+
+// Apply converts readings into the records the sink should receive.
+func (f Filter) Apply(readings []exporter.Reading) []Record {
+	records := []Record{}
+	for _, r := range readings {
+		if r.Values == nil || r.Config == nil {
+			continue
+		}
+		if f.Devices != nil && !f.Devices[r.Config.DeviceUUID] {
+			continue
+		}
+		labels := map[string]string{
+			"device_uuid":      r.Config.DeviceUUID,
+			"firmware_version": r.Config.FirmwareVersion,
+		}
+		for k, v := range f.StaticLabels {
+			labels[k] = v
+		}
+		if f.Labels != nil {
+			for k := range labels {
+				if !f.Labels[k] {
+					delete(labels, k)
+				}
+			}
+		}
+		fields := []exporter.Field{}
+		for _, field := range r.Values.Fields() {
+			if f.Metrics == nil || f.Metrics[field.Name] {
+				fields = append(fields, field)
+			}
+		}
+		records = append(records, Record{
+			DeviceUUID: r.Config.DeviceUUID,
+			Timestamp:  r.Values.Timestamp,
+			Labels:     labels,
+			Fields:     fields,
+		})
+	}
+	return records
+}
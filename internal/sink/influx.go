@@ -0,0 +1,107 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	Register("influx", newInfluxSink)
+}
+
+// influxSink writes readings using the InfluxDB line protocol via the v1
+// compatible /write endpoint, which InfluxDB 1.x and 2.x both serve.
+type influxSink struct {
+	writeURL    string
+	token       string
+	measurement string
+	client      *http.Client
+}
+
+func newInfluxSink(params map[string]string) (Sink, error) {
+	if params["url"] == "" || params["db"] == "" {
+		return nil, fmt.Errorf("influx sink requires url and db parameters")
+	}
+	u, err := url.Parse(strings.TrimSuffix(params["url"], "/") + "/write")
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	q.Set("db", params["db"])
+	q.Set("precision", "s")
+	u.RawQuery = q.Encode()
+
+	measurement := params["measurement"]
+	if measurement == "" {
+		measurement = "awair"
+	}
+	return &influxSink{
+		writeURL:    u.String(),
+		token:       params["token"],
+		measurement: measurement,
+		client:      &http.Client{},
+	}, nil
+}
+
+var influxEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+
+func (s *influxSink) lines(records []Record) []byte {
+	buf := &bytes.Buffer{}
+	now := time.Now().Unix()
+	for _, r := range records {
+		if len(r.Fields) == 0 {
+			continue
+		}
+		tags := []string{}
+		for k, v := range r.Labels {
+			if v == "" {
+				continue
+			}
+			tags = append(tags, influxEscaper.Replace(k)+"="+influxEscaper.Replace(v))
+		}
+		sort.Strings(tags)
+		fields := []string{}
+		for _, f := range r.Fields {
+			fields = append(fields, f.Name+"="+strconv.FormatFloat(f.Value, 'f', -1, 64))
+		}
+		ts := now
+		if t, err := time.Parse(time.RFC3339Nano, r.Timestamp); err == nil {
+			ts = t.Unix()
+		}
+		measurement := influxEscaper.Replace(s.measurement)
+		if len(tags) > 0 {
+			measurement += "," + strings.Join(tags, ",")
+		}
+		fmt.Fprintf(buf, "%s %s %d\n", measurement, strings.Join(fields, ","), ts)
+	}
+	return buf.Bytes()
+}
+
+func (s *influxSink) Write(ctx context.Context, records []Record) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.writeURL, bytes.NewReader(s.lines(records)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Token "+s.token)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("influx write failed: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
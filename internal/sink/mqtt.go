@@ -0,0 +1,239 @@
+package sink
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+func init() {
+	Register("mqtt", newMQTTSink)
+}
+
+// mqttSink publishes a JSON document of each device's record to
+// <topic>/<device_uuid> using a minimal MQTT 3.1.1 client (QoS 0).
+type mqttSink struct {
+	address  string
+	useTLS   bool
+	topic    string
+	clientID string
+	username string
+	password string
+	retain   bool
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func newMQTTSink(params map[string]string) (Sink, error) {
+	if params["url"] == "" {
+		return nil, fmt.Errorf("mqtt sink requires a url parameter")
+	}
+	u, err := url.Parse(params["url"])
+	if err != nil {
+		return nil, err
+	}
+	s := &mqttSink{
+		address:  u.Host,
+		topic:    params["topic"],
+		clientID: params["client_id"],
+		username: params["username"],
+		password: params["password"],
+	}
+	switch u.Scheme {
+	case "tcp", "mqtt":
+		if u.Port() == "" {
+			s.address = net.JoinHostPort(u.Hostname(), "1883")
+		}
+	case "ssl", "tls", "mqtts":
+		s.useTLS = true
+		if u.Port() == "" {
+			s.address = net.JoinHostPort(u.Hostname(), "8883")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported mqtt url scheme %q", u.Scheme)
+	}
+	if s.topic == "" {
+		s.topic = "awair"
+	}
+	if s.clientID == "" {
+		hostname, _ := os.Hostname()
+		s.clientID = "awair-exporter-" + hostname
+	}
+	if params["retain"] != "" {
+		if s.retain, err = strconv.ParseBool(params["retain"]); err != nil {
+			return nil, fmt.Errorf("invalid mqtt retain parameter: %w", err)
+		}
+	}
+	return s, nil
+}
+
+// MQTTPublisher publishes single messages with the client of the mqtt
+// sink, e.g. to command topics. It takes the same parameters as the sink.
+type MQTTPublisher struct {
+	s *mqttSink
+}
+
+// NewMQTTPublisher returns a publisher to the broker of params.
+func NewMQTTPublisher(params map[string]string) (*MQTTPublisher, error) {
+	s, err := newMQTTSink(params)
+	if err != nil {
+		return nil, err
+	}
+	return &MQTTPublisher{s: s.(*mqttSink)}, nil
+}
+
+// Publish publishes payload to topic.
+func (p *MQTTPublisher) Publish(ctx context.Context, topic string, payload []byte) error {
+	p.s.mu.Lock()
+	defer p.s.mu.Unlock()
+	if err := p.s.ensureConn(ctx); err != nil {
+		return err
+	}
+	if err := p.s.publish(topic, payload); err != nil {
+		p.s.conn.Close()
+		p.s.conn = nil
+		return err
+	}
+	return nil
+}
+
+// ensureConn connects to the broker unless connected, bounding writes by
+// the deadline of ctx.
+func (s *mqttSink) ensureConn(ctx context.Context) error {
+	if s.conn == nil {
+		conn, err := s.connect(ctx)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		s.conn.SetWriteDeadline(deadline)
+	}
+	return nil
+}
+
+func (s *mqttSink) Write(ctx context.Context, records []Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.ensureConn(ctx); err != nil {
+		return err
+	}
+	for _, r := range records {
+		payload := map[string]interface{}{}
+		for _, f := range r.Fields {
+			payload[f.Name] = f.Value
+		}
+		if r.Timestamp != "" {
+			payload["timestamp"] = r.Timestamp
+		}
+		if len(r.Labels) > 0 {
+			payload["labels"] = r.Labels
+		}
+		body, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		if err := s.publish(s.topic+"/"+r.DeviceUUID, body); err != nil {
+			s.conn.Close()
+			s.conn = nil
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *mqttSink) connect(ctx context.Context) (net.Conn, error) {
+	var conn net.Conn
+	var err error
+	dialer := &net.Dialer{}
+	if s.useTLS {
+		host, _, _ := net.SplitHostPort(s.address)
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: host}}).DialContext(ctx, "tcp", s.address)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", s.address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	// CONNECT with a clean session and keep-alive disabled, as we only
+	// publish on every poll.
+	flags := byte(0x02)
+	payload := mqttString(s.clientID)
+	if s.username != "" {
+		flags |= 0x80
+		payload = append(payload, mqttString(s.username)...)
+	}
+	if s.password != "" {
+		flags |= 0x40
+		payload = append(payload, mqttString(s.password)...)
+	}
+	header := append(mqttString("MQTT"), 0x04, flags, 0x00, 0x00)
+	if _, err := conn.Write(mqttPacket(0x10, append(header, payload...))); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	connack := make([]byte, 4)
+	if _, err := io.ReadFull(conn, connack); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("reading mqtt connack: %w", err)
+	}
+	if connack[0] != 0x20 {
+		conn.Close()
+		return nil, errors.New("unexpected mqtt packet, expected connack")
+	}
+	if connack[3] != 0 {
+		conn.Close()
+		return nil, fmt.Errorf("mqtt connection refused, return code %d", connack[3])
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+func (s *mqttSink) publish(topic string, payload []byte) error {
+	packetType := byte(0x30)
+	if s.retain {
+		packetType |= 0x01
+	}
+	_, err := s.conn.Write(mqttPacket(packetType, append(mqttString(topic), payload...)))
+	return err
+}
+
+// mqttString encodes s as a length-prefixed UTF-8 string.
+func mqttString(s string) []byte {
+	return append([]byte{byte(len(s) >> 8), byte(len(s))}, s...)
+}
+
+// mqttPacket prefixes body with the fixed header for packetType.
+func mqttPacket(packetType byte, body []byte) []byte {
+	packet := []byte{packetType}
+	length := len(body)
+	for {
+		b := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			b |= 0x80
+		}
+		packet = append(packet, b)
+		if length == 0 {
+			break
+		}
+	}
+	return append(packet, body...)
+}
@@ -0,0 +1,92 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+func init() {
+	Register("prometheus", newPrometheusSink)
+}
+
+// prometheusSink serves the latest record of each device for Prometheus to
+// pull, on a listener of its own, so the filters of the sink apply unlike
+// to the exporter's /metrics endpoint.
+type prometheusSink struct {
+	listen string
+	path   string
+
+	mu     sync.Mutex
+	latest map[string]Record
+}
+
+func newPrometheusSink(params map[string]string) (Sink, error) {
+	if params["listen"] == "" {
+		return nil, fmt.Errorf("prometheus sink requires a listen parameter")
+	}
+	s := &prometheusSink{listen: params["listen"], path: params["path"], latest: map[string]Record{}}
+	if s.path == "" {
+		s.path = "/metrics"
+	}
+	return s, nil
+}
+
+func (s *prometheusSink) Write(_ context.Context, records []Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range records {
+		s.latest[r.DeviceUUID] = r
+	}
+	return nil
+}
+
+// Run serves the records until ctx is cancelled.
+func (s *prometheusSink) Run(ctx context.Context) error {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(s)
+	mux := http.NewServeMux()
+	mux.Handle(s.path, promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	srv := &http.Server{Addr: s.listen, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Describe describes nothing, as the labels of the series depend on the
+// filter and the records, making the sink an unchecked collector.
+func (s *prometheusSink) Describe(chan<- *prometheus.Desc) {}
+
+func (s *prometheusSink) Collect(ch chan<- prometheus.Metric) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	devices := make([]string, 0, len(s.latest))
+	for uuid := range s.latest {
+		devices = append(devices, uuid)
+	}
+	sort.Strings(devices)
+	for _, uuid := range devices {
+		r := s.latest[uuid]
+		names, values := []string{}, []string{}
+		for _, l := range recordLabels(r) {
+			names = append(names, l.Name)
+			values = append(values, l.Value)
+		}
+		for _, f := range r.Fields {
+			desc := prometheus.NewDesc(metricName(f.Name), "Latest reading of "+f.Name+" delivered to the sink", names, nil)
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, f.Value, values...)
+		}
+	}
+}
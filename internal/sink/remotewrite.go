@@ -0,0 +1,75 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"prometheus-awair-exporter/internal/history"
+	"prometheus-awair-exporter/internal/remotewrite"
+)
+
+func init() {
+	Register("remote_write", newRemoteWriteSink)
+}
+
+// remoteWriteSink pushes records to a Prometheus remote write endpoint as
+// the series the exporter serves, e.g. awair_co2.
+type remoteWriteSink struct {
+	client *remotewrite.Client
+}
+
+func newRemoteWriteSink(params map[string]string) (Sink, error) {
+	if params["url"] == "" {
+		return nil, fmt.Errorf("remote_write sink requires a url parameter")
+	}
+	return &remoteWriteSink{client: remotewrite.New(params["url"])}, nil
+}
+
+// metricName returns the name of the series the exporter serves field as.
+func metricName(field string) string {
+	if name, ok := history.MetricName(field); ok {
+		return name
+	}
+	return "awair_" + field
+}
+
+// recordLabels returns the non-empty labels of r, sorted by name.
+func recordLabels(r Record) []remotewrite.Label {
+	labels := []remotewrite.Label{}
+	for k, v := range r.Labels {
+		if v != "" {
+			labels = append(labels, remotewrite.Label{Name: k, Value: v})
+		}
+	}
+	sort.Slice(labels, func(i, j int) bool { return labels[i].Name < labels[j].Name })
+	return labels
+}
+
+func (s *remoteWriteSink) series(records []Record) []remotewrite.Series {
+	now := time.Now()
+	series := []remotewrite.Series{}
+	for _, r := range records {
+		at := now
+		if t, err := time.Parse(time.RFC3339Nano, r.Timestamp); err == nil {
+			at = t
+		}
+		labels := recordLabels(r)
+		for _, f := range r.Fields {
+			series = append(series, remotewrite.Series{
+				Labels:  append([]remotewrite.Label{{Name: "__name__", Value: metricName(f.Name)}}, labels...),
+				Samples: []remotewrite.Sample{{Time: at, Value: f.Value}},
+			})
+		}
+	}
+	return series
+}
+
+func (s *remoteWriteSink) Write(ctx context.Context, records []Record) error {
+	series := s.series(records)
+	if len(series) == 0 {
+		return nil
+	}
+	return s.client.Write(ctx, series)
+}
@@ -0,0 +1,332 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"prometheus-awair-exporter/internal/exporter"
+	"prometheus-awair-exporter/internal/leader"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+// Sink delivers device records to an output such as InfluxDB, MQTT or a
+// Prometheus remote write endpoint, or serves them for Prometheus to pull.
+type Sink interface {
+	Write(ctx context.Context, records []Record) error
+}
+
+// Runner is implemented by sinks which run alongside their writes, such as
+// the prometheus sink serving its listener. The manager runs them until
+// its context is cancelled.
+type Runner interface {
+	Run(ctx context.Context) error
+}
+
+// Factory creates a Sink from its configuration parameters.
+type Factory func(params map[string]string) (Sink, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = map[string]Factory{}
+)
+
+// Register makes a sink kind available to New. Plugins call it from init.
+func Register(kind string, f Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	if _, dup := factories[kind]; dup {
+		panic("sink: Register called twice for kind " + kind)
+	}
+	factories[kind] = f
+}
+
+// Kinds returns the registered sink kinds.
+func Kinds() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	kinds := make([]string, 0, len(factories))
+	for k := range factories {
+		kinds = append(kinds, k)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+// Config describes a single configured sink.
+type Config struct {
+	Kind   string
+	Name   string
+	Params map[string]string
+	Filter Filter
+	Buffer BufferOptions
+}
+
+// ParseConfig parses a sink definition of the form
+// kind[:key=value,key=value...]. The optional name parameter distinguishes
+// several sinks of the same kind and defaults to the kind.
+func ParseConfig(s string) (Config, error) {
+	kind, rest, _ := strings.Cut(s, ":")
+	cfg := Config{
+		Kind:   strings.TrimSpace(kind),
+		Params: map[string]string{},
+	}
+	if cfg.Kind == "" {
+		return Config{}, fmt.Errorf("invalid sink %q, missing kind", s)
+	}
+	for _, param := range strings.Split(rest, ",") {
+		if strings.TrimSpace(param) == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(param, "=")
+		if !ok {
+			return Config{}, fmt.Errorf("invalid sink parameter %q, expected key=value", param)
+		}
+		cfg.Params[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	cfg.Name = cfg.Kind
+	if name, ok := cfg.Params["name"]; ok {
+		cfg.Name = name
+		delete(cfg.Params, "name")
+	}
+	filter, err := parseFilter(cfg.Params)
+	if err != nil {
+		return Config{}, err
+	}
+	cfg.Filter = filter
+	buffer, err := parseBufferOptions(cfg.Params)
+	if err != nil {
+		return Config{}, err
+	}
+	cfg.Buffer = buffer
+	return cfg, nil
+}
+
+// New creates the sink described by cfg.
+func New(cfg Config) (Sink, error) {
+	factoriesMu.RLock()
+	f, ok := factories[cfg.Kind]
+	factoriesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown sink kind %q (available: %s)", cfg.Kind, strings.Join(Kinds(), ", "))
+	}
+	return f(cfg.Params)
+}
+
+// Manager fans polled readings out to every configured sink. Each sink has
+// its own bounded buffer and runs concurrently, so a slow or unavailable
+// output doesn't hold up the others or grow memory without bound.
+type Manager struct {
+	sinks        []*bufferedSink
+	writeTimeout time.Duration
+	elector      leader.Elector
+
+	queueDepth  *prometheus.GaugeVec
+	dropped     *prometheus.CounterVec
+	writeErrors *prometheus.CounterVec
+}
+
+func NewManager() *Manager {
+	return &Manager{
+		writeTimeout: 10 * time.Second,
+		queueDepth: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: "awair",
+				Subsystem: "sink",
+				Name:      "queue_depth",
+				Help:      "Number of batches waiting to be delivered to the sink",
+			},
+			[]string{"sink"},
+		),
+		dropped: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "awair",
+				Subsystem: "sink",
+				Name:      "dropped_batches_total",
+				Help:      "Number of batches dropped without being delivered to the sink",
+			},
+			[]string{"sink", "reason"},
+		),
+		writeErrors: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "awair",
+				Subsystem: "sink",
+				Name:      "write_errors_total",
+				Help:      "Number of failed attempts to deliver a batch to the sink",
+			},
+			[]string{"sink"},
+		),
+	}
+}
+
+// Add registers the sink described by cfg. It must be called before Run.
+func (m *Manager) Add(cfg Config, s Sink) {
+	b := newBufferedSink(cfg, s)
+	if b.opts.File != "" {
+		if err := b.load(); err != nil {
+			log.Error().Err(err).
+				Str("sink", cfg.Name).
+				Msg("Failed to restore sink buffer")
+		}
+	}
+	m.sinks = append(m.sinks, b)
+	m.queueDepth.WithLabelValues(cfg.Name).Set(float64(b.len()))
+}
+
+// Len returns the number of configured sinks.
+func (m *Manager) Len() int {
+	return len(m.sinks)
+}
+
+// Publish buffers readings for every sink without blocking the poller.
+func (m *Manager) Publish(readings []exporter.Reading) {
+	if m.elector != nil && !m.elector.IsLeader() {
+		return
+	}
+	for _, s := range m.sinks {
+		records := s.filter.Apply(readings)
+		if len(records) == 0 {
+			continue
+		}
+		if !s.push(records) {
+			m.dropped.WithLabelValues(s.name, "buffer_full").Inc()
+			log.Warn().
+				Str("sink", s.name).
+				Msg("Sink buffer is full, dropping batch")
+		}
+		m.queueDepth.WithLabelValues(s.name).Set(float64(s.len()))
+	}
+}
+
+// SetElector makes the manager only publish while e reports this replica as
+// the leader, so HA pairs don't deliver every reading twice.
+func (m *Manager) SetElector(e leader.Elector) {
+	m.elector = e
+}
+
+// Run delivers buffered batches to the sinks until ctx is cancelled. Sinks
+// configured with a buffer file keep their undelivered batches in it as
+// they are queued and delivered.
+func (m *Manager) Run(ctx context.Context) {
+	wg := sync.WaitGroup{}
+	for _, s := range m.sinks {
+		if r, ok := s.sink.(Runner); ok {
+			wg.Add(1)
+			go func(s *bufferedSink) {
+				defer wg.Done()
+				if err := r.Run(ctx); err != nil {
+					log.Error().Err(err).
+						Str("sink", s.name).
+						Msg("Sink stopped running")
+				}
+			}(s)
+		}
+		if s.opts.File != "" {
+			wg.Add(1)
+			go func(s *bufferedSink) {
+				defer wg.Done()
+				s.persist(ctx)
+			}(s)
+		}
+		wg.Add(1)
+		go func(s *bufferedSink) {
+			defer wg.Done()
+			m.run(ctx, s)
+		}(s)
+	}
+	wg.Wait()
+}
+
+func (m *Manager) run(ctx context.Context, s *bufferedSink) {
+	for {
+		b, ok := s.head()
+		if !ok {
+			select {
+			case <-ctx.Done():
+				return
+			case <-s.notify:
+				continue
+			}
+		}
+		if err := m.write(ctx, s, b.Records); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			m.writeErrors.WithLabelValues(s.name).Inc()
+			if s.opts.MaxRetries > 0 && s.failed(b) > s.opts.MaxRetries {
+				// A batch dropped for a newer one while being written has
+				// been counted already.
+				if s.remove(b) {
+					m.dropped.WithLabelValues(s.name, "retries_exhausted").Inc()
+					log.Error().Err(err).
+						Str("sink", s.name).
+						Msg("Giving up on batch after exhausting retries")
+					m.deadLetter(s, b.Records, err)
+				}
+				m.queueDepth.WithLabelValues(s.name).Set(float64(s.len()))
+				continue
+			}
+			log.Error().Err(err).
+				Str("sink", s.name).
+				Dur("retry_in", s.opts.RetryInterval).
+				Msg("Failed to write records to sink")
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(s.opts.RetryInterval):
+			}
+			continue
+		}
+		s.remove(b)
+		m.queueDepth.WithLabelValues(s.name).Set(float64(s.len()))
+	}
+}
+
+// deadLetter records a batch given up on, if the sink has a dead-letter file.
+func (m *Manager) deadLetter(s *bufferedSink, records []Record, cause error) {
+	if s.opts.DeadLetterFile == "" {
+		return
+	}
+	err := appendDeadLetter(s.opts.DeadLetterFile, DeadLetter{
+		Sink:     s.name,
+		FailedAt: time.Now(),
+		Error:    cause.Error(),
+		Records:  records,
+	})
+	if err != nil {
+		log.Error().Err(err).
+			Str("sink", s.name).
+			Str("file", s.opts.DeadLetterFile).
+			Msg("Failed to write dead letter")
+	}
+}
+
+func (m *Manager) write(ctx context.Context, s *bufferedSink, records []Record) error {
+	ctx, cancel := context.WithTimeout(ctx, m.writeTimeout)
+	defer cancel()
+	if err := s.sink.Write(ctx, records); err != nil {
+		return err
+	}
+	log.Debug().
+		Str("sink", s.name).
+		Int("records", len(records)).
+		Msg("Wrote records to sink")
+	return nil
+}
+
+func (m *Manager) Describe(ch chan<- *prometheus.Desc) {
+	m.queueDepth.Describe(ch)
+	m.dropped.Describe(ch)
+	m.writeErrors.Describe(ch)
+}
+
+func (m *Manager) Collect(ch chan<- prometheus.Metric) {
+	m.queueDepth.Collect(ch)
+	m.dropped.Collect(ch)
+	m.writeErrors.Collect(ch)
+}
@@ -0,0 +1,534 @@
+package sink
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"prometheus-awair-exporter/internal/exporter"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/require"
+	"github.com/tj/assert"
+)
+
+func init() {
+	log.Logger = zerolog.New(io.Discard)
+}
+
+var testReadings = []exporter.Reading{
+	{
+		Config: &exporter.ConfigResponse{DeviceUUID: "awair-element_1"},
+		Values: &exporter.AwairValues{
+			Timestamp: "2023-03-01T12:00:00.000Z",
+			Score:     89,
+			CO2:       625,
+		},
+	},
+}
+
+type recordingSink struct {
+	written chan []Record
+}
+
+func (s *recordingSink) Write(_ context.Context, records []Record) error {
+	s.written <- records
+	return nil
+}
+
+func TestParseConfig(t *testing.T) {
+	assert := assert.New(t)
+	cfg, err := ParseConfig("influx:url=http://influx:8086, db=awair,name=primary")
+	assert.Nil(err)
+	assert.Equal(Config{
+		Kind: "influx",
+		Name: "primary",
+		Params: map[string]string{
+			"url": "http://influx:8086",
+			"db":  "awair",
+		},
+		Buffer: BufferOptions{
+			Size:          100,
+			RetryInterval: 5 * time.Second,
+		},
+	}, cfg)
+
+	cfg, err = ParseConfig("mqtt")
+	assert.Nil(err)
+	assert.Equal("mqtt", cfg.Name)
+
+	_, err = ParseConfig("influx:url")
+	assert.NotNil(err)
+	_, err = New(Config{Kind: "carrier_pigeon"})
+	assert.NotNil(err)
+}
+
+func TestFilter(t *testing.T) {
+	assert := assert.New(t)
+	cfg, err := ParseConfig("mqtt:url=tcp://broker,metrics=co2|pm25,devices=awair-element_1,labels=device_uuid|room,static_labels=room:nursery|floor:2")
+	assert.Nil(err)
+	assert.Equal(map[string]string{"url": "tcp://broker"}, cfg.Params)
+
+	readings := append([]exporter.Reading{
+		{
+			Config: &exporter.ConfigResponse{DeviceUUID: "awair-element_2"},
+			Values: &exporter.AwairValues{CO2: 900},
+		},
+	}, testReadings...)
+	records := cfg.Filter.Apply(readings)
+	assert.Equal([]Record{
+		{
+			DeviceUUID: "awair-element_1",
+			Timestamp:  "2023-03-01T12:00:00.000Z",
+			Labels: map[string]string{
+				"device_uuid": "awair-element_1",
+				"room":        "nursery",
+			},
+			Fields: []exporter.Field{
+				{Name: "co2", Value: 625},
+				{Name: "pm25", Value: 0},
+			},
+		},
+	}, records)
+
+	_, err = ParseConfig("mqtt:static_labels=room")
+	assert.NotNil(err)
+}
+
+func TestManagerPublish(t *testing.T) {
+	assert := assert.New(t)
+	m := NewManager()
+	first := &recordingSink{written: make(chan []Record, 1)}
+	second := &recordingSink{written: make(chan []Record, 1)}
+	m.Add(Config{Name: "first"}, first)
+	m.Add(Config{Name: "second", Filter: Filter{Metrics: map[string]bool{"co2": true}}}, second)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go m.Run(ctx)
+	m.Publish(testReadings)
+
+	for s, fields := range map[*recordingSink]int{first: 14, second: 1} {
+		select {
+		case records := <-s.written:
+			assert.Equal(1, len(records))
+			assert.Equal("awair-element_1", records[0].DeviceUUID)
+			assert.Equal(fields, len(records[0].Fields))
+		case <-time.After(time.Second):
+			t.Fatal("sink did not receive records")
+		}
+	}
+}
+
+type staticElector bool
+
+func (e staticElector) IsLeader() bool {
+	return bool(e)
+}
+
+func TestManagerPublishLeaderOnly(t *testing.T) {
+	assert := assert.New(t)
+	m := NewManager()
+	m.Add(Config{Name: "passive"}, &recordingSink{})
+
+	m.SetElector(staticElector(false))
+	m.Publish(testReadings)
+	assert.Equal(float64(0), testutil.ToFloat64(m.queueDepth.WithLabelValues("passive")))
+
+	m.SetElector(staticElector(true))
+	m.Publish(testReadings)
+	assert.Equal(float64(1), testutil.ToFloat64(m.queueDepth.WithLabelValues("passive")))
+}
+
+type flakySink struct {
+	failures int
+	written  chan []Record
+}
+
+func (s *flakySink) Write(_ context.Context, records []Record) error {
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("broker unavailable")
+	}
+	s.written <- records
+	return nil
+}
+
+func TestParseBufferOptions(t *testing.T) {
+	assert := assert.New(t)
+	cfg, err := ParseConfig("mqtt:url=tcp://broker,buffer_size=10,drop_policy=newest,retry_interval=1s,max_retries=3")
+	assert.Nil(err)
+	assert.Equal(map[string]string{"url": "tcp://broker"}, cfg.Params)
+	assert.Equal(BufferOptions{Size: 10, DropNewest: true, RetryInterval: time.Second, MaxRetries: 3}, cfg.Buffer)
+
+	_, err = ParseConfig("mqtt:drop_policy=random")
+	assert.NotNil(err)
+	_, err = ParseConfig("mqtt:buffer_size=0")
+	assert.NotNil(err)
+}
+
+func TestManagerRetries(t *testing.T) {
+	assert := assert.New(t)
+	m := NewManager()
+	s := &flakySink{failures: 2, written: make(chan []Record, 1)}
+	m.Add(Config{Name: "flaky", Buffer: BufferOptions{Size: 10, RetryInterval: time.Millisecond}}, s)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go m.Run(ctx)
+	m.Publish(testReadings)
+
+	select {
+	case records := <-s.written:
+		assert.Equal("awair-element_1", records[0].DeviceUUID)
+	case <-time.After(time.Second):
+		t.Fatal("sink did not receive records")
+	}
+	assert.Equal(float64(2), testutil.ToFloat64(m.writeErrors.WithLabelValues("flaky")))
+}
+
+func TestManagerBufferBounds(t *testing.T) {
+	assert := assert.New(t)
+	m := NewManager()
+	m.Add(Config{Name: "oldest", Buffer: BufferOptions{Size: 2}}, &flakySink{})
+	m.Add(Config{Name: "retries", Buffer: BufferOptions{Size: 2, RetryInterval: time.Millisecond, MaxRetries: 1}}, &flakySink{failures: 10})
+
+	// Run isn't started for the first sink's queue to fill up.
+	for i := 0; i < 3; i++ {
+		m.Publish(testReadings)
+	}
+	assert.Equal(float64(2), testutil.ToFloat64(m.queueDepth.WithLabelValues("oldest")))
+	assert.Equal(float64(1), testutil.ToFloat64(m.dropped.WithLabelValues("oldest", "buffer_full")))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m.sinks = m.sinks[1:]
+	done := make(chan struct{})
+	go func() {
+		m.Run(ctx)
+		close(done)
+	}()
+	assert.Eventually(func() bool {
+		return testutil.ToFloat64(m.dropped.WithLabelValues("retries", "retries_exhausted")) == 2
+	}, time.Second, time.Millisecond)
+	cancel()
+	<-done
+}
+
+func TestManagerBufferFile(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	file := filepath.Join(t.TempDir(), "buffer.json")
+	cfg := Config{Name: "persisted", Buffer: BufferOptions{Size: 10, RetryInterval: time.Hour, File: file}}
+
+	m := NewManager()
+	m.Add(cfg, &flakySink{failures: 1})
+	m.Publish(testReadings)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		m.Run(ctx)
+		close(done)
+	}()
+	require.Eventually(func() bool {
+		return testutil.ToFloat64(m.writeErrors.WithLabelValues("persisted")) == 1
+	}, time.Second, time.Millisecond)
+	cancel()
+	<-done
+
+	restored := NewManager()
+	s := &flakySink{written: make(chan []Record, 1)}
+	restored.Add(cfg, s)
+	assert.Equal(float64(1), testutil.ToFloat64(restored.queueDepth.WithLabelValues("persisted")))
+	ctx, cancel = context.WithCancel(context.Background())
+	done = make(chan struct{})
+	defer func() {
+		cancel()
+		<-done
+	}()
+	go func() {
+		restored.Run(ctx)
+		close(done)
+	}()
+	select {
+	case records := <-s.written:
+		assert.Equal("awair-element_1", records[0].DeviceUUID)
+	case <-time.After(time.Second):
+		t.Fatal("restored batch was not delivered")
+	}
+}
+
+func TestInfluxSink(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	received := make(chan *http.Request, 1)
+	bodies := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- r
+		bodies <- string(body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	s, err := New(Config{Kind: "influx", Params: map[string]string{"url": srv.URL, "db": "awair", "token": "secret"}})
+	require.Nil(err)
+	require.Nil(s.Write(context.Background(), Filter{}.Apply(testReadings)))
+
+	r := <-received
+	assert.Equal("/write", r.URL.Path)
+	assert.Equal("awair", r.URL.Query().Get("db"))
+	assert.Equal("Token secret", r.Header.Get("Authorization"))
+	body := <-bodies
+	assert.Contains(body, "awair,device_uuid=awair-element_1 score=89,")
+	assert.Contains(body, ",co2=625,")
+	assert.Contains(body, " 1677672000\n")
+}
+
+func TestManagerBufferFileSurvivesCrash(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	file := filepath.Join(t.TempDir(), "buffer.json")
+	cfg := Config{Name: "persisted", Buffer: BufferOptions{Size: 10, RetryInterval: time.Hour, File: file}}
+	depth := func() float64 {
+		m := NewManager()
+		m.Add(cfg, &flakySink{})
+		return testutil.ToFloat64(m.queueDepth.WithLabelValues("persisted"))
+	}
+
+	// Published but never delivered, and read back before shutting down
+	// as if the exporter had been killed.
+	m := NewManager()
+	m.Add(cfg, &flakySink{failures: 10})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		m.Run(ctx)
+		close(done)
+	}()
+	m.Publish(testReadings)
+	m.Publish(testReadings)
+	require.Eventually(func() bool { return depth() == 2 }, time.Second, time.Millisecond)
+
+	b, ok := m.sinks[0].head()
+	require.True(ok)
+	require.True(m.sinks[0].remove(b))
+	assert.Eventually(func() bool { return depth() == 1 }, time.Second, time.Millisecond, "deliveries are persisted too")
+	cancel()
+	<-done
+	matches, _ := filepath.Glob(file + ".tmp*")
+	assert.Empty(matches)
+}
+
+// blockingSink holds every write until it is released.
+type blockingSink struct {
+	started chan []Record
+	release chan struct{}
+}
+
+func (s *blockingSink) Write(_ context.Context, records []Record) error {
+	s.started <- records
+	<-s.release
+	return nil
+}
+
+func TestManagerDropsBatchInFlight(t *testing.T) {
+	assert := assert.New(t)
+	m := NewManager()
+	s := &blockingSink{started: make(chan []Record, 2), release: make(chan struct{}, 2)}
+	m.Add(Config{Name: "full", Buffer: BufferOptions{Size: 1}}, s)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go m.Run(ctx)
+
+	m.Publish(testReadings)
+	first := <-s.started
+	// The batch being written is dropped for the next one.
+	later := []exporter.Reading{{
+		Config: &exporter.ConfigResponse{DeviceUUID: "awair-element_2"},
+		Values: &exporter.AwairValues{Score: 70},
+	}}
+	m.Publish(later)
+	assert.Equal(float64(1), testutil.ToFloat64(m.dropped.WithLabelValues("full", "buffer_full")))
+	s.release <- struct{}{}
+
+	select {
+	case second := <-s.started:
+		assert.Equal("awair-element_1", first[0].DeviceUUID)
+		assert.Equal("awair-element_2", second[0].DeviceUUID, "the newer batch isn't removed in place of the one written")
+	case <-time.After(time.Second):
+		t.Fatal("the newer batch was not delivered")
+	}
+	s.release <- struct{}{}
+}
+
+func TestRemoteWriteSink(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	bodies := make(chan []byte, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies <- body
+	}))
+	defer srv.Close()
+
+	_, err := New(Config{Kind: "remote_write", Params: map[string]string{}})
+	assert.NotNil(err)
+	s, err := New(Config{Kind: "remote_write", Params: map[string]string{"url": srv.URL}})
+	require.Nil(err)
+	records := Filter{}.Apply(testReadings)
+	require.Nil(s.Write(context.Background(), records))
+
+	body, err := snappy.Decode(nil, <-bodies)
+	require.Nil(err)
+	assert.Contains(string(body), "awair_co2")
+	assert.Contains(string(body), "awair-element_1")
+	assert.NotContains(string(body), "firmware_version", "empty labels are left out")
+	series := s.(*remoteWriteSink).series(records)
+	require.Len(series, len(records[0].Fields))
+	assert.Equal(time.Date(2023, 3, 1, 12, 0, 0, 0, time.UTC), series[0].Samples[0].Time.UTC())
+}
+
+func TestPrometheusSink(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	_, err := New(Config{Kind: "prometheus", Params: map[string]string{}})
+	assert.NotNil(err)
+	s, err := New(Config{Kind: "prometheus", Params: map[string]string{"listen": "127.0.0.1:0"}})
+	require.Nil(err)
+	require.Nil(s.Write(context.Background(), Filter{Metrics: map[string]bool{"co2": true}}.Apply(testReadings)))
+
+	expected := `
+# HELP awair_co2 Latest reading of co2 delivered to the sink
+# TYPE awair_co2 gauge
+awair_co2{device_uuid="awair-element_1"} 625
+`
+	assert.Nil(testutil.CollectAndCompare(s.(*prometheusSink), strings.NewReader(expected)))
+}
+
+type brokerPacket struct {
+	header byte
+	body   []byte
+}
+
+// mqttBroker accepts a single connection, acknowledging the CONNECT and
+// passing on the first two packets.
+func mqttBroker(t *testing.T) (string, <-chan brokerPacket) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	packets := make(chan brokerPacket, 2)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for i := 0; i < 2; i++ {
+			header, _ := r.ReadByte()
+			length, multiplier := 0, 1
+			for {
+				b, _ := r.ReadByte()
+				length += int(b&0x7f) * multiplier
+				multiplier *= 128
+				if b&0x80 == 0 {
+					break
+				}
+			}
+			body := make([]byte, length)
+			io.ReadFull(r, body)
+			packets <- brokerPacket{header, body}
+			if header == 0x10 {
+				conn.Write([]byte{0x20, 0x02, 0x00, 0x00})
+			}
+		}
+	}()
+	return "tcp://" + l.Addr().String(), packets
+}
+
+func TestMQTTSink(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	url, packets := mqttBroker(t)
+
+	s, err := New(Config{Kind: "mqtt", Params: map[string]string{"url": url, "topic": "home/awair"}})
+	require.Nil(err)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.Nil(s.Write(ctx, Filter{}.Apply(testReadings)))
+
+	connect := <-packets
+	assert.Equal(byte(0x10), connect.header)
+	assert.Equal(mqttString("MQTT"), connect.body[:6])
+
+	publish := <-packets
+	assert.Equal(byte(0x30), publish.header)
+	topic := mqttString("home/awair/awair-element_1")
+	assert.Equal(topic, publish.body[:len(topic)])
+	assert.Contains(string(publish.body[len(topic):]), `"co2":625`)
+}
+
+func TestMQTTPublisher(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	url, packets := mqttBroker(t)
+
+	p, err := NewMQTTPublisher(map[string]string{"url": url})
+	require.Nil(err)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.Nil(p.Publish(ctx, "erv/cmnd/POWER", []byte("ON")))
+
+	assert.Equal(byte(0x10), (<-packets).header)
+	publish := <-packets
+	assert.Equal(byte(0x30), publish.header)
+	assert.Equal(append(mqttString("erv/cmnd/POWER"), "ON"...), publish.body)
+
+	_, err = NewMQTTPublisher(map[string]string{"url": "http://broker"})
+	assert.NotNil(err)
+}
+
+func TestManagerDeadLetter(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	file := filepath.Join(t.TempDir(), "dead.jsonl")
+	m := NewManager()
+	m.Add(Config{Name: "dead", Buffer: BufferOptions{Size: 2, RetryInterval: time.Millisecond, MaxRetries: 1, DeadLetterFile: file}}, &flakySink{failures: 10})
+	m.Publish(testReadings)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		m.Run(ctx)
+		close(done)
+	}()
+	require.Eventually(func() bool {
+		return testutil.ToFloat64(m.dropped.WithLabelValues("dead", "retries_exhausted")) == 1
+	}, time.Second, time.Millisecond)
+	cancel()
+	<-done
+
+	letters, err := ReadDeadLetters(file)
+	require.Nil(err)
+	require.Equal(1, len(letters))
+	assert.Equal("dead", letters[0].Sink)
+	assert.Equal("broker unavailable", letters[0].Error)
+	assert.Equal("awair-element_1", letters[0].Records[0].DeviceUUID)
+
+	require.Nil(WriteDeadLetters(file, nil))
+	letters, err = ReadDeadLetters(file)
+	require.Nil(err)
+	assert.Equal(0, len(letters))
+}
@@ -0,0 +1,128 @@
+// Package smoke switches the exporter into a mode for wildfire smoke
+// events, when indoor PM2.5 needs closer watching.
+package smoke
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+// Status is the state of the smoke mode.
+type Status struct {
+	Active bool      `json:"active"`
+	Until  time.Time `json:"until,omitempty"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+// Mode is the smoke mode of the exporter. While active, devices are polled
+// at a shorter interval and the PM2.5 alert threshold exposed for alerting
+// rules is lowered. It ends by itself once its duration has passed.
+type Mode struct {
+	pollInterval time.Duration
+	normalPM25   float64
+	smokePM25    float64
+	now          func() time.Time
+
+	mu     sync.Mutex
+	until  time.Time
+	reason string
+
+	active    *prometheus.Desc
+	expiry    *prometheus.Desc
+	threshold *prometheus.Desc
+}
+
+// New returns an inactive Mode polling at pollInterval and lowering the
+// PM2.5 alert threshold from normalPM25 to smokePM25 while active.
+func New(pollInterval time.Duration, normalPM25, smokePM25 float64) *Mode {
+	return &Mode{
+		pollInterval: pollInterval,
+		normalPM25:   normalPM25,
+		smokePM25:    smokePM25,
+		now:          time.Now,
+		active: prometheus.NewDesc(
+			"awair_smoke_mode",
+			"Whether the smoke mode is active (1) or not (0)",
+			nil, nil,
+		),
+		expiry: prometheus.NewDesc(
+			"awair_smoke_mode_until_timestamp_seconds",
+			"End of the active smoke mode since unix epoch in seconds",
+			nil, nil,
+		),
+		threshold: prometheus.NewDesc(
+			"awair_pm25_alert_threshold",
+			"PM2.5 level alerting rules should fire above, lowered during the smoke mode (µg/m³)",
+			nil, nil,
+		),
+	}
+}
+
+// Activate activates the smoke mode for d, or extends it.
+func (m *Mode) Activate(d time.Duration, reason string) Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.until = m.now().Add(d)
+	m.reason = reason
+	log.Warn().Time("until", m.until).Str("reason", reason).Msg("Smoke mode activated.")
+	return m.statusLocked()
+}
+
+// Deactivate ends the smoke mode.
+func (m *Mode) Deactivate() Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.activeLocked() {
+		log.Info().Msg("Smoke mode deactivated.")
+	}
+	m.until = time.Time{}
+	m.reason = ""
+	return m.statusLocked()
+}
+
+// Status returns the current state of the smoke mode.
+func (m *Mode) Status() Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.statusLocked()
+}
+
+func (m *Mode) activeLocked() bool {
+	return m.now().Before(m.until)
+}
+
+func (m *Mode) statusLocked() Status {
+	if !m.activeLocked() {
+		return Status{}
+	}
+	return Status{Active: true, Until: m.until, Reason: m.reason}
+}
+
+// PollInterval returns the smoke mode's poll interval while it is active
+// and shorter than normal, else normal.
+func (m *Mode) PollInterval(normal time.Duration) time.Duration {
+	if m.Status().Active && m.pollInterval > 0 && m.pollInterval < normal {
+		return m.pollInterval
+	}
+	return normal
+}
+
+func (m *Mode) Describe(ch chan<- *prometheus.Desc) {
+	ch <- m.active
+	ch <- m.expiry
+	ch <- m.threshold
+}
+
+func (m *Mode) Collect(ch chan<- prometheus.Metric) {
+	status := m.Status()
+	active, threshold := 0.0, m.normalPM25
+	if status.Active {
+		active, threshold = 1, m.smokePM25
+		ch <- prometheus.MustNewConstMetric(m.expiry, prometheus.GaugeValue, float64(status.Until.Unix()))
+	}
+	ch <- prometheus.MustNewConstMetric(m.active, prometheus.GaugeValue, active)
+	ch <- prometheus.MustNewConstMetric(m.threshold, prometheus.GaugeValue, threshold)
+}
@@ -0,0 +1,54 @@
+package smoke
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tj/assert"
+)
+
+func TestMode(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Date(2024, 8, 1, 12, 0, 0, 0, time.UTC)
+	m := New(10*time.Second, 35, 12)
+	m.now = func() time.Time { return now }
+
+	assert.False(m.Status().Active)
+	assert.Equal(time.Minute, m.PollInterval(time.Minute))
+	err := testutil.CollectAndCompare(m, strings.NewReader(`
+# HELP awair_pm25_alert_threshold PM2.5 level alerting rules should fire above, lowered during the smoke mode (µg/m³)
+# TYPE awair_pm25_alert_threshold gauge
+awair_pm25_alert_threshold 35
+# HELP awair_smoke_mode Whether the smoke mode is active (1) or not (0)
+# TYPE awair_smoke_mode gauge
+awair_smoke_mode 0
+`))
+	assert.Nil(err)
+
+	status := m.Activate(6*time.Hour, "outdoor AQI 180")
+	assert.Equal(Status{Active: true, Until: now.Add(6 * time.Hour), Reason: "outdoor AQI 180"}, status)
+	assert.Equal(10*time.Second, m.PollInterval(time.Minute))
+	assert.Equal(5*time.Second, m.PollInterval(5*time.Second), "never polls less often")
+	err = testutil.CollectAndCompare(m, strings.NewReader(`
+# HELP awair_pm25_alert_threshold PM2.5 level alerting rules should fire above, lowered during the smoke mode (µg/m³)
+# TYPE awair_pm25_alert_threshold gauge
+awair_pm25_alert_threshold 12
+# HELP awair_smoke_mode Whether the smoke mode is active (1) or not (0)
+# TYPE awair_smoke_mode gauge
+awair_smoke_mode 1
+# HELP awair_smoke_mode_until_timestamp_seconds End of the active smoke mode since unix epoch in seconds
+# TYPE awair_smoke_mode_until_timestamp_seconds gauge
+awair_smoke_mode_until_timestamp_seconds 1.7225352e+09
+`))
+	assert.Nil(err)
+
+	// It ends by itself.
+	now = now.Add(7 * time.Hour)
+	assert.False(m.Status().Active)
+	assert.Equal(time.Minute, m.PollInterval(time.Minute))
+
+	m.Activate(time.Hour, "manual")
+	assert.Equal(Status{}, m.Deactivate())
+}
@@ -0,0 +1,62 @@
+package state
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// lifecycle is the state recorded on every start of the exporter.
+type lifecycle struct {
+	Starts    int       `json:"starts"`
+	LastStart time.Time `json:"last_start"`
+}
+
+// Lifecycle exposes the exporter's start time and the number of times it
+// was restarted, as recorded in a Store, to spot crash loops.
+type Lifecycle struct {
+	restarts  *prometheus.Desc
+	startTime *prometheus.Desc
+
+	state lifecycle
+}
+
+// RecordStart records a start of the exporter at now in s.
+func RecordStart(s *Store, now time.Time) (*Lifecycle, error) {
+	l := &Lifecycle{
+		restarts: prometheus.NewDesc(
+			"awair_exporter_restarts_total",
+			"Number of times the exporter was restarted, as recorded in the state file",
+			nil, nil,
+		),
+		startTime: prometheus.NewDesc(
+			"awair_exporter_start_time_seconds",
+			"Start time of the exporter since unix epoch in seconds",
+			nil, nil,
+		),
+	}
+	if _, err := s.Get("lifecycle", &l.state); err != nil {
+		return nil, err
+	}
+	l.state.Starts++
+	l.state.LastStart = now
+	if err := s.Set("lifecycle", l.state); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Restarts returns the number of starts before the current one.
+func (l *Lifecycle) Restarts() int {
+	return l.state.Starts - 1
+}
+
+func (l *Lifecycle) Describe(ch chan<- *prometheus.Desc) {
+	ch <- l.restarts
+	ch <- l.startTime
+}
+
+func (l *Lifecycle) Collect(ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(l.restarts, prometheus.CounterValue, float64(l.Restarts()))
+	ch <- prometheus.MustNewConstMetric(l.startTime, prometheus.GaugeValue, float64(l.state.LastStart.UnixNano())/1e9)
+}
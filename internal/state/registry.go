@@ -0,0 +1,68 @@
+package state
+
+import (
+	"sort"
+	"sync"
+)
+
+// RegisteredDevice is a device added at runtime.
+type RegisteredDevice struct {
+	Name     string `json:"name"`
+	Hostname string `json:"hostname"`
+}
+
+// Registry records the devices added at runtime, e.g. by a subnet scan or
+// through the admin API, in a Store, so they are restored after a restart
+// without waiting for discovery to find them again.
+type Registry struct {
+	store *Store
+
+	mu      sync.Mutex
+	devices map[string]string
+}
+
+// OpenRegistry returns the registry recorded in s.
+func OpenRegistry(s *Store) (*Registry, error) {
+	r := &Registry{store: s, devices: map[string]string{}}
+	if _, err := s.Get("devices", &r.devices); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Add records the device at hostname under name, replacing any device
+// recorded under the same name.
+func (r *Registry) Add(name, hostname string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.devices[name] == hostname {
+		return nil
+	}
+	r.devices[name] = hostname
+	return r.store.Set("devices", r.devices)
+}
+
+// Remove forgets the device recorded under name.
+func (r *Registry) Remove(name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.devices[name]; !ok {
+		return nil
+	}
+	delete(r.devices, name)
+	return r.store.Set("devices", r.devices)
+}
+
+// Devices returns the recorded devices, ordered by name.
+func (r *Registry) Devices() []RegisteredDevice {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	devices := make([]RegisteredDevice, 0, len(r.devices))
+	for name, hostname := range r.devices {
+		devices = append(devices, RegisteredDevice{Name: name, Hostname: hostname})
+	}
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].Name < devices[j].Name
+	})
+	return devices
+}
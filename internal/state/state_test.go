@@ -0,0 +1,98 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"github.com/tj/assert"
+)
+
+func TestStore(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	s, err := Open(path)
+	require.Nil(err)
+	v := map[string]int{}
+	ok, err := s.Get("counts", &v)
+	assert.Nil(err)
+	assert.False(ok)
+	require.Nil(s.Set("counts", map[string]int{"a": 1}))
+	info, err := os.Stat(path)
+	require.Nil(err)
+	assert.Equal(os.FileMode(0o600), info.Mode().Perm())
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.Nil(err)
+	assert.Len(entries, 1, "no temporary file is left behind")
+
+	s, err = Open(path)
+	require.Nil(err)
+	ok, err = s.Get("counts", &v)
+	assert.Nil(err)
+	assert.True(ok)
+	assert.Equal(map[string]int{"a": 1}, v)
+
+	memory, err := Open("")
+	require.Nil(err)
+	assert.Nil(memory.Set("counts", v))
+}
+
+func TestLifecycle(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	for i := 0; i < 3; i++ {
+		s, err := Open(path)
+		require.Nil(err)
+		l, err := RecordStart(s, time.Unix(1700000000+int64(i), 0))
+		require.Nil(err)
+		assert.Equal(i, l.Restarts())
+	}
+
+	s, err := Open(path)
+	require.Nil(err)
+	l, err := RecordStart(s, time.Unix(1700000010, 0))
+	require.Nil(err)
+	assert.Nil(testutil.CollectAndCompare(l, strings.NewReader(`
+# HELP awair_exporter_restarts_total Number of times the exporter was restarted, as recorded in the state file
+# TYPE awair_exporter_restarts_total counter
+awair_exporter_restarts_total 3
+# HELP awair_exporter_start_time_seconds Start time of the exporter since unix epoch in seconds
+# TYPE awair_exporter_start_time_seconds gauge
+awair_exporter_start_time_seconds 1.70000001e+09
+`)))
+}
+
+func TestRegistry(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	s, err := Open(path)
+	require.Nil(err)
+	r, err := OpenRegistry(s)
+	require.Nil(err)
+	assert.Empty(r.Devices())
+	require.Nil(r.Add("office", "192.168.1.20"))
+	require.Nil(r.Add("bedroom", "192.168.1.21"))
+	require.Nil(r.Add("office", "192.168.1.22"))
+	require.Nil(r.Remove("kitchen"))
+
+	s, err = Open(path)
+	require.Nil(err)
+	r, err = OpenRegistry(s)
+	require.Nil(err)
+	assert.Equal([]RegisteredDevice{
+		{Name: "bedroom", Hostname: "192.168.1.21"},
+		{Name: "office", Hostname: "192.168.1.22"},
+	}, r.Devices())
+	require.Nil(r.Remove("bedroom"))
+	assert.Equal([]RegisteredDevice{{Name: "office", Hostname: "192.168.1.22"}}, r.Devices())
+}
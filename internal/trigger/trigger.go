@@ -0,0 +1,248 @@
+// Package trigger calls webhooks when sensor values cross thresholds, so
+// automations such as IFTTT applets can act on the air quality without
+// MQTT or a home automation hub.
+package trigger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"prometheus-awair-exporter/internal/config"
+	"prometheus-awair-exporter/internal/exporter"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+// ReadingSource provides the latest reading of every device by name.
+type ReadingSource interface {
+	NamedReadings() map[string]exporter.Reading
+}
+
+// Payload is the body POSTed to the webhooks, in the format of IFTTT's
+// Maker webhooks: the device name, the sensor value and whether the
+// trigger is firing or resolved.
+type Payload struct {
+	Value1 string `json:"value1"`
+	Value2 string `json:"value2"`
+	Value3 string `json:"value3"`
+}
+
+type trigger struct {
+	config.Trigger
+	// firing holds the devices the trigger fired for.
+	firing   map[string]bool
+	calls    float64
+	failures float64
+}
+
+// fires reports whether the trigger fires at value, or keeps firing
+// unless it is back past the clear level.
+func (t *trigger) fires(value float64, firing bool) bool {
+	if t.Above != nil {
+		if firing && t.Clear != nil {
+			return value > *t.Clear
+		}
+		return value > *t.Above
+	}
+	if firing && t.Clear != nil {
+		return value < *t.Clear
+	}
+	return value < *t.Below
+}
+
+// Triggers periodically compares the readings of the devices with the
+// thresholds of every trigger, calling its webhook when it fires and
+// resolves. Failed calls are retried on the next evaluation.
+type Triggers struct {
+	src      ReadingSource
+	client   *http.Client
+	triggers []*trigger
+
+	mu sync.Mutex
+
+	firing   *prometheus.Desc
+	calls    *prometheus.Desc
+	failures *prometheus.Desc
+}
+
+// New returns Triggers for the given configurations.
+func New(src ReadingSource, triggers []config.Trigger) (*Triggers, error) {
+	known := map[string]bool{}
+	for _, f := range (&exporter.AwairValues{}).Fields() {
+		known[f.Name] = true
+	}
+	t := &Triggers{src: src, client: &http.Client{Timeout: 10 * time.Second}}
+	names := map[string]bool{}
+	for _, cfg := range triggers {
+		if cfg.Name == "" || names[cfg.Name] {
+			return nil, fmt.Errorf("trigger needs a unique name")
+		}
+		names[cfg.Name] = true
+		if !known[cfg.Sensor] {
+			return nil, fmt.Errorf("trigger %s: unknown sensor %q", cfg.Name, cfg.Sensor)
+		}
+		if (cfg.Above == nil) == (cfg.Below == nil) {
+			return nil, fmt.Errorf("trigger %s needs either above or below", cfg.Name)
+		}
+		if cfg.Clear != nil && ((cfg.Above != nil && *cfg.Clear > *cfg.Above) || (cfg.Below != nil && *cfg.Clear < *cfg.Below)) {
+			return nil, fmt.Errorf("trigger %s: the clear level lies past the threshold", cfg.Name)
+		}
+		for i, u := range []string{cfg.URL, cfg.ResolvedURL} {
+			if i == 1 && u == "" {
+				continue
+			}
+			if parsed, err := url.Parse(u); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+				return nil, fmt.Errorf("trigger %s: URL %q is not http(s)", cfg.Name, u)
+			}
+		}
+		t.triggers = append(t.triggers, &trigger{Trigger: cfg, firing: map[string]bool{}})
+	}
+
+	t.firing = prometheus.NewDesc(
+		prometheus.BuildFQName("awair", "trigger", "firing"),
+		"Whether the trigger is firing for the device",
+		[]string{"trigger", "device"}, nil,
+	)
+	t.calls = prometheus.NewDesc(
+		prometheus.BuildFQName("awair", "trigger", "calls_total"),
+		"Number of webhook calls of the trigger",
+		[]string{"trigger"}, nil,
+	)
+	t.failures = prometheus.NewDesc(
+		prometheus.BuildFQName("awair", "trigger", "failures_total"),
+		"Number of failed webhook calls of the trigger",
+		[]string{"trigger"}, nil,
+	)
+	return t, nil
+}
+
+// Run evaluates the triggers at interval until ctx is done.
+func (t *Triggers) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			t.evaluate(ctx)
+		}
+	}
+}
+
+// evaluate calls the webhooks of the triggers which fired or resolved for
+// any of their devices.
+func (t *Triggers) evaluate(ctx context.Context) {
+	readings := t.src.NamedReadings()
+	devices := make([]string, 0, len(readings))
+	for name := range readings {
+		devices = append(devices, name)
+	}
+	sort.Strings(devices)
+	for _, tr := range t.triggers {
+		for _, device := range devices {
+			values := readings[device].Values
+			if values == nil || (tr.Device != "" && tr.Device != device) {
+				continue
+			}
+			value, ok := 0.0, false
+			for _, f := range values.Fields() {
+				if f.Name == tr.Sensor {
+					value, ok = f.Value, true
+				}
+			}
+			if !ok {
+				continue
+			}
+			t.mu.Lock()
+			firing := tr.firing[device]
+			t.mu.Unlock()
+			fires := tr.fires(value, firing)
+			if fires == firing {
+				continue
+			}
+			state, u := "firing", tr.URL
+			if !fires {
+				state, u = "resolved", tr.ResolvedURL
+			}
+			var err error
+			if u != "" {
+				err = t.call(ctx, u, Payload{device, strconv.FormatFloat(value, 'f', -1, 64), state})
+			}
+			t.mu.Lock()
+			if err != nil {
+				tr.failures++
+			} else {
+				tr.firing[device] = fires
+				if u != "" {
+					tr.calls++
+				}
+			}
+			t.mu.Unlock()
+			if err != nil {
+				log.Error().Err(err).Str("trigger", tr.Name).Str("device", device).Msg("Failed to call trigger webhook.")
+				continue
+			}
+			log.Info().
+				Str("trigger", tr.Name).
+				Str("device", device).
+				Str("state", state).
+				Float64("value", value).
+				Msg("Trigger changed state.")
+		}
+	}
+}
+
+func (t *Triggers) call(ctx context.Context, u string, payload Payload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
+
+func (t *Triggers) Describe(ch chan<- *prometheus.Desc) {
+	ch <- t.firing
+	ch <- t.calls
+	ch <- t.failures
+}
+
+func (t *Triggers) Collect(ch chan<- prometheus.Metric) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, tr := range t.triggers {
+		for device, firing := range tr.firing {
+			value := 0.0
+			if firing {
+				value = 1
+			}
+			ch <- prometheus.MustNewConstMetric(t.firing, prometheus.GaugeValue, value, tr.Name, device)
+		}
+		ch <- prometheus.MustNewConstMetric(t.calls, prometheus.CounterValue, tr.calls, tr.Name)
+		ch <- prometheus.MustNewConstMetric(t.failures, prometheus.CounterValue, tr.failures, tr.Name)
+	}
+}
@@ -0,0 +1,103 @@
+package trigger
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"prometheus-awair-exporter/internal/config"
+	"prometheus-awair-exporter/internal/exporter"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"github.com/tj/assert"
+)
+
+type staticSource map[string]exporter.Reading
+
+func (s staticSource) NamedReadings() map[string]exporter.Reading {
+	return s
+}
+
+func level(v float64) *float64 {
+	return &v
+}
+
+func TestNewValidatesTriggers(t *testing.T) {
+	assert := assert.New(t)
+	for _, cfg := range []config.Trigger{
+		{Sensor: "co2", Above: level(1000), URL: "http://hook"},
+		{Name: "a", Sensor: "radon", Above: level(1000), URL: "http://hook"},
+		{Name: "a", Sensor: "co2", URL: "http://hook"},
+		{Name: "a", Sensor: "co2", Above: level(1000), Below: level(400), URL: "http://hook"},
+		{Name: "a", Sensor: "co2", Above: level(1000), Clear: level(1100), URL: "http://hook"},
+		{Name: "a", Sensor: "co2", Above: level(1000)},
+		{Name: "a", Sensor: "co2", Above: level(1000), URL: "http://hook", ResolvedURL: "hook"},
+	} {
+		_, err := New(staticSource{}, []config.Trigger{cfg})
+		assert.NotNil(err, cfg)
+	}
+}
+
+func TestTriggers(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	calls := []string{}
+	fail := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			http.Error(w, "down", http.StatusBadGateway)
+			return
+		}
+		p := Payload{}
+		json.NewDecoder(r.Body).Decode(&p)
+		calls = append(calls, r.URL.Path+" "+p.Value1+" "+p.Value2+" "+p.Value3)
+	}))
+	defer srv.Close()
+
+	bedroom := &exporter.AwairValues{CO2: 800, Humidity: 40}
+	office := &exporter.AwairValues{CO2: 1200, Humidity: 25}
+	src := staticSource{"bedroom": {Values: bedroom}, "office": {Values: office}}
+	tr, err := New(src, []config.Trigger{
+		{Name: "co2-high", Sensor: "co2", Above: level(1000), Clear: level(900), URL: srv.URL + "/high", ResolvedURL: srv.URL + "/ok"},
+		{Name: "bedroom-dry", Device: "bedroom", Sensor: "humid", Below: level(30), URL: srv.URL + "/dry"},
+	})
+	require.Nil(err)
+
+	tr.evaluate(context.Background())
+	assert.Equal([]string{"/high office 1200 firing"}, calls)
+
+	office.CO2 = 950
+	bedroom.Humidity = 28
+	tr.evaluate(context.Background())
+	assert.Equal([]string{"/high office 1200 firing", "/dry bedroom 28 firing"}, calls, "kept firing above the clear level")
+
+	fail = true
+	office.CO2 = 850
+	tr.evaluate(context.Background())
+	fail = false
+	bedroom.Humidity = 35
+	tr.evaluate(context.Background())
+	assert.Equal([]string{
+		"/high office 1200 firing", "/dry bedroom 28 firing", "/ok office 850 resolved",
+	}, calls, "retried after failing, resolved without a call")
+
+	err = testutil.CollectAndCompare(tr, strings.NewReader(`
+# HELP awair_trigger_calls_total Number of webhook calls of the trigger
+# TYPE awair_trigger_calls_total counter
+awair_trigger_calls_total{trigger="bedroom-dry"} 1
+awair_trigger_calls_total{trigger="co2-high"} 2
+# HELP awair_trigger_failures_total Number of failed webhook calls of the trigger
+# TYPE awair_trigger_failures_total counter
+awair_trigger_failures_total{trigger="bedroom-dry"} 0
+awair_trigger_failures_total{trigger="co2-high"} 1
+# HELP awair_trigger_firing Whether the trigger is firing for the device
+# TYPE awair_trigger_firing gauge
+awair_trigger_firing{device="bedroom",trigger="bedroom-dry"} 0
+awair_trigger_firing{device="office",trigger="co2-high"} 0
+`))
+	assert.Nil(err)
+}
@@ -0,0 +1,90 @@
+// Package units converts readings to the unit system preferred for display
+// by the status pages, the JSON API and reports. Prometheus metrics keep
+// their metric units regardless.
+package units
+
+import (
+	"fmt"
+
+	"prometheus-awair-exporter/internal/exporter"
+)
+
+// System is a system of units readings are shown in.
+type System string
+
+const (
+	// Metric shows readings as the devices report them.
+	Metric System = "metric"
+	// Imperial shows temperatures in °F and absolute humidity in grains
+	// per cubic foot.
+	Imperial System = "imperial"
+)
+
+// gramsPerCubicMetreInGrainsPerCubicFoot converts absolute humidity.
+const gramsPerCubicMetreInGrainsPerCubicFoot = 0.43700
+
+// Parse parses the name of a system, metric if empty.
+func Parse(s string) (System, error) {
+	switch System(s) {
+	case "", Metric:
+		return Metric, nil
+	case Imperial:
+		return Imperial, nil
+	}
+	return "", fmt.Errorf("unknown units %q, expected %s or %s", s, Metric, Imperial)
+}
+
+// symbols are the units of the fields of the Local API.
+var symbols = map[System]map[string]string{
+	Metric: {
+		"dew_point": "°C",
+		"temp":      "°C",
+		"humid":     "%",
+		"abs_humid": "g/m³",
+		"co2":       "ppm",
+		"co2_est":   "ppm",
+		"voc":       "ppb",
+		"pm25":      "µg/m³",
+		"pm10_est":  "µg/m³",
+	},
+	Imperial: {
+		"dew_point": "°F",
+		"temp":      "°F",
+		"abs_humid": "gr/ft³",
+	},
+}
+
+// Symbol returns the unit of field, a Local API field name such as temp,
+// empty for unitless fields such as the score.
+func (s System) Symbol(field string) string {
+	if symbol, ok := symbols[s][field]; ok {
+		return symbol
+	}
+	return symbols[Metric][field]
+}
+
+// Convert converts v, a value of field as reported by the devices, to s.
+func (s System) Convert(field string, v float64) float64 {
+	if s != Imperial {
+		return v
+	}
+	switch field {
+	case "temp", "dew_point":
+		return v*9/5 + 32
+	case "abs_humid":
+		return v * gramsPerCubicMetreInGrainsPerCubicFoot
+	}
+	return v
+}
+
+// Values returns a copy of v converted to s.
+func (s System) Values(v *exporter.AwairValues) *exporter.AwairValues {
+	if v == nil || s != Imperial {
+		return v
+	}
+	c := *v
+	c.Temp = s.Convert("temp", v.Temp)
+	c.DewPoint = s.Convert("dew_point", v.DewPoint)
+	c.AbsHumidity = s.Convert("abs_humid", v.AbsHumidity)
+	return &c
+}
@@ -0,0 +1,39 @@
+package units
+
+import (
+	"testing"
+
+	"prometheus-awair-exporter/internal/exporter"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tj/assert"
+)
+
+func TestParse(t *testing.T) {
+	s, err := Parse("")
+	require.Nil(t, err)
+	assert.Equal(t, Metric, s)
+	s, err = Parse("imperial")
+	require.Nil(t, err)
+	assert.Equal(t, Imperial, s)
+	_, err = Parse("kelvin")
+	assert.NotNil(t, err)
+}
+
+func TestConvert(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal(21.5, Metric.Convert("temp", 21.5))
+	assert.Equal("°C", Metric.Symbol("temp"))
+	assert.InDelta(70.7, Imperial.Convert("temp", 21.5), 0.001)
+	assert.Equal("°F", Imperial.Symbol("temp"))
+	assert.InDelta(3.496, Imperial.Convert("abs_humid", 8), 0.001)
+	assert.Equal(600.0, Imperial.Convert("co2", 600))
+	assert.Equal("ppm", Imperial.Symbol("co2"))
+	assert.Equal("", Imperial.Symbol("score"))
+
+	v := &exporter.AwairValues{Temp: 20, DewPoint: 10, CO2: 600}
+	c := Imperial.Values(v)
+	assert.Equal(&exporter.AwairValues{Temp: 68, DewPoint: 50, CO2: 600}, c)
+	assert.Equal(20.0, v.Temp, "the reading is left as is")
+	assert.Equal(v, Metric.Values(v))
+}
@@ -0,0 +1,213 @@
+// Package ventilation ranks zones of a building by how well they are
+// ventilated, judged by how their CO2 levels build up and decay.
+package ventilation
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"prometheus-awair-exporter/internal/config"
+	"prometheus-awair-exporter/internal/drift"
+	"prometheus-awair-exporter/internal/exporter"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultWindow is the period ranked unless a zone sets its own.
+const DefaultWindow = 7 * 24 * time.Hour
+
+// minExcess is the CO2 level above outdoor air a decay has to start from to
+// estimate air changes, below it sensor noise dominates.
+const minExcess = 100
+
+// ReadingSource provides the latest reading of every device by name.
+type ReadingSource interface {
+	NamedReadings() map[string]exporter.Reading
+}
+
+// rate is the rate of change of the CO2 level of a device between two
+// readings, either how fast it rose in ppm/h or the air changes per hour
+// its decay towards outdoor levels implies.
+type rate struct {
+	at    time.Time
+	value float64
+}
+
+type zone struct {
+	config.Zone
+	rises   []rate
+	changes []rate
+}
+
+type reading struct {
+	at  time.Time
+	co2 float64
+}
+
+// Ranker periodically follows the CO2 levels of the devices of each zone.
+// Zones whose rooms accumulate CO2 fastest while occupied are ranked first,
+// as those need more supply air. The air changes of each zone are
+// estimated from the exponential decay of CO2 towards outdoor levels and
+// compared with those assumed for its ventilation system.
+type Ranker struct {
+	src ReadingSource
+
+	mu    sync.Mutex
+	zones []*zone
+	last  map[string]reading
+
+	rise     *prometheus.Desc
+	changes  *prometheus.Desc
+	adequacy *prometheus.Desc
+	rank     *prometheus.Desc
+}
+
+// New returns a Ranker for the given zones, filling in defaults.
+func New(src ReadingSource, zones []config.Zone) (*Ranker, error) {
+	r := &Ranker{src: src, last: map[string]reading{}}
+	names := map[string]bool{}
+	for _, z := range zones {
+		if z.Name == "" || len(z.Devices) == 0 {
+			return nil, fmt.Errorf("zone needs both a name and devices")
+		}
+		if names[z.Name] {
+			return nil, fmt.Errorf("zone %s is configured twice", z.Name)
+		}
+		names[z.Name] = true
+		if z.Window <= 0 {
+			z.Window = DefaultWindow
+		}
+		r.zones = append(r.zones, &zone{Zone: z})
+	}
+
+	r.rise = prometheus.NewDesc(
+		prometheus.BuildFQName("awair", "ventilation", "co2_rise_rate"),
+		"Mean rate at which CO2 rose in the zone's rooms while rising over the ranking window (ppm/h)",
+		[]string{"zone"}, nil,
+	)
+	r.changes = prometheus.NewDesc(
+		prometheus.BuildFQName("awair", "ventilation", "air_changes"),
+		"Mean air changes per hour of the zone, estimated from the decay of CO2 towards outdoor levels over the ranking window",
+		[]string{"zone"}, nil,
+	)
+	r.adequacy = prometheus.NewDesc(
+		prometheus.BuildFQName("awair", "ventilation", "adequacy_ratio"),
+		"Estimated air changes of the zone relative to those assumed for its ventilation system",
+		[]string{"zone"}, nil,
+	)
+	r.rank = prometheus.NewDesc(
+		prometheus.BuildFQName("awair", "ventilation", "rank"),
+		"Rank of the zone by CO2 rise rate, 1 being the zone most in need of supply air",
+		[]string{"zone"}, nil,
+	)
+	return r, nil
+}
+
+// Run records the readings at interval until ctx is done.
+func (r *Ranker) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			r.record(now)
+		}
+	}
+}
+
+// record folds the change of every device's CO2 level since the previous
+// call into the rates of its zones.
+func (r *Ranker) record(now time.Time) {
+	readings := r.src.NamedReadings()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	current := map[string]reading{}
+	for name, rd := range readings {
+		// Devices without a CO2 sensor report 0.
+		if rd.Values != nil && rd.Values.CO2 > 0 {
+			current[name] = reading{at: now, co2: rd.Values.CO2}
+		}
+	}
+	for _, z := range r.zones {
+		z.rises = prune(z.rises, now.Add(-z.Window))
+		z.changes = prune(z.changes, now.Add(-z.Window))
+		for _, device := range z.Devices {
+			cur, ok := current[device]
+			prev, prevOK := r.last[device]
+			if !ok || !prevOK {
+				continue
+			}
+			hours := cur.at.Sub(prev.at).Hours()
+			if hours <= 0 {
+				continue
+			}
+			switch {
+			case cur.co2 > prev.co2:
+				z.rises = append(z.rises, rate{at: now, value: (cur.co2 - prev.co2) / hours})
+			case cur.co2 < prev.co2 && prev.co2 > drift.OutdoorCO2+minExcess && cur.co2 > drift.OutdoorCO2:
+				// C(t) - outdoor = (C(0) - outdoor) * e^(-ach * t)
+				ach := math.Log((prev.co2-drift.OutdoorCO2)/(cur.co2-drift.OutdoorCO2)) / hours
+				z.changes = append(z.changes, rate{at: now, value: ach})
+			}
+		}
+	}
+	r.last = current
+}
+
+func prune(rates []rate, since time.Time) []rate {
+	kept := rates[:0]
+	for _, r := range rates {
+		if r.at.After(since) {
+			kept = append(kept, r)
+		}
+	}
+	return kept
+}
+
+func mean(rates []rate) float64 {
+	sum := 0.0
+	for _, r := range rates {
+		sum += r.value
+	}
+	return sum / float64(len(rates))
+}
+
+func (r *Ranker) Describe(ch chan<- *prometheus.Desc) {
+	ch <- r.rise
+	ch <- r.changes
+	ch <- r.adequacy
+	ch <- r.rank
+}
+
+func (r *Ranker) Collect(ch chan<- prometheus.Metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ranked := []*zone{}
+	rises := map[string]float64{}
+	for _, z := range r.zones {
+		if len(z.rises) > 0 {
+			rises[z.Name] = mean(z.rises)
+			ranked = append(ranked, z)
+			ch <- prometheus.MustNewConstMetric(r.rise, prometheus.GaugeValue, rises[z.Name], z.Name)
+		}
+		if len(z.changes) > 0 {
+			changes := mean(z.changes)
+			ch <- prometheus.MustNewConstMetric(r.changes, prometheus.GaugeValue, changes, z.Name)
+			if z.AirChanges > 0 {
+				ch <- prometheus.MustNewConstMetric(r.adequacy, prometheus.GaugeValue, changes/z.AirChanges, z.Name)
+			}
+		}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return rises[ranked[i].Name] > rises[ranked[j].Name]
+	})
+	for i, z := range ranked {
+		ch <- prometheus.MustNewConstMetric(r.rank, prometheus.GaugeValue, float64(i+1), z.Name)
+	}
+}
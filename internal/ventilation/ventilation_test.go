@@ -0,0 +1,88 @@
+package ventilation
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"prometheus-awair-exporter/internal/config"
+	"prometheus-awair-exporter/internal/exporter"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"github.com/tj/assert"
+)
+
+type staticSource map[string]exporter.Reading
+
+func (s staticSource) NamedReadings() map[string]exporter.Reading {
+	return s
+}
+
+func TestNewValidatesZones(t *testing.T) {
+	assert := assert.New(t)
+	_, err := New(staticSource{}, []config.Zone{{Name: "north"}})
+	assert.NotNil(err)
+	_, err = New(staticSource{}, []config.Zone{{Name: "north", Devices: []string{"a"}}, {Name: "north", Devices: []string{"b"}}})
+	assert.NotNil(err)
+}
+
+func TestRanker(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	bedroom := &exporter.AwairValues{CO2: 600}
+	office := &exporter.AwairValues{CO2: 600}
+	src := staticSource{
+		"bedroom": {Values: bedroom},
+		"office":  {Values: office},
+		"hall":    {Values: &exporter.AwairValues{}},
+	}
+	r, err := New(src, []config.Zone{
+		{Name: "north", Devices: []string{"bedroom", "nursery"}, AirChanges: 2},
+		{Name: "south", Devices: []string{"office", "hall"}},
+	})
+	require.Nil(err)
+
+	now := time.Now()
+	r.record(now)
+	bedroom.CO2, office.CO2 = 800, 700
+	r.record(now.Add(30 * time.Minute))
+	// One air change per hour for the bedroom, half of one for the office.
+	bedroom.CO2 = 420 + 380*math.Exp(-0.5)
+	office.CO2 = 420 + 280*math.Exp(-0.25)
+	r.record(now.Add(time.Hour))
+
+	err = testutil.CollectAndCompare(r, strings.NewReader(`
+# HELP awair_ventilation_co2_rise_rate Mean rate at which CO2 rose in the zone's rooms while rising over the ranking window (ppm/h)
+# TYPE awair_ventilation_co2_rise_rate gauge
+awair_ventilation_co2_rise_rate{zone="north"} 400
+awair_ventilation_co2_rise_rate{zone="south"} 200
+# HELP awair_ventilation_rank Rank of the zone by CO2 rise rate, 1 being the zone most in need of supply air
+# TYPE awair_ventilation_rank gauge
+awair_ventilation_rank{zone="north"} 1
+awair_ventilation_rank{zone="south"} 2
+`), "awair_ventilation_co2_rise_rate", "awair_ventilation_rank")
+	assert.Nil(err)
+
+	reg := prometheus.NewPedanticRegistry()
+	require.Nil(reg.Register(r))
+	mfs, err := reg.Gather()
+	require.Nil(err)
+	values := map[string]float64{}
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			values[mf.GetName()+"/"+m.GetLabel()[0].GetValue()] = m.GetGauge().GetValue()
+		}
+	}
+	assert.InDelta(1, values["awair_ventilation_air_changes/north"], 1e-9)
+	assert.InDelta(0.5, values["awair_ventilation_adequacy_ratio/north"], 1e-9)
+	assert.InDelta(0.5, values["awair_ventilation_air_changes/south"], 1e-9)
+	_, ok := values["awair_ventilation_adequacy_ratio/south"]
+	assert.False(ok, "south assumes no air changes")
+
+	// Rates age out of the window.
+	r.record(now.Add(DefaultWindow + 2*time.Hour))
+	assert.Equal(0, testutil.CollectAndCount(r))
+}